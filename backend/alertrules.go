@@ -0,0 +1,546 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Alert Rules ---
+//
+// Rules are threshold checks configured by moderators and evaluated every
+// few minutes:
+//
+//   - sensor: an aggregate (mean, min, max, count) of a station parameter
+//     over the last window, compared with a threshold, per station;
+//   - sensor_gap: a station has not reported the parameter (or anything)
+//     for longer than the window, or twice its expected interval;
+//   - occurrence: the number of occurrence records in the last window,
+//     optionally for one species and region.
+//
+// A firing rule keeps one open alert per scope (station or region) and
+// updates it while the condition holds; with auto_resolve, the alert is
+// resolved by the system once the condition clears.
+
+const alertRuleSchema = `
+CREATE TABLE IF NOT EXISTS alert_rules (
+	id                SERIAL PRIMARY KEY,
+	name              TEXT NOT NULL,
+	description       TEXT,
+	source            TEXT NOT NULL,
+	category          TEXT NOT NULL,
+	severity          TEXT NOT NULL,
+	station_code      TEXT,
+	region            TEXT,
+	parameter         TEXT,
+	aggregate         TEXT NOT NULL DEFAULT 'mean',
+	comparator        TEXT NOT NULL DEFAULT 'gt',
+	threshold         DOUBLE PRECISION NOT NULL DEFAULT 0,
+	window_minutes    INTEGER NOT NULL DEFAULT 60,
+	species_id        INTEGER,
+	auto_resolve      BOOLEAN NOT NULL DEFAULT true,
+	enabled           BOOLEAN NOT NULL DEFAULT true,
+	created_by        TEXT NOT NULL,
+	created_at        TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at        TIMESTAMPTZ NOT NULL DEFAULT now(),
+	last_evaluated_at TIMESTAMPTZ,
+	last_error        TEXT
+);`
+
+const (
+	ruleSourceSensor     = "sensor"
+	ruleSourceSensorGap  = "sensor_gap"
+	ruleSourceOccurrence = "occurrence"
+
+	ruleInterval = 5 * time.Minute
+)
+
+var ruleAggregates = map[string]string{"mean": "AVG(value)", "min": "MIN(value)", "max": "MAX(value)", "count": "COUNT(*)"}
+
+var ruleComparators = map[string]string{"gt": ">", "gte": ">=", "lt": "<", "lte": "<="}
+
+type AlertRule struct {
+	ID              int     `json:"id"`
+	Name            string  `json:"name"`
+	Description     string  `json:"description"`
+	Source          string  `json:"source"`
+	Category        string  `json:"category"`
+	Severity        string  `json:"severity"`
+	StationCode     string  `json:"station_code,omitempty"`
+	Region          string  `json:"region,omitempty"`
+	Parameter       string  `json:"parameter,omitempty"`
+	Aggregate       string  `json:"aggregate"`
+	Comparator      string  `json:"comparator"`
+	Threshold       float64 `json:"threshold"`
+	WindowMinutes   int     `json:"window_minutes"`
+	SpeciesID       *int    `json:"species_id,omitempty"`
+	AutoResolve     bool    `json:"auto_resolve"`
+	Enabled         bool    `json:"enabled"`
+	CreatedBy       string  `json:"created_by"`
+	CreatedAt       string  `json:"created_at"`
+	UpdatedAt       string  `json:"updated_at"`
+	LastEvaluatedAt string  `json:"last_evaluated_at,omitempty"`
+	LastError       string  `json:"last_error,omitempty"`
+}
+
+const alertRuleColumns = `id, name, COALESCE(description, ''), source, category, severity, COALESCE(station_code, ''),
+	COALESCE(region, ''), COALESCE(parameter, ''), aggregate, comparator, threshold, window_minutes, species_id, auto_resolve,
+	enabled, created_by, created_at::text, updated_at::text, COALESCE(last_evaluated_at::text, ''), COALESCE(last_error, '')`
+
+func scanAlertRule(row interface{ Scan(...any) error }) (*AlertRule, error) {
+	var rule AlertRule
+	var speciesID sql.NullInt64
+	err := row.Scan(&rule.ID, &rule.Name, &rule.Description, &rule.Source, &rule.Category, &rule.Severity, &rule.StationCode,
+		&rule.Region, &rule.Parameter, &rule.Aggregate, &rule.Comparator, &rule.Threshold, &rule.WindowMinutes, &speciesID,
+		&rule.AutoResolve, &rule.Enabled, &rule.CreatedBy, &rule.CreatedAt, &rule.UpdatedAt, &rule.LastEvaluatedAt, &rule.LastError)
+	if err != nil {
+		return nil, err
+	}
+	if speciesID.Valid {
+		id := int(speciesID.Int64)
+		rule.SpeciesID = &id
+	}
+	return &rule, nil
+}
+
+func loadAlertRule(id string) (*AlertRule, error) {
+	return scanAlertRule(db.QueryRow("SELECT "+alertRuleColumns+" FROM alert_rules WHERE id = $1", id))
+}
+
+func (rule *AlertRule) validate() error {
+	rule.Name = strings.TrimSpace(rule.Name)
+	if rule.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if !validAlertCategory(rule.Category) {
+		return fmt.Errorf("category must be one of %s", strings.Join(alertCategories, ", "))
+	}
+	if !validAlertSeverity(rule.Severity) {
+		return fmt.Errorf("severity must be one of %s", strings.Join(alertSeverities, ", "))
+	}
+	if _, ok := ruleComparators[rule.Comparator]; !ok {
+		return fmt.Errorf("comparator must be gt, gte, lt or lte")
+	}
+	if rule.WindowMinutes < 0 {
+		return fmt.Errorf("window_minutes must not be negative")
+	}
+
+	switch rule.Source {
+	case ruleSourceSensor:
+		if !isEnvParameter(rule.Parameter) {
+			return fmt.Errorf("sensor rules need a known parameter")
+		}
+		if _, ok := ruleAggregates[rule.Aggregate]; !ok {
+			return fmt.Errorf("aggregate must be mean, min, max or count")
+		}
+		if rule.WindowMinutes == 0 {
+			return fmt.Errorf("sensor rules need window_minutes")
+		}
+	case ruleSourceSensorGap:
+		if rule.Parameter != "" && !isEnvParameter(rule.Parameter) {
+			return fmt.Errorf("unknown parameter %q", rule.Parameter)
+		}
+	case ruleSourceOccurrence:
+		rule.Aggregate = "count"
+		if rule.WindowMinutes == 0 {
+			return fmt.Errorf("occurrence rules need window_minutes")
+		}
+	default:
+		return fmt.Errorf("source must be sensor, sensor_gap or occurrence")
+	}
+	return nil
+}
+
+// --- Evaluation ---
+
+// ruleCheck is the outcome of a rule for one scope. Scopes without data
+// are not reported, so their alerts are left alone.
+type ruleCheck struct {
+	Scope   string      `json:"scope"`
+	Value   float64     `json:"value"`
+	Firing  bool        `json:"firing"`
+	AlertID int         `json:"alert_id,omitempty"`
+	Station *EnvStation `json:"-"`
+	Region  string      `json:"-"`
+}
+
+func (rule *AlertRule) compare(v float64) bool {
+	switch rule.Comparator {
+	case "gt":
+		return v > rule.Threshold
+	case "gte":
+		return v >= rule.Threshold
+	case "lt":
+		return v < rule.Threshold
+	case "lte":
+		return v <= rule.Threshold
+	}
+	return false
+}
+
+func (rule *AlertRule) stations() ([]*EnvStation, error) {
+	query := "SELECT " + envStationColumns + " FROM " + envStationFrom + " WHERE 1=1"
+	args := []interface{}{}
+	if rule.StationCode != "" {
+		args = append(args, rule.StationCode)
+		query += " AND s.code = $" + strconv.Itoa(len(args))
+	}
+	if rule.Region != "" {
+		args = append(args, rule.Region)
+		query += " AND s.region ILIKE $" + strconv.Itoa(len(args))
+	}
+	rows, err := db.Query(query+" ORDER BY s.code", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stations []*EnvStation
+	for rows.Next() {
+		st, err := scanEnvStation(rows)
+		if err != nil {
+			return nil, err
+		}
+		stations = append(stations, st)
+	}
+	return stations, rows.Err()
+}
+
+func (rule *AlertRule) checkSensor() ([]ruleCheck, error) {
+	stations, err := rule.stations()
+	if err != nil {
+		return nil, err
+	}
+	var checks []ruleCheck
+	for _, st := range stations {
+		var n int
+		var v sql.NullFloat64
+		err := db.QueryRow(`SELECT COUNT(*), `+ruleAggregates[rule.Aggregate]+`::double precision FROM env_observations
+			WHERE station_id = $1 AND parameter = $2 AND observed_at >= now() - make_interval(mins => $3)`,
+			st.ID, rule.Parameter, rule.WindowMinutes).Scan(&n, &v)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			continue
+		}
+		checks = append(checks, ruleCheck{Scope: st.Code, Value: v.Float64, Firing: rule.compare(v.Float64), Station: st, Region: st.Region})
+	}
+	return checks, nil
+}
+
+// checkSensorGap reports the minutes since each station's last
+// observation. Stations that never reported are skipped.
+func (rule *AlertRule) checkSensorGap() ([]ruleCheck, error) {
+	stations, err := rule.stations()
+	if err != nil {
+		return nil, err
+	}
+	var checks []ruleCheck
+	for _, st := range stations {
+		var last sql.NullTime
+		query := "SELECT MAX(observed_at) FROM env_observations WHERE station_id = $1"
+		args := []interface{}{st.ID}
+		if rule.Parameter != "" {
+			query += " AND parameter = $2"
+			args = append(args, rule.Parameter)
+		}
+		if err := db.QueryRow(query, args...).Scan(&last); err != nil {
+			return nil, err
+		}
+		if !last.Valid {
+			continue
+		}
+		limit := time.Duration(rule.WindowMinutes) * time.Minute
+		if limit == 0 {
+			limit = st.gapThreshold()
+		}
+		age := time.Since(last.Time)
+		checks = append(checks, ruleCheck{Scope: st.Code, Value: math.Round(age.Minutes()), Firing: age > limit, Station: st, Region: st.Region})
+	}
+	return checks, nil
+}
+
+// checkOccurrence counts occurrence records dated within the window,
+// rounded up to whole days since eventdate has no time of day.
+func (rule *AlertRule) checkOccurrence() ([]ruleCheck, error) {
+	days := (rule.WindowMinutes + 1439) / 1440
+	query := "SELECT COUNT(*) FROM occurrence_data WHERE eventdate::date >= current_date - $1::int"
+	args := []interface{}{days}
+	if rule.SpeciesID != nil {
+		args = append(args, *rule.SpeciesID)
+		query += " AND species_id = $" + strconv.Itoa(len(args))
+	}
+	if rule.Region != "" {
+		args = append(args, rule.Region)
+		query += " AND region ILIKE $" + strconv.Itoa(len(args))
+	}
+	var n int
+	if err := db.QueryRow(query, args...).Scan(&n); err != nil {
+		return nil, err
+	}
+	scope := rule.Region
+	if scope == "" {
+		scope = "all"
+	}
+	return []ruleCheck{{Scope: scope, Value: float64(n), Firing: rule.compare(float64(n)), Region: rule.Region}}, nil
+}
+
+func (rule *AlertRule) alertFor(c ruleCheck) *Alert {
+	subject := c.Scope
+	if c.Station != nil {
+		subject = stationLabel(c.Station)
+	}
+
+	var what string
+	switch rule.Source {
+	case ruleSourceSensor:
+		what = fmt.Sprintf("%s %s over the last %d minutes is %.2f", rule.Aggregate, rule.Parameter, rule.WindowMinutes, c.Value)
+	case ruleSourceSensorGap:
+		what = fmt.Sprintf("no observations for %.0f minutes", c.Value)
+	case ruleSourceOccurrence:
+		what = fmt.Sprintf("%.0f occurrence records in the last %d days", c.Value, (rule.WindowMinutes+1439)/1440)
+	}
+	message := fmt.Sprintf("%s: %s", subject, what)
+	if rule.Source != ruleSourceSensorGap {
+		message += fmt.Sprintf(" (%s %g)", ruleComparators[rule.Comparator], rule.Threshold)
+	}
+
+	details, _ := json.Marshal(map[string]interface{}{
+		"rule": rule.Name, "source": rule.Source, "parameter": rule.Parameter, "aggregate": rule.Aggregate,
+		"comparator": rule.Comparator, "threshold": rule.Threshold, "window_minutes": rule.WindowMinutes,
+		"species_id": rule.SpeciesID, "value": c.Value,
+	})
+	a := &Alert{
+		Category:  rule.Category,
+		Severity:  rule.Severity,
+		Type:      "rule_" + rule.Source,
+		Title:     fmt.Sprintf("%s at %s", rule.Name, subject),
+		Message:   message,
+		Region:    c.Region,
+		StartedAt: time.Now().UTC().Format(time.RFC3339),
+		PeakValue: &c.Value,
+		Details:   details,
+		RuleID:    &rule.ID,
+		RuleScope: c.Scope,
+	}
+	if c.Station != nil {
+		a.StationID = &c.Station.ID
+	}
+	return a
+}
+
+// evaluate runs the rule once, raising, refreshing or resolving its
+// alerts, and records the outcome on the rule.
+func (rule *AlertRule) evaluate() ([]ruleCheck, error) {
+	var checks []ruleCheck
+	var err error
+	switch rule.Source {
+	case ruleSourceSensor:
+		checks, err = rule.checkSensor()
+	case ruleSourceSensorGap:
+		checks, err = rule.checkSensorGap()
+	case ruleSourceOccurrence:
+		checks, err = rule.checkOccurrence()
+	}
+
+	for i := range checks {
+		if err != nil {
+			break
+		}
+		c := &checks[i]
+		var openID int
+		var dedupeKey string
+		lookup := db.QueryRow("SELECT id, dedupe_key FROM alerts WHERE rule_id = $1 AND rule_scope = $2 AND status <> 'resolved'",
+			rule.ID, c.Scope).Scan(&openID, &dedupeKey)
+		if lookup != nil && lookup != sql.ErrNoRows {
+			err = lookup
+			break
+		}
+
+		if !c.Firing {
+			if openID != 0 && rule.AutoResolve {
+				_, err = db.Exec(`UPDATE alerts SET status = 'resolved', resolved_by = 'system', resolved_at = now(), ended_at = now(),
+						resolution_note = 'Condition cleared', updated_at = now()
+					WHERE id = $1`, openID)
+			}
+			continue
+		}
+
+		a := rule.alertFor(*c)
+		if openID != 0 {
+			a.DedupeKey = dedupeKey
+		} else {
+			a.DedupeKey = fmt.Sprintf("rule:%d:%s:%d", rule.ID, c.Scope, time.Now().Unix())
+		}
+		c.AlertID, _, err = raiseAlert(a)
+	}
+
+	var lastError interface{}
+	if err != nil {
+		lastError = err.Error()
+	}
+	db.Exec("UPDATE alert_rules SET last_evaluated_at = now(), last_error = $2 WHERE id = $1", rule.ID, lastError)
+	return checks, err
+}
+
+func evaluateAlertRules() {
+	rows, err := db.Query("SELECT " + alertRuleColumns + " FROM alert_rules WHERE enabled ORDER BY id")
+	if err != nil {
+		log.Println("Could not load alert rules:", err)
+		return
+	}
+	var rules []*AlertRule
+	for rows.Next() {
+		if rule, err := scanAlertRule(rows); err == nil {
+			rules = append(rules, rule)
+		}
+	}
+	rows.Close()
+
+	for _, rule := range rules {
+		if _, err := rule.evaluate(); err != nil {
+			log.Printf("Alert rule %d (%s) failed: %v", rule.ID, rule.Name, err)
+		}
+	}
+}
+
+func ruleLoop() {
+	for {
+		evaluateAlertRules()
+		time.Sleep(ruleInterval)
+	}
+}
+
+// --- Handlers ---
+
+func listAlertRules(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireRole(w, r, moderatorRoles...); !ok {
+		return
+	}
+	rows, err := db.Query("SELECT " + alertRuleColumns + " FROM alert_rules ORDER BY id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	rules := []*AlertRule{}
+	for rows.Next() {
+		rule, err := scanAlertRule(rows)
+		if err != nil {
+			continue
+		}
+		rules = append(rules, rule)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+// saveAlertRule creates a rule (POST) or replaces the {id} rule (PUT).
+func saveAlertRule(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireRole(w, r, moderatorRoles...)
+	if !ok {
+		return
+	}
+	rule := AlertRule{Aggregate: "mean", Comparator: "gt", WindowMinutes: 60, AutoResolve: true, Enabled: true}
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if err := rule.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var id int
+	var err error
+	status := http.StatusOK
+	if r.PathValue("id") == "" {
+		status = http.StatusCreated
+		err = db.QueryRow(`INSERT INTO alert_rules (name, description, source, category, severity, station_code, region, parameter,
+				aggregate, comparator, threshold, window_minutes, species_id, auto_resolve, enabled, created_by)
+			VALUES ($1, NULLIF($2, ''), $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''), $9, $10, $11, $12, $13, $14, $15, $16)
+			RETURNING id`,
+			rule.Name, rule.Description, rule.Source, rule.Category, rule.Severity, rule.StationCode, rule.Region, rule.Parameter,
+			rule.Aggregate, rule.Comparator, rule.Threshold, rule.WindowMinutes, rule.SpeciesID, rule.AutoResolve, rule.Enabled,
+			user.ID).Scan(&id)
+	} else {
+		err = db.QueryRow(`UPDATE alert_rules SET name = $2, description = NULLIF($3, ''), source = $4, category = $5, severity = $6,
+				station_code = NULLIF($7, ''), region = NULLIF($8, ''), parameter = NULLIF($9, ''), aggregate = $10, comparator = $11,
+				threshold = $12, window_minutes = $13, species_id = $14, auto_resolve = $15, enabled = $16, updated_at = now()
+			WHERE id = $1 RETURNING id`,
+			r.PathValue("id"), rule.Name, rule.Description, rule.Source, rule.Category, rule.Severity, rule.StationCode, rule.Region,
+			rule.Parameter, rule.Aggregate, rule.Comparator, rule.Threshold, rule.WindowMinutes, rule.SpeciesID, rule.AutoResolve,
+			rule.Enabled).Scan(&id)
+	}
+	if err == sql.ErrNoRows {
+		http.Error(w, "Rule not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	saved, err := loadAlertRule(strconv.Itoa(id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(saved)
+}
+
+// deleteAlertRule removes the rule. Its alerts are kept for the record.
+func deleteAlertRule(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireRole(w, r, moderatorRoles...); !ok {
+		return
+	}
+	res, err := db.Exec("DELETE FROM alert_rules WHERE id = $1", r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Rule not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// evaluateAlertRuleHandler runs one rule now, enabled or not, and returns
+// the per-scope outcome.
+func evaluateAlertRuleHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireRole(w, r, moderatorRoles...); !ok {
+		return
+	}
+	rule, err := loadAlertRule(r.PathValue("id"))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Rule not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	checks, err := rule.evaluate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if checks == nil {
+		checks = []ruleCheck{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(checks)
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// --- Alerts ---
+//
+// Detectors and alert rules write alerts here. Each alert carries a dedupe
+// key naming the event it describes (detector, station and start), so
+// re-running a detector updates the alert for an ongoing event instead of
+// raising a new one. Alerts move from open to acknowledged to resolved;
+// new alerts are queued for delivery to matching subscriptions.
+
+const alertSchema = `
+CREATE TABLE IF NOT EXISTS alerts (
+	id             SERIAL PRIMARY KEY,
+	category       TEXT NOT NULL,
+	severity       TEXT NOT NULL,
+	alert_type     TEXT NOT NULL,
+	title          TEXT NOT NULL,
+	message        TEXT NOT NULL,
+	region         TEXT,
+	station_id     INTEGER REFERENCES env_stations(id) ON DELETE SET NULL,
+	started_at     TIMESTAMPTZ NOT NULL,
+	peak_at        TIMESTAMPTZ,
+	ended_at       TIMESTAMPTZ,
+	duration_hours DOUBLE PRECISION,
+	peak_value     DOUBLE PRECISION,
+	event_category TEXT,
+	details        JSONB NOT NULL DEFAULT '{}',
+	dedupe_key     TEXT NOT NULL UNIQUE,
+	created_at     TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS alerts_started_idx ON alerts (started_at DESC);
+
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'open';
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS acknowledged_by TEXT;
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS acknowledged_at TIMESTAMPTZ;
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS resolved_by TEXT;
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS resolved_at TIMESTAMPTZ;
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS resolution_note TEXT;
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS rule_id INTEGER;
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS rule_scope TEXT;
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS species_id INTEGER;
+CREATE INDEX IF NOT EXISTS alerts_rule_idx ON alerts (rule_id, rule_scope) WHERE status <> 'resolved';`
+
+const (
+	categoryCritical      = "critical"
+	categoryEnvironmental = "environmental"
+	categoryBiological    = "biological"
+	categoryFisheries     = "fisheries"
+	categorySystem        = "system"
+
+	severityCritical = "critical"
+	severityHigh     = "high"
+	severityMedium   = "medium"
+	severityLow      = "low"
+
+	alertOpen         = "open"
+	alertAcknowledged = "acknowledged"
+	alertResolved     = "resolved"
+)
+
+var alertCategories = []string{categoryCritical, categoryEnvironmental, categoryBiological, categoryFisheries, categorySystem}
+
+// alertSeverities are in increasing order.
+var alertSeverities = []string{severityLow, severityMedium, severityHigh, severityCritical}
+
+func validAlertCategory(c string) bool {
+	for _, v := range alertCategories {
+		if v == c {
+			return true
+		}
+	}
+	return false
+}
+
+func validAlertSeverity(s string) bool {
+	for _, v := range alertSeverities {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+type Alert struct {
+	ID             int             `json:"id"`
+	Category       string          `json:"category"`
+	Severity       string          `json:"severity"`
+	Type           string          `json:"type"`
+	Title          string          `json:"title"`
+	Message        string          `json:"message"`
+	Region         string          `json:"region,omitempty"`
+	StationID      *int            `json:"-"`
+	Station        string          `json:"station,omitempty"`
+	StartedAt      string          `json:"started_at"`
+	PeakAt         string          `json:"peak_at,omitempty"`
+	EndedAt        string          `json:"ended_at,omitempty"`
+	DurationHours  *float64        `json:"duration_hours,omitempty"`
+	PeakValue      *float64        `json:"peak_value,omitempty"`
+	EventCategory  string          `json:"event_category,omitempty"`
+	Details        json.RawMessage `json:"details"`
+	DedupeKey      string          `json:"-"`
+	RuleID         *int            `json:"rule_id,omitempty"`
+	RuleScope      string          `json:"-"`
+	SpeciesID      *int            `json:"species_id,omitempty"`
+	Status         string          `json:"status"`
+	AcknowledgedBy string          `json:"acknowledged_by,omitempty"`
+	AcknowledgedAt string          `json:"acknowledged_at,omitempty"`
+	ResolvedBy     string          `json:"resolved_by,omitempty"`
+	ResolvedAt     string          `json:"resolved_at,omitempty"`
+	ResolutionNote string          `json:"resolution_note,omitempty"`
+	CreatedAt      string          `json:"created_at"`
+	UpdatedAt      string          `json:"updated_at"`
+}
+
+// raiseAlert inserts the alert or updates the one with the same dedupe
+// key, returning its id and whether it is new. New alerts are queued for
+// delivery.
+func raiseAlert(a *Alert) (int, bool, error) {
+	if len(a.Details) == 0 {
+		a.Details = json.RawMessage("{}")
+	}
+	var id int
+	var inserted bool
+	err := db.QueryRow(`INSERT INTO alerts (category, severity, alert_type, title, message, region, station_id, started_at, peak_at,
+			ended_at, duration_hours, peak_value, event_category, details, dedupe_key, rule_id, rule_scope, species_id)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8, NULLIF($9, '')::timestamptz, NULLIF($10, '')::timestamptz, $11, $12,
+			NULLIF($13, ''), $14, $15, $16, NULLIF($17, ''), $18)
+		ON CONFLICT (dedupe_key) DO UPDATE SET
+			severity = EXCLUDED.severity, title = EXCLUDED.title, message = EXCLUDED.message, peak_at = EXCLUDED.peak_at,
+			ended_at = EXCLUDED.ended_at, duration_hours = EXCLUDED.duration_hours, peak_value = EXCLUDED.peak_value,
+			event_category = EXCLUDED.event_category, details = EXCLUDED.details, updated_at = now()
+		RETURNING id, xmax = 0`,
+		a.Category, a.Severity, a.Type, a.Title, a.Message, a.Region, a.StationID, a.StartedAt, a.PeakAt,
+		a.EndedAt, a.DurationHours, a.PeakValue, a.EventCategory, []byte(a.Details), a.DedupeKey, a.RuleID, a.RuleScope, a.SpeciesID).Scan(&id, &inserted)
+	if err != nil {
+		return 0, false, err
+	}
+	if inserted {
+		if err := queueAlertDeliveries(id, a); err != nil {
+			log.Printf("Could not queue deliveries for alert %d: %v", id, err)
+		}
+	}
+	return id, inserted, nil
+}
+
+const alertColumns = `a.id, a.category, a.severity, a.alert_type, a.title, a.message, COALESCE(a.region, ''), a.station_id,
+	COALESCE(s.code, ''), a.started_at::text, COALESCE(a.peak_at::text, ''), COALESCE(a.ended_at::text, ''), a.duration_hours,
+	a.peak_value, COALESCE(a.event_category, ''), a.details, a.rule_id, a.species_id, a.status, COALESCE(a.acknowledged_by, ''),
+	COALESCE(a.acknowledged_at::text, ''), COALESCE(a.resolved_by, ''), COALESCE(a.resolved_at::text, ''),
+	COALESCE(a.resolution_note, ''), a.created_at::text, a.updated_at::text`
+
+const alertFrom = `alerts a LEFT JOIN env_stations s ON s.id = a.station_id`
+
+func scanAlert(row interface{ Scan(...any) error }) (*Alert, error) {
+	var a Alert
+	var stationID, ruleID, speciesID sql.NullInt64
+	var duration, peak sql.NullFloat64
+	var details []byte
+	err := row.Scan(&a.ID, &a.Category, &a.Severity, &a.Type, &a.Title, &a.Message, &a.Region, &stationID, &a.Station,
+		&a.StartedAt, &a.PeakAt, &a.EndedAt, &duration, &peak, &a.EventCategory, &details, &ruleID, &speciesID, &a.Status,
+		&a.AcknowledgedBy, &a.AcknowledgedAt, &a.ResolvedBy, &a.ResolvedAt, &a.ResolutionNote, &a.CreatedAt, &a.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if ruleID.Valid {
+		id := int(ruleID.Int64)
+		a.RuleID = &id
+	}
+	if speciesID.Valid {
+		id := int(speciesID.Int64)
+		a.SpeciesID = &id
+	}
+	if stationID.Valid {
+		id := int(stationID.Int64)
+		a.StationID = &id
+	}
+	a.DurationHours, a.PeakValue = nullableFloat(duration), nullableFloat(peak)
+	a.Details = json.RawMessage(details)
+	return &a, nil
+}
+
+// --- Handlers ---
+
+// listAlerts returns alerts newest first, filtered by ?category= (where
+// "critical" also matches any critical-severity alert, as in the warnings
+// tab), status, severity, type, species_id, station, region, from and to.
+func listAlerts(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	where := []string{"1=1"}
+	args := []interface{}{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if c := q.Get("category"); c == categoryCritical {
+		where = append(where, "(a.category = "+arg(c)+" OR a.severity = 'critical')")
+	} else if c != "" {
+		where = append(where, "a.category = "+arg(c))
+	}
+	if v := q.Get("status"); v != "" {
+		where = append(where, "a.status = "+arg(v))
+	}
+	if v := q.Get("severity"); v != "" {
+		where = append(where, "a.severity = "+arg(v))
+	}
+	if v := q.Get("type"); v != "" {
+		where = append(where, "a.alert_type = "+arg(v))
+	}
+	if v := q.Get("species_id"); v != "" {
+		where = append(where, "a.species_id = "+arg(v)+"::int")
+	}
+	if v := q.Get("station"); v != "" {
+		where = append(where, "s.code = "+arg(v))
+	}
+	if v := q.Get("region"); v != "" {
+		where = append(where, "a.region ILIKE "+arg(v))
+	}
+	if v := q.Get("from"); v != "" {
+		where = append(where, "COALESCE(a.ended_at, now()) >= "+arg(v)+"::timestamptz")
+	}
+	if v := q.Get("to"); v != "" {
+		where = append(where, "a.started_at <= "+arg(v)+"::timestamptz")
+	}
+
+	page, pageSize := pagination(r)
+	limit, offset := arg(pageSize), arg((page-1)*pageSize)
+	rows, err := db.Query("SELECT "+alertColumns+" FROM "+alertFrom+" WHERE "+strings.Join(where, " AND ")+
+		" ORDER BY a.started_at DESC, a.id DESC LIMIT "+limit+" OFFSET "+offset, args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer rows.Close()
+
+	alerts := []*Alert{}
+	for rows.Next() {
+		a, err := scanAlert(rows)
+		if err != nil {
+			continue
+		}
+		alerts = append(alerts, a)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(alerts)
+}
+
+func loadAlert(id string) (*Alert, error) {
+	return scanAlert(db.QueryRow("SELECT "+alertColumns+" FROM "+alertFrom+" WHERE a.id = $1", id))
+}
+
+func getAlert(w http.ResponseWriter, r *http.Request) {
+	a, err := loadAlert(r.PathValue("id"))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Alert not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a)
+}
+
+// alertTransition returns a handler moving an alert to status. Open alerts
+// can be acknowledged; open or acknowledged alerts can be resolved.
+func alertTransition(status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := requireRole(w, r, moderatorRoles...)
+		if !ok {
+			return
+		}
+		var body struct {
+			Note string `json:"note"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+				return
+			}
+		}
+
+		var res sql.Result
+		var err error
+		switch status {
+		case alertAcknowledged:
+			res, err = db.Exec(`UPDATE alerts SET status = $2, acknowledged_by = $3, acknowledged_at = now(), updated_at = now()
+				WHERE id = $1 AND status = 'open'`, r.PathValue("id"), status, user.ID)
+		case alertResolved:
+			res, err = db.Exec(`UPDATE alerts SET status = $2, resolved_by = $3, resolved_at = now(), resolution_note = NULLIF($4, ''),
+					acknowledged_by = COALESCE(acknowledged_by, $3), acknowledged_at = COALESCE(acknowledged_at, now()), updated_at = now()
+				WHERE id = $1 AND status <> 'resolved'`, r.PathValue("id"), status, user.ID, strings.TrimSpace(body.Note))
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		a, err := loadAlert(r.PathValue("id"))
+		if err == sql.ErrNoRows {
+			http.Error(w, "Alert not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "Alert is already "+a.Status, http.StatusConflict)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a)
+	}
+}
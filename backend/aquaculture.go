@@ -0,0 +1,1469 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// --- Aquaculture Farms ---
+//
+// A farm has culture units (sea cages, ponds, tanks, raceways), and each
+// unit holds stocked cohorts. A cohort is stocked with a number of fish at
+// a mean weight; feed, mortality and growth samples are logged against it
+// and water quality against its unit, until it is harvested.
+//
+// Performance is derived from those logs. Numbers alive step down with
+// each recorded death, and mean weight between samples is interpolated
+// exponentially, which is how fish grow over a few weeks. From those come
+// biomass and stocking density on any day, and per cohort:
+//
+//	eFCR     feed / (final biomass - stocked biomass)
+//	bFCR     feed / (final biomass + dead biomass - stocked biomass)
+//	SGR      100 * (ln Wt - ln W0) / days, in % body weight per day
+//	TGC      1000 * (Wt^(1/3) - W0^(1/3)) / degree-days
+//
+// TGC needs temperature, taken from the unit's water quality readings or,
+// failing those, the env station linked to the farm.
+
+const aquacultureSchema = `
+CREATE TABLE IF NOT EXISTS aqua_farms (
+	id             SERIAL PRIMARY KEY,
+	name           TEXT NOT NULL UNIQUE,
+	operator       TEXT,
+	region         TEXT,
+	latitude       DOUBLE PRECISION,
+	longitude      DOUBLE PRECISION,
+	env_station_id INTEGER REFERENCES env_stations(id) ON DELETE SET NULL,
+	submitted_by   TEXT NOT NULL,
+	created_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS aqua_units (
+	id         SERIAL PRIMARY KEY,
+	farm_id    INTEGER NOT NULL REFERENCES aqua_farms(id) ON DELETE CASCADE,
+	code       TEXT NOT NULL,
+	unit_type  TEXT NOT NULL CHECK (unit_type IN ('cage', 'pond', 'tank', 'raceway')),
+	volume_m3  DOUBLE PRECISION CHECK (volume_m3 > 0),
+	area_m2    DOUBLE PRECISION CHECK (area_m2 > 0),
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	UNIQUE (farm_id, code)
+);
+
+CREATE TABLE IF NOT EXISTS aqua_cohorts (
+	id                    SERIAL PRIMARY KEY,
+	unit_id               INTEGER NOT NULL REFERENCES aqua_units(id) ON DELETE CASCADE,
+	label                 TEXT NOT NULL,
+	species_id            INTEGER,
+	species_name          TEXT NOT NULL,
+	stocked_on            DATE NOT NULL,
+	initial_count         INTEGER NOT NULL CHECK (initial_count > 0),
+	initial_mean_weight_g DOUBLE PRECISION NOT NULL CHECK (initial_mean_weight_g > 0),
+	status                TEXT NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'harvested')),
+	harvested_on          DATE,
+	harvest_count         INTEGER,
+	harvest_mean_weight_g DOUBLE PRECISION,
+	submitted_by          TEXT NOT NULL,
+	created_at            TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS aqua_cohorts_unit_idx ON aqua_cohorts (unit_id);
+
+CREATE TABLE IF NOT EXISTS aqua_feed (
+	id        SERIAL PRIMARY KEY,
+	cohort_id INTEGER NOT NULL REFERENCES aqua_cohorts(id) ON DELETE CASCADE,
+	fed_on    DATE NOT NULL,
+	feed_kg   DOUBLE PRECISION NOT NULL CHECK (feed_kg >= 0),
+	feed_type TEXT
+);
+CREATE INDEX IF NOT EXISTS aqua_feed_cohort_idx ON aqua_feed (cohort_id, fed_on);
+
+CREATE TABLE IF NOT EXISTS aqua_mortality (
+	id          SERIAL PRIMARY KEY,
+	cohort_id   INTEGER NOT NULL REFERENCES aqua_cohorts(id) ON DELETE CASCADE,
+	recorded_on DATE NOT NULL,
+	dead_count  INTEGER NOT NULL CHECK (dead_count >= 0),
+	cause       TEXT
+);
+CREATE INDEX IF NOT EXISTS aqua_mortality_cohort_idx ON aqua_mortality (cohort_id, recorded_on);
+
+CREATE TABLE IF NOT EXISTS aqua_growth_samples (
+	id             SERIAL PRIMARY KEY,
+	cohort_id      INTEGER NOT NULL REFERENCES aqua_cohorts(id) ON DELETE CASCADE,
+	sampled_on     DATE NOT NULL,
+	sample_size    INTEGER NOT NULL CHECK (sample_size > 0),
+	mean_weight_g  DOUBLE PRECISION NOT NULL CHECK (mean_weight_g > 0),
+	mean_length_cm DOUBLE PRECISION
+);
+CREATE INDEX IF NOT EXISTS aqua_growth_samples_cohort_idx ON aqua_growth_samples (cohort_id, sampled_on);
+
+CREATE TABLE IF NOT EXISTS aqua_water_quality (
+	unit_id     INTEGER NOT NULL REFERENCES aqua_units(id) ON DELETE CASCADE,
+	parameter   TEXT NOT NULL,
+	measured_at TIMESTAMPTZ NOT NULL,
+	value       DOUBLE PRECISION NOT NULL,
+	PRIMARY KEY (unit_id, parameter, measured_at)
+);`
+
+// aquaParameters are the water quality parameters logged per unit: the
+// sensor parameters plus the nitrogen and clarity measurements farms take.
+var aquaParameters = append(append([]EnvParameter{}, envParameters...),
+	EnvParameter{"ammonia_mg_l", "Total ammonia nitrogen", "mg/L"},
+	EnvParameter{"nitrite_mg_l", "Nitrite", "mg/L"},
+	EnvParameter{"turbidity_ntu", "Turbidity", "NTU"},
+)
+
+func isAquaParameter(name string) bool {
+	for _, p := range aquaParameters {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+var aquaUnitTypes = []string{"cage", "pond", "tank", "raceway"}
+
+const (
+	cohortActive    = "active"
+	cohortHarvested = "harvested"
+)
+
+type AquaUnit struct {
+	ID       int           `json:"id"`
+	FarmID   int           `json:"farm_id"`
+	Code     string        `json:"code"`
+	UnitType string        `json:"unit_type"`
+	VolumeM3 *float64      `json:"volume_m3,omitempty"`
+	AreaM2   *float64      `json:"area_m2,omitempty"`
+	Cohorts  []*AquaCohort `json:"cohorts,omitempty"`
+}
+
+type AquaFarm struct {
+	ID        int         `json:"id"`
+	Name      string      `json:"name"`
+	Operator  string      `json:"operator,omitempty"`
+	Region    string      `json:"region,omitempty"`
+	Latitude  *float64    `json:"latitude,omitempty"`
+	Longitude *float64    `json:"longitude,omitempty"`
+	Station   string      `json:"env_station,omitempty"`
+	CreatedAt string      `json:"created_at"`
+	Units     []*AquaUnit `json:"units,omitempty"`
+}
+
+type AquaCohort struct {
+	ID                 int      `json:"id"`
+	UnitID             int      `json:"unit_id"`
+	UnitCode           string   `json:"unit_code"`
+	FarmID             int      `json:"farm_id"`
+	Label              string   `json:"label"`
+	SpeciesID          *int     `json:"species_id"`
+	SpeciesName        string   `json:"species_name"`
+	StockedOn          string   `json:"stocked_on"`
+	InitialCount       int      `json:"initial_count"`
+	InitialMeanWeightG float64  `json:"initial_mean_weight_g"`
+	Status             string   `json:"status"`
+	HarvestedOn        string   `json:"harvested_on,omitempty"`
+	HarvestCount       *int     `json:"harvest_count,omitempty"`
+	HarvestMeanWeightG *float64 `json:"harvest_mean_weight_g,omitempty"`
+	CreatedAt          string   `json:"created_at"`
+}
+
+const aquaFarmColumns = `f.id, f.name, COALESCE(f.operator, ''), COALESCE(f.region, ''), f.latitude, f.longitude,
+	COALESCE((SELECT code FROM env_stations WHERE id = f.env_station_id), ''), f.created_at::text`
+
+func scanAquaFarm(row interface{ Scan(...any) error }) (*AquaFarm, error) {
+	var f AquaFarm
+	var lat, lon sql.NullFloat64
+	if err := row.Scan(&f.ID, &f.Name, &f.Operator, &f.Region, &lat, &lon, &f.Station, &f.CreatedAt); err != nil {
+		return nil, err
+	}
+	f.Latitude, f.Longitude = nullableFloat(lat), nullableFloat(lon)
+	return &f, nil
+}
+
+const aquaUnitColumns = `u.id, u.farm_id, u.code, u.unit_type, u.volume_m3, u.area_m2`
+
+func scanAquaUnit(row interface{ Scan(...any) error }) (*AquaUnit, error) {
+	var u AquaUnit
+	var volume, area sql.NullFloat64
+	if err := row.Scan(&u.ID, &u.FarmID, &u.Code, &u.UnitType, &volume, &area); err != nil {
+		return nil, err
+	}
+	u.VolumeM3, u.AreaM2 = nullableFloat(volume), nullableFloat(area)
+	return &u, nil
+}
+
+const aquaCohortColumns = `c.id, c.unit_id, u.code, u.farm_id, c.label, c.species_id, c.species_name, c.stocked_on::text,
+	c.initial_count, c.initial_mean_weight_g, c.status, COALESCE(c.harvested_on::text, ''), c.harvest_count,
+	c.harvest_mean_weight_g, c.created_at::text`
+
+const aquaCohortFrom = `aqua_cohorts c JOIN aqua_units u ON u.id = c.unit_id`
+
+func scanAquaCohort(row interface{ Scan(...any) error }) (*AquaCohort, error) {
+	var c AquaCohort
+	var speciesID, harvestCount sql.NullInt64
+	var harvestWeight sql.NullFloat64
+	err := row.Scan(&c.ID, &c.UnitID, &c.UnitCode, &c.FarmID, &c.Label, &speciesID, &c.SpeciesName, &c.StockedOn,
+		&c.InitialCount, &c.InitialMeanWeightG, &c.Status, &c.HarvestedOn, &harvestCount, &harvestWeight, &c.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if speciesID.Valid {
+		id := int(speciesID.Int64)
+		c.SpeciesID = &id
+	}
+	if harvestCount.Valid {
+		n := int(harvestCount.Int64)
+		c.HarvestCount = &n
+	}
+	c.HarvestMeanWeightG = nullableFloat(harvestWeight)
+	return &c, nil
+}
+
+func loadAquaCohort(id string) (*AquaCohort, error) {
+	return scanAquaCohort(db.QueryRow("SELECT "+aquaCohortColumns+" FROM "+aquaCohortFrom+" WHERE c.id = $1", id))
+}
+
+// cohortForRequest loads the {id} cohort, writing the error response
+// itself when it returns nil.
+func cohortForRequest(w http.ResponseWriter, r *http.Request) *AquaCohort {
+	c, err := loadAquaCohort(r.PathValue("id"))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Cohort not found", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+	return c
+}
+
+func createAquaFarm(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireRole(w, r, contributorRoles...)
+	if !ok {
+		return
+	}
+	var body struct {
+		Name      string   `json:"name"`
+		Operator  string   `json:"operator"`
+		Region    string   `json:"region"`
+		Latitude  *float64 `json:"latitude"`
+		Longitude *float64 `json:"longitude"`
+		Station   string   `json:"env_station"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	if body.Latitude != nil && math.Abs(*body.Latitude) > 90 || body.Longitude != nil && math.Abs(*body.Longitude) > 180 {
+		http.Error(w, "coordinates must be decimal degrees", http.StatusBadRequest)
+		return
+	}
+	var stationID *int
+	if code := strings.TrimSpace(body.Station); code != "" {
+		st, err := loadEnvStation(code)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Unknown env_station "+code, http.StatusUnprocessableEntity)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		stationID = &st.ID
+	}
+
+	var id int
+	err := db.QueryRow(`INSERT INTO aqua_farms (name, operator, region, latitude, longitude, env_station_id, submitted_by)
+		VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), $4, $5, $6, $7) RETURNING id`,
+		body.Name, strings.TrimSpace(body.Operator), strings.TrimSpace(body.Region), body.Latitude, body.Longitude, stationID, user.ID).Scan(&id)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		http.Error(w, "A farm with that name already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	farm, err := scanAquaFarm(db.QueryRow("SELECT "+aquaFarmColumns+" FROM aqua_farms f WHERE f.id = $1", id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(farm)
+}
+
+func listAquaFarms(w http.ResponseWriter, r *http.Request) {
+	where := []string{"1=1"}
+	args := []interface{}{}
+	for _, f := range []struct{ param, cond string }{
+		{"region", "f.region ILIKE $%d"},
+		{"operator", "f.operator ILIKE $%d"},
+		{"search", "f.name ILIKE '%%' || $%d || '%%'"},
+	} {
+		if v := strings.TrimSpace(r.URL.Query().Get(f.param)); v != "" {
+			args = append(args, v)
+			where = append(where, fmt.Sprintf(f.cond, len(args)))
+		}
+	}
+	page, pageSize := pagination(r)
+	args = append(args, pageSize, (page-1)*pageSize)
+	rows, err := db.Query(fmt.Sprintf("SELECT "+aquaFarmColumns+" FROM aqua_farms f WHERE %s ORDER BY f.name LIMIT $%d OFFSET $%d",
+		strings.Join(where, " AND "), len(args)-1, len(args)), args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer rows.Close()
+	farms := []*AquaFarm{}
+	for rows.Next() {
+		f, err := scanAquaFarm(rows)
+		if err != nil {
+			continue
+		}
+		farms = append(farms, f)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(farms)
+}
+
+// getAquaFarm returns a farm with its units and the cohorts in them.
+func getAquaFarm(w http.ResponseWriter, r *http.Request) {
+	farm, err := scanAquaFarm(db.QueryRow("SELECT "+aquaFarmColumns+" FROM aqua_farms f WHERE f.id = $1", r.PathValue("id")))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Farm not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query("SELECT "+aquaUnitColumns+" FROM aqua_units u WHERE u.farm_id = $1 ORDER BY u.code", farm.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	units := map[int]*AquaUnit{}
+	farm.Units = []*AquaUnit{}
+	for rows.Next() {
+		u, err := scanAquaUnit(rows)
+		if err != nil {
+			continue
+		}
+		u.Cohorts = []*AquaCohort{}
+		units[u.ID] = u
+		farm.Units = append(farm.Units, u)
+	}
+	rows.Close()
+
+	rows, err = db.Query("SELECT "+aquaCohortColumns+" FROM "+aquaCohortFrom+" WHERE u.farm_id = $1 ORDER BY c.stocked_on DESC", farm.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		c, err := scanAquaCohort(rows)
+		if err != nil {
+			continue
+		}
+		if u := units[c.UnitID]; u != nil {
+			u.Cohorts = append(u.Cohorts, c)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(farm)
+}
+
+func createAquaUnit(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireRole(w, r, contributorRoles...); !ok {
+		return
+	}
+	var body struct {
+		Code     string   `json:"code"`
+		UnitType string   `json:"unit_type"`
+		VolumeM3 *float64 `json:"volume_m3"`
+		AreaM2   *float64 `json:"area_m2"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	body.Code = strings.TrimSpace(body.Code)
+	if body.Code == "" || !containsString(aquaUnitTypes, body.UnitType) {
+		http.Error(w, "code and a unit_type of "+strings.Join(aquaUnitTypes, ", ")+" are required", http.StatusBadRequest)
+		return
+	}
+	if body.VolumeM3 != nil && *body.VolumeM3 <= 0 || body.AreaM2 != nil && *body.AreaM2 <= 0 {
+		http.Error(w, "volume_m3 and area_m2 must be positive", http.StatusBadRequest)
+		return
+	}
+
+	u, err := scanAquaUnit(db.QueryRow(`INSERT INTO aqua_units AS u (farm_id, code, unit_type, volume_m3, area_m2)
+		SELECT f.id, $2, $3, $4, $5 FROM aqua_farms f WHERE f.id = $1::int
+		RETURNING `+aquaUnitColumns, r.PathValue("id"), body.Code, body.UnitType, body.VolumeM3, body.AreaM2))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Farm not found", http.StatusNotFound)
+		return
+	}
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		http.Error(w, "The farm already has a unit "+body.Code, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(u)
+}
+
+// createAquaCohort stocks a cohort into the {id} unit. The species is
+// matched against species_data by name when no species_id is given.
+func createAquaCohort(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireRole(w, r, contributorRoles...)
+	if !ok {
+		return
+	}
+	var body struct {
+		Label              string  `json:"label"`
+		SpeciesID          *int    `json:"species_id"`
+		SpeciesName        string  `json:"species_name"`
+		StockedOn          string  `json:"stocked_on"`
+		InitialCount       int     `json:"initial_count"`
+		InitialMeanWeightG float64 `json:"initial_mean_weight_g"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if _, err := time.Parse("2006-01-02", body.StockedOn); err != nil {
+		http.Error(w, "stocked_on must be a YYYY-MM-DD date", http.StatusBadRequest)
+		return
+	}
+	if body.InitialCount <= 0 || body.InitialMeanWeightG <= 0 {
+		http.Error(w, "initial_count and initial_mean_weight_g must be positive", http.StatusBadRequest)
+		return
+	}
+	body.SpeciesName = strings.TrimSpace(body.SpeciesName)
+	if body.SpeciesID != nil {
+		s, err := scanSpecies(db.QueryRow("SELECT "+speciesSelectFields+" FROM species_data s WHERE s.id = $1", *body.SpeciesID))
+		if err == sql.ErrNoRows {
+			http.Error(w, "Unknown species_id", http.StatusUnprocessableEntity)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if body.SpeciesName == "" {
+			body.SpeciesName = s.ScientificName
+		}
+	} else if body.SpeciesName != "" {
+		body.SpeciesID = guessSpeciesID(body.SpeciesName)
+	} else {
+		http.Error(w, "species_id or species_name is required", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(body.Label) == "" {
+		body.Label = body.SpeciesName + " " + body.StockedOn
+	}
+
+	var id int
+	err := db.QueryRow(`INSERT INTO aqua_cohorts (unit_id, label, species_id, species_name, stocked_on, initial_count, initial_mean_weight_g, submitted_by)
+		SELECT u.id, $2, $3, $4, $5::date, $6, $7, $8 FROM aqua_units u WHERE u.id = $1::int RETURNING id`,
+		r.PathValue("id"), strings.TrimSpace(body.Label), body.SpeciesID, body.SpeciesName, body.StockedOn,
+		body.InitialCount, body.InitialMeanWeightG, user.ID).Scan(&id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Unit not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c, err := loadAquaCohort(strconv.Itoa(id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(c)
+}
+
+func listAquaCohorts(w http.ResponseWriter, r *http.Request) {
+	where := []string{"1=1"}
+	args := []interface{}{}
+	for _, f := range []struct{ param, cond string }{
+		{"farm_id", "u.farm_id = $%d::int"},
+		{"unit_id", "c.unit_id = $%d::int"},
+		{"species_id", "c.species_id = $%d::int"},
+		{"status", "c.status = $%d"},
+		{"from", "c.stocked_on >= $%d::date"},
+		{"to", "c.stocked_on <= $%d::date"},
+	} {
+		if v := strings.TrimSpace(r.URL.Query().Get(f.param)); v != "" {
+			args = append(args, v)
+			where = append(where, fmt.Sprintf(f.cond, len(args)))
+		}
+	}
+	page, pageSize := pagination(r)
+	args = append(args, pageSize, (page-1)*pageSize)
+	rows, err := db.Query(fmt.Sprintf("SELECT "+aquaCohortColumns+" FROM "+aquaCohortFrom+" WHERE %s ORDER BY c.stocked_on DESC, c.id DESC LIMIT $%d OFFSET $%d",
+		strings.Join(where, " AND "), len(args)-1, len(args)), args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer rows.Close()
+	cohorts := []*AquaCohort{}
+	for rows.Next() {
+		c, err := scanAquaCohort(rows)
+		if err != nil {
+			continue
+		}
+		cohorts = append(cohorts, c)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cohorts)
+}
+
+func getAquaCohort(w http.ResponseWriter, r *http.Request) {
+	c := cohortForRequest(w, r)
+	if c == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+type FeedRecord struct {
+	Date     string  `json:"date"`
+	FeedKg   float64 `json:"feed_kg"`
+	FeedType string  `json:"feed_type,omitempty"`
+}
+
+type MortalityRecord struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+	Cause string `json:"cause,omitempty"`
+}
+
+type GrowthSample struct {
+	Date         string   `json:"date"`
+	SampleSize   int      `json:"sample_size"`
+	MeanWeightG  float64  `json:"mean_weight_g"`
+	MeanLengthCm *float64 `json:"mean_length_cm,omitempty"`
+}
+
+type CohortRecords struct {
+	Feed      []FeedRecord      `json:"feed"`
+	Mortality []MortalityRecord `json:"mortality"`
+	Samples   []GrowthSample    `json:"samples"`
+}
+
+func loadCohortRecords(cohortID int) (*CohortRecords, error) {
+	recs := &CohortRecords{Feed: []FeedRecord{}, Mortality: []MortalityRecord{}, Samples: []GrowthSample{}}
+	rows, err := db.Query("SELECT fed_on::text, feed_kg, COALESCE(feed_type, '') FROM aqua_feed WHERE cohort_id = $1 ORDER BY fed_on, id", cohortID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var f FeedRecord
+		if err := rows.Scan(&f.Date, &f.FeedKg, &f.FeedType); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		recs.Feed = append(recs.Feed, f)
+	}
+	rows.Close()
+
+	rows, err = db.Query("SELECT recorded_on::text, dead_count, COALESCE(cause, '') FROM aqua_mortality WHERE cohort_id = $1 ORDER BY recorded_on, id", cohortID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var m MortalityRecord
+		if err := rows.Scan(&m.Date, &m.Count, &m.Cause); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		recs.Mortality = append(recs.Mortality, m)
+	}
+	rows.Close()
+
+	rows, err = db.Query("SELECT sampled_on::text, sample_size, mean_weight_g, mean_length_cm FROM aqua_growth_samples WHERE cohort_id = $1 ORDER BY sampled_on, id", cohortID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s GrowthSample
+		var length sql.NullFloat64
+		if err := rows.Scan(&s.Date, &s.SampleSize, &s.MeanWeightG, &length); err != nil {
+			return nil, err
+		}
+		s.MeanLengthCm = nullableFloat(length)
+		recs.Samples = append(recs.Samples, s)
+	}
+	return recs, rows.Err()
+}
+
+func getCohortRecords(w http.ResponseWriter, r *http.Request) {
+	c := cohortForRequest(w, r)
+	if c == nil {
+		return
+	}
+	recs, err := loadCohortRecords(c.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recs)
+}
+
+// addCohortRecords appends feed, mortality and growth sample records to a
+// cohort. Dates must fall within the cohort's time in the unit, and
+// deaths may not exceed the fish stocked.
+func addCohortRecords(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireRole(w, r, contributorRoles...); !ok {
+		return
+	}
+	c := cohortForRequest(w, r)
+	if c == nil {
+		return
+	}
+	var body CohortRecords
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if len(body.Feed)+len(body.Mortality)+len(body.Samples) == 0 {
+		http.Error(w, "No feed, mortality or samples given", http.StatusBadRequest)
+		return
+	}
+	inRange := func(kind string, i int, date string) error {
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			return fmt.Errorf("%s %d: date must be a YYYY-MM-DD date", kind, i+1)
+		}
+		if date < c.StockedOn || c.HarvestedOn != "" && date > c.HarvestedOn {
+			return fmt.Errorf("%s %d: %s is outside the cohort's stocking period", kind, i+1, date)
+		}
+		return nil
+	}
+	var dead int
+	if err := db.QueryRow("SELECT COALESCE(SUM(dead_count), 0) FROM aqua_mortality WHERE cohort_id = $1", c.ID).Scan(&dead); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	for i, f := range body.Feed {
+		err := inRange("feed", i, f.Date)
+		if err == nil && f.FeedKg < 0 {
+			err = fmt.Errorf("feed %d: feed_kg must not be negative", i+1)
+		}
+		if err == nil {
+			_, err = tx.Exec("INSERT INTO aqua_feed (cohort_id, fed_on, feed_kg, feed_type) VALUES ($1, $2::date, $3, NULLIF($4, ''))",
+				c.ID, f.Date, f.FeedKg, strings.TrimSpace(f.FeedType))
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	for i, m := range body.Mortality {
+		err := inRange("mortality", i, m.Date)
+		if err == nil && m.Count < 0 {
+			err = fmt.Errorf("mortality %d: count must not be negative", i+1)
+		}
+		if dead += m.Count; err == nil && dead > c.InitialCount {
+			err = fmt.Errorf("mortality %d: total deaths %d exceed the %d fish stocked", i+1, dead, c.InitialCount)
+		}
+		if err == nil {
+			_, err = tx.Exec("INSERT INTO aqua_mortality (cohort_id, recorded_on, dead_count, cause) VALUES ($1, $2::date, $3, NULLIF($4, ''))",
+				c.ID, m.Date, m.Count, strings.TrimSpace(m.Cause))
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	for i, s := range body.Samples {
+		err := inRange("sample", i, s.Date)
+		if err == nil && (s.SampleSize <= 0 || s.MeanWeightG <= 0) {
+			err = fmt.Errorf("sample %d: sample_size and mean_weight_g must be positive", i+1)
+		}
+		if err == nil {
+			_, err = tx.Exec(`INSERT INTO aqua_growth_samples (cohort_id, sampled_on, sample_size, mean_weight_g, mean_length_cm)
+				VALUES ($1, $2::date, $3, $4, $5)`, c.ID, s.Date, s.SampleSize, s.MeanWeightG, s.MeanLengthCm)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]int{
+		"feed":      len(body.Feed),
+		"mortality": len(body.Mortality),
+		"samples":   len(body.Samples),
+	})
+}
+
+// harvestAquaCohort closes a cohort with the number and mean weight of
+// fish harvested. The difference from the fish left after recorded deaths
+// shows up as unrecorded losses in its performance.
+func harvestAquaCohort(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireRole(w, r, contributorRoles...); !ok {
+		return
+	}
+	c := cohortForRequest(w, r)
+	if c == nil {
+		return
+	}
+	var body struct {
+		Date        string  `json:"date"`
+		Count       int     `json:"count"`
+		MeanWeightG float64 `json:"mean_weight_g"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if c.Status == cohortHarvested {
+		http.Error(w, "Cohort was already harvested on "+c.HarvestedOn, http.StatusConflict)
+		return
+	}
+	if _, err := time.Parse("2006-01-02", body.Date); err != nil || body.Date < c.StockedOn {
+		http.Error(w, "date must be a YYYY-MM-DD date on or after stocking", http.StatusBadRequest)
+		return
+	}
+	if body.Count < 0 || body.MeanWeightG <= 0 {
+		http.Error(w, "count must not be negative and mean_weight_g must be positive", http.StatusBadRequest)
+		return
+	}
+	var dead int
+	var last sql.NullString
+	err := db.QueryRow(`SELECT (SELECT COALESCE(SUM(dead_count), 0) FROM aqua_mortality WHERE cohort_id = $1),
+		(SELECT MAX(d)::text FROM (
+			SELECT fed_on AS d FROM aqua_feed WHERE cohort_id = $1
+			UNION ALL SELECT recorded_on FROM aqua_mortality WHERE cohort_id = $1
+			UNION ALL SELECT sampled_on FROM aqua_growth_samples WHERE cohort_id = $1
+		) x)`, c.ID).Scan(&dead, &last)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if body.Count > c.InitialCount-dead {
+		http.Error(w, fmt.Sprintf("count %d exceeds the %d fish left after recorded deaths", body.Count, c.InitialCount-dead), http.StatusUnprocessableEntity)
+		return
+	}
+	if last.Valid && last.String > body.Date {
+		http.Error(w, "There are records after "+body.Date, http.StatusUnprocessableEntity)
+		return
+	}
+
+	_, err = db.Exec(`UPDATE aqua_cohorts SET status = $2, harvested_on = $3::date, harvest_count = $4, harvest_mean_weight_g = $5
+		WHERE id = $1`, c.ID, cohortHarvested, body.Date, body.Count, body.MeanWeightG)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c, err = loadAquaCohort(strconv.Itoa(c.ID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+type WaterReading struct {
+	Parameter  string  `json:"parameter"`
+	MeasuredAt string  `json:"measured_at"`
+	Value      float64 `json:"value"`
+}
+
+// addWaterQuality stores readings for the {id} unit. Times are RFC 3339
+// or plain dates; a repeated parameter and time overwrites the value.
+func addWaterQuality(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireRole(w, r, contributorRoles...); !ok {
+		return
+	}
+	var body struct {
+		Readings []WaterReading `json:"readings"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if len(body.Readings) == 0 {
+		http.Error(w, "readings are required", http.StatusBadRequest)
+		return
+	}
+	var unitID int
+	err := db.QueryRow("SELECT id FROM aqua_units WHERE id = $1::int", r.PathValue("id")).Scan(&unitID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Unit not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	for i, rd := range body.Readings {
+		if !isAquaParameter(rd.Parameter) {
+			http.Error(w, fmt.Sprintf("reading %d: unknown parameter %q", i+1, rd.Parameter), http.StatusBadRequest)
+			return
+		}
+		at, err := time.Parse(time.RFC3339, rd.MeasuredAt)
+		if err != nil {
+			at, err = time.Parse("2006-01-02", rd.MeasuredAt)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("reading %d: measured_at must be an RFC 3339 time or a date", i+1), http.StatusBadRequest)
+			return
+		}
+		if _, err := tx.Exec(`INSERT INTO aqua_water_quality (unit_id, parameter, measured_at, value) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (unit_id, parameter, measured_at) DO UPDATE SET value = EXCLUDED.value`,
+			unitID, rd.Parameter, at, rd.Value); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]int{"readings": len(body.Readings)})
+}
+
+// getWaterQuality returns the {id} unit's readings, optionally for one
+// ?parameter= between ?from= and ?to=, with a summary per parameter.
+func getWaterQuality(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	where := []string{"unit_id = $1::int"}
+	args := []interface{}{r.PathValue("id")}
+	if p := q.Get("parameter"); p != "" {
+		if !isAquaParameter(p) {
+			http.Error(w, "Unknown parameter "+p, http.StatusBadRequest)
+			return
+		}
+		args = append(args, p)
+		where = append(where, fmt.Sprintf("parameter = $%d", len(args)))
+	}
+	for _, f := range []struct{ param, cond string }{{"from", "measured_at >= $%d"}, {"to", "measured_at <= $%d"}} {
+		if v := q.Get(f.param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				t, err = time.Parse("2006-01-02", v)
+			}
+			if err != nil {
+				http.Error(w, f.param+" must be an RFC 3339 time or a date", http.StatusBadRequest)
+				return
+			}
+			args = append(args, t)
+			where = append(where, fmt.Sprintf(f.cond, len(args)))
+		}
+	}
+	rows, err := db.Query(`SELECT parameter, measured_at, value FROM aqua_water_quality WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY measured_at, parameter LIMIT 10000`, args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer rows.Close()
+
+	type summary struct {
+		Count int     `json:"count"`
+		Min   float64 `json:"min"`
+		Mean  float64 `json:"mean"`
+		Max   float64 `json:"max"`
+	}
+	readings := []WaterReading{}
+	summaries := map[string]*summary{}
+	for rows.Next() {
+		var rd WaterReading
+		var at time.Time
+		if err := rows.Scan(&rd.Parameter, &at, &rd.Value); err != nil {
+			continue
+		}
+		rd.MeasuredAt = at.UTC().Format(time.RFC3339)
+		readings = append(readings, rd)
+		s := summaries[rd.Parameter]
+		if s == nil {
+			s = &summary{Min: rd.Value, Max: rd.Value}
+			summaries[rd.Parameter] = s
+		}
+		s.Count++
+		s.Mean += rd.Value
+		s.Min, s.Max = math.Min(s.Min, rd.Value), math.Max(s.Max, rd.Value)
+	}
+	for _, s := range summaries {
+		s.Mean /= float64(s.Count)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"readings": readings, "summary": summaries})
+}
+
+// cohortModel tracks numbers, weight and feed of a cohort through time.
+type cohortModel struct {
+	start, end time.Time
+	initial    int
+	final      int // harvest count, or fish alive at end
+	volume     *float64
+	area       *float64
+	weights    []weightPoint
+	deaths     []datedValue
+	feed       []datedValue
+}
+
+type weightPoint struct {
+	t time.Time
+	w float64
+}
+
+type datedValue struct {
+	t time.Time
+	v float64
+}
+
+func isoDay(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+// newCohortModel builds the model from a cohort and its records. An
+// active cohort runs to its latest record.
+func newCohortModel(c *AquaCohort, u *AquaUnit, recs *CohortRecords) *cohortModel {
+	m := &cohortModel{start: isoDay(c.StockedOn), initial: c.InitialCount}
+	if u != nil {
+		m.volume, m.area = u.VolumeM3, u.AreaM2
+	}
+	m.weights = []weightPoint{{m.start, c.InitialMeanWeightG}}
+	m.end = m.start
+	for _, s := range recs.Samples {
+		t := isoDay(s.Date)
+		if last := &m.weights[len(m.weights)-1]; last.t.Equal(t) {
+			last.w = s.MeanWeightG
+		} else {
+			m.weights = append(m.weights, weightPoint{t, s.MeanWeightG})
+		}
+		m.end = maxTime(m.end, t)
+	}
+	for _, d := range recs.Mortality {
+		m.deaths = append(m.deaths, datedValue{isoDay(d.Date), float64(d.Count)})
+		m.end = maxTime(m.end, isoDay(d.Date))
+	}
+	for _, f := range recs.Feed {
+		m.feed = append(m.feed, datedValue{isoDay(f.Date), f.FeedKg})
+		m.end = maxTime(m.end, isoDay(f.Date))
+	}
+	if c.HarvestedOn != "" {
+		m.end = isoDay(c.HarvestedOn)
+		if c.HarvestMeanWeightG != nil {
+			if last := &m.weights[len(m.weights)-1]; last.t.Equal(m.end) {
+				last.w = *c.HarvestMeanWeightG
+			} else {
+				m.weights = append(m.weights, weightPoint{m.end, *c.HarvestMeanWeightG})
+			}
+		}
+	}
+	m.final = m.alive(m.end)
+	if c.HarvestCount != nil {
+		m.final = *c.HarvestCount
+	}
+	return m
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+// weight is the mean weight in grams on day t, interpolated exponentially
+// between weighings and held flat outside them.
+func (m *cohortModel) weight(t time.Time) float64 {
+	ws := m.weights
+	if !t.After(ws[0].t) {
+		return ws[0].w
+	}
+	for i := 1; i < len(ws); i++ {
+		if !t.After(ws[i].t) {
+			f := t.Sub(ws[i-1].t).Hours() / ws[i].t.Sub(ws[i-1].t).Hours()
+			return math.Exp(math.Log(ws[i-1].w) + f*(math.Log(ws[i].w)-math.Log(ws[i-1].w)))
+		}
+	}
+	return ws[len(ws)-1].w
+}
+
+// alive is the number stocked less deaths recorded up to and including t.
+func (m *cohortModel) alive(t time.Time) int {
+	n := m.initial
+	for _, d := range m.deaths {
+		if !d.t.After(t) {
+			n -= int(d.v)
+		}
+	}
+	return n
+}
+
+func sumBetween(ds []datedValue, from, to time.Time) float64 {
+	var s float64
+	for _, d := range ds {
+		if d.t.After(from) && !d.t.After(to) {
+			s += d.v
+		}
+	}
+	return s
+}
+
+// deadBiomass is the weight in kg of the fish that died after from and
+// up to to, each at the cohort's mean weight on the day.
+func (m *cohortModel) deadBiomass(from, to time.Time) float64 {
+	var kg float64
+	for _, d := range m.deaths {
+		if d.t.After(from) && !d.t.After(to) {
+			kg += d.v * m.weight(d.t) / 1000
+		}
+	}
+	return kg
+}
+
+func (m *cohortModel) density(biomassKg float64) (perM3, perM2 *float64) {
+	if m.volume != nil {
+		v := biomassKg / *m.volume
+		perM3 = &v
+	}
+	if m.area != nil {
+		v := biomassKg / *m.area
+		perM2 = &v
+	}
+	return
+}
+
+func safeRatio(num, den float64) *float64 {
+	if den <= 0 || math.IsNaN(num/den) {
+		return nil
+	}
+	v := num / den
+	return &v
+}
+
+type CohortPeriod struct {
+	From             string   `json:"from"`
+	To               string   `json:"to"`
+	Days             int      `json:"days"`
+	StartWeightG     float64  `json:"start_weight_g"`
+	EndWeightG       float64  `json:"end_weight_g"`
+	StartCount       int      `json:"start_count"`
+	EndCount         int      `json:"end_count"`
+	Deaths           int      `json:"deaths"`
+	FeedKg           float64  `json:"feed_kg"`
+	BiomassGainKg    float64  `json:"biomass_gain_kg"`
+	FCR              *float64 `json:"fcr"`
+	SGR              *float64 `json:"sgr_pct_per_day"`
+	MeanTemperatureC *float64 `json:"mean_temperature_c,omitempty"`
+}
+
+type CohortPerformance struct {
+	Cohort             *AquaCohort     `json:"cohort"`
+	From               string          `json:"from"`
+	To                 string          `json:"to"`
+	Days               int             `json:"days"`
+	StockedCount       int             `json:"stocked_count"`
+	RecordedDeaths     int             `json:"recorded_deaths"`
+	UnrecordedLosses   int             `json:"unrecorded_losses"`
+	FinalCount         int             `json:"final_count"`
+	Survival           float64         `json:"survival"`
+	DailyMortalityRate *float64        `json:"daily_mortality_rate"`
+	InitialWeightG     float64         `json:"initial_weight_g"`
+	FinalWeightG       float64         `json:"final_weight_g"`
+	StockedBiomassKg   float64         `json:"stocked_biomass_kg"`
+	FinalBiomassKg     float64         `json:"final_biomass_kg"`
+	DeadBiomassKg      float64         `json:"dead_biomass_kg"`
+	DensityKgM3        *float64        `json:"density_kg_m3,omitempty"`
+	DensityKgM2        *float64        `json:"density_kg_m2,omitempty"`
+	FeedKg             float64         `json:"feed_kg"`
+	EconomicFCR        *float64        `json:"economic_fcr"`
+	BiologicalFCR      *float64        `json:"biological_fcr"`
+	SGR                *float64        `json:"sgr_pct_per_day"`
+	DailyGainG         *float64        `json:"daily_weight_gain_g"`
+	DegreeDays         *float64        `json:"degree_days,omitempty"`
+	TGC                *float64        `json:"tgc,omitempty"`
+	ProjectedHarvest   string          `json:"projected_harvest_date,omitempty"`
+	Periods            []*CohortPeriod `json:"periods"`
+}
+
+// dailyTemperature maps days to mean water temperature.
+type dailyTemperature map[time.Time]float64
+
+// degreeDays sums temperature over the days after from up to to, filling
+// days without a reading with the mean of those with one. ok is false
+// when there are no readings in the range.
+func (dt dailyTemperature) degreeDays(from, to time.Time) (sum float64, mean float64, ok bool) {
+	var n int
+	for t, v := range dt {
+		if t.After(from) && !t.After(to) {
+			sum += v
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, 0, false
+	}
+	mean = sum / float64(n)
+	return mean * to.Sub(from).Hours() / 24, mean, true
+}
+
+// loadDailyTemperature reads daily mean temperature for the unit, or for
+// the farm's env station when the unit has no temperature readings.
+func loadDailyTemperature(unitID int, from, to time.Time) (dailyTemperature, error) {
+	dt := dailyTemperature{}
+	queries := []string{
+		`SELECT date_trunc('day', measured_at AT TIME ZONE 'UTC'), AVG(value) FROM aqua_water_quality
+			WHERE unit_id = $1 AND parameter = 'temperature_c' AND measured_at >= $2 AND measured_at < $3 GROUP BY 1`,
+		`SELECT date_trunc('day', o.observed_at AT TIME ZONE 'UTC'), AVG(o.value) FROM env_observations o
+			JOIN aqua_farms f ON f.env_station_id = o.station_id JOIN aqua_units u ON u.farm_id = f.id
+			WHERE u.id = $1 AND o.parameter = 'temperature_c' AND o.observed_at >= $2 AND o.observed_at < $3 GROUP BY 1`,
+	}
+	for _, query := range queries {
+		rows, err := db.Query(query, unitID, from, to.AddDate(0, 0, 1))
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var t time.Time
+			var v float64
+			if err := rows.Scan(&t, &v); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			dt[time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)] = v
+		}
+		rows.Close()
+		if len(dt) > 0 {
+			break
+		}
+	}
+	return dt, nil
+}
+
+// performance computes the cohort's summary and its growth periods between
+// weighings. targetWeight, when above the current weight of an active
+// cohort, projects a harvest date at the cohort's SGR so far.
+func (m *cohortModel) performance(temps dailyTemperature, targetWeight float64) *CohortPerformance {
+	days := int(m.end.Sub(m.start).Hours() / 24)
+	p := &CohortPerformance{
+		From:           m.start.Format("2006-01-02"),
+		To:             m.end.Format("2006-01-02"),
+		Days:           days,
+		StockedCount:   m.initial,
+		RecordedDeaths: m.initial - m.alive(m.end),
+		FinalCount:     m.final,
+		Survival:       float64(m.final) / float64(m.initial),
+		InitialWeightG: m.weights[0].w,
+		FinalWeightG:   m.weight(m.end),
+		FeedKg:         sumBetween(m.feed, m.start.AddDate(0, 0, -1), m.end),
+		Periods:        []*CohortPeriod{},
+	}
+	p.UnrecordedLosses = m.alive(m.end) - m.final
+	p.StockedBiomassKg = float64(m.initial) * p.InitialWeightG / 1000
+	p.FinalBiomassKg = float64(m.final) * p.FinalWeightG / 1000
+	p.DeadBiomassKg = m.deadBiomass(m.start.AddDate(0, 0, -1), m.end)
+	p.DensityKgM3, p.DensityKgM2 = m.density(p.FinalBiomassKg)
+	p.EconomicFCR = safeRatio(p.FeedKg, p.FinalBiomassKg-p.StockedBiomassKg)
+	p.BiologicalFCR = safeRatio(p.FeedKg, p.FinalBiomassKg+p.DeadBiomassKg-p.StockedBiomassKg)
+	if days > 0 {
+		sgr := 100 * (math.Log(p.FinalWeightG) - math.Log(p.InitialWeightG)) / float64(days)
+		gain := (p.FinalWeightG - p.InitialWeightG) / float64(days)
+		p.SGR, p.DailyGainG = &sgr, &gain
+		if p.Survival > 0 {
+			z := -math.Log(p.Survival) / float64(days)
+			p.DailyMortalityRate = &z
+		}
+	}
+	if dd, _, ok := temps.degreeDays(m.start, m.end); ok && dd > 0 {
+		tgc := 1000 * (math.Cbrt(p.FinalWeightG) - math.Cbrt(p.InitialWeightG)) / dd
+		p.DegreeDays, p.TGC = &dd, &tgc
+	}
+	if targetWeight > p.FinalWeightG && p.SGR != nil && *p.SGR > 0 {
+		toGo := math.Log(targetWeight/p.FinalWeightG) / (*p.SGR / 100)
+		p.ProjectedHarvest = m.end.AddDate(0, 0, int(math.Ceil(toGo))).Format("2006-01-02")
+	}
+
+	for i := 1; i < len(m.weights); i++ {
+		a, b := m.weights[i-1], m.weights[i]
+		per := &CohortPeriod{
+			From:         a.t.Format("2006-01-02"),
+			To:           b.t.Format("2006-01-02"),
+			Days:         int(b.t.Sub(a.t).Hours() / 24),
+			StartWeightG: a.w,
+			EndWeightG:   b.w,
+			StartCount:   m.alive(a.t),
+			EndCount:     m.alive(b.t),
+			FeedKg:       sumBetween(m.feed, a.t, b.t),
+		}
+		if i == 1 {
+			// Feed and deaths on stocking day belong to the first period.
+			per.FeedKg = sumBetween(m.feed, a.t.AddDate(0, 0, -1), b.t)
+			per.StartCount = m.initial
+		}
+		per.Deaths = per.StartCount - per.EndCount
+		if b.t.Equal(m.end) {
+			per.EndCount = m.final
+		}
+		per.BiomassGainKg = (float64(per.EndCount)*b.w - float64(per.StartCount)*a.w) / 1000
+		if i == 1 {
+			per.BiomassGainKg += m.deadBiomass(a.t.AddDate(0, 0, -1), b.t)
+		} else {
+			per.BiomassGainKg += m.deadBiomass(a.t, b.t)
+		}
+		per.FCR = safeRatio(per.FeedKg, per.BiomassGainKg)
+		if per.Days > 0 {
+			sgr := 100 * (math.Log(b.w) - math.Log(a.w)) / float64(per.Days)
+			per.SGR = &sgr
+		}
+		if _, mean, ok := temps.degreeDays(a.t, b.t); ok {
+			per.MeanTemperatureC = &mean
+		}
+		p.Periods = append(p.Periods, per)
+	}
+	return p
+}
+
+type CohortDay struct {
+	Date          string   `json:"date"`
+	Alive         int      `json:"alive"`
+	MeanWeightG   float64  `json:"mean_weight_g"`
+	BiomassKg     float64  `json:"biomass_kg"`
+	DensityKgM3   *float64 `json:"density_kg_m3,omitempty"`
+	DensityKgM2   *float64 `json:"density_kg_m2,omitempty"`
+	FeedKg        float64  `json:"feed_kg"`
+	CumulativeFed float64  `json:"cumulative_feed_kg"`
+	Deaths        int      `json:"deaths"`
+}
+
+// series steps through the cohort every step days, reporting numbers,
+// weight, biomass and stocking density on each day and the feed and
+// deaths since the previous one.
+func (m *cohortModel) series(step int) []CohortDay {
+	out := []CohortDay{}
+	prev := m.start.AddDate(0, 0, -1)
+	var fed float64
+	for t := m.start; ; t = t.AddDate(0, 0, step) {
+		if t.After(m.end) {
+			t = m.end
+		}
+		d := CohortDay{Date: t.Format("2006-01-02"), Alive: m.alive(t), MeanWeightG: m.weight(t)}
+		if t.Equal(m.end) {
+			d.Alive = m.final
+		}
+		d.BiomassKg = float64(d.Alive) * d.MeanWeightG / 1000
+		d.DensityKgM3, d.DensityKgM2 = m.density(d.BiomassKg)
+		d.FeedKg = sumBetween(m.feed, prev, t)
+		fed += d.FeedKg
+		d.CumulativeFed = fed
+		d.Deaths = int(sumBetween(m.deaths, prev, t))
+		out = append(out, d)
+		if !t.Before(m.end) {
+			return out
+		}
+		prev = t
+	}
+}
+
+// cohortModelForRequest loads the {id} cohort with its unit and records,
+// writing the error response itself when it returns nil.
+func cohortModelForRequest(w http.ResponseWriter, r *http.Request) (*AquaCohort, *cohortModel) {
+	c := cohortForRequest(w, r)
+	if c == nil {
+		return nil, nil
+	}
+	u, err := scanAquaUnit(db.QueryRow("SELECT "+aquaUnitColumns+" FROM aqua_units u WHERE u.id = $1", c.UnitID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, nil
+	}
+	recs, err := loadCohortRecords(c.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, nil
+	}
+	return c, newCohortModel(c, u, recs)
+}
+
+// getCohortPerformance returns FCR, survival and growth for a cohort;
+// ?target_weight_g= adds a projected harvest date.
+func getCohortPerformance(w http.ResponseWriter, r *http.Request) {
+	var target float64
+	if v := r.URL.Query().Get("target_weight_g"); v != "" {
+		t, err := strconv.ParseFloat(v, 64)
+		if err != nil || t <= 0 {
+			http.Error(w, "target_weight_g must be a positive number", http.StatusBadRequest)
+			return
+		}
+		target = t
+	}
+	c, m := cohortModelForRequest(w, r)
+	if m == nil {
+		return
+	}
+	temps, err := loadDailyTemperature(c.UnitID, m.start, m.end)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	p := m.performance(temps, target)
+	p.Cohort = c
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+// getCohortSeries returns the cohort's numbers, biomass, stocking density
+// and feed every ?step_days= days (default 7).
+func getCohortSeries(w http.ResponseWriter, r *http.Request) {
+	step := 7
+	if v := r.URL.Query().Get("step_days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 365 {
+			http.Error(w, "step_days must be between 1 and 365", http.StatusBadRequest)
+			return
+		}
+		step = n
+	}
+	c, m := cohortModelForRequest(w, r)
+	if m == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"cohort": c, "step_days": step, "series": m.series(step)})
+}
+
+// getFarmPerformance summarises every cohort on a farm, optionally only
+// those with ?status=.
+func getFarmPerformance(w http.ResponseWriter, r *http.Request) {
+	farm, err := scanAquaFarm(db.QueryRow("SELECT "+aquaFarmColumns+" FROM aqua_farms f WHERE f.id = $1", r.PathValue("id")))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Farm not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" && status != cohortActive && status != cohortHarvested {
+		http.Error(w, "status must be active or harvested", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query("SELECT "+aquaUnitColumns+" FROM aqua_units u WHERE u.farm_id = $1", farm.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	units := map[int]*AquaUnit{}
+	for rows.Next() {
+		if u, err := scanAquaUnit(rows); err == nil {
+			units[u.ID] = u
+		}
+	}
+	rows.Close()
+
+	rows, err = db.Query("SELECT "+aquaCohortColumns+" FROM "+aquaCohortFrom+" WHERE u.farm_id = $1 AND ($2 = '' OR c.status = $2) ORDER BY c.stocked_on",
+		farm.ID, status)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var cohorts []*AquaCohort
+	for rows.Next() {
+		if c, err := scanAquaCohort(rows); err == nil {
+			cohorts = append(cohorts, c)
+		}
+	}
+	rows.Close()
+
+	out := []*CohortPerformance{}
+	var feed, gain float64
+	var stocked, final int
+	for _, c := range cohorts {
+		recs, err := loadCohortRecords(c.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		m := newCohortModel(c, units[c.UnitID], recs)
+		temps, err := loadDailyTemperature(c.UnitID, m.start, m.end)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		p := m.performance(temps, 0)
+		p.Cohort = c
+		p.Periods = nil
+		out = append(out, p)
+		feed += p.FeedKg
+		gain += p.FinalBiomassKg - p.StockedBiomassKg
+		stocked += p.StockedCount
+		final += p.FinalCount
+	}
+	var survival *float64
+	if stocked > 0 {
+		s := float64(final) / float64(stocked)
+		survival = &s
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Cohort.Status < out[j].Cohort.Status })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"farm":         farm,
+		"cohorts":      out,
+		"feed_kg":      feed,
+		"economic_fcr": safeRatio(feed, gain),
+		"survival":     survival,
+	})
+}
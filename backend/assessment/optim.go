@@ -0,0 +1,125 @@
+package assessment
+
+import (
+	"math"
+	"sort"
+)
+
+// minimize runs Nelder-Mead from x0 with initial simplex steps, stopping
+// when the objective spread across the simplex falls below tol.
+func minimize(f func([]float64) float64, x0, step []float64, maxIter int, tol float64) ([]float64, float64) {
+	n := len(x0)
+	type vertex struct {
+		x []float64
+		v float64
+	}
+	simplex := make([]vertex, n+1)
+	simplex[0] = vertex{append([]float64(nil), x0...), f(x0)}
+	for i := 0; i < n; i++ {
+		x := append([]float64(nil), x0...)
+		x[i] += step[i]
+		simplex[i+1] = vertex{x, f(x)}
+	}
+
+	point := func(c []float64, towards []float64, t float64) []float64 {
+		x := make([]float64, n)
+		for i := range x {
+			x[i] = c[i] + t*(towards[i]-c[i])
+		}
+		return x
+	}
+
+	for iter := 0; iter < maxIter; iter++ {
+		sort.Slice(simplex, func(i, j int) bool { return simplex[i].v < simplex[j].v })
+		if math.Abs(simplex[n].v-simplex[0].v) <= tol*(math.Abs(simplex[0].v)+tol) {
+			break
+		}
+
+		centroid := make([]float64, n)
+		for _, s := range simplex[:n] {
+			for i := range centroid {
+				centroid[i] += s.x[i] / float64(n)
+			}
+		}
+		worst := simplex[n]
+
+		xr := point(centroid, worst.x, -1)
+		vr := f(xr)
+		switch {
+		case vr < simplex[0].v:
+			xe := point(centroid, worst.x, -2)
+			if ve := f(xe); ve < vr {
+				simplex[n] = vertex{xe, ve}
+			} else {
+				simplex[n] = vertex{xr, vr}
+			}
+		case vr < simplex[n-1].v:
+			simplex[n] = vertex{xr, vr}
+		default:
+			xc := point(centroid, worst.x, 0.5)
+			if vr < worst.v {
+				xc = point(centroid, xr, 0.5)
+			}
+			if vc := f(xc); vc < math.Min(vr, worst.v) {
+				simplex[n] = vertex{xc, vc}
+				continue
+			}
+			for i := 1; i <= n; i++ {
+				x := point(simplex[0].x, simplex[i].x, 0.5)
+				simplex[i] = vertex{x, f(x)}
+			}
+		}
+	}
+	sort.Slice(simplex, func(i, j int) bool { return simplex[i].v < simplex[j].v })
+	return simplex[0].x, simplex[0].v
+}
+
+// bisect finds a root of f on [lo, hi], assuming f changes sign there.
+func bisect(f func(float64) float64, lo, hi float64) float64 {
+	flo := f(lo)
+	for i := 0; i < 80; i++ {
+		mid := (lo + hi) / 2
+		fm := f(mid)
+		if (fm > 0) == (flo > 0) {
+			lo, flo = mid, fm
+		} else {
+			hi = mid
+		}
+	}
+	return (lo + hi) / 2
+}
+
+// gridMax brackets the maximum of f on [lo, hi] with an n-point grid and
+// refines it by golden section, so flat stretches such as zero yield past
+// a crash do not mislead the search.
+func gridMax(f func(float64) float64, lo, hi float64, n int) float64 {
+	step := (hi - lo) / float64(n)
+	best, bestV := 0, math.Inf(-1)
+	for i := 0; i <= n; i++ {
+		if v := f(lo + step*float64(i)); v > bestV {
+			best, bestV = i, v
+		}
+	}
+	a := lo + step*float64(max(best-1, 0))
+	b := lo + step*float64(min(best+1, n))
+	return goldenMax(f, a, b)
+}
+
+// goldenMax maximises a unimodal f on [lo, hi].
+func goldenMax(f func(float64) float64, lo, hi float64) float64 {
+	g := (math.Sqrt(5) - 1) / 2
+	a, b := hi-g*(hi-lo), lo+g*(hi-lo)
+	fa, fb := f(a), f(b)
+	for i := 0; i < 100; i++ {
+		if fa > fb {
+			hi, b, fb = b, a, fa
+			a = hi - g*(hi-lo)
+			fa = f(a)
+		} else {
+			lo, a, fa = a, b, fb
+			b = lo + g*(hi-lo)
+			fb = f(b)
+		}
+	}
+	return (lo + hi) / 2
+}
@@ -0,0 +1,53 @@
+package assessment
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMinimize(t *testing.T) {
+	tests := []struct {
+		name string
+		f    func([]float64) float64
+		x0   []float64
+		want []float64
+	}{
+		{"quadratic", func(x []float64) float64 { return (x[0]-3)*(x[0]-3) + 10*(x[1]+1)*(x[1]+1) }, []float64{0, 0}, []float64{3, -1}},
+		{"rosenbrock", func(x []float64) float64 {
+			return 100*(x[1]-x[0]*x[0])*(x[1]-x[0]*x[0]) + (1-x[0])*(1-x[0])
+		}, []float64{-1.2, 1}, []float64{1, 1}},
+		{"three dimensions", func(x []float64) float64 {
+			return math.Pow(x[0]-1, 2) + math.Pow(x[1]-2, 2) + math.Pow(x[2]-3, 2)
+		}, []float64{0, 0, 0}, []float64{1, 2, 3}},
+	}
+	for _, tt := range tests {
+		step := make([]float64, len(tt.x0))
+		for i := range step {
+			step[i] = 0.5
+		}
+		x, v := minimize(tt.f, tt.x0, step, 5000, 1e-14)
+		for i := range x {
+			if math.Abs(x[i]-tt.want[i]) > 1e-3 {
+				t.Errorf("%s: got %v (f=%g), want %v", tt.name, x, v, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestBisectAndGoldenMax(t *testing.T) {
+	if root := bisect(func(x float64) float64 { return x*x - 2 }, 0, 2); math.Abs(root-math.Sqrt2) > 1e-9 {
+		t.Errorf("bisect: got %g, want √2", root)
+	}
+	if root := bisect(func(x float64) float64 { return math.Cos(x) }, 0, 3); math.Abs(root-math.Pi/2) > 1e-9 {
+		t.Errorf("bisect: got %g, want π/2", root)
+	}
+	if x := goldenMax(func(x float64) float64 { return -(x - 0.7) * (x - 0.7) }, 0, 2); math.Abs(x-0.7) > 1e-6 {
+		t.Errorf("goldenMax: got %g, want 0.7", x)
+	}
+	// A flat zero stretch must not hide the peak from gridMax.
+	f := func(x float64) float64 { return math.Max(0, x*(1-x)) }
+	if x := gridMax(f, 0, 5, 50); math.Abs(x-0.5) > 1e-6 {
+		t.Errorf("gridMax: got %g, want 0.5", x)
+	}
+}
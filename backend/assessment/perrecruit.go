@@ -0,0 +1,208 @@
+package assessment
+
+import (
+	"errors"
+	"math"
+)
+
+// --- Per-Recruit Analysis ---
+//
+// Yield and spawning biomass per recruit over a grid of fishing
+// mortalities, integrating one recruit through its life in steps of
+// perRecruitStep years. Growth is von Bertalanffy, weight is aL^b, and
+// maturity and selectivity are knife-edge at the given ages. With a
+// Beverton-Holt steepness the per-recruit curves also give equilibrium
+// yield, and from it Fmsy.
+
+const perRecruitStep = 0.05
+
+type LifeHistory struct {
+	Linf float64 `json:"linf_cm"`
+	K    float64 `json:"k"`
+	T0   float64 `json:"t0"`
+	// A and B give weight in kg from length in cm.
+	A           float64 `json:"a"`
+	B           float64 `json:"b"`
+	M           float64 `json:"m"`
+	MaxAge      float64 `json:"max_age"`
+	AgeMaturity float64 `json:"age_maturity"`
+	AgeCapture  float64 `json:"age_capture"`
+	// Steepness, when set, adds equilibrium yield relative to unfished
+	// recruitment.
+	Steepness float64 `json:"steepness,omitempty"`
+}
+
+type PerRecruitPoint struct {
+	F        float64  `json:"f"`
+	YPR      float64  `json:"ypr"`
+	SPR      float64  `json:"spr"`
+	SPRRatio float64  `json:"spr_ratio"`
+	Yield    *float64 `json:"equilibrium_yield,omitempty"`
+}
+
+type PerRecruitResult struct {
+	LifeHistory LifeHistory `json:"life_history"`
+	SPR0        float64     `json:"spr0"`
+	F01         *float64    `json:"f01"`
+	Fmax        *float64    `json:"fmax"`
+	F40         *float64    `json:"f40"`
+	F30         *float64    `json:"f30"`
+	Fmsy        *float64    `json:"fmsy,omitempty"`
+	// MSY is relative to unfished recruitment.
+	MSY      *float64          `json:"msy_per_r0,omitempty"`
+	Curve    []PerRecruitPoint `json:"curve"`
+	Growth   []GrowthPoint     `json:"growth"`
+	Warnings []string          `json:"warnings"`
+}
+
+type GrowthPoint struct {
+	Age      float64 `json:"age"`
+	LengthCm float64 `json:"length_cm"`
+	WeightKg float64 `json:"weight_kg"`
+	Mature   bool    `json:"mature"`
+	Selected bool    `json:"selected"`
+}
+
+func (lh LifeHistory) length(age float64) float64 {
+	return math.Max(0, lh.Linf*(1-math.Exp(-lh.K*(age-lh.T0))))
+}
+
+func (lh LifeHistory) weight(age float64) float64 {
+	return lh.A * math.Pow(lh.length(age), lh.B)
+}
+
+func (lh LifeHistory) validate() error {
+	switch {
+	case lh.Linf <= 0 || lh.K <= 0:
+		return errors.New("growth needs positive linf and k")
+	case lh.A <= 0 || lh.B <= 0:
+		return errors.New("length-weight needs positive a and b")
+	case lh.M <= 0:
+		return errors.New("natural mortality must be positive")
+	case lh.MaxAge <= 0 || lh.MaxAge > 200:
+		return errors.New("max age must be between 0 and 200")
+	case lh.AgeMaturity < 0 || lh.AgeCapture < 0:
+		return errors.New("ages of maturity and capture cannot be negative")
+	case lh.Steepness != 0 && (lh.Steepness <= 0.2 || lh.Steepness > 1):
+		return errors.New("steepness must be in (0.2, 1]")
+	}
+	return nil
+}
+
+// perRecruit integrates yield and spawning biomass per recruit at f.
+// Spawning biomass accrues over the mature part of each year.
+func (lh LifeHistory) perRecruit(f float64) (ypr, spr float64) {
+	n := 1.0
+	for age := 0.0; age < lh.MaxAge; age += perRecruitStep {
+		mid := age + perRecruitStep/2
+		w := lh.weight(mid)
+		fa := 0.0
+		if mid >= lh.AgeCapture {
+			fa = f
+		}
+		z := lh.M + fa
+		survive := math.Exp(-z * perRecruitStep)
+		ypr += n * w * fa / z * (1 - survive)
+		if mid >= lh.AgeMaturity {
+			spr += n * w * (1 - survive) / z
+		}
+		n *= survive
+	}
+	return ypr, spr
+}
+
+// equilibriumYield is yield relative to unfished recruitment under
+// Beverton-Holt recruitment with the given steepness.
+func (lh LifeHistory) equilibriumYield(f, spr0 float64) float64 {
+	ypr, spr := lh.perRecruit(f)
+	h := lh.Steepness
+	if spr <= 0 {
+		return 0
+	}
+	r := (4*h*spr - spr0*(1-h)) / (spr * (5*h - 1))
+	return math.Max(0, r*ypr)
+}
+
+// PerRecruit evaluates the curves on fmax/steps increments and solves for
+// the reference points. Fmax and Fmsy are nil when yield still rises at
+// fmax.
+func PerRecruit(lh LifeHistory, fmax float64, steps int) (*PerRecruitResult, error) {
+	if err := lh.validate(); err != nil {
+		return nil, err
+	}
+	if fmax <= 0 || steps < 2 {
+		return nil, errors.New("f range needs a positive maximum and at least 2 steps")
+	}
+
+	_, spr0 := lh.perRecruit(0)
+	res := &PerRecruitResult{LifeHistory: lh, SPR0: spr0, Warnings: []string{}}
+	if spr0 == 0 {
+		return nil, errors.New("no spawning biomass: age of maturity is beyond max age")
+	}
+
+	for i := 0; i <= steps; i++ {
+		f := fmax * float64(i) / float64(steps)
+		ypr, spr := lh.perRecruit(f)
+		p := PerRecruitPoint{F: f, YPR: ypr, SPR: spr, SPRRatio: spr / spr0}
+		if lh.Steepness > 0 {
+			y := lh.equilibriumYield(f, spr0)
+			p.Yield = &y
+		}
+		res.Curve = append(res.Curve, p)
+	}
+	for age := 0.0; age <= lh.MaxAge; age++ {
+		res.Growth = append(res.Growth, GrowthPoint{
+			Age:      age,
+			LengthCm: lh.length(age),
+			WeightKg: lh.weight(age),
+			Mature:   age >= lh.AgeMaturity,
+			Selected: age >= lh.AgeCapture,
+		})
+	}
+
+	// F0.1: where the YPR slope falls to a tenth of its slope at F = 0.
+	const h = 1e-4
+	slope := func(f float64) float64 {
+		a, _ := lh.perRecruit(f + h)
+		b, _ := lh.perRecruit(math.Max(0, f-h))
+		return (a - b) / (f + h - math.Max(0, f-h))
+	}
+	origin := slope(0)
+	target := func(f float64) float64 { return slope(f) - 0.1*origin }
+	if origin > 0 && target(fmax) < 0 {
+		f01 := bisect(target, 0, fmax)
+		res.F01 = &f01
+	} else {
+		res.Warnings = append(res.Warnings, "F0.1 lies beyond the F range")
+	}
+
+	if slope(fmax) < 0 {
+		fm := gridMax(func(f float64) float64 { y, _ := lh.perRecruit(f); return y }, 0, fmax, 50)
+		res.Fmax = &fm
+	} else {
+		res.Warnings = append(res.Warnings, "yield per recruit has no maximum in the F range")
+	}
+
+	for _, level := range []struct {
+		ratio float64
+		dest  **float64
+	}{{0.4, &res.F40}, {0.3, &res.F30}} {
+		ratio := func(f float64) float64 { _, s := lh.perRecruit(f); return s/spr0 - level.ratio }
+		if ratio(fmax) < 0 {
+			v := bisect(ratio, 0, fmax)
+			*level.dest = &v
+		}
+	}
+
+	if lh.Steepness > 0 {
+		yield := func(f float64) float64 { return lh.equilibriumYield(f, spr0) }
+		fm := gridMax(yield, 0, fmax, 50)
+		if fm < fmax*0.999 {
+			msy := yield(fm)
+			res.Fmsy, res.MSY = &fm, &msy
+		} else {
+			res.Warnings = append(res.Warnings, "equilibrium yield has no maximum in the F range")
+		}
+	}
+	return res, nil
+}
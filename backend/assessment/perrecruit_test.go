@@ -0,0 +1,136 @@
+package assessment
+
+import (
+	"math"
+	"testing"
+)
+
+// bhIntegral is the Beverton-Holt closed form of the biomass integral
+//
+//	∫ n e^{-z (t-a)} W∞ (1 - e^{-K (t-t0)})³ dt  over [a, tmax]
+//
+// for cubic von Bertalanffy weight, expanded with U = 1, -3, 3, -1.
+func bhIntegral(lh LifeHistory, n, z, a float64) float64 {
+	winf := lh.A * math.Pow(lh.Linf, 3)
+	u := []float64{1, -3, 3, -1}
+	sum := 0.0
+	for i, un := range u {
+		zk := z + float64(i)*lh.K
+		sum += un * math.Exp(-float64(i)*lh.K*(a-lh.T0)) / zk * (1 - math.Exp(-zk*(lh.MaxAge-a)))
+	}
+	return n * winf * sum
+}
+
+// textbook per-recruit values, with capture before maturity.
+func bhYPR(lh LifeHistory, f float64) float64 {
+	return f * bhIntegral(lh, math.Exp(-lh.M*lh.AgeCapture), lh.M+f, lh.AgeCapture)
+}
+
+func bhSPR(lh LifeHistory, f float64) float64 {
+	n := math.Exp(-lh.M*lh.AgeCapture - (lh.M+f)*(lh.AgeMaturity-lh.AgeCapture))
+	return bhIntegral(lh, n, lh.M+f, lh.AgeMaturity)
+}
+
+func bisectTest(f func(float64) float64, lo, hi float64) float64 {
+	for i := 0; i < 100; i++ {
+		mid := (lo + hi) / 2
+		if (f(mid) > 0) == (f(lo) > 0) {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return (lo + hi) / 2
+}
+
+var textbookStock = LifeHistory{Linf: 100, K: 0.2, T0: -0.5, A: 1e-5, B: 3, M: 0.2, MaxAge: 20, AgeMaturity: 3, AgeCapture: 2}
+
+func TestPerRecruitMatchesBevertonHolt(t *testing.T) {
+	lh := textbookStock
+	for _, f := range []float64{0, 0.1, 0.2, 0.5, 1, 2} {
+		ypr, spr := lh.perRecruit(f)
+		if want := bhYPR(lh, f); !within(ypr, want, 0.005) && !(f == 0 && ypr == 0) {
+			t.Errorf("F=%g: YPR %g, want %g", f, ypr, want)
+		}
+		if want := bhSPR(lh, f); !within(spr, want, 0.005) {
+			t.Errorf("F=%g: SPR %g, want %g", f, spr, want)
+		}
+	}
+}
+
+func TestPerRecruitReferencePoints(t *testing.T) {
+	lh := textbookStock
+	const h = 1e-5
+	slope := func(f float64) float64 {
+		return (bhYPR(lh, f+h) - bhYPR(lh, math.Max(0, f-h))) / (f + h - math.Max(0, f-h))
+	}
+	origin := slope(0)
+	wantF01 := bisectTest(func(f float64) float64 { return slope(f) - 0.1*origin }, 0, 3)
+	wantFmax := bisectTest(slope, 0.05, 3)
+	spr0 := bhSPR(lh, 0)
+	wantF40 := bisectTest(func(f float64) float64 { return bhSPR(lh, f)/spr0 - 0.4 }, 0, 3)
+	wantF30 := bisectTest(func(f float64) float64 { return bhSPR(lh, f)/spr0 - 0.3 }, 0, 3)
+
+	res, err := PerRecruit(lh, 3, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		got       *float64
+		want, tol float64
+	}{
+		{"F0.1", res.F01, wantF01, 0.01},
+		{"Fmax", res.Fmax, wantFmax, 0.02},
+		{"F40%", res.F40, wantF40, 0.01},
+		{"F30%", res.F30, wantF30, 0.01},
+	}
+	for _, tt := range tests {
+		if tt.got == nil {
+			t.Errorf("%s: missing, want %g", tt.name, tt.want)
+			continue
+		}
+		if !within(*tt.got, tt.want, tt.tol) {
+			t.Errorf("%s: got %g, want %g", tt.name, *tt.got, tt.want)
+		}
+	}
+	if *res.F01 >= *res.Fmax {
+		t.Errorf("F0.1 %g should be below Fmax %g", *res.F01, *res.Fmax)
+	}
+}
+
+func TestPerRecruitSteepnessOne(t *testing.T) {
+	// With steepness 1 recruitment is constant, so equilibrium yield is
+	// YPR scaled by R0 = 1 and Fmsy is Fmax.
+	lh := textbookStock
+	lh.Steepness = 1
+	res, err := PerRecruit(lh, 3, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Fmsy == nil || res.Fmax == nil {
+		t.Fatalf("Fmsy %v, Fmax %v", res.Fmsy, res.Fmax)
+	}
+	if !within(*res.Fmsy, *res.Fmax, 0.01) {
+		t.Errorf("Fmsy %g, want Fmax %g", *res.Fmsy, *res.Fmax)
+	}
+}
+
+func TestPerRecruitValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*LifeHistory)
+	}{
+		{"no growth", func(lh *LifeHistory) { lh.K = 0 }},
+		{"no mortality", func(lh *LifeHistory) { lh.M = 0 }},
+		{"steepness too low", func(lh *LifeHistory) { lh.Steepness = 0.1 }},
+		{"mature after max age", func(lh *LifeHistory) { lh.AgeMaturity = 25 }},
+	}
+	for _, tt := range tests {
+		lh := textbookStock
+		tt.change(&lh)
+		if _, err := PerRecruit(lh, 2, 20); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
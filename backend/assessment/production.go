@@ -0,0 +1,333 @@
+// Package assessment holds the stock assessment models behind the
+// /api/assessment endpoints: surplus production fits to catch and
+// abundance index series, and yield and spawning biomass per recruit.
+// It does no I/O; callers assemble the series and life-history inputs.
+package assessment
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// --- Surplus Production ---
+//
+// Observation-error fits of
+//
+//	B[t+1] = B[t] + g(B[t]) - C[t],  I[t] = q B[t] e^ε
+//
+// with g(B) = rB(1 - B/K) for Schaefer and g(B) = rB ln(K/B) for Fox.
+// q has a closed form given the biomass path, leaving r, K and the
+// initial depletion B[0]/K to Nelder-Mead on log residuals.
+
+type Model string
+
+const (
+	Schaefer Model = "schaefer"
+	Fox      Model = "fox"
+)
+
+var Models = []Model{Schaefer, Fox}
+
+// Observation is one year of a series. Index is the abundance index,
+// usually CPUE, and may be missing.
+type Observation struct {
+	Year  int      `json:"year"`
+	Catch float64  `json:"catch"`
+	Index *float64 `json:"index"`
+}
+
+type FitOptions struct {
+	// InitialDepletion fixes B[0]/K; nil estimates it.
+	InitialDepletion *float64
+}
+
+type ProductionYear struct {
+	Year        int      `json:"year"`
+	Catch       float64  `json:"catch"`
+	Index       *float64 `json:"index"`
+	Fitted      float64  `json:"fitted_index"`
+	Residual    *float64 `json:"log_residual"`
+	Biomass     float64  `json:"biomass"`
+	HarvestRate float64  `json:"harvest_rate"`
+	BOverBmsy   float64  `json:"b_over_bmsy"`
+	FOverFmsy   float64  `json:"f_over_fmsy"`
+	Production  float64  `json:"surplus_production"`
+}
+
+type CurvePoint struct {
+	Biomass    float64 `json:"biomass"`
+	Production float64 `json:"production"`
+}
+
+type ProductionFit struct {
+	Model            Model   `json:"model"`
+	R                float64 `json:"r"`
+	K                float64 `json:"k"`
+	Q                float64 `json:"q"`
+	InitialDepletion float64 `json:"initial_depletion"`
+	Sigma            float64 `json:"sigma"`
+	MSY              float64 `json:"msy"`
+	Bmsy             float64 `json:"bmsy"`
+	Fmsy             float64 `json:"fmsy"`
+	// FinalDepletion is B/K in the year after the last catch.
+	FinalDepletion float64 `json:"final_depletion"`
+	Parameters     int     `json:"parameters"`
+	N              int     `json:"n"`
+	NegLogLik      float64 `json:"neg_log_likelihood"`
+	AIC            float64 `json:"aic"`
+	// RSquared is for log index on log fitted index.
+	RSquared float64          `json:"r_squared"`
+	Years    []ProductionYear `json:"years"`
+	Curve    []CurvePoint     `json:"production_curve"`
+	Warnings []string         `json:"warnings"`
+}
+
+const minBiomassFraction = 1e-4
+
+func (m Model) production(b, r, k float64) float64 {
+	if m == Fox {
+		return r * b * math.Log(k/b)
+	}
+	return r * b * (1 - b/k)
+}
+
+// referencePoints returns MSY, Bmsy and Fmsy.
+func (m Model) referencePoints(r, k float64) (float64, float64, float64) {
+	if m == Fox {
+		return r * k / math.E, k / math.E, r
+	}
+	return r * k / 4, k / 2, r / 2
+}
+
+// biomassPath projects the model through the catches, returning one more
+// biomass than there are years and a penalty for every year catch would
+// have driven biomass below the floor.
+func (m Model) biomassPath(obs []Observation, r, k, b0 float64) ([]float64, float64) {
+	b := make([]float64, len(obs)+1)
+	b[0] = b0 * k
+	floor := minBiomassFraction * k
+	penalty := 0.0
+	for t, o := range obs {
+		next := b[t] + m.production(b[t], r, k) - o.Catch
+		if next < floor {
+			d := (floor - next) / k
+			penalty += 100 * (1 + d*d)
+			next = floor
+		}
+		b[t+1] = next
+	}
+	return b, penalty
+}
+
+// concentrated returns ln q and the sum of squared log residuals.
+func concentrated(obs []Observation, b []float64) (float64, float64, int) {
+	var sum float64
+	n := 0
+	for t, o := range obs {
+		if o.Index != nil {
+			sum += math.Log(*o.Index) - math.Log(b[t])
+			n++
+		}
+	}
+	lnq := sum / float64(n)
+	var ssq float64
+	for t, o := range obs {
+		if o.Index != nil {
+			e := math.Log(*o.Index) - math.Log(b[t]) - lnq
+			ssq += e * e
+		}
+	}
+	return lnq, ssq, n
+}
+
+func checkSeries(obs []Observation) ([]Observation, error) {
+	if len(obs) == 0 {
+		return nil, errors.New("series is empty")
+	}
+	sorted := append([]Observation(nil), obs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Year < sorted[j].Year })
+	indexed := 0
+	for i, o := range sorted {
+		if i > 0 && o.Year != sorted[i-1].Year+1 {
+			if o.Year == sorted[i-1].Year {
+				return nil, fmt.Errorf("year %d appears twice", o.Year)
+			}
+			return nil, fmt.Errorf("series has a gap between %d and %d; catch is needed for every year", sorted[i-1].Year, o.Year)
+		}
+		if o.Catch < 0 || math.IsNaN(o.Catch) {
+			return nil, fmt.Errorf("catch for %d must be zero or more", o.Year)
+		}
+		if o.Index != nil {
+			if *o.Index <= 0 {
+				return nil, fmt.Errorf("index for %d must be positive", o.Year)
+			}
+			indexed++
+		}
+	}
+	if indexed < 5 {
+		return nil, fmt.Errorf("need at least 5 years with an abundance index, have %d", indexed)
+	}
+	return sorted, nil
+}
+
+// FitProduction fits a surplus production model to annual catches and an
+// abundance index. Years must be consecutive.
+func FitProduction(model Model, series []Observation, opts FitOptions) (*ProductionFit, error) {
+	if model != Schaefer && model != Fox {
+		return nil, fmt.Errorf("unknown model %q: use schaefer or fox", model)
+	}
+	if d := opts.InitialDepletion; d != nil && (*d <= 0 || *d > 1.5) {
+		return nil, errors.New("initial depletion must be in (0, 1.5]")
+	}
+	obs, err := checkSeries(series)
+	if err != nil {
+		return nil, err
+	}
+
+	maxCatch := 0.0
+	for _, o := range obs {
+		maxCatch = math.Max(maxCatch, o.Catch)
+	}
+	if maxCatch == 0 {
+		return nil, errors.New("series has no catch")
+	}
+
+	// Parameters: ln r, ln K and, when estimated, logit of B[0]/K over
+	// (0, 1.5).
+	unpack := func(x []float64) (float64, float64, float64) {
+		b0 := 1.0
+		if opts.InitialDepletion != nil {
+			b0 = *opts.InitialDepletion
+		} else {
+			b0 = 1.5 / (1 + math.Exp(-x[2]))
+		}
+		return math.Exp(x[0]), math.Exp(x[1]), b0
+	}
+	objective := func(x []float64) float64 {
+		r, k, b0 := unpack(x)
+		if r > 3 || r < 1e-3 {
+			return math.Inf(1)
+		}
+		b, penalty := model.biomassPath(obs, r, k, b0)
+		_, ssq, n := concentrated(obs, b)
+		return float64(n)/2*math.Log(ssq/float64(n)+1e-12) + penalty
+	}
+
+	dims := 3
+	if opts.InitialDepletion != nil {
+		dims = 2
+	}
+	var best []float64
+	bestV := math.Inf(1)
+	for _, r := range []float64{0.05, 0.1, 0.2, 0.4, 0.7, 1.2} {
+		for _, km := range []float64{2, 4, 8, 16, 32, 64, 128} {
+			for _, d := range []float64{0.5, 0.9} {
+				x := []float64{math.Log(r), math.Log(km * maxCatch), math.Log(d / (1.5 - d))}[:dims]
+				if v := objective(x); v < bestV {
+					best, bestV = x, v
+				}
+			}
+		}
+	}
+	step := []float64{0.5, 0.5, 1}[:dims]
+	for i := 0; i < 3; i++ {
+		best, bestV = minimize(objective, best, step, 2000, 1e-10)
+		step = []float64{0.1, 0.1, 0.3}[:dims]
+	}
+
+	r, k, b0 := unpack(best)
+	b, penalty := model.biomassPath(obs, r, k, b0)
+	lnq, ssq, n := concentrated(obs, b)
+	q := math.Exp(lnq)
+	msy, bmsy, fmsy := model.referencePoints(r, k)
+
+	fit := &ProductionFit{
+		Model:            model,
+		R:                r,
+		K:                k,
+		Q:                q,
+		InitialDepletion: b0,
+		Sigma:            math.Sqrt(ssq / float64(n)),
+		MSY:              msy,
+		Bmsy:             bmsy,
+		Fmsy:             fmsy,
+		FinalDepletion:   b[len(obs)] / k,
+		Parameters:       dims + 2,
+		N:                n,
+		Warnings:         []string{},
+	}
+	// Concentrated normal likelihood for log residuals; q and sigma count
+	// as parameters alongside those in the simplex.
+	fit.NegLogLik = float64(n)/2*(math.Log(2*math.Pi*ssq/float64(n))+1) + sumLogIndex(obs)
+	fit.AIC = 2*fit.NegLogLik + 2*float64(fit.Parameters)
+
+	var meanLog, sst float64
+	for _, o := range obs {
+		if o.Index != nil {
+			meanLog += math.Log(*o.Index) / float64(n)
+		}
+	}
+	for _, o := range obs {
+		if o.Index != nil {
+			d := math.Log(*o.Index) - meanLog
+			sst += d * d
+		}
+	}
+	if sst > 0 {
+		fit.RSquared = 1 - ssq/sst
+	}
+
+	for t, o := range obs {
+		y := ProductionYear{
+			Year:        o.Year,
+			Catch:       o.Catch,
+			Index:       o.Index,
+			Fitted:      q * b[t],
+			Biomass:     b[t],
+			HarvestRate: o.Catch / b[t],
+			BOverBmsy:   b[t] / bmsy,
+			Production:  model.production(b[t], r, k),
+		}
+		y.FOverFmsy = y.HarvestRate / fmsy
+		if o.Index != nil {
+			e := math.Log(*o.Index) - math.Log(q*b[t])
+			y.Residual = &e
+		}
+		fit.Years = append(fit.Years, y)
+	}
+	for i := 0; i <= 50; i++ {
+		bb := k * float64(i) / 50
+		p := 0.0
+		if bb > 0 {
+			p = model.production(bb, r, k)
+		}
+		fit.Curve = append(fit.Curve, CurvePoint{Biomass: bb, Production: p})
+	}
+
+	if penalty > 0 {
+		fit.Warnings = append(fit.Warnings, "catches exceed the fitted biomass in some years; the fit is unreliable")
+	}
+	if k > 100*maxCatch {
+		fit.Warnings = append(fit.Warnings, "K is over 100 times the largest catch; the index may carry little contrast")
+	}
+	if r >= 2.9 || r <= 1.1e-3 {
+		fit.Warnings = append(fit.Warnings, "r is at its bound")
+	}
+	if opts.InitialDepletion == nil && (b0 > 1.45 || b0 < 0.02) {
+		fit.Warnings = append(fit.Warnings, "initial depletion is at its bound; consider fixing it")
+	}
+	return fit, nil
+}
+
+// sumLogIndex is the Jacobian term for a lognormal likelihood on the index.
+func sumLogIndex(obs []Observation) float64 {
+	var s float64
+	for _, o := range obs {
+		if o.Index != nil {
+			s += math.Log(*o.Index)
+		}
+	}
+	return s
+}
@@ -0,0 +1,117 @@
+package assessment
+
+import (
+	"math"
+	"testing"
+)
+
+func TestReferencePoints(t *testing.T) {
+	tests := []struct {
+		model           Model
+		r, k            float64
+		msy, bmsy, fmsy float64
+	}{
+		{Schaefer, 0.4, 10000, 1000, 5000, 0.2},
+		{Schaefer, 1.2, 250, 75, 125, 0.6},
+		{Fox, 0.4, 10000, 4000 / math.E, 10000 / math.E, 0.4},
+		{Fox, 0.15, 5000, 750 / math.E, 5000 / math.E, 0.15},
+	}
+	for _, tt := range tests {
+		msy, bmsy, fmsy := tt.model.referencePoints(tt.r, tt.k)
+		if !within(msy, tt.msy, 1e-12) || !within(bmsy, tt.bmsy, 1e-12) || !within(fmsy, tt.fmsy, 1e-12) {
+			t.Errorf("%s r=%g K=%g: got MSY %g Bmsy %g Fmsy %g, want %g %g %g",
+				tt.model, tt.r, tt.k, msy, bmsy, fmsy, tt.msy, tt.bmsy, tt.fmsy)
+		}
+		// MSY is the production at Bmsy, and Fmsy the harvest rate there.
+		if p := tt.model.production(bmsy, tt.r, tt.k); !within(p, msy, 1e-12) {
+			t.Errorf("%s: production at Bmsy is %g, want MSY %g", tt.model, p, msy)
+		}
+		if !within(msy/bmsy, fmsy, 1e-12) {
+			t.Errorf("%s: MSY/Bmsy is %g, want Fmsy %g", tt.model, msy/bmsy, fmsy)
+		}
+	}
+}
+
+// simulate runs the model forward and returns an exact index q*B.
+func simulate(model Model, r, k, b0, q float64, catches []float64) []Observation {
+	obs := make([]Observation, len(catches))
+	for i, c := range catches {
+		obs[i] = Observation{Year: 2000 + i, Catch: c}
+	}
+	b, _ := model.biomassPath(obs, r, k, b0)
+	for i := range obs {
+		index := q * b[i]
+		obs[i].Index = &index
+	}
+	return obs
+}
+
+func TestFitProductionRecoversParameters(t *testing.T) {
+	// A one-way trip down and a partial recovery gives the index contrast
+	// needed to separate r and K.
+	var catches []float64
+	for i := 0; i < 15; i++ {
+		catches = append(catches, 200+float64(i)*60)
+	}
+	for i := 0; i < 10; i++ {
+		catches = append(catches, 400)
+	}
+	depletion := 1.0
+	tests := []struct {
+		model Model
+		r, k  float64
+	}{
+		{Schaefer, 0.4, 10000},
+		{Fox, 0.3, 12000},
+	}
+	for _, tt := range tests {
+		obs := simulate(tt.model, tt.r, tt.k, depletion, 0.001, catches)
+		fit, err := FitProduction(tt.model, obs, FitOptions{InitialDepletion: &depletion})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.model, err)
+		}
+		if !within(fit.R, tt.r, 0.01) || !within(fit.K, tt.k, 0.01) || !within(fit.Q, 0.001, 0.01) {
+			t.Errorf("%s: got r %g K %g q %g, want %g %g 0.001", tt.model, fit.R, fit.K, fit.Q, tt.r, tt.k)
+		}
+		msy, _, fmsy := tt.model.referencePoints(tt.r, tt.k)
+		if !within(fit.MSY, msy, 0.02) || !within(fit.Fmsy, fmsy, 0.02) {
+			t.Errorf("%s: got MSY %g Fmsy %g, want %g %g", tt.model, fit.MSY, fit.Fmsy, msy, fmsy)
+		}
+		if fit.Sigma > 1e-3 {
+			t.Errorf("%s: sigma %g on an exact index", tt.model, fit.Sigma)
+		}
+	}
+}
+
+func TestCheckSeries(t *testing.T) {
+	index := 1.0
+	full := func(years ...int) []Observation {
+		var obs []Observation
+		for _, y := range years {
+			obs = append(obs, Observation{Year: y, Catch: 1, Index: &index})
+		}
+		return obs
+	}
+	tests := []struct {
+		name string
+		obs  []Observation
+		ok   bool
+	}{
+		{"consecutive", full(2001, 2002, 2003, 2004, 2005), true},
+		{"unsorted", full(2003, 2001, 2002, 2005, 2004), true},
+		{"gap", full(2001, 2002, 2004, 2005, 2006), false},
+		{"duplicate", full(2001, 2002, 2002, 2003, 2004), false},
+		{"too few indexed", full(2001, 2002, 2003, 2004), false},
+		{"empty", nil, false},
+	}
+	for _, tt := range tests {
+		if _, err := checkSeries(tt.obs); (err == nil) != tt.ok {
+			t.Errorf("%s: got error %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+// within reports whether got is within rel (relative) of want.
+func within(got, want, rel float64) bool {
+	return math.Abs(got-want) <= rel*math.Abs(want)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// --- Authentication ---
+//
+// The frontend signs in through Supabase, which issues HS256 JWTs signed
+// with the project's JWT secret. The backend only verifies those tokens; it
+// never issues its own.
+
+const (
+	roleGeneralUser   = "General User"
+	roleResearcher    = "Researcher"
+	roleAdministrator = "Administrator"
+)
+
+// moderatorRoles may review citizen submissions and other user content.
+var moderatorRoles = []string{roleResearcher, roleAdministrator}
+
+// contributorRoles may publish datasets.
+var contributorRoles = []string{roleResearcher, roleAdministrator}
+
+type AuthUser struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+type jwtClaims struct {
+	Sub         string                 `json:"sub"`
+	Email       string                 `json:"email"`
+	Exp         int64                  `json:"exp"`
+	UserRole    string                 `json:"user_role"`
+	AppMetadata map[string]interface{} `json:"app_metadata"`
+}
+
+var errUnauthenticated = errors.New("missing or invalid bearer token")
+
+func verifyJWT(token string, secret []byte) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errUnauthenticated
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errUnauthenticated
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil || header.Alg != "HS256" {
+		return nil, errUnauthenticated
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errUnauthenticated
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, errUnauthenticated
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errUnauthenticated
+	}
+	var claims jwtClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errUnauthenticated
+	}
+	if claims.Sub == "" || claims.Exp == 0 || time.Now().Unix() > claims.Exp {
+		return nil, errUnauthenticated
+	}
+	return &claims, nil
+}
+
+// appRole finds the application role (Researcher, Administrator, ...) in
+// the token. Only the custom claim and app_metadata are trusted: both are
+// set server-side, whereas user_metadata is editable by the user (the
+// signup form lets them pick a role) and is deliberately ignored.
+func (c *jwtClaims) appRole() string {
+	if c.UserRole != "" {
+		return c.UserRole
+	}
+	if role, ok := c.AppMetadata["role"].(string); ok && role != "" {
+		return role
+	}
+	return roleGeneralUser
+}
+
+func authenticate(r *http.Request) (*AuthUser, error) {
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		return nil, errors.New("authentication is not configured")
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil, errUnauthenticated
+	}
+	claims, err := verifyJWT(strings.TrimSpace(token), []byte(secret))
+	if err != nil {
+		return nil, err
+	}
+	return &AuthUser{ID: claims.Sub, Email: claims.Email, Role: claims.appRole()}, nil
+}
+
+// requireUser writes a 401 and returns false when the request carries no
+// valid token.
+func requireUser(w http.ResponseWriter, r *http.Request) (*AuthUser, bool) {
+	user, err := authenticate(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return nil, false
+	}
+	return user, true
+}
+
+// optionalUser returns the signed-in user, or nil for anonymous requests.
+func optionalUser(r *http.Request) *AuthUser {
+	if r.Header.Get("Authorization") == "" {
+		return nil
+	}
+	user, err := authenticate(r)
+	if err != nil {
+		return nil
+	}
+	return user
+}
+
+// requireRole is requireUser plus a 403 when the user has none of roles.
+func requireRole(w http.ResponseWriter, r *http.Request, roles ...string) (*AuthUser, bool) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return nil, false
+	}
+	if !user.hasRole(roles...) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, false
+	}
+	return user, true
+}
+
+func (u *AuthUser) hasRole(roles ...string) bool {
+	for _, role := range roles {
+		if strings.EqualFold(u.Role, role) {
+			return true
+		}
+	}
+	return false
+}
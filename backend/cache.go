@@ -0,0 +1,83 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+// --- LRU Cache ---
+//
+// A size-bounded cache shared by the in-memory grid and SDM caches. Each
+// entry has a cost (1 unless a cost function is given); once the total
+// passes the capacity, least recently used entries are evicted. The
+// newest entry is always kept, even if it alone exceeds the capacity.
+
+type lruCache[K comparable, V any] struct {
+	mu       sync.Mutex
+	capacity int
+	cost     func(V) int
+	used     int
+	order    *list.List // front is most recently used
+	items    map[K]*list.Element
+}
+
+type lruEntry[K comparable, V any] struct {
+	key   K
+	value V
+	cost  int
+}
+
+func newLRUCache[K comparable, V any](capacity int, cost func(V) int) *lruCache[K, V] {
+	if cost == nil {
+		cost = func(V) int { return 1 }
+	}
+	return &lruCache[K, V]{capacity: capacity, cost: cost, order: list.New(), items: map[K]*list.Element{}}
+}
+
+func (c *lruCache[K, V]) get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*lruEntry[K, V]).value, true
+}
+
+func (c *lruCache[K, V]) put(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+	e := &lruEntry[K, V]{key: key, value: value, cost: c.cost(value)}
+	c.items[key] = c.order.PushFront(e)
+	c.used += e.cost
+	for c.used > c.capacity && c.order.Len() > 1 {
+		c.removeElement(c.order.Back())
+	}
+}
+
+func (c *lruCache[K, V]) remove(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+func (c *lruCache[K, V]) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.items = map[K]*list.Element{}
+	c.used = 0
+}
+
+func (c *lruCache[K, V]) removeElement(el *list.Element) {
+	e := c.order.Remove(el).(*lruEntry[K, V])
+	delete(c.items, e.key)
+	c.used -= e.cost
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// --- Dataset Citations ---
+//
+// Citation text and DataCite metadata are generated from a version's
+// snapshot, never from the live dataset, so a citation keeps describing
+// exactly what was cited.
+
+// personName splits an author into family and given names. "Nair, Priya"
+// and "Priya Nair" both give ("Nair", "Priya"); a single word is treated as
+// an organisation.
+func personName(author string) (family, given string, personal bool) {
+	author = strings.TrimSpace(author)
+	if f, g, ok := strings.Cut(author, ","); ok {
+		return strings.TrimSpace(f), strings.TrimSpace(g), true
+	}
+	parts := strings.Fields(author)
+	if len(parts) < 2 {
+		return author, "", false
+	}
+	return parts[len(parts)-1], strings.Join(parts[:len(parts)-1], " "), true
+}
+
+// initials turns "Priya Anand" into "P. A." and "Jean-Luc" into "J.-L.".
+func initials(given string) string {
+	var out []string
+	for _, word := range strings.Fields(given) {
+		var pieces []string
+		for _, piece := range strings.Split(word, "-") {
+			if r := []rune(piece); len(r) > 0 && unicode.IsLetter(r[0]) {
+				pieces = append(pieces, string(unicode.ToUpper(r[0]))+".")
+			}
+		}
+		if len(pieces) > 0 {
+			out = append(out, strings.Join(pieces, "-"))
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+func citationYear(v *DatasetVersion) string {
+	if len(v.CreatedAt) >= 4 {
+		return v.CreatedAt[:4]
+	}
+	return ""
+}
+
+// citationURL is the DOI link once minted and the landing page before that.
+func citationURL(v *DatasetVersion) string {
+	if v.DOI != "" {
+		return "https://doi.org/" + v.DOI
+	}
+	return versionLandingURL(v.Accession, v.Version)
+}
+
+// --- APA ---
+
+func apaAuthor(author string) string {
+	family, given, personal := personName(author)
+	if !personal || given == "" {
+		return family
+	}
+	return family + ", " + initials(given)
+}
+
+// apaAuthors follows APA 7: up to 20 names, the last joined with "&";
+// beyond that the first 19, an ellipsis and the final author.
+func apaAuthors(authors []string) string {
+	names := make([]string, len(authors))
+	for i, a := range authors {
+		names[i] = apaAuthor(a)
+	}
+	switch {
+	case len(names) == 0:
+		return ""
+	case len(names) == 1:
+		return names[0]
+	case len(names) == 2:
+		return names[0] + ", & " + names[1]
+	case len(names) <= 20:
+		return strings.Join(names[:len(names)-1], ", ") + ", & " + names[len(names)-1]
+	default:
+		return strings.Join(names[:19], ", ") + ", . . . " + names[len(names)-1]
+	}
+}
+
+func apaCitation(v *DatasetVersion) string {
+	authors := apaAuthors(v.Metadata.Authors)
+	if !strings.HasSuffix(authors, ".") {
+		authors += "."
+	}
+	return fmt.Sprintf("%s (%s). %s (Version %d) [Data set]. %s. %s",
+		authors, citationYear(v), v.Metadata.Title, v.Version, datasetPublisher(), citationURL(v))
+}
+
+// --- BibTeX ---
+
+var bibtexEscaper = strings.NewReplacer(
+	`\`, `\textbackslash{}`, "{", `\{`, "}", `\}`, "&", `\&`, "%", `\%`, "$", `\$`, "#", `\#`, "_", `\_`,
+)
+
+func bibtexAuthor(author string) string {
+	family, given, personal := personName(author)
+	if !personal {
+		// Braces keep organisation names from being split into parts.
+		return "{" + bibtexEscaper.Replace(family) + "}"
+	}
+	if given == "" {
+		return bibtexEscaper.Replace(family)
+	}
+	return bibtexEscaper.Replace(family + ", " + given)
+}
+
+func bibtexCitation(v *DatasetVersion) string {
+	authors := make([]string, len(v.Metadata.Authors))
+	for i, a := range v.Metadata.Authors {
+		authors[i] = bibtexAuthor(a)
+	}
+
+	fields := [][2]string{
+		{"author", strings.Join(authors, " and ")},
+		{"title", "{" + bibtexEscaper.Replace(v.Metadata.Title) + "}"},
+		{"year", citationYear(v)},
+		{"publisher", bibtexEscaper.Replace(datasetPublisher())},
+		{"version", strconv.Itoa(v.Version)},
+	}
+	if v.DOI != "" {
+		fields = append(fields, [2]string{"doi", v.DOI})
+	}
+	fields = append(fields, [2]string{"url", citationURL(v)})
+	if len(v.Metadata.Keywords) > 0 {
+		fields = append(fields, [2]string{"keywords", bibtexEscaper.Replace(strings.Join(v.Metadata.Keywords, ", "))})
+	}
+
+	var b strings.Builder
+	key := strings.ReplaceAll(versionIdentifier(v.Accession, v.Version), ".", "_")
+	fmt.Fprintf(&b, "@dataset{%s,\n", key)
+	for i, f := range fields {
+		sep := ","
+		if i == len(fields)-1 {
+			sep = ""
+		}
+		fmt.Fprintf(&b, "  %-9s = {%s}%s\n", f[0], f[1], sep)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// --- RIS ---
+
+func risCitation(v *DatasetVersion) string {
+	var b strings.Builder
+	line := func(tag, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "%s  - %s\r\n", tag, strings.ReplaceAll(value, "\n", " "))
+		}
+	}
+
+	line("TY", "DATA")
+	for _, a := range v.Metadata.Authors {
+		family, given, personal := personName(a)
+		if personal && given != "" {
+			line("AU", family+", "+given)
+		} else {
+			line("AU", family)
+		}
+	}
+	line("TI", v.Metadata.Title)
+	line("PY", citationYear(v))
+	line("DA", strings.ReplaceAll(firstN(v.CreatedAt, 10), "-", "/"))
+	line("PB", datasetPublisher())
+	line("ET", "Version "+strconv.Itoa(v.Version))
+	line("DO", v.DOI)
+	line("UR", citationURL(v))
+	line("AB", v.Metadata.Description)
+	for _, k := range v.Metadata.Keywords {
+		line("KW", k)
+	}
+	line("AN", versionIdentifier(v.Accession, v.Version))
+	b.WriteString("ER  - \r\n")
+	return b.String()
+}
+
+func firstN(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// --- DataCite Metadata Schema 4 ---
+
+type dataCiteResource struct {
+	XMLName        xml.Name `xml:"http://datacite.org/schema/kernel-4 resource"`
+	XSI            string   `xml:"xmlns:xsi,attr"`
+	SchemaLocation string   `xml:"xsi:schemaLocation,attr"`
+	Identifier     struct {
+		Type  string `xml:"identifierType,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"identifier"`
+	Creators             []dataCiteCreator  `xml:"creators>creator"`
+	Titles               []string           `xml:"titles>title"`
+	Publisher            string             `xml:"publisher"`
+	PublicationYear      string             `xml:"publicationYear"`
+	ResourceType         dataCiteTyped      `xml:"resourceType"`
+	Subjects             *dataCiteSubjects  `xml:"subjects,omitempty"`
+	Dates                []dataCiteDate     `xml:"dates>date,omitempty"`
+	AlternateIdentifiers []dataCiteAltID    `xml:"alternateIdentifiers>alternateIdentifier,omitempty"`
+	Sizes                []string           `xml:"sizes>size,omitempty"`
+	Formats              *dataCiteFormats   `xml:"formats,omitempty"`
+	Version              string             `xml:"version"`
+	Descriptions         *dataCiteAbstracts `xml:"descriptions,omitempty"`
+}
+
+// Optional lists are pointers: encoding/xml writes an empty wrapper
+// element for a nil slice tagged "a>b,omitempty".
+type dataCiteSubjects struct {
+	Items []string `xml:"subject"`
+}
+
+type dataCiteFormats struct {
+	Items []string `xml:"format"`
+}
+
+type dataCiteAbstracts struct {
+	Items []dataCiteTypedText `xml:"description"`
+}
+
+type dataCiteCreator struct {
+	Name struct {
+		Type  string `xml:"nameType,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"creatorName"`
+	GivenName  string `xml:"givenName,omitempty"`
+	FamilyName string `xml:"familyName,omitempty"`
+}
+
+type dataCiteTyped struct {
+	General string `xml:"resourceTypeGeneral,attr"`
+	Value   string `xml:",chardata"`
+}
+
+type dataCiteDate struct {
+	Type  string `xml:"dateType,attr"`
+	Value string `xml:",chardata"`
+}
+
+type dataCiteAltID struct {
+	Type  string `xml:"alternateIdentifierType,attr"`
+	Value string `xml:",chardata"`
+}
+
+type dataCiteTypedText struct {
+	Type  string `xml:"descriptionType,attr"`
+	Value string `xml:",chardata"`
+}
+
+// dataCiteXML renders a version as DataCite kernel-4 XML. The identifier is
+// the DOI the version has, or would be given when minted.
+func dataCiteXML(v *DatasetVersion) ([]byte, error) {
+	res := dataCiteResource{
+		XSI:             "http://www.w3.org/2001/XMLSchema-instance",
+		SchemaLocation:  "http://datacite.org/schema/kernel-4 http://schema.datacite.org/meta/kernel-4/metadata.xsd",
+		Titles:          []string{v.Metadata.Title},
+		Publisher:       datasetPublisher(),
+		PublicationYear: citationYear(v),
+		ResourceType:    dataCiteTyped{General: "Dataset", Value: "Dataset"},
+		Version:         strconv.Itoa(v.Version),
+		AlternateIdentifiers: []dataCiteAltID{
+			{Type: "Accession", Value: versionIdentifier(v.Accession, v.Version)},
+			{Type: "SHA-256", Value: v.ContentHash},
+		},
+	}
+	res.Identifier.Type = "DOI"
+	res.Identifier.Value = v.DOI
+	if v.DOI == "" {
+		res.Identifier.Value = versionDOI(v.Accession, v.Version)
+	}
+
+	for _, a := range v.Metadata.Authors {
+		family, given, personal := personName(a)
+		var c dataCiteCreator
+		if personal {
+			c.Name.Type = "Personal"
+			c.Name.Value = family
+			if given != "" {
+				c.Name.Value += ", " + given
+			}
+			c.GivenName, c.FamilyName = given, family
+		} else {
+			c.Name.Type = "Organizational"
+			c.Name.Value = family
+		}
+		res.Creators = append(res.Creators, c)
+	}
+
+	if start, end := v.Metadata.CollectionStart, v.Metadata.CollectionEnd; start != "" || end != "" {
+		value := start + "/" + end
+		if start == end {
+			value = start
+		}
+		res.Dates = append(res.Dates, dataCiteDate{Type: "Collected", Value: value})
+	}
+	res.Dates = append(res.Dates, dataCiteDate{Type: "Created", Value: firstN(v.CreatedAt, 10)})
+	if v.DOIMintedAt != "" {
+		res.Dates = append(res.Dates, dataCiteDate{Type: "Issued", Value: firstN(v.DOIMintedAt, 10)})
+	}
+
+	if len(v.Metadata.Keywords) > 0 {
+		res.Subjects = &dataCiteSubjects{Items: v.Metadata.Keywords}
+	}
+	if v.Metadata.Description != "" {
+		res.Descriptions = &dataCiteAbstracts{Items: []dataCiteTypedText{{Type: "Abstract", Value: v.Metadata.Description}}}
+	}
+
+	res.Sizes = []string{fmt.Sprintf("%d files", v.FileCount), fmt.Sprintf("%d bytes", v.TotalSize)}
+	seen := map[string]bool{}
+	var formats []string
+	for _, f := range v.Files {
+		if f.ContentType != "" && !seen[f.ContentType] {
+			seen[f.ContentType] = true
+			formats = append(formats, f.ContentType)
+		}
+	}
+	if len(formats) > 0 {
+		sort.Strings(formats)
+		res.Formats = &dataCiteFormats{Items: formats}
+	}
+
+	out, err := xml.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+// --- Handlers ---
+
+// getVersionCitation returns ?format=bibtex, ris or apa as text, or all
+// three as JSON when no format is given.
+func getVersionCitation(w http.ResponseWriter, r *http.Request) {
+	_, v := versionForRequest(w, r, optionalUser(r))
+	if v == nil {
+		return
+	}
+
+	switch format := strings.ToLower(r.URL.Query().Get("format")); format {
+	case "bibtex", "bib":
+		w.Header().Set("Content-Type", "application/x-bibtex; charset=utf-8")
+		fmt.Fprint(w, bibtexCitation(v))
+	case "ris":
+		w.Header().Set("Content-Type", "application/x-research-info-systems; charset=utf-8")
+		fmt.Fprint(w, risCitation(v))
+	case "apa":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, apaCitation(v))
+	case "":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"identifier": v.Identifier,
+			"apa":        apaCitation(v),
+			"bibtex":     bibtexCitation(v),
+			"ris":        risCitation(v),
+		})
+	default:
+		http.Error(w, "format must be bibtex, ris or apa", http.StatusBadRequest)
+	}
+}
+
+func getVersionDataCite(w http.ResponseWriter, r *http.Request) {
+	_, v := versionForRequest(w, r, optionalUser(r))
+	if v == nil {
+		return
+	}
+	files, err := loadVersionFiles(v.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	v.Files = files
+
+	out, err := dataCiteXML(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write(out)
+}
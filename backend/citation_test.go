@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func testVersion(t *testing.T) *DatasetVersion {
+	t.Setenv("DOI_PREFIX", "10.5072")
+	t.Setenv("PUBLIC_URL", "https://data.example.org/")
+	t.Setenv("DATASET_PUBLISHER", "paradoxx6")
+	return &DatasetVersion{
+		Accession:   "MDS000123",
+		Version:     2,
+		ContentHash: "9f86d081884c7d65",
+		CreatedAt:   "2026-03-04 10:15:00+00",
+		Metadata: VersionMetadata{
+			Title:           "Reef fish counts 2024_25 & transects",
+			Description:     "Counts by transect\nand depth.",
+			Authors:         []string{"Nair, Priya", "Jean-Luc Picard", "CMFRI"},
+			Keywords:        []string{"reef", "fish"},
+			CollectionStart: "2024-01-01",
+			CollectionEnd:   "2024-12-31",
+		},
+		FileCount: 3,
+		TotalSize: 300,
+		Files:     []DatasetFile{{ContentType: "text/csv"}, {ContentType: "application/json"}, {ContentType: "text/csv"}},
+	}
+}
+
+func TestAPACitation(t *testing.T) {
+	v := testVersion(t)
+	want := "Nair, P., Picard, J.-L., & CMFRI. (2026). Reef fish counts 2024_25 & transects (Version 2) [Data set]. " +
+		"paradoxx6. https://data.example.org/api/datasets/MDS000123/versions/2"
+	if got := apaCitation(v); got != want {
+		t.Errorf("apaCitation =\n%s\nwant\n%s", got, want)
+	}
+	v.DOI = "10.5072/mds000123.v2"
+	if got := apaCitation(v); !strings.HasSuffix(got, ". https://doi.org/10.5072/mds000123.v2") {
+		t.Errorf("minted citation does not end in the DOI link: %s", got)
+	}
+
+	tests := []struct {
+		authors []string
+		want    string
+	}{
+		{nil, ""},
+		{[]string{"Priya Nair"}, "Nair, P."},
+		{[]string{"Nair, Priya", "Ravi Kumar"}, "Nair, P., & Kumar, R."},
+	}
+	for _, tt := range tests {
+		if got := apaAuthors(tt.authors); got != tt.want {
+			t.Errorf("apaAuthors(%q) = %q, want %q", tt.authors, got, tt.want)
+		}
+	}
+	many := make([]string, 22)
+	for i := range many {
+		many[i] = "Author " + string(rune('A'+i))
+	}
+	got := apaAuthors(many)
+	if !strings.HasSuffix(got, "S, A., . . . V, A.") || strings.Contains(got, "T, A.") {
+		t.Errorf("22 authors not cut to 19 and the last: %s", got)
+	}
+}
+
+func TestBibTeXCitation(t *testing.T) {
+	v := testVersion(t)
+	v.DOI = "10.5072/mds000123.v2"
+	want := `@dataset{MDS000123_v2,
+  author    = {Nair, Priya and Picard, Jean-Luc and {CMFRI}},
+  title     = {{Reef fish counts 2024\_25 \& transects}},
+  year      = {2026},
+  publisher = {paradoxx6},
+  version   = {2},
+  doi       = {10.5072/mds000123.v2},
+  url       = {https://doi.org/10.5072/mds000123.v2},
+  keywords  = {reef, fish}
+}
+`
+	if got := bibtexCitation(v); got != want {
+		t.Errorf("bibtexCitation =\n%s\nwant\n%s", got, want)
+	}
+
+	v.DOI, v.Metadata.Keywords = "", nil
+	got := bibtexCitation(v)
+	if strings.Contains(got, "doi ") || strings.Contains(got, "keywords") {
+		t.Errorf("unminted version without keywords has empty fields:\n%s", got)
+	}
+	if !strings.Contains(got, "  url       = {https://data.example.org/api/datasets/MDS000123/versions/2}\n}") {
+		t.Errorf("url should be the last field and point at the landing page:\n%s", got)
+	}
+}
+
+func TestRISCitation(t *testing.T) {
+	v := testVersion(t)
+	v.DOI = "10.5072/mds000123.v2"
+	want := strings.Join([]string{
+		"TY  - DATA",
+		"AU  - Nair, Priya",
+		"AU  - Picard, Jean-Luc",
+		"AU  - CMFRI",
+		"TI  - Reef fish counts 2024_25 & transects",
+		"PY  - 2026",
+		"DA  - 2026/03/04",
+		"PB  - paradoxx6",
+		"ET  - Version 2",
+		"DO  - 10.5072/mds000123.v2",
+		"UR  - https://doi.org/10.5072/mds000123.v2",
+		"AB  - Counts by transect and depth.",
+		"KW  - reef",
+		"KW  - fish",
+		"AN  - MDS000123.v2",
+		"ER  - ",
+		"",
+	}, "\r\n")
+	if got := risCitation(v); got != want {
+		t.Errorf("risCitation =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestDataCiteXML(t *testing.T) {
+	v := testVersion(t)
+	out, err := dataCiteXML(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(out), xml.Header) || !strings.Contains(string(out), `xmlns="http://datacite.org/schema/kernel-4"`) {
+		t.Errorf("missing XML header or kernel-4 namespace:\n%s", out)
+	}
+
+	var doc struct {
+		Identifier string `xml:"identifier"`
+		Creators   []struct {
+			Name struct {
+				Type  string `xml:"nameType,attr"`
+				Value string `xml:",chardata"`
+			} `xml:"creatorName"`
+			GivenName  string `xml:"givenName"`
+			FamilyName string `xml:"familyName"`
+		} `xml:"creators>creator"`
+		Year     string          `xml:"publicationYear"`
+		Subjects []string        `xml:"subjects>subject"`
+		Dates    []dataCiteDate  `xml:"dates>date"`
+		AltIDs   []dataCiteAltID `xml:"alternateIdentifiers>alternateIdentifier"`
+		Sizes    []string        `xml:"sizes>size"`
+		Formats  []string        `xml:"formats>format"`
+		Version  string          `xml:"version"`
+		Abstract string          `xml:"descriptions>description"`
+	}
+	if err := xml.Unmarshal(out, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Identifier != "10.5072/mds000123.v2" {
+		t.Errorf("identifier = %q, want the DOI the version would be given", doc.Identifier)
+	}
+	if len(doc.Creators) != 3 || doc.Creators[0].Name.Value != "Nair, Priya" || doc.Creators[0].Name.Type != "Personal" ||
+		doc.Creators[1].GivenName != "Jean-Luc" || doc.Creators[1].FamilyName != "Picard" ||
+		doc.Creators[2].Name.Type != "Organizational" || doc.Creators[2].GivenName != "" {
+		t.Errorf("creators = %+v", doc.Creators)
+	}
+	wantDates := []dataCiteDate{{"Collected", "2024-01-01/2024-12-31"}, {"Created", "2026-03-04"}}
+	if len(doc.Dates) != len(wantDates) || doc.Dates[0] != wantDates[0] || doc.Dates[1] != wantDates[1] {
+		t.Errorf("dates = %v, want %v", doc.Dates, wantDates)
+	}
+	if len(doc.AltIDs) != 2 || doc.AltIDs[0].Value != "MDS000123.v2" || doc.AltIDs[1] != (dataCiteAltID{"SHA-256", v.ContentHash}) {
+		t.Errorf("alternate identifiers = %v", doc.AltIDs)
+	}
+	if strings.Join(doc.Formats, ",") != "application/json,text/csv" {
+		t.Errorf("formats = %v, want distinct and sorted", doc.Formats)
+	}
+	if doc.Year != "2026" || doc.Version != "2" || len(doc.Subjects) != 2 || doc.Abstract != v.Metadata.Description ||
+		strings.Join(doc.Sizes, ",") != "3 files,300 bytes" {
+		t.Errorf("document = %+v", doc)
+	}
+
+	// A minted version is issued on its minting date; optional lists left
+	// empty produce no wrapper elements.
+	v.DOI, v.DOIMintedAt = "10.5072/mds000123.v2", "2026-03-05 08:00:00+00"
+	v.Metadata.Keywords, v.Metadata.Description, v.Files = nil, "", nil
+	v.Metadata.CollectionStart, v.Metadata.CollectionEnd = "", ""
+	out, err = dataCiteXML(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, absent := range []string{"<subjects", "<formats", "<descriptions", `dateType="Collected"`} {
+		if strings.Contains(string(out), absent) {
+			t.Errorf("output contains %s:\n%s", absent, out)
+		}
+	}
+	if !strings.Contains(string(out), `<date dateType="Issued">2026-03-05</date>`) {
+		t.Errorf("no Issued date:\n%s", out)
+	}
+}
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Citizen Science Sightings ---
+//
+// Signed-in users report sightings into citizen_sightings with status
+// pending. A moderator verifies (optionally correcting the species) or
+// rejects each report; only verified reports are copied into
+// occurrence_data, with source "citizen".
+
+const citizenSchema = `
+CREATE TABLE IF NOT EXISTS citizen_sightings (
+	id              SERIAL PRIMARY KEY,
+	submitted_by    TEXT NOT NULL,
+	submitter_email TEXT,
+	species_guess   TEXT NOT NULL,
+	species_id      INTEGER,
+	latitude        DOUBLE PRECISION NOT NULL,
+	longitude       DOUBLE PRECISION NOT NULL,
+	region          TEXT,
+	event_date      DATE NOT NULL,
+	water_depth_m   DOUBLE PRECISION,
+	photo_key       TEXT,
+	notes           TEXT,
+	status          TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'verified', 'rejected')),
+	reviewed_by     TEXT,
+	reviewed_at     TIMESTAMPTZ,
+	review_note     TEXT,
+	occurrence_id   INTEGER,
+	created_at      TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS citizen_sightings_status_idx ON citizen_sightings (status, created_at);
+
+ALTER TABLE occurrence_data ADD COLUMN IF NOT EXISTS source TEXT;
+UPDATE occurrence_data o SET source = 'citizen' FROM citizen_sightings c WHERE c.occurrence_id = o.id AND o.source IS NULL;`
+
+// occurrenceSourceCitizen marks occurrence_data rows copied from verified
+// reports.
+const occurrenceSourceCitizen = "citizen"
+
+const (
+	sightingPending  = "pending"
+	sightingVerified = "verified"
+	sightingRejected = "rejected"
+
+	maxPhotoSize = 15 << 20
+)
+
+var photoExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+type SightingReport struct {
+	ID             int      `json:"id"`
+	SubmittedBy    string   `json:"submitted_by"`
+	SubmitterEmail string   `json:"submitter_email,omitempty"`
+	SpeciesGuess   string   `json:"species_guess"`
+	SpeciesID      *int     `json:"species_id"`
+	Latitude       float64  `json:"latitude"`
+	Longitude      float64  `json:"longitude"`
+	Region         string   `json:"region"`
+	EventDate      string   `json:"event_date"`
+	WaterDepth     *float64 `json:"water_depth_m"`
+	HasPhoto       bool     `json:"has_photo"`
+	Notes          string   `json:"notes"`
+	Status         string   `json:"status"`
+	ReviewedBy     string   `json:"reviewed_by,omitempty"`
+	ReviewedAt     string   `json:"reviewed_at,omitempty"`
+	ReviewNote     string   `json:"review_note,omitempty"`
+	OccurrenceID   *int     `json:"occurrence_id,omitempty"`
+	CreatedAt      string   `json:"created_at"`
+}
+
+type ReviewRequest struct {
+	SpeciesID *int   `json:"species_id"`
+	Note      string `json:"note"`
+}
+
+const sightingReportColumns = `id, submitted_by, COALESCE(submitter_email, ''), species_guess, species_id, latitude, longitude,
+	COALESCE(region, ''), event_date::text, water_depth_m, COALESCE(photo_key, ''), COALESCE(notes, ''), status,
+	COALESCE(reviewed_by, ''), COALESCE(reviewed_at::text, ''), COALESCE(review_note, ''), occurrence_id, created_at::text`
+
+func scanSightingReport(row interface{ Scan(...any) error }) (SightingReport, string, error) {
+	var s SightingReport
+	var speciesID, occurrenceID sql.NullInt64
+	var depth sql.NullFloat64
+	var photoKey string
+	err := row.Scan(&s.ID, &s.SubmittedBy, &s.SubmitterEmail, &s.SpeciesGuess, &speciesID, &s.Latitude, &s.Longitude,
+		&s.Region, &s.EventDate, &depth, &photoKey, &s.Notes, &s.Status,
+		&s.ReviewedBy, &s.ReviewedAt, &s.ReviewNote, &occurrenceID, &s.CreatedAt)
+	if err != nil {
+		return s, "", err
+	}
+	if speciesID.Valid {
+		id := int(speciesID.Int64)
+		s.SpeciesID = &id
+	}
+	if occurrenceID.Valid {
+		id := int(occurrenceID.Int64)
+		s.OccurrenceID = &id
+	}
+	if depth.Valid {
+		s.WaterDepth = &depth.Float64
+	}
+	s.HasPhoto = photoKey != ""
+	return s, photoKey, nil
+}
+
+// randomID returns a random hex identifier of n bytes.
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// saveSightingPhoto stores the photo in the object store and returns its key.
+func saveSightingPhoto(ctx context.Context, r io.Reader, size int64, contentType string) (string, error) {
+	ext, ok := photoExtensions[contentType]
+	if !ok {
+		return "", fmt.Errorf("photo must be JPEG, PNG or WebP")
+	}
+	if size > maxPhotoSize {
+		return "", fmt.Errorf("photo is larger than %d MB", maxPhotoSize>>20)
+	}
+	key := "sightings/" + randomID(16) + ext
+	if err := store.Put(ctx, key, r, size, contentType); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// guessSpeciesID matches a free-text guess against species names, first
+// directly and then through the taxonomic backbone.
+func guessSpeciesID(guess string) *int {
+	var id int
+	err := db.QueryRow(`SELECT id FROM species_data
+		WHERE lower(scientific_name) = lower($1) OR lower(vernacularname) = lower($1)
+		ORDER BY id LIMIT 1`, strings.TrimSpace(guess)).Scan(&id)
+	if err == nil {
+		return &id
+	}
+	m, err := resolveName(guess)
+	if err != nil || m.Accepted == nil {
+		return nil
+	}
+	err = db.QueryRow(`SELECT species_id FROM species_name_match WHERE accepted_aphia_id = $1 ORDER BY species_id LIMIT 1`,
+		m.Accepted.AphiaID).Scan(&id)
+	if err != nil {
+		return nil
+	}
+	return &id
+}
+
+func submitSightingReport(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var report SightingReport
+	var photo multipart.File
+	var photoHeader *multipart.FileHeader
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		if err := r.ParseMultipartForm(maxPhotoSize); err != nil {
+			http.Error(w, "Failed to parse form", http.StatusBadRequest)
+			return
+		}
+		report.SpeciesGuess = r.FormValue("species_guess")
+		report.Region = r.FormValue("region")
+		report.EventDate = r.FormValue("event_date")
+		report.Notes = r.FormValue("notes")
+		var err error
+		if report.Latitude, err = strconv.ParseFloat(r.FormValue("latitude"), 64); err != nil {
+			http.Error(w, "latitude must be a number", http.StatusBadRequest)
+			return
+		}
+		if report.Longitude, err = strconv.ParseFloat(r.FormValue("longitude"), 64); err != nil {
+			http.Error(w, "longitude must be a number", http.StatusBadRequest)
+			return
+		}
+		if v := r.FormValue("water_depth_m"); v != "" {
+			depth, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				http.Error(w, "water_depth_m must be a number", http.StatusBadRequest)
+				return
+			}
+			report.WaterDepth = &depth
+		}
+
+		if file, header, err := r.FormFile("photo"); err == nil {
+			defer file.Close()
+			photo, photoHeader = file, header
+		}
+	} else {
+		// Pointers tell a missing coordinate from 0, which is a valid one.
+		var body struct {
+			SightingReport
+			Latitude  *float64 `json:"latitude"`
+			Longitude *float64 `json:"longitude"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if body.Latitude == nil || body.Longitude == nil {
+			http.Error(w, "latitude and longitude are required", http.StatusBadRequest)
+			return
+		}
+		report = body.SightingReport
+		report.Latitude, report.Longitude = *body.Latitude, *body.Longitude
+	}
+
+	report.SpeciesGuess = strings.TrimSpace(report.SpeciesGuess)
+	if report.SpeciesGuess == "" {
+		http.Error(w, "species_guess is required", http.StatusBadRequest)
+		return
+	}
+	if math.IsNaN(report.Latitude) || math.IsNaN(report.Longitude) ||
+		report.Latitude < -90 || report.Latitude > 90 || report.Longitude < -180 || report.Longitude > 180 {
+		http.Error(w, "coordinates are out of range", http.StatusBadRequest)
+		return
+	}
+	eventDate, err := time.Parse("2006-01-02", report.EventDate)
+	if err != nil || eventDate.After(time.Now()) {
+		http.Error(w, "event_date must be a past date in YYYY-MM-DD format", http.StatusBadRequest)
+		return
+	}
+	if report.WaterDepth != nil && *report.WaterDepth < 0 {
+		http.Error(w, "water_depth_m cannot be negative", http.StatusBadRequest)
+		return
+	}
+
+	var photoKey sql.NullString
+	if photo != nil {
+		key, err := saveSightingPhoto(r.Context(), photo, photoHeader.Size, photoHeader.Header.Get("Content-Type"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		photoKey = sql.NullString{String: key, Valid: true}
+	}
+
+	speciesID := guessSpeciesID(report.SpeciesGuess)
+	row := db.QueryRow(`INSERT INTO citizen_sightings
+		(submitted_by, submitter_email, species_guess, species_id, latitude, longitude, region, event_date, water_depth_m, photo_key, notes)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8, $9, $10, $11)
+		RETURNING `+sightingReportColumns,
+		user.ID, user.Email, report.SpeciesGuess, speciesID, report.Latitude, report.Longitude, report.Region,
+		report.EventDate, report.WaterDepth, photoKey, report.Notes)
+	created, _, err := scanSightingReport(row)
+	if err != nil {
+		if photoKey.Valid {
+			deleteObjects([]string{photoKey.String})
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// listSightingReports returns the moderation queue (status defaults to
+// pending) to moderators, and a user's own submissions with ?mine=true.
+func listSightingReports(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	where := []string{"1=1"}
+	args := []interface{}{}
+	if q.Get("mine") == "true" {
+		args = append(args, user.ID)
+		where = append(where, "submitted_by = $"+strconv.Itoa(len(args)))
+	} else if !user.hasRole(moderatorRoles...) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	status := q.Get("status")
+	if status == "" && q.Get("mine") != "true" {
+		status = sightingPending
+	}
+	if status != "" {
+		args = append(args, status)
+		where = append(where, "status = $"+strconv.Itoa(len(args)))
+	}
+
+	page, pageSize := pagination(r)
+	args = append(args, pageSize, (page-1)*pageSize)
+	rows, err := db.Query("SELECT "+sightingReportColumns+" FROM citizen_sightings WHERE "+strings.Join(where, " AND ")+
+		" ORDER BY created_at LIMIT $"+strconv.Itoa(len(args)-1)+" OFFSET $"+strconv.Itoa(len(args)), args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	reports := []SightingReport{}
+	for rows.Next() {
+		s, _, err := scanSightingReport(rows)
+		if err != nil {
+			continue
+		}
+		reports = append(reports, s)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reports)
+}
+
+func getSightingPhoto(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid report id", http.StatusBadRequest)
+		return
+	}
+	report, photoKey, err := scanSightingReport(db.QueryRow("SELECT "+sightingReportColumns+" FROM citizen_sightings WHERE id = $1", id))
+	if err != nil || photoKey == "" {
+		http.Error(w, "Photo not found", http.StatusNotFound)
+		return
+	}
+
+	// Photos of unverified reports are only visible to the submitter and
+	// moderators.
+	if report.Status != sightingVerified {
+		user, ok := requireUser(w, r)
+		if !ok {
+			return
+		}
+		if user.ID != report.SubmittedBy && !user.hasRole(moderatorRoles...) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	body, err := store.Get(r.Context(), photoKey)
+	if err != nil {
+		http.Error(w, "Photo not found", http.StatusNotFound)
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(photoKey)))
+	io.Copy(w, body)
+}
+
+func verifySightingReport(w http.ResponseWriter, r *http.Request) {
+	reviewSightingReport(w, r, sightingVerified)
+}
+
+func rejectSightingReport(w http.ResponseWriter, r *http.Request) {
+	reviewSightingReport(w, r, sightingRejected)
+}
+
+// reviewSightingReport moves a pending report to its final status. Verified
+// reports are inserted into occurrence_data in the same transaction.
+func reviewSightingReport(w http.ResponseWriter, r *http.Request, status string) {
+	user, ok := requireRole(w, r, moderatorRoles...)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid report id", http.StatusBadRequest)
+		return
+	}
+
+	var req ReviewRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	report, _, err := scanSightingReport(tx.QueryRow("SELECT "+sightingReportColumns+" FROM citizen_sightings WHERE id = $1 FOR UPDATE", id))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Report not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if report.Status != sightingPending {
+		http.Error(w, "Report has already been "+report.Status, http.StatusConflict)
+		return
+	}
+
+	speciesID := report.SpeciesID
+	if req.SpeciesID != nil {
+		speciesID = req.SpeciesID
+	}
+
+	var occurrenceID sql.NullInt64
+	if status == sightingVerified {
+		if speciesID == nil {
+			http.Error(w, "species_id is required to verify a report with an unrecognised species", http.StatusBadRequest)
+			return
+		}
+		var exists bool
+		if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM species_data WHERE id = $1)", *speciesID).Scan(&exists); err != nil || !exists {
+			http.Error(w, "Unknown species_id", http.StatusBadRequest)
+			return
+		}
+		err = tx.QueryRow(`INSERT INTO occurrence_data (species_id, eventdate, region, waterdepth_m, recordedby, decimallatitude, decimallongitude,
+				source)
+			VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7, $8) RETURNING id`,
+			*speciesID, report.EventDate, report.Region, report.WaterDepth, "Citizen report #"+strconv.Itoa(report.ID),
+			report.Latitude, report.Longitude, occurrenceSourceCitizen).Scan(&occurrenceID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	updated, _, err := scanSightingReport(tx.QueryRow(`UPDATE citizen_sightings SET status = $2, species_id = $3,
+		reviewed_by = $4, reviewed_at = now(), review_note = NULLIF($5, ''), occurrence_id = $6
+		WHERE id = $1 RETURNING `+sightingReportColumns, id, status, speciesID, user.ID, req.Note, occurrenceID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// correctSightingSpecies lets a moderator fix the species of a pending
+// report before deciding on it.
+func correctSightingSpecies(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireRole(w, r, moderatorRoles...); !ok {
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid report id", http.StatusBadRequest)
+		return
+	}
+
+	var req ReviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.SpeciesID == nil {
+		http.Error(w, "species_id is required", http.StatusBadRequest)
+		return
+	}
+	exists, err := speciesExists(*req.SpeciesID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Unknown species_id", http.StatusBadRequest)
+		return
+	}
+
+	updated, _, err := scanSightingReport(db.QueryRow(`UPDATE citizen_sightings SET species_id = $2
+		WHERE id = $1 AND status = 'pending' RETURNING `+sightingReportColumns, id, *req.SpeciesID))
+	if err == sql.ErrNoRows {
+		http.Error(w, "No pending report with that id", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// --- Species Comparison ---
+
+const (
+	minCompareSpecies = 2
+	maxCompareSpecies = 5
+)
+
+// compareSkipTraits are Species fields that identify a row rather than
+// describe it, so they are not part of the trait table.
+var compareSkipTraits = map[string]bool{
+	"id":         true,
+	"image_urls": true,
+}
+
+type SpeciesRef struct {
+	ID             int    `json:"id"`
+	VernacularName string `json:"vernacular_name"`
+	ScientificName string `json:"scientific_name"`
+}
+
+// TraitRow holds one trait aligned across the compared species, in the
+// order of the request's ids. Relative is only set for numeric traits and
+// gives each value as a fraction of the largest one.
+type TraitRow struct {
+	Trait    string        `json:"trait"`
+	Numeric  bool          `json:"numeric"`
+	Values   []interface{} `json:"values"`
+	Differs  bool          `json:"differs"`
+	Relative []float64     `json:"relative,omitempty"`
+	Min      *float64      `json:"min,omitempty"`
+	Max      *float64      `json:"max,omitempty"`
+}
+
+type OccurrenceSummary struct {
+	SpeciesID   int     `json:"species_id"`
+	Count       int     `json:"count"`
+	FirstSeen   string  `json:"first_seen"`
+	LastSeen    string  `json:"last_seen"`
+	RegionCount int     `json:"region_count"`
+	MeanDepth   float64 `json:"mean_depth"`
+	MaxDepth    float64 `json:"max_depth"`
+}
+
+type OtolithSummary struct {
+	SpeciesID        int     `json:"species_id"`
+	Count            int     `json:"count"`
+	MeanEstimatedAge float64 `json:"mean_estimated_age"`
+	MaxEstimatedAge  float64 `json:"max_estimated_age"`
+	MeanGrowthRate   float64 `json:"mean_growth_rate"`
+	MeanRingCount    float64 `json:"mean_ring_count"`
+	MeanArea         float64 `json:"mean_area"`
+	MeanCircularity  float64 `json:"mean_circularity"`
+}
+
+type SpeciesComparison struct {
+	Species     []SpeciesRef        `json:"species"`
+	Traits      []TraitRow          `json:"traits"`
+	Differences []string            `json:"differences"`
+	Occurrences []OccurrenceSummary `json:"occurrences"`
+	Otoliths    []OtolithSummary    `json:"otoliths"`
+}
+
+// parseIDList parses a comma-separated list of integer ids.
+func parseIDList(raw string) ([]int, error) {
+	var ids []int
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id %q", part)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// buildTraitRows walks the Species struct so new columns are compared
+// without touching this code.
+func buildTraitRows(species []Species) ([]TraitRow, []string) {
+	var traits []TraitRow
+	differences := []string{}
+
+	t := reflect.TypeOf(Species{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if compareSkipTraits[name] {
+			continue
+		}
+
+		row := TraitRow{Trait: name, Numeric: field.Type.Kind() == reflect.Float64}
+		var nums []float64
+		for _, s := range species {
+			v := reflect.ValueOf(s).Field(i)
+			switch field.Type.Kind() {
+			case reflect.Float64:
+				nums = append(nums, v.Float())
+				row.Values = append(row.Values, v.Float())
+			default:
+				row.Values = append(row.Values, v.Interface())
+			}
+		}
+
+		for j := 1; j < len(row.Values); j++ {
+			if !reflect.DeepEqual(row.Values[j], row.Values[0]) {
+				row.Differs = true
+				break
+			}
+		}
+
+		if row.Numeric {
+			lo, hi := nums[0], nums[0]
+			for _, n := range nums {
+				lo, hi = min(lo, n), max(hi, n)
+			}
+			row.Min, row.Max = &lo, &hi
+			if hi > 0 {
+				for _, n := range nums {
+					row.Relative = append(row.Relative, n/hi)
+				}
+			}
+		}
+
+		if row.Differs {
+			differences = append(differences, name)
+		}
+		traits = append(traits, row)
+	}
+	return traits, differences
+}
+
+func occurrenceSummaries(ids []int) ([]OccurrenceSummary, error) {
+	rows, err := db.Query(`SELECT species_id, COUNT(*), COALESCE(MIN(eventdate)::text, ''), COALESCE(MAX(eventdate)::text, ''),
+		COUNT(DISTINCT region), COALESCE(AVG(waterdepth_m), 0), COALESCE(MAX(waterdepth_m), 0)
+		FROM occurrence_data WHERE species_id = ANY($1) GROUP BY species_id`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byID := map[int]OccurrenceSummary{}
+	for rows.Next() {
+		var o OccurrenceSummary
+		if err := rows.Scan(&o.SpeciesID, &o.Count, &o.FirstSeen, &o.LastSeen, &o.RegionCount, &o.MeanDepth, &o.MaxDepth); err != nil {
+			continue
+		}
+		byID[o.SpeciesID] = o
+	}
+
+	summaries := make([]OccurrenceSummary, len(ids))
+	for i, id := range ids {
+		summaries[i] = byID[id]
+		summaries[i].SpeciesID = id
+	}
+	return summaries, rows.Err()
+}
+
+func otolithSummaries(ids []int) ([]OtolithSummary, error) {
+	rows, err := db.Query(`SELECT species_id, COUNT(*), COALESCE(AVG(estimated_age), 0), COALESCE(MAX(estimated_age), 0),
+		COALESCE(AVG(growth_rate), 0), COALESCE(AVG(ring_count), 0), COALESCE(AVG(area), 0), COALESCE(AVG(circularity), 0)
+		FROM otolith_metadata WHERE species_id = ANY($1) GROUP BY species_id`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byID := map[int]OtolithSummary{}
+	for rows.Next() {
+		var o OtolithSummary
+		if err := rows.Scan(&o.SpeciesID, &o.Count, &o.MeanEstimatedAge, &o.MaxEstimatedAge, &o.MeanGrowthRate, &o.MeanRingCount, &o.MeanArea, &o.MeanCircularity); err != nil {
+			continue
+		}
+		byID[o.SpeciesID] = o
+	}
+
+	summaries := make([]OtolithSummary, len(ids))
+	for i, id := range ids {
+		summaries[i] = byID[id]
+		summaries[i].SpeciesID = id
+	}
+	return summaries, rows.Err()
+}
+
+func compareSpecies(w http.ResponseWriter, r *http.Request) {
+	ids, err := parseIDList(r.URL.Query().Get("ids"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(ids) < minCompareSpecies || len(ids) > maxCompareSpecies {
+		http.Error(w, fmt.Sprintf("ids must list between %d and %d species", minCompareSpecies, maxCompareSpecies), http.StatusBadRequest)
+		return
+	}
+
+	found, err := querySpecies("s.id = ANY($1)", pq.Array(ids))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	byID := map[int]Species{}
+	for _, s := range found {
+		byID[s.ID] = s
+	}
+
+	// Keep the caller's order so every column lines up with ids.
+	species := make([]Species, 0, len(ids))
+	for _, id := range ids {
+		s, ok := byID[id]
+		if !ok {
+			http.Error(w, fmt.Sprintf("species %d not found", id), http.StatusNotFound)
+			return
+		}
+		species = append(species, s)
+	}
+
+	var result SpeciesComparison
+	for _, s := range species {
+		result.Species = append(result.Species, SpeciesRef{ID: s.ID, VernacularName: s.VernacularName, ScientificName: s.ScientificName})
+	}
+	result.Traits, result.Differences = buildTraitRows(species)
+	if result.Occurrences, err = occurrenceSummaries(ids); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if result.Otoliths, err = otolithSummaries(ids); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
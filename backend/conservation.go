@@ -0,0 +1,605 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// --- Conservation Status ---
+//
+// Conservation status is an IUCN Red List category, stored as the
+// iucn_category enum. Every assessment is kept in conservation_assessments
+// with its date, assessor, source and scope: global, or regional with the
+// region it covers. The latest global assessment is the species' current
+// category and is copied to species_data.iucn_category, with its label in
+// conservation_status so existing filters and the frontend keep working.
+//
+// Species without assessments get a category parsed from their free-text
+// conservation_status at startup; those are not assessments and add no
+// history.
+
+const conservationSchema = `
+DO $$ BEGIN
+	CREATE TYPE iucn_category AS ENUM ('EX', 'EW', 'CR', 'EN', 'VU', 'NT', 'LC', 'DD', 'NE');
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
+
+ALTER TABLE species_data ADD COLUMN IF NOT EXISTS iucn_category iucn_category;
+
+CREATE TABLE IF NOT EXISTS conservation_assessments (
+	id           SERIAL PRIMARY KEY,
+	species_id   INTEGER NOT NULL REFERENCES species_data(id) ON DELETE CASCADE,
+	category     iucn_category NOT NULL,
+	criteria     TEXT,
+	scope        TEXT NOT NULL DEFAULT 'global' CHECK (scope IN ('global', 'regional')),
+	region       TEXT NOT NULL DEFAULT '',
+	assessed_on  DATE NOT NULL,
+	assessor     TEXT,
+	source       TEXT,
+	source_url   TEXT,
+	notes        TEXT,
+	submitted_by TEXT NOT NULL,
+	created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+	CHECK ((scope = 'global') = (region = '')),
+	UNIQUE (species_id, scope, region, assessed_on)
+);
+CREATE INDEX IF NOT EXISTS conservation_assessments_date_idx ON conservation_assessments (assessed_on);
+
+DO $$ BEGIN
+	DELETE FROM conservation_assessments a WHERE NOT EXISTS (SELECT 1 FROM species_data s WHERE s.id = a.species_id);
+	ALTER TABLE conservation_assessments ADD CONSTRAINT conservation_assessments_species_id_fkey
+		FOREIGN KEY (species_id) REFERENCES species_data(id) ON DELETE CASCADE;
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;`
+
+type IUCNCategory string
+
+const (
+	iucnExtinct        IUCNCategory = "EX"
+	iucnExtinctInWild  IUCNCategory = "EW"
+	iucnCritical       IUCNCategory = "CR"
+	iucnEndangered     IUCNCategory = "EN"
+	iucnVulnerable     IUCNCategory = "VU"
+	iucnNearThreatened IUCNCategory = "NT"
+	iucnLeastConcern   IUCNCategory = "LC"
+	iucnDataDeficient  IUCNCategory = "DD"
+	iucnNotEvaluated   IUCNCategory = "NE"
+)
+
+// iucnCategories lists the categories from most to least threatened, then
+// those without a threat level, in enum order.
+var iucnCategories = []IUCNCategory{
+	iucnExtinct, iucnExtinctInWild, iucnCritical, iucnEndangered, iucnVulnerable,
+	iucnNearThreatened, iucnLeastConcern, iucnDataDeficient, iucnNotEvaluated,
+}
+
+var iucnLabels = map[IUCNCategory]string{
+	iucnExtinct:        "Extinct",
+	iucnExtinctInWild:  "Extinct in the Wild",
+	iucnCritical:       "Critically Endangered",
+	iucnEndangered:     "Endangered",
+	iucnVulnerable:     "Vulnerable",
+	iucnNearThreatened: "Near Threatened",
+	iucnLeastConcern:   "Least Concern",
+	iucnDataDeficient:  "Data Deficient",
+	iucnNotEvaluated:   "Not Evaluated",
+}
+
+func (c IUCNCategory) Label() string { return iucnLabels[c] }
+
+// Threatened reports whether c is one of the threatened categories CR, EN
+// and VU.
+func (c IUCNCategory) Threatened() bool {
+	return c == iucnCritical || c == iucnEndangered || c == iucnVulnerable
+}
+
+// rank orders categories by extinction risk, 0 for Least Concern up to 6
+// for Extinct; DD and NE have no rank.
+func (c IUCNCategory) rank() (int, bool) {
+	switch c {
+	case iucnLeastConcern:
+		return 0, true
+	case iucnNearThreatened:
+		return 1, true
+	case iucnVulnerable:
+		return 2, true
+	case iucnEndangered:
+		return 3, true
+	case iucnCritical:
+		return 4, true
+	case iucnExtinctInWild:
+		return 5, true
+	case iucnExtinct:
+		return 6, true
+	}
+	return 0, false
+}
+
+// iucnAliases maps lower-cased spellings found in species data and in Red
+// List exports, including the retired Lower Risk subcategories, to
+// categories.
+var iucnAliases = map[string]IUCNCategory{
+	"lr/cd": iucnNearThreatened, "lr/nt": iucnNearThreatened, "lr/lc": iucnLeastConcern,
+	"lower risk/conservation dependent": iucnNearThreatened, "lower risk/near threatened": iucnNearThreatened,
+	"lower risk/least concern": iucnLeastConcern, "conservation dependent": iucnNearThreatened,
+	"critically endangered (possibly extinct)": iucnCritical, "not assessed": iucnNotEvaluated,
+}
+
+var iucnSeparators = regexp.MustCompile(`[\s_-]+`)
+
+// parseIUCNCategory accepts a category code or label in any case. Free
+// text such as "Endangered (IUCN 2019)" is matched on its leading label.
+func parseIUCNCategory(s string) (IUCNCategory, bool) {
+	t := strings.ToLower(strings.TrimSpace(s))
+	if t == "" {
+		return "", false
+	}
+	if c, ok := iucnAliases[t]; ok {
+		return c, true
+	}
+	for _, c := range iucnCategories {
+		if strings.EqualFold(t, string(c)) {
+			return c, true
+		}
+	}
+	// Longest label first so "Critically Endangered" is not read as
+	// "Endangered".
+	labels := append([]IUCNCategory{}, iucnCategories...)
+	sort.SliceStable(labels, func(i, j int) bool { return len(iucnLabels[labels[i]]) > len(iucnLabels[labels[j]]) })
+	t = iucnSeparators.ReplaceAllString(t, " ")
+	for _, c := range labels {
+		if strings.HasPrefix(t, strings.ToLower(iucnLabels[c])) {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+// parseIUCNList parses a comma separated list of categories; "threatened"
+// stands for CR, EN and VU.
+func parseIUCNList(v string) ([]string, error) {
+	var out []string
+	for _, s := range splitList([]string{v}, ",") {
+		if strings.EqualFold(s, "threatened") {
+			out = append(out, string(iucnCritical), string(iucnEndangered), string(iucnVulnerable))
+			continue
+		}
+		c, ok := parseIUCNCategory(s)
+		if !ok {
+			return nil, fmt.Errorf("unknown IUCN category %q", s)
+		}
+		out = append(out, string(c))
+	}
+	return out, nil
+}
+
+type ConservationAssessment struct {
+	ID               int          `json:"id"`
+	SpeciesID        int          `json:"species_id"`
+	ScientificName   string       `json:"scientific_name,omitempty"`
+	Category         IUCNCategory `json:"category"`
+	CategoryLabel    string       `json:"category_label"`
+	Criteria         string       `json:"criteria,omitempty"`
+	Scope            string       `json:"scope"`
+	Region           string       `json:"region,omitempty"`
+	AssessedOn       string       `json:"assessed_on"`
+	Assessor         string       `json:"assessor,omitempty"`
+	Source           string       `json:"source,omitempty"`
+	SourceURL        string       `json:"source_url,omitempty"`
+	Notes            string       `json:"notes,omitempty"`
+	PreviousCategory IUCNCategory `json:"previous_category,omitempty"`
+	PreviousOn       string       `json:"previous_assessed_on,omitempty"`
+	Change           string       `json:"change"`
+	CreatedAt        string       `json:"created_at"`
+}
+
+// Change values compare an assessment with the previous one of the same
+// species and scope.
+const (
+	changeFirst        = "first"
+	changeUnchanged    = "unchanged"
+	changeUplisted     = "uplisted"
+	changeDownlisted   = "downlisted"
+	changeReclassified = "reclassified" // to or from DD or NE
+)
+
+func classifyChange(prev, cur IUCNCategory) string {
+	if prev == "" {
+		return changeFirst
+	}
+	if prev == cur {
+		return changeUnchanged
+	}
+	a, okA := prev.rank()
+	b, okB := cur.rank()
+	if !okA || !okB {
+		return changeReclassified
+	}
+	if b > a {
+		return changeUplisted
+	}
+	return changeDownlisted
+}
+
+// assessmentsWithPrevious selects assessments alongside the previous
+// assessment of the same species, scope and region.
+const assessmentsWithPrevious = `
+	SELECT a.*, LAG(a.category) OVER w AS previous_category, LAG(a.assessed_on) OVER w AS previous_on
+	FROM conservation_assessments a
+	WINDOW w AS (PARTITION BY a.species_id, a.scope, a.region ORDER BY a.assessed_on, a.id)`
+
+const assessmentColumns = `h.id, h.species_id, COALESCE(s.scientific_name, ''), h.category::text, COALESCE(h.criteria, ''), h.scope, h.region,
+	h.assessed_on::text, COALESCE(h.assessor, ''), COALESCE(h.source, ''), COALESCE(h.source_url, ''), COALESCE(h.notes, ''),
+	COALESCE(h.previous_category::text, ''), COALESCE(h.previous_on::text, ''), h.created_at::text`
+
+func scanAssessment(row interface{ Scan(...any) error }) (*ConservationAssessment, error) {
+	var a ConservationAssessment
+	err := row.Scan(&a.ID, &a.SpeciesID, &a.ScientificName, &a.Category, &a.Criteria, &a.Scope, &a.Region, &a.AssessedOn,
+		&a.Assessor, &a.Source, &a.SourceURL, &a.Notes, &a.PreviousCategory, &a.PreviousOn, &a.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	a.CategoryLabel = a.Category.Label()
+	a.Change = classifyChange(a.PreviousCategory, a.Category)
+	return &a, nil
+}
+
+// refreshCurrentStatus sets a species' current category from its latest
+// global assessment, clearing it when the last one is gone. Callers only
+// refresh after a global assessment changes, so categories parsed from
+// free text survive regional edits.
+func refreshCurrentStatus(tx *sql.Tx, speciesID int) error {
+	var category sql.NullString
+	err := tx.QueryRow(`SELECT category::text FROM conservation_assessments WHERE species_id = $1 AND scope = 'global'
+		ORDER BY assessed_on DESC, id DESC LIMIT 1`, speciesID).Scan(&category)
+	if err == sql.ErrNoRows {
+		_, err = tx.Exec("UPDATE species_data SET iucn_category = NULL, conservation_status = NULL WHERE id = $1", speciesID)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE species_data SET iucn_category = $2::iucn_category, conservation_status = $3 WHERE id = $1",
+		speciesID, category.String, IUCNCategory(category.String).Label())
+	return err
+}
+
+// backfillIUCNCategories parses the free-text conservation_status of
+// species that have no category yet, normalising the text to the label
+// when it parses.
+func backfillIUCNCategories() {
+	rows, err := db.Query("SELECT id, conservation_status FROM species_data WHERE iucn_category IS NULL AND conservation_status IS NOT NULL")
+	if err != nil {
+		log.Println("Could not backfill IUCN categories:", err)
+		return
+	}
+	parsed := map[IUCNCategory][]int{}
+	for rows.Next() {
+		var id int
+		var status string
+		if rows.Scan(&id, &status) != nil {
+			continue
+		}
+		if c, ok := parseIUCNCategory(status); ok {
+			parsed[c] = append(parsed[c], id)
+		}
+	}
+	rows.Close()
+
+	for c, ids := range parsed {
+		if _, err := db.Exec("UPDATE species_data SET iucn_category = $1::iucn_category, conservation_status = $2 WHERE id = ANY($3)",
+			string(c), c.Label(), pq.Array(ids)); err != nil {
+			log.Printf("Could not backfill IUCN category %s: %v", c, err)
+		}
+	}
+}
+
+// listIUCNCategories returns the IUCN categories with the number of species
+// currently in each.
+func listIUCNCategories(w http.ResponseWriter, r *http.Request) {
+	counts := map[string]int{}
+	rows, err := db.Query("SELECT iucn_category::text, COUNT(*) FROM species_data WHERE iucn_category IS NOT NULL GROUP BY 1")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c string
+		var n int
+		if rows.Scan(&c, &n) == nil {
+			counts[c] = n
+		}
+	}
+	type category struct {
+		Code       IUCNCategory `json:"code"`
+		Label      string       `json:"label"`
+		Threatened bool         `json:"threatened"`
+		Species    int          `json:"species"`
+	}
+	out := []category{}
+	for _, c := range iucnCategories {
+		out = append(out, category{c, c.Label(), c.Threatened(), counts[string(c)]})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// createAssessment records an assessment for the {id} species. A global
+// assessment that is the latest on record becomes the current status.
+func createAssessment(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireRole(w, r, moderatorRoles...)
+	if !ok {
+		return
+	}
+	speciesID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid species id", http.StatusBadRequest)
+		return
+	}
+	var body struct {
+		Category   string `json:"category"`
+		Criteria   string `json:"criteria"`
+		Scope      string `json:"scope"`
+		Region     string `json:"region"`
+		AssessedOn string `json:"assessed_on"`
+		Assessor   string `json:"assessor"`
+		Source     string `json:"source"`
+		SourceURL  string `json:"source_url"`
+		Notes      string `json:"notes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	category, ok := parseIUCNCategory(body.Category)
+	if !ok {
+		http.Error(w, "category must be an IUCN category code or name", http.StatusBadRequest)
+		return
+	}
+	assessed, err := time.Parse("2006-01-02", body.AssessedOn)
+	if err != nil || assessed.After(time.Now()) {
+		http.Error(w, "assessed_on must be a YYYY-MM-DD date not in the future", http.StatusBadRequest)
+		return
+	}
+	body.Region = strings.TrimSpace(body.Region)
+	switch body.Scope {
+	case "", "global":
+		body.Scope = "global"
+		if body.Region != "" {
+			http.Error(w, "region only applies to regional assessments", http.StatusBadRequest)
+			return
+		}
+	case "regional":
+		if body.Region == "" {
+			http.Error(w, "regional assessments need a region", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "scope must be global or regional", http.StatusBadRequest)
+		return
+	}
+	if exists, err := speciesExists(speciesID); err != nil || !exists {
+		http.Error(w, "Species not found", http.StatusNotFound)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	var id int
+	err = tx.QueryRow(`INSERT INTO conservation_assessments (species_id, category, criteria, scope, region, assessed_on, assessor, source,
+			source_url, notes, submitted_by)
+		VALUES ($1, $2::iucn_category, NULLIF($3, ''), $4, $5, $6::date, NULLIF($7, ''), NULLIF($8, ''), NULLIF($9, ''), NULLIF($10, ''), $11)
+		RETURNING id`,
+		speciesID, string(category), strings.TrimSpace(body.Criteria), body.Scope, body.Region, body.AssessedOn,
+		strings.TrimSpace(body.Assessor), strings.TrimSpace(body.Source), strings.TrimSpace(body.SourceURL), strings.TrimSpace(body.Notes),
+		user.ID).Scan(&id)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		http.Error(w, "The species already has an assessment with that scope and date", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.Scope == "global" {
+		if err := refreshCurrentStatus(tx, speciesID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	a, err := scanAssessment(db.QueryRow("SELECT "+assessmentColumns+" FROM ("+assessmentsWithPrevious+
+		") h LEFT JOIN species_data s ON s.id = h.species_id WHERE h.id = $1", id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(a)
+}
+
+// getConservationHistory returns a species' current category and its
+// assessments, newest first, each compared with the one before it.
+func getConservationHistory(w http.ResponseWriter, r *http.Request) {
+	speciesID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid species id", http.StatusBadRequest)
+		return
+	}
+	var current sql.NullString
+	var status string
+	err = db.QueryRow("SELECT iucn_category::text, COALESCE(conservation_status, 'Unknown') FROM species_data WHERE id = $1", speciesID).
+		Scan(&current, &status)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Species not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := db.Query("SELECT "+assessmentColumns+" FROM ("+assessmentsWithPrevious+
+		") h LEFT JOIN species_data s ON s.id = h.species_id WHERE h.species_id = $1 ORDER BY h.assessed_on DESC, h.id DESC", speciesID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	history := []*ConservationAssessment{}
+	for rows.Next() {
+		a, err := scanAssessment(rows)
+		if err != nil {
+			continue
+		}
+		history = append(history, a)
+	}
+
+	resp := map[string]interface{}{
+		"species_id":          speciesID,
+		"conservation_status": status,
+		"category":            nil,
+		"assessments":         history,
+	}
+	if current.Valid {
+		c := IUCNCategory(current.String)
+		resp["category"] = c
+		resp["category_label"] = c.Label()
+		resp["threatened"] = c.Threatened()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func deleteAssessment(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireRole(w, r, moderatorRoles...); !ok {
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	var speciesID int
+	var scope string
+	err = tx.QueryRow("DELETE FROM conservation_assessments WHERE id = $1::int RETURNING species_id, scope", r.PathValue("id")).
+		Scan(&speciesID, &scope)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Assessment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if scope == "global" {
+		if err := refreshCurrentStatus(tx, speciesID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getStatusChanges lists assessments dated within ?from= and ?to= that
+// changed a species' category from its previous assessment in the same
+// scope. ?direction= (uplisted, downlisted, reclassified) narrows the
+// change, ?scope= and ?region= the assessments, and ?category= the new
+// category. ?include_first=true also lists first assessments.
+func getStatusChanges(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	where := []string{"h.previous_category IS DISTINCT FROM h.category"}
+	args := []interface{}{}
+	for _, f := range []struct{ param, cond string }{
+		{"from", "h.assessed_on >= $%d::date"},
+		{"to", "h.assessed_on <= $%d::date"},
+	} {
+		if v := q.Get(f.param); v != "" {
+			if _, err := time.Parse("2006-01-02", v); err != nil {
+				http.Error(w, f.param+" must be a YYYY-MM-DD date", http.StatusBadRequest)
+				return
+			}
+			args = append(args, v)
+			where = append(where, fmt.Sprintf(f.cond, len(args)))
+		}
+	}
+	switch scope := q.Get("scope"); scope {
+	case "":
+	case "global", "regional":
+		args = append(args, scope)
+		where = append(where, fmt.Sprintf("h.scope = $%d", len(args)))
+	default:
+		http.Error(w, "scope must be global or regional", http.StatusBadRequest)
+		return
+	}
+	if region := strings.TrimSpace(q.Get("region")); region != "" {
+		args = append(args, region)
+		where = append(where, fmt.Sprintf("h.region ILIKE $%d", len(args)))
+	}
+	if v := q.Get("category"); v != "" {
+		cats, err := parseIUCNList(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		args = append(args, pq.Array(cats))
+		where = append(where, fmt.Sprintf("h.category::text = ANY($%d)", len(args)))
+	}
+	if q.Get("include_first") != "true" {
+		where = append(where, "h.previous_category IS NOT NULL")
+	}
+	direction := q.Get("direction")
+	if direction != "" && direction != changeUplisted && direction != changeDownlisted && direction != changeReclassified {
+		http.Error(w, "direction must be uplisted, downlisted or reclassified", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query("SELECT "+assessmentColumns+" FROM ("+assessmentsWithPrevious+
+		") h LEFT JOIN species_data s ON s.id = h.species_id WHERE "+strings.Join(where, " AND ")+
+		" ORDER BY h.assessed_on DESC, h.id DESC", args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer rows.Close()
+	changes := []*ConservationAssessment{}
+	summary := map[string]int{}
+	for rows.Next() {
+		a, err := scanAssessment(rows)
+		if err != nil {
+			continue
+		}
+		if direction != "" && a.Change != direction {
+			continue
+		}
+		changes = append(changes, a)
+		summary[a.Change]++
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"changes": changes, "summary": summary})
+}
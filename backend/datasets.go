@@ -0,0 +1,652 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// --- Dataset Registry ---
+//
+// Researchers upload datasets as multipart forms: metadata fields plus one
+// or more files. Files are spooled to disk while their SHA-256 is computed,
+// checked against any checksums the client sent, and then written to the
+// object store. Every dataset gets a stable accession (MDS000123) derived
+// from its row id, and every change records a version (see versions.go).
+
+const datasetSchema = `
+CREATE TABLE IF NOT EXISTS datasets (
+	id               SERIAL PRIMARY KEY,
+	accession        TEXT UNIQUE,
+	title            TEXT NOT NULL,
+	description      TEXT,
+	authors          TEXT[] NOT NULL DEFAULT '{}',
+	collection_start DATE,
+	collection_end   DATE,
+	keywords         TEXT[] NOT NULL DEFAULT '{}',
+	is_public        BOOLEAN NOT NULL DEFAULT false,
+	owner_id         TEXT NOT NULL,
+	owner_email      TEXT,
+	created_at       TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at       TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS datasets_owner_idx ON datasets (owner_id);
+
+CREATE TABLE IF NOT EXISTS dataset_files (
+	id           SERIAL PRIMARY KEY,
+	dataset_id   INTEGER NOT NULL REFERENCES datasets(id) ON DELETE CASCADE,
+	file_name    TEXT NOT NULL,
+	object_key   TEXT NOT NULL,
+	content_type TEXT,
+	size_bytes   BIGINT NOT NULL,
+	sha256       TEXT NOT NULL,
+	uploaded_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS dataset_files_dataset_idx ON dataset_files (dataset_id);`
+
+const (
+	maxDatasetUpload = 5 << 30
+	maxFormFieldSize = 1 << 20
+)
+
+type DatasetFile struct {
+	ID          int    `json:"id"`
+	FileName    string `json:"file_name"`
+	ContentType string `json:"content_type"`
+	SizeBytes   int64  `json:"size_bytes"`
+	SHA256      string `json:"sha256"`
+	UploadedAt  string `json:"uploaded_at"`
+}
+
+type Dataset struct {
+	ID              int           `json:"id"`
+	Accession       string        `json:"accession"`
+	Title           string        `json:"title"`
+	Description     string        `json:"description"`
+	Authors         []string      `json:"authors"`
+	CollectionStart string        `json:"collection_start,omitempty"`
+	CollectionEnd   string        `json:"collection_end,omitempty"`
+	Keywords        []string      `json:"keywords"`
+	IsPublic        bool          `json:"is_public"`
+	Status          string        `json:"status"`
+	OwnerID         string        `json:"uploaded_by_id"`
+	CreatedAt       string        `json:"created_at"`
+	UpdatedAt       string        `json:"updated_at"`
+	Version         int           `json:"version"`
+	DOI             string        `json:"doi,omitempty"`
+	FileCount       int           `json:"file_count"`
+	TotalSize       int64         `json:"total_size"`
+	Files           []DatasetFile `json:"files,omitempty"`
+}
+
+// spooledFile is an uploaded file held on local disk until it is verified.
+type spooledFile struct {
+	Name        string
+	ContentType string
+	Path        string
+	Size        int64
+	SHA256      string
+}
+
+func (f *spooledFile) Remove() {
+	os.Remove(f.Path)
+}
+
+func datasetAccession(id int) string {
+	return fmt.Sprintf("MDS%06d", id)
+}
+
+// spoolPart copies an upload to a temporary file, hashing it on the way.
+func spoolPart(r io.Reader, name, contentType string) (*spooledFile, error) {
+	tmp, err := os.CreateTemp("", "dataset-upload-*")
+	if err != nil {
+		return nil, err
+	}
+	defer tmp.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	if contentType == "" || contentType == "application/octet-stream" {
+		if byExt := mime.TypeByExtension(path.Ext(name)); byExt != "" {
+			contentType = byExt
+		}
+	}
+	return &spooledFile{Name: path.Base(name), ContentType: contentType, Path: tmp.Name(), Size: size, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// readUploadForm streams a multipart request, returning its text fields and
+// the spooled files. The caller must Remove the files.
+func readUploadForm(w http.ResponseWriter, r *http.Request) (url.Values, []*spooledFile, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxDatasetUpload)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, nil, fmt.Errorf("expected a multipart form")
+	}
+
+	fields := url.Values{}
+	var files []*spooledFile
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			for _, f := range files {
+				f.Remove()
+			}
+			return nil, nil, fmt.Errorf("reading upload: %w", err)
+		}
+		if part.FileName() == "" {
+			value, _ := io.ReadAll(io.LimitReader(part, maxFormFieldSize))
+			fields.Add(part.FormName(), string(value))
+			continue
+		}
+		f, err := spoolPart(part, part.FileName(), part.Header.Get("Content-Type"))
+		if err != nil {
+			for _, f := range files {
+				f.Remove()
+			}
+			return nil, nil, err
+		}
+		files = append(files, f)
+	}
+	return fields, files, nil
+}
+
+// verifyChecksums compares each file against the optional "checksums" field,
+// a JSON object mapping file names to hex SHA-256 digests.
+func verifyChecksums(fields url.Values, files []*spooledFile) error {
+	raw := fields.Get("checksums")
+	if raw == "" {
+		return nil
+	}
+	var expected map[string]string
+	if err := json.Unmarshal([]byte(raw), &expected); err != nil {
+		return fmt.Errorf("checksums must be a JSON object of file name to SHA-256")
+	}
+	var mismatched []string
+	for _, f := range files {
+		if want, ok := expected[f.Name]; ok && !strings.EqualFold(want, f.SHA256) {
+			mismatched = append(mismatched, fmt.Sprintf("%s (expected %s, got %s)", f.Name, want, f.SHA256))
+		}
+	}
+	if len(mismatched) > 0 {
+		return fmt.Errorf("checksum mismatch: %s", strings.Join(mismatched, "; "))
+	}
+	return nil
+}
+
+// splitList splits repeated or delimited form values into a clean list.
+func splitList(values []string, seps string) []string {
+	out := []string{}
+	for _, v := range values {
+		for _, item := range strings.FieldsFunc(v, func(r rune) bool { return strings.ContainsRune(seps, r) }) {
+			if item = strings.TrimSpace(item); item != "" {
+				out = append(out, item)
+			}
+		}
+	}
+	return out
+}
+
+func parseDatasetMetadata(fields url.Values) (*Dataset, error) {
+	ds := &Dataset{
+		Title:       strings.TrimSpace(fields.Get("title")),
+		Description: strings.TrimSpace(fields.Get("description")),
+		Authors:     splitList(fields["authors"], ";\n"),
+		Keywords:    splitList(fields["keywords"], ",;\n"),
+	}
+	if ds.Title == "" {
+		return nil, fmt.Errorf("title is required")
+	}
+	if len(ds.Authors) == 0 {
+		return nil, fmt.Errorf("at least one author is required")
+	}
+
+	switch strings.ToLower(fields.Get("is_public")) {
+	case "true", "1", "yes", "public":
+		ds.IsPublic = true
+	}
+
+	ds.CollectionStart = fields.Get("collection_start")
+	ds.CollectionEnd = fields.Get("collection_end")
+	if date := fields.Get("collection_date"); date != "" {
+		ds.CollectionStart, ds.CollectionEnd = date, date
+	}
+	for _, d := range []string{ds.CollectionStart, ds.CollectionEnd} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", d); err != nil {
+			return nil, fmt.Errorf("collection dates must be YYYY-MM-DD")
+		}
+	}
+	if ds.CollectionStart != "" && ds.CollectionEnd != "" && ds.CollectionEnd < ds.CollectionStart {
+		return nil, fmt.Errorf("collection_end is before collection_start")
+	}
+	return ds, nil
+}
+
+// putDatasetObject writes one file to the object store under the
+// dataset's prefix and returns its key.
+func putDatasetObject(ctx context.Context, accession, name, contentType string, r io.Reader, size int64) (string, error) {
+	key := fmt.Sprintf("datasets/%s/%s/%s", accession, randomID(8), path.Base(name))
+	return key, store.Put(ctx, key, r, size, contentType)
+}
+
+// recordDatasetFile inserts the row for an object that is already stored.
+func recordDatasetFile(tx *sql.Tx, ds *Dataset, key, name, contentType string, size int64, sum string) (DatasetFile, error) {
+	f := DatasetFile{FileName: path.Base(name), ContentType: contentType, SizeBytes: size, SHA256: sum}
+	err := tx.QueryRow(`INSERT INTO dataset_files (dataset_id, file_name, object_key, content_type, size_bytes, sha256)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, uploaded_at::text`,
+		ds.ID, f.FileName, key, contentType, size, sum).Scan(&f.ID, &f.UploadedAt)
+	return f, err
+}
+
+// deleteObjects removes stored objects whose rows were never committed.
+func deleteObjects(keys []string) {
+	for _, key := range keys {
+		store.Delete(context.Background(), key)
+	}
+}
+
+// storeSpooledFiles writes every spooled file to the object store before
+// any transaction is opened, returning their keys in order. If one fails,
+// the objects already written are deleted.
+func storeSpooledFiles(ctx context.Context, accession string, files []*spooledFile) ([]string, error) {
+	keys := make([]string, 0, len(files))
+	for _, sf := range files {
+		f, err := os.Open(sf.Path)
+		if err != nil {
+			deleteObjects(keys)
+			return nil, err
+		}
+		key, err := putDatasetObject(ctx, accession, sf.Name, sf.ContentType, f, sf.Size)
+		f.Close()
+		if err != nil {
+			deleteObjects(append(keys, key))
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// recordSpooledFiles inserts the rows for files stored by storeSpooledFiles.
+func recordSpooledFiles(tx *sql.Tx, ds *Dataset, files []*spooledFile, keys []string) error {
+	for i, sf := range files {
+		df, err := recordDatasetFile(tx, ds, keys[i], sf.Name, sf.ContentType, sf.Size, sf.SHA256)
+		if err != nil {
+			return err
+		}
+		ds.Files = append(ds.Files, df)
+	}
+	return nil
+}
+
+const datasetColumns = `d.id, d.accession, d.title, COALESCE(d.description, ''), d.authors, COALESCE(d.collection_start::text, ''),
+	COALESCE(d.collection_end::text, ''), d.keywords, d.is_public, d.owner_id, d.created_at::text, d.updated_at::text,
+	COALESCE((SELECT MAX(v.version) FROM dataset_versions v WHERE v.dataset_id = d.id), 0),
+	COALESCE((SELECT v.doi FROM dataset_versions v WHERE v.dataset_id = d.id AND v.doi IS NOT NULL ORDER BY v.version DESC LIMIT 1), ''),
+	(SELECT COUNT(*) FROM dataset_files f WHERE f.dataset_id = d.id),
+	(SELECT COALESCE(SUM(size_bytes), 0) FROM dataset_files f WHERE f.dataset_id = d.id)`
+
+func scanDataset(row interface{ Scan(...any) error }) (*Dataset, error) {
+	var ds Dataset
+	err := row.Scan(&ds.ID, &ds.Accession, &ds.Title, &ds.Description, pq.Array(&ds.Authors), &ds.CollectionStart,
+		&ds.CollectionEnd, pq.Array(&ds.Keywords), &ds.IsPublic, &ds.OwnerID, &ds.CreatedAt, &ds.UpdatedAt,
+		&ds.Version, &ds.DOI, &ds.FileCount, &ds.TotalSize)
+	if err != nil {
+		return nil, err
+	}
+	ds.Status = "Private"
+	if ds.IsPublic {
+		ds.Status = "Public"
+	}
+	return &ds, nil
+}
+
+// loadDataset finds a dataset by accession or numeric id.
+func loadDataset(ref string) (*Dataset, error) {
+	id, err := strconv.Atoi(ref)
+	if err != nil {
+		return scanDataset(db.QueryRow("SELECT "+datasetColumns+" FROM datasets d WHERE d.accession = $1", strings.ToUpper(ref)))
+	}
+	return scanDataset(db.QueryRow("SELECT "+datasetColumns+" FROM datasets d WHERE d.id = $1", id))
+}
+
+func loadDatasetFiles(datasetID int) ([]DatasetFile, error) {
+	rows, err := db.Query(`SELECT id, file_name, COALESCE(content_type, ''), size_bytes, sha256, uploaded_at::text
+		FROM dataset_files WHERE dataset_id = $1 ORDER BY id`, datasetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []DatasetFile{}
+	for rows.Next() {
+		var f DatasetFile
+		if err := rows.Scan(&f.ID, &f.FileName, &f.ContentType, &f.SizeBytes, &f.SHA256, &f.UploadedAt); err != nil {
+			continue
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+func (ds *Dataset) canRead(user *AuthUser) bool {
+	return ds.IsPublic || ds.canWrite(user)
+}
+
+func (ds *Dataset) canWrite(user *AuthUser) bool {
+	return user != nil && (user.ID == ds.OwnerID || user.hasRole(roleAdministrator))
+}
+
+// datasetForRequest loads the {id} dataset and checks read access, writing
+// the error response itself when it returns nil.
+func datasetForRequest(w http.ResponseWriter, r *http.Request, user *AuthUser) *Dataset {
+	ds, err := loadDataset(r.PathValue("id"))
+	if err == sql.ErrNoRows || (err == nil && !ds.canRead(user)) {
+		http.Error(w, "Dataset not found", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+	return ds
+}
+
+// --- Handlers ---
+
+func createDataset(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireRole(w, r, contributorRoles...)
+	if !ok {
+		return
+	}
+
+	fields, files, err := readUploadForm(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer func() {
+		for _, f := range files {
+			f.Remove()
+		}
+	}()
+
+	ds, err := parseDatasetMetadata(fields)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := verifyChecksums(fields, files); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	// The id is taken up front so the files can be stored under the
+	// accession before the transaction opens; a slow object store then
+	// never holds the transaction open.
+	if err := db.QueryRow("SELECT nextval(pg_get_serial_sequence('datasets', 'id'))").Scan(&ds.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ds.Accession = datasetAccession(ds.ID)
+	keys, err := storeSpooledFiles(r.Context(), ds.Accession, files)
+	if err != nil {
+		http.Error(w, "Failed to store files: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			deleteObjects(keys)
+		}
+	}()
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO datasets (id, accession, title, description, authors, collection_start, collection_end, keywords,
+			is_public, owner_id, owner_email)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, '')::date, NULLIF($7, '')::date, $8, $9, $10, $11)`,
+		ds.ID, ds.Accession, ds.Title, ds.Description, pq.Array(ds.Authors), ds.CollectionStart, ds.CollectionEnd,
+		pq.Array(ds.Keywords), ds.IsPublic, user.ID, user.Email)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := recordSpooledFiles(tx, ds, files, keys); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := recordDatasetVersion(tx, ds.ID, user.ID, "Initial version"); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	committed = true
+
+	created, err := loadDataset(ds.Accession)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	created.Files = ds.Files
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+func addDatasetFiles(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	ds := datasetForRequest(w, r, user)
+	if ds == nil {
+		return
+	}
+	if !ds.canWrite(user) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	fields, files, err := readUploadForm(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer func() {
+		for _, f := range files {
+			f.Remove()
+		}
+	}()
+	if len(files) == 0 {
+		http.Error(w, "No files uploaded", http.StatusBadRequest)
+		return
+	}
+	if err := verifyChecksums(fields, files); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	keys, err := storeSpooledFiles(r.Context(), ds.Accession, files)
+	if err != nil {
+		http.Error(w, "Failed to store files: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			deleteObjects(keys)
+		}
+	}()
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := recordSpooledFiles(tx, ds, files, keys); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := tx.Exec("UPDATE datasets SET updated_at = now() WHERE id = $1", ds.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	note := strings.TrimSpace(fields.Get("change_note"))
+	if note == "" {
+		note = fmt.Sprintf("Added %d file(s)", len(files))
+	}
+	if _, err := recordDatasetVersion(tx, ds.ID, user.ID, note); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	committed = true
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ds.Files)
+}
+
+// listDatasets returns public datasets plus the caller's own; ?mine=true
+// restricts the list to the caller's datasets.
+func listDatasets(w http.ResponseWriter, r *http.Request) {
+	user := optionalUser(r)
+	q := r.URL.Query()
+
+	where := []string{}
+	args := []interface{}{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	switch {
+	case q.Get("mine") == "true":
+		if user == nil {
+			http.Error(w, errUnauthenticated.Error(), http.StatusUnauthorized)
+			return
+		}
+		where = append(where, "d.owner_id = "+arg(user.ID))
+	case user != nil && user.hasRole(roleAdministrator):
+		where = append(where, "1=1")
+	case user != nil:
+		where = append(where, "(d.is_public OR d.owner_id = "+arg(user.ID)+")")
+	default:
+		where = append(where, "d.is_public")
+	}
+	if search := q.Get("q"); search != "" {
+		p := arg("%" + search + "%")
+		where = append(where, "(d.title ILIKE "+p+" OR d.description ILIKE "+p+" OR array_to_string(d.keywords, ' ') ILIKE "+p+")")
+	}
+	if keyword := q.Get("keyword"); keyword != "" {
+		where = append(where, arg(keyword)+" = ANY(d.keywords)")
+	}
+
+	page, pageSize := pagination(r)
+	limit, offset := arg(pageSize), arg((page-1)*pageSize)
+	rows, err := db.Query("SELECT "+datasetColumns+" FROM datasets d WHERE "+strings.Join(where, " AND ")+
+		" ORDER BY d.created_at DESC LIMIT "+limit+" OFFSET "+offset, args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	datasets := []*Dataset{}
+	for rows.Next() {
+		ds, err := scanDataset(rows)
+		if err != nil {
+			continue
+		}
+		datasets = append(datasets, ds)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(datasets)
+}
+
+func getDataset(w http.ResponseWriter, r *http.Request) {
+	ds := datasetForRequest(w, r, optionalUser(r))
+	if ds == nil {
+		return
+	}
+	files, err := loadDatasetFiles(ds.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ds.Files = files
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ds)
+}
+
+func downloadDatasetFile(w http.ResponseWriter, r *http.Request) {
+	ds := datasetForRequest(w, r, optionalUser(r))
+	if ds == nil {
+		return
+	}
+
+	var name, key, contentType, sum string
+	var size int64
+	err := db.QueryRow(`SELECT file_name, object_key, COALESCE(content_type, ''), size_bytes, sha256
+		FROM dataset_files WHERE id = $1 AND dataset_id = $2`, r.PathValue("fileId"), ds.ID).Scan(&name, &key, &contentType, &size, &sum)
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	body, err := store.Get(r.Context(), key)
+	if err != nil {
+		http.Error(w, "Failed to read file: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer body.Close()
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	w.Header().Set("X-Checksum-SHA256", sum)
+	io.Copy(w, body)
+}
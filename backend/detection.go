@@ -0,0 +1,823 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// --- Marine Heatwave and Hypoxia Detection ---
+//
+// Marine heatwaves follow Hobday et al. (2016): daily mean temperature
+// above the seasonally varying 90th-percentile threshold for at least five
+// days, with events separated by two days or less joined. The climatology
+// pools an 11-day window around each day of year across the baseline years
+// and is smoothed with a 31-day moving average. Each station keeps its
+// baseline in env_baselines: the one last set through the handler, or else
+// the up to 30 complete years before the latest year of data, fixed when
+// first computed so that the period being tested never moves its own
+// threshold. Categories follow Hobday et al. (2018), from the peak
+// intensity in multiples of the threshold-minus-climatology difference.
+//
+// Hypoxia is dissolved oxygen below a threshold, judged on hourly means at
+// the station's worst depth.
+
+const envClimatologySchema = `
+CREATE TABLE IF NOT EXISTS env_climatology (
+	station_id     INTEGER NOT NULL REFERENCES env_stations(id) ON DELETE CASCADE,
+	parameter      TEXT NOT NULL,
+	day_of_year    INTEGER NOT NULL,
+	mean           DOUBLE PRECISION,
+	p90            DOUBLE PRECISION,
+	baseline_start INTEGER NOT NULL,
+	baseline_end   INTEGER NOT NULL,
+	computed_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+	PRIMARY KEY (station_id, parameter, day_of_year)
+);
+
+CREATE TABLE IF NOT EXISTS env_baselines (
+	station_id     INTEGER NOT NULL REFERENCES env_stations(id) ON DELETE CASCADE,
+	parameter      TEXT NOT NULL,
+	baseline_start INTEGER NOT NULL,
+	baseline_end   INTEGER NOT NULL,
+	set_by         TEXT,
+	updated_at     TIMESTAMPTZ NOT NULL DEFAULT now(),
+	PRIMARY KEY (station_id, parameter)
+);`
+
+const (
+	mhwMinDuration     = 5
+	mhwMaxJoinGap      = 2
+	mhwWindowHalfWidth = 5
+	mhwSmoothWidth     = 31
+	mhwPercentile      = 0.9
+	mhwMaxInterpGap    = 2
+
+	defaultMinBaselineYears  = 3
+	defaultBaselineYears     = 30
+	defaultHypoxiaThreshold  = 2.0
+	severeHypoxiaThreshold   = 1.0
+	anoxiaThreshold          = 0.2
+	defaultHypoxiaMinHours   = 1
+	detectionInterval        = time.Hour
+	climatologyDaysPerYear   = 366
+	alertTypeMarineHeatwave  = "marine_heatwave"
+	alertTypeHypoxia         = "hypoxia"
+	parameterTemperature     = "temperature_c"
+	parameterDissolvedOxygen = "dissolved_oxygen_mg_l"
+)
+
+// DetectionOptions tunes a detection run. A baseline given here replaces
+// the stations' stored baselines; SetBy records who changed it.
+type DetectionOptions struct {
+	Station            string  `json:"station"`
+	BaselineStart      int     `json:"baseline_start"`
+	BaselineEnd        int     `json:"baseline_end"`
+	MinBaselineYears   int     `json:"min_baseline_years"`
+	HypoxiaThreshold   float64 `json:"hypoxia_threshold"`
+	HypoxiaMinDuration int     `json:"hypoxia_min_hours"`
+	SetBy              string  `json:"-"`
+}
+
+func (o *DetectionOptions) defaults() {
+	if o.MinBaselineYears <= 0 {
+		o.MinBaselineYears = defaultMinBaselineYears
+	}
+	if o.HypoxiaThreshold <= 0 {
+		o.HypoxiaThreshold = defaultHypoxiaThreshold
+	}
+	if o.HypoxiaMinDuration <= 0 {
+		o.HypoxiaMinDuration = defaultHypoxiaMinHours
+	}
+}
+
+type StationDetection struct {
+	Station         string   `json:"station"`
+	MarineHeatwaves int      `json:"marine_heatwaves"`
+	HypoxiaEvents   int      `json:"hypoxia_events"`
+	NewAlerts       int      `json:"new_alerts"`
+	BaselineStart   int      `json:"baseline_start,omitempty"`
+	BaselineEnd     int      `json:"baseline_end,omitempty"`
+	Skipped         []string `json:"skipped"`
+	ClimatologyDays int      `json:"climatology_days"`
+	TemperatureDays int      `json:"temperature_days"`
+	OxygenHours     int      `json:"oxygen_hours"`
+}
+
+// dayOfYear maps dates onto a 366-day year, so 1 March is day 61 in every
+// year and 29 February (day 60) only occurs in leap years.
+func dayOfYear(t time.Time) int {
+	doy := t.YearDay()
+	leap := time.Date(t.Year(), 12, 31, 0, 0, 0, 0, time.UTC).YearDay() == 366
+	if !leap && doy >= 60 {
+		doy++
+	}
+	return doy
+}
+
+// percentile interpolates linearly between order statistics, as numpy does.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	pos := p * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo))
+}
+
+// dailySeries is a gap-aware daily series; Values[i] is day Start+i and is
+// NaN where there is no data.
+type dailySeries struct {
+	Start  time.Time
+	Values []float64
+}
+
+func (s dailySeries) day(i int) time.Time {
+	return s.Start.AddDate(0, 0, i)
+}
+
+// loadDailyMeans returns daily means at the station's shallowest depth,
+// with gaps of up to mhwMaxInterpGap days linearly interpolated.
+func loadDailyMeans(stationID int, parameter string) (dailySeries, error) {
+	rows, err := db.Query(`SELECT date_trunc('day', observed_at AT TIME ZONE 'UTC'), AVG(value) FROM env_observations
+		WHERE station_id = $1 AND parameter = $2
+			AND depth_m = (SELECT MIN(depth_m) FROM env_observations WHERE station_id = $1 AND parameter = $2)
+		GROUP BY 1 ORDER BY 1`, stationID, parameter)
+	if err != nil {
+		return dailySeries{}, err
+	}
+	defer rows.Close()
+
+	var s dailySeries
+	for rows.Next() {
+		var t time.Time
+		var v float64
+		if err := rows.Scan(&t, &v); err != nil {
+			return dailySeries{}, err
+		}
+		t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		if s.Values == nil {
+			s.Start = t
+		}
+		i := int(t.Sub(s.Start).Hours() / 24)
+		for len(s.Values) < i {
+			s.Values = append(s.Values, math.NaN())
+		}
+		s.Values = append(s.Values, v)
+	}
+	if err := rows.Err(); err != nil {
+		return dailySeries{}, err
+	}
+
+	for i := 0; i < len(s.Values); i++ {
+		if !math.IsNaN(s.Values[i]) {
+			continue
+		}
+		j := i
+		for j < len(s.Values) && math.IsNaN(s.Values[j]) {
+			j++
+		}
+		if i > 0 && j < len(s.Values) && j-i <= mhwMaxInterpGap {
+			a, b := s.Values[i-1], s.Values[j]
+			for k := i; k < j; k++ {
+				s.Values[k] = a + (b-a)*float64(k-i+1)/float64(j-i+1)
+			}
+		}
+		i = j
+	}
+	return s, nil
+}
+
+// climatology holds the smoothed mean and 90th percentile per day of year,
+// indexed 1..366.
+type climatology struct {
+	Mean, P90                  [climatologyDaysPerYear + 1]float64
+	BaselineStart, BaselineEnd int
+}
+
+func smoothCircular(values []float64, width int) []float64 {
+	n := len(values)
+	out := make([]float64, n)
+	half := width / 2
+	for i := range values {
+		sum, count := 0.0, 0
+		for k := -half; k <= half; k++ {
+			v := values[((i+k)%n+n)%n]
+			if !math.IsNaN(v) {
+				sum += v
+				count++
+			}
+		}
+		out[i] = math.NaN()
+		if count > 0 {
+			out[i] = sum / float64(count)
+		}
+	}
+	return out
+}
+
+// computeClimatology builds the baseline from the years in
+// [baselineStart, baselineEnd], or every year with data when they are zero.
+func computeClimatology(s dailySeries, baselineStart, baselineEnd int) (*climatology, int) {
+	pools := make([][]float64, climatologyDaysPerYear+1)
+	years := map[int]bool{}
+	for i, v := range s.Values {
+		if math.IsNaN(v) {
+			continue
+		}
+		t := s.day(i)
+		if baselineStart > 0 && t.Year() < baselineStart || baselineEnd > 0 && t.Year() > baselineEnd {
+			continue
+		}
+		years[t.Year()] = true
+		doy := dayOfYear(t)
+		for k := -mhwWindowHalfWidth; k <= mhwWindowHalfWidth; k++ {
+			d := (doy+k-1+climatologyDaysPerYear)%climatologyDaysPerYear + 1
+			pools[d] = append(pools[d], v)
+		}
+	}
+
+	mean := make([]float64, climatologyDaysPerYear)
+	p90 := make([]float64, climatologyDaysPerYear)
+	for d := 1; d <= climatologyDaysPerYear; d++ {
+		pool := pools[d]
+		if len(pool) == 0 {
+			mean[d-1], p90[d-1] = math.NaN(), math.NaN()
+			continue
+		}
+		sort.Float64s(pool)
+		sum := 0.0
+		for _, v := range pool {
+			sum += v
+		}
+		mean[d-1], p90[d-1] = sum/float64(len(pool)), percentile(pool, mhwPercentile)
+	}
+	mean, p90 = smoothCircular(mean, mhwSmoothWidth), smoothCircular(p90, mhwSmoothWidth)
+
+	c := &climatology{BaselineStart: math.MaxInt, BaselineEnd: 0}
+	for y := range years {
+		c.BaselineStart, c.BaselineEnd = min(c.BaselineStart, y), max(c.BaselineEnd, y)
+	}
+	for d := 1; d <= climatologyDaysPerYear; d++ {
+		c.Mean[d], c.P90[d] = mean[d-1], p90[d-1]
+	}
+	return c, len(years)
+}
+
+func saveClimatology(stationID int, parameter string, c *climatology) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`INSERT INTO env_climatology (station_id, parameter, day_of_year, mean, p90, baseline_start, baseline_end)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (station_id, parameter, day_of_year) DO UPDATE SET mean = EXCLUDED.mean, p90 = EXCLUDED.p90,
+			baseline_start = EXCLUDED.baseline_start, baseline_end = EXCLUDED.baseline_end, computed_at = now()`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for d := 1; d <= climatologyDaysPerYear; d++ {
+		var mean, p90 *float64
+		if !math.IsNaN(c.Mean[d]) {
+			mean = &c.Mean[d]
+		}
+		if !math.IsNaN(c.P90[d]) {
+			p90 = &c.P90[d]
+		}
+		if _, err := stmt.Exec(stationID, parameter, d, mean, p90, c.BaselineStart, c.BaselineEnd); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+type heatwaveEvent struct {
+	Start, End, Peak    int
+	MaxIntensity        float64
+	MeanIntensity       float64
+	CumulativeIntensity float64
+	PeakTemperature     float64
+	ClimatologyAtPeak   float64
+	ThresholdAtPeak     float64
+	Category            int
+	CategoryName        string
+	Ongoing             bool
+	DurationDays        int
+}
+
+var mhwCategoryNames = []string{"", "I Moderate", "II Strong", "III Severe", "IV Extreme"}
+
+// detectHeatwaves finds Hobday events in s against c. An event that runs
+// to the last day of data is ongoing when that day is recent.
+func detectHeatwaves(s dailySeries, c *climatology) []heatwaveEvent {
+	exceed := make([]bool, len(s.Values))
+	for i, v := range s.Values {
+		doy := dayOfYear(s.day(i))
+		exceed[i] = !math.IsNaN(v) && !math.IsNaN(c.P90[doy]) && v > c.P90[doy]
+	}
+
+	type run struct{ start, end int }
+	var runs []run
+	for i := 0; i < len(exceed); i++ {
+		if !exceed[i] {
+			continue
+		}
+		j := i
+		for j+1 < len(exceed) && exceed[j+1] {
+			j++
+		}
+		if j-i+1 >= mhwMinDuration {
+			if n := len(runs); n > 0 && i-runs[n-1].end-1 <= mhwMaxJoinGap {
+				runs[n-1].end = j
+			} else {
+				runs = append(runs, run{i, j})
+			}
+		}
+		i = j
+	}
+
+	recent := time.Now().UTC().AddDate(0, 0, -mhwMaxJoinGap-1)
+	var events []heatwaveEvent
+	for _, r := range runs {
+		e := heatwaveEvent{Start: r.start, End: r.end, Peak: r.start, MaxIntensity: math.Inf(-1), DurationDays: r.end - r.start + 1}
+		n := 0
+		for i := r.start; i <= r.end; i++ {
+			if math.IsNaN(s.Values[i]) {
+				continue
+			}
+			doy := dayOfYear(s.day(i))
+			intensity := s.Values[i] - c.Mean[doy]
+			e.CumulativeIntensity += intensity
+			n++
+			if intensity > e.MaxIntensity {
+				e.MaxIntensity, e.Peak = intensity, i
+				e.PeakTemperature, e.ClimatologyAtPeak, e.ThresholdAtPeak = s.Values[i], c.Mean[doy], c.P90[doy]
+			}
+		}
+		if n > 0 {
+			e.MeanIntensity = e.CumulativeIntensity / float64(n)
+		}
+		if diff := e.ThresholdAtPeak - e.ClimatologyAtPeak; diff > 0 {
+			e.Category = min(int(math.Floor(e.MaxIntensity/diff)), 4)
+		}
+		e.Category = max(e.Category, 1)
+		e.CategoryName = mhwCategoryNames[e.Category]
+		e.Ongoing = r.end == len(s.Values)-1 && !s.day(r.end).Before(recent)
+		events = append(events, e)
+	}
+	return events
+}
+
+func heatwaveSeverity(category int) string {
+	switch category {
+	case 1:
+		return severityMedium
+	case 2:
+		return severityHigh
+	}
+	return severityCritical
+}
+
+func stationLabel(st *EnvStation) string {
+	if st.Name != "" {
+		return st.Name
+	}
+	return st.Code
+}
+
+func heatwaveAlert(st *EnvStation, s dailySeries, c *climatology, e heatwaveEvent) *Alert {
+	start, peak, end := s.day(e.Start), s.day(e.Peak), s.day(e.End).AddDate(0, 0, 1)
+	duration := float64(e.DurationDays * 24)
+	a := &Alert{
+		Category:      categoryEnvironmental,
+		Severity:      heatwaveSeverity(e.Category),
+		Type:          alertTypeMarineHeatwave,
+		Title:         "Marine Heatwave Detected",
+		Region:        st.Region,
+		StationID:     &st.ID,
+		StartedAt:     start.Format(time.RFC3339),
+		PeakAt:        peak.Format(time.RFC3339),
+		DurationHours: &duration,
+		PeakValue:     &e.PeakTemperature,
+		EventCategory: e.CategoryName,
+		DedupeKey:     fmt.Sprintf("%s:%d:%s", alertTypeMarineHeatwave, st.ID, start.Format("2006-01-02")),
+	}
+	if !e.Ongoing {
+		a.EndedAt = end.Format(time.RFC3339)
+	}
+	a.Message = fmt.Sprintf("SST at %s peaked at %.1f°C on %s (+%.1f°C above the %d–%d climatology); category %s, %d days",
+		stationLabel(st), e.PeakTemperature, peak.Format("2 Jan 2006"), e.MaxIntensity, c.BaselineStart, c.BaselineEnd,
+		e.CategoryName, e.DurationDays)
+	if e.Ongoing {
+		a.Message += " and ongoing"
+	}
+	a.Message += "."
+	a.Details, _ = json.Marshal(map[string]interface{}{
+		"max_intensity":        e.MaxIntensity,
+		"mean_intensity":       e.MeanIntensity,
+		"cumulative_intensity": e.CumulativeIntensity,
+		"climatology_at_peak":  e.ClimatologyAtPeak,
+		"threshold_at_peak":    e.ThresholdAtPeak,
+		"category":             e.Category,
+		"duration_days":        e.DurationDays,
+		"ongoing":              e.Ongoing,
+		"baseline_start":       c.BaselineStart,
+		"baseline_end":         c.BaselineEnd,
+	})
+	return a
+}
+
+type hypoxiaEvent struct {
+	Start, End, Peak time.Time
+	MinValue         float64
+	Hours            int
+	Ongoing          bool
+	Depth            float64
+}
+
+// detectHypoxia scans hourly means, taking the lowest across depths, for
+// runs below threshold. A run ends at the first hour back above threshold
+// or at a gap longer than maxGap.
+func detectHypoxia(stationID int, threshold float64, minHours int, maxGap time.Duration) ([]hypoxiaEvent, int, error) {
+	rows, err := db.Query(`SELECT DISTINCT ON (h) h, depth_m, v FROM (
+			SELECT date_trunc('hour', observed_at AT TIME ZONE 'UTC') AS h, depth_m, AVG(value) AS v
+			FROM env_observations WHERE station_id = $1 AND parameter = $2 GROUP BY 1, 2
+		) t ORDER BY h, v`, stationID, parameterDissolvedOxygen)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var events []hypoxiaEvent
+	var cur *hypoxiaEvent
+	var lastHour time.Time
+	hours := 0
+	closeEvent := func() {
+		if cur != nil && cur.Hours >= minHours {
+			events = append(events, *cur)
+		}
+		cur = nil
+	}
+	for rows.Next() {
+		var h time.Time
+		var depth, v float64
+		if err := rows.Scan(&h, &depth, &v); err != nil {
+			return nil, 0, err
+		}
+		h = time.Date(h.Year(), h.Month(), h.Day(), h.Hour(), 0, 0, 0, time.UTC)
+		hours++
+		if cur != nil && h.Sub(lastHour) > maxGap {
+			closeEvent()
+		}
+		lastHour = h
+		if v >= threshold {
+			closeEvent()
+			continue
+		}
+		if cur == nil {
+			cur = &hypoxiaEvent{Start: h, Peak: h, MinValue: v, Depth: depth}
+		}
+		cur.End = h.Add(time.Hour)
+		cur.Hours++
+		if v < cur.MinValue {
+			cur.MinValue, cur.Peak, cur.Depth = v, h, depth
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	if cur != nil {
+		cur.Ongoing = time.Since(lastHour) <= maxGap
+	}
+	closeEvent()
+	return events, hours, nil
+}
+
+func hypoxiaCategory(minValue float64) (string, string) {
+	switch {
+	case minValue < anoxiaThreshold:
+		return "anoxic", severityCritical
+	case minValue < severeHypoxiaThreshold:
+		return "severe hypoxia", severityCritical
+	}
+	return "hypoxia", severityHigh
+}
+
+func hypoxiaAlert(st *EnvStation, e hypoxiaEvent, threshold float64) *Alert {
+	category, severity := hypoxiaCategory(e.MinValue)
+	duration := float64(e.Hours)
+	a := &Alert{
+		Category:      categoryEnvironmental,
+		Severity:      severity,
+		Type:          alertTypeHypoxia,
+		Title:         "Hypoxia Alert",
+		Region:        st.Region,
+		StationID:     &st.ID,
+		StartedAt:     e.Start.Format(time.RFC3339),
+		PeakAt:        e.Peak.Format(time.RFC3339),
+		DurationHours: &duration,
+		PeakValue:     &e.MinValue,
+		EventCategory: category,
+		DedupeKey:     fmt.Sprintf("%s:%d:%s", alertTypeHypoxia, st.ID, e.Start.Format(time.RFC3339)),
+	}
+	if !e.Ongoing {
+		a.EndedAt = e.End.Format(time.RFC3339)
+	}
+	a.Message = fmt.Sprintf("Dissolved oxygen at %s dropped to %.1f mg/L at %g m (threshold %g mg/L), below threshold for %d hours",
+		stationLabel(st), e.MinValue, e.Depth, threshold, e.Hours)
+	if e.Ongoing {
+		a.Message += " and ongoing"
+	}
+	a.Message += "."
+	a.Details, _ = json.Marshal(map[string]interface{}{
+		"threshold_mg_l": threshold,
+		"min_mg_l":       e.MinValue,
+		"depth_m":        e.Depth,
+		"hours":          e.Hours,
+		"ongoing":        e.Ongoing,
+	})
+	return a
+}
+
+// stationBaseline picks the climatology years for a station: the
+// requested ones, else the stored ones, else the defaultBaselineYears
+// complete years before the last year of data. The bool reports whether
+// the baseline should be stored once it proves usable.
+func stationBaseline(stationID int, s dailySeries, opts DetectionOptions) (int, int, bool, error) {
+	lastYear := s.day(len(s.Values) - 1).Year()
+	end := lastYear - 1
+	if opts.BaselineStart > 0 || opts.BaselineEnd > 0 {
+		start := opts.BaselineStart
+		if opts.BaselineEnd > 0 {
+			end = opts.BaselineEnd
+		}
+		if start == 0 {
+			start = end - defaultBaselineYears + 1
+		}
+		return start, end, true, nil
+	}
+
+	var start int
+	err := db.QueryRow("SELECT baseline_start, baseline_end FROM env_baselines WHERE station_id = $1 AND parameter = $2",
+		stationID, parameterTemperature).Scan(&start, &end)
+	if err == nil {
+		return start, end, false, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, 0, false, err
+	}
+	end = lastYear - 1
+	return end - defaultBaselineYears + 1, end, true, nil
+}
+
+func saveBaseline(stationID int, start, end int, setBy string) error {
+	_, err := db.Exec(`INSERT INTO env_baselines (station_id, parameter, baseline_start, baseline_end, set_by)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''))
+		ON CONFLICT (station_id, parameter) DO UPDATE SET baseline_start = EXCLUDED.baseline_start,
+			baseline_end = EXCLUDED.baseline_end, set_by = EXCLUDED.set_by, updated_at = now()`,
+		stationID, parameterTemperature, start, end, setBy)
+	return err
+}
+
+// eventDedupeKey keeps one alert per event when the event's start moves,
+// as it can when late data fills a gap: it returns the key of an existing
+// alert of the same type at the station whose period overlaps a's, and
+// a's own key when there is none.
+func eventDedupeKey(a *Alert) (string, error) {
+	var key string
+	err := db.QueryRow(`SELECT dedupe_key FROM alerts
+		WHERE alert_type = $1 AND station_id = $2
+			AND started_at < COALESCE(NULLIF($4, '')::timestamptz, 'infinity')
+			AND COALESCE(ended_at, 'infinity') > $3::timestamptz
+		ORDER BY started_at LIMIT 1`, a.Type, a.StationID, a.StartedAt, a.EndedAt).Scan(&key)
+	if err == sql.ErrNoRows {
+		return a.DedupeKey, nil
+	}
+	return key, err
+}
+
+// raiseEventAlert raises a detector's alert under the key of any existing
+// alert for the same event.
+func raiseEventAlert(a *Alert) (bool, error) {
+	key, err := eventDedupeKey(a)
+	if err != nil {
+		return false, err
+	}
+	a.DedupeKey = key
+	_, inserted, err := raiseAlert(a)
+	return inserted, err
+}
+
+// runStationDetection runs both detectors for one station and writes the
+// events as alerts.
+func runStationDetection(st *EnvStation, opts DetectionOptions) (*StationDetection, error) {
+	res := &StationDetection{Station: st.Code, Skipped: []string{}}
+
+	temps, err := loadDailyMeans(st.ID, parameterTemperature)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range temps.Values {
+		if !math.IsNaN(v) {
+			res.TemperatureDays++
+		}
+	}
+	if res.TemperatureDays == 0 {
+		res.Skipped = append(res.Skipped, "marine heatwaves: no temperature data")
+	} else {
+		start, end, store, err := stationBaseline(st.ID, temps, opts)
+		if err != nil {
+			return nil, err
+		}
+		c, years := computeClimatology(temps, start, end)
+		if years < opts.MinBaselineYears {
+			res.Skipped = append(res.Skipped, fmt.Sprintf("marine heatwaves: baseline %d–%d has %d year(s) of data, need %d",
+				start, end, years, opts.MinBaselineYears))
+		} else {
+			if store {
+				if err := saveBaseline(st.ID, start, end, opts.SetBy); err != nil {
+					return nil, err
+				}
+			}
+			res.BaselineStart, res.BaselineEnd = c.BaselineStart, c.BaselineEnd
+			for d := 1; d <= climatologyDaysPerYear; d++ {
+				if !math.IsNaN(c.P90[d]) {
+					res.ClimatologyDays++
+				}
+			}
+			if err := saveClimatology(st.ID, parameterTemperature, c); err != nil {
+				return nil, err
+			}
+			for _, e := range detectHeatwaves(temps, c) {
+				inserted, err := raiseEventAlert(heatwaveAlert(st, temps, c, e))
+				if err != nil {
+					return nil, err
+				}
+				res.MarineHeatwaves++
+				if inserted {
+					res.NewAlerts++
+				}
+			}
+		}
+	}
+
+	events, hours, err := detectHypoxia(st.ID, opts.HypoxiaThreshold, opts.HypoxiaMinDuration, st.gapThreshold())
+	if err != nil {
+		return nil, err
+	}
+	res.OxygenHours = hours
+	if hours == 0 {
+		res.Skipped = append(res.Skipped, "hypoxia: no dissolved oxygen data")
+	}
+	for _, e := range events {
+		inserted, err := raiseEventAlert(hypoxiaAlert(st, e, opts.HypoxiaThreshold))
+		if err != nil {
+			return nil, err
+		}
+		res.HypoxiaEvents++
+		if inserted {
+			res.NewAlerts++
+		}
+	}
+	return res, nil
+}
+
+// detectionMu keeps the background loop and on-demand runs from fixing
+// baselines and raising alerts for the same station at once.
+var detectionMu sync.Mutex
+
+func runDetection(opts DetectionOptions) ([]*StationDetection, error) {
+	detectionMu.Lock()
+	defer detectionMu.Unlock()
+
+	opts.defaults()
+	query := "SELECT " + envStationColumns + " FROM " + envStationFrom
+	args := []interface{}{}
+	if opts.Station != "" {
+		query += " WHERE s.code = $1"
+		args = append(args, opts.Station)
+	}
+	rows, err := db.Query(query+" ORDER BY s.code", args...)
+	if err != nil {
+		return nil, err
+	}
+	var stations []*EnvStation
+	for rows.Next() {
+		if st, err := scanEnvStation(rows); err == nil {
+			stations = append(stations, st)
+		}
+	}
+	rows.Close()
+
+	results := []*StationDetection{}
+	for _, st := range stations {
+		res, err := runStationDetection(st, opts)
+		if err != nil {
+			return results, fmt.Errorf("station %s: %w", st.Code, err)
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// detectionLoop re-runs detection with default options every hour, so
+// ongoing events are extended and new ones raised as data arrives. It uses
+// each station's stored baseline and never changes it.
+func detectionLoop() {
+	for {
+		if _, err := runDetection(DetectionOptions{}); err != nil {
+			log.Println("Environmental detection failed:", err)
+		}
+		time.Sleep(detectionInterval)
+	}
+}
+
+// --- Handlers ---
+
+func runDetectionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireRole(w, r, contributorRoles...)
+	if !ok {
+		return
+	}
+	var opts DetectionOptions
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+	}
+	if opts.BaselineStart > 0 && opts.BaselineEnd > 0 && opts.BaselineEnd < opts.BaselineStart {
+		http.Error(w, "baseline_end is before baseline_start", http.StatusBadRequest)
+		return
+	}
+	if opts.Station != "" {
+		if _, err := loadEnvStation(opts.Station); err != nil {
+			http.Error(w, "Station not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	opts.SetBy = user.ID
+
+	results, err := runDetection(opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// getEnvClimatology returns the stored temperature climatology of a
+// station, one entry per day of year.
+func getEnvClimatology(w http.ResponseWriter, r *http.Request) {
+	st := stationForRequest(w, r)
+	if st == nil {
+		return
+	}
+	rows, err := db.Query(`SELECT day_of_year, mean, p90, baseline_start, baseline_end FROM env_climatology
+		WHERE station_id = $1 AND parameter = $2 ORDER BY day_of_year`, st.ID, parameterTemperature)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	type day struct {
+		DayOfYear int      `json:"day_of_year"`
+		Mean      *float64 `json:"mean"`
+		P90       *float64 `json:"p90"`
+	}
+	resp := struct {
+		Station       string `json:"station"`
+		Parameter     string `json:"parameter"`
+		BaselineStart int    `json:"baseline_start"`
+		BaselineEnd   int    `json:"baseline_end"`
+		Days          []day  `json:"days"`
+	}{Station: st.Code, Parameter: parameterTemperature, Days: []day{}}
+	for rows.Next() {
+		var d day
+		var mean, p90 sql.NullFloat64
+		if err := rows.Scan(&d.DayOfYear, &mean, &p90, &resp.BaselineStart, &resp.BaselineEnd); err != nil {
+			continue
+		}
+		d.Mean, d.P90 = nullableFloat(mean), nullableFloat(p90)
+		resp.Days = append(resp.Days, d)
+	}
+	if len(resp.Days) == 0 {
+		http.Error(w, "No climatology yet; run detection once the station has enough temperature data", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDayOfYear(t *testing.T) {
+	tests := []struct {
+		date string
+		want int
+	}{
+		{"2021-01-01", 1},
+		{"2021-02-28", 59},
+		{"2021-03-01", 61},
+		{"2020-02-29", 60},
+		{"2020-03-01", 61},
+		{"2021-12-31", 366},
+		{"2020-12-31", 366},
+	}
+	for _, tt := range tests {
+		d, _ := time.Parse("2006-01-02", tt.date)
+		if got := dayOfYear(d); got != tt.want {
+			t.Errorf("dayOfYear(%s) = %d, want %d", tt.date, got, tt.want)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	tests := []struct {
+		values []float64
+		p      float64
+		want   float64
+	}{
+		{[]float64{1, 2, 3, 4}, 0.9, 3.7},
+		{[]float64{1, 2, 3, 4}, 0.5, 2.5},
+		{[]float64{5}, 0.9, 5},
+		{[]float64{0, 10}, 0.25, 2.5},
+	}
+	for _, tt := range tests {
+		if got := percentile(tt.values, tt.p); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("percentile(%v, %g) = %g, want %g", tt.values, tt.p, got, tt.want)
+		}
+	}
+}
+
+// seasonalSeries is a daily SST series with a seasonal cycle and a small
+// repeating wobble, so the 90th percentile sits a little above the mean.
+func seasonalSeries(start time.Time, days int) dailySeries {
+	s := dailySeries{Start: start, Values: make([]float64, days)}
+	for i := range s.Values {
+		doy := float64(dayOfYear(s.day(i)))
+		s.Values[i] = 27 + 2*math.Sin(2*math.Pi*doy/366) + 0.3*math.Sin(float64(i)*1.7)
+	}
+	return s
+}
+
+func TestDetectHeatwaves(t *testing.T) {
+	start := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	testYear := int(time.Date(2004, 1, 1, 0, 0, 0, 0, time.UTC).Sub(start).Hours() / 24)
+
+	tests := []struct {
+		name      string
+		spikes    [][2]int // day offsets into 2004, inclusive; +3°C
+		cool      [][2]int // -1°C, so the wobble cannot bridge a gap
+		events    int
+		durations []int
+	}{
+		{"no spike", nil, nil, 0, nil},
+		{"ten days", [][2]int{{150, 159}}, [][2]int{{149, 149}, {160, 160}}, 1, []int{10}},
+		{"four days is too short", [][2]int{{150, 153}}, [][2]int{{149, 149}, {154, 154}}, 0, nil},
+		{"two-day gap joins", [][2]int{{150, 154}, {157, 161}}, [][2]int{{149, 149}, {155, 156}, {162, 162}}, 1, []int{12}},
+		{"three-day gap splits", [][2]int{{150, 154}, {158, 162}}, [][2]int{{149, 149}, {155, 157}, {163, 163}}, 2, []int{5, 5}},
+	}
+	for _, tt := range tests {
+		s := seasonalSeries(start, testYear+366)
+		c, years := computeClimatology(s, 2001, 2003)
+		if years != 3 || c.BaselineStart != 2001 || c.BaselineEnd != 2003 {
+			t.Fatalf("baseline: %d years %d–%d", years, c.BaselineStart, c.BaselineEnd)
+		}
+		for _, sp := range tt.spikes {
+			for d := sp[0]; d <= sp[1]; d++ {
+				s.Values[testYear+d] += 3
+			}
+		}
+		for _, sp := range tt.cool {
+			for d := sp[0]; d <= sp[1]; d++ {
+				s.Values[testYear+d] -= 1
+			}
+		}
+		events := detectHeatwaves(s, c)
+		if len(events) != tt.events {
+			t.Errorf("%s: got %d events, want %d", tt.name, len(events), tt.events)
+			continue
+		}
+		for i, e := range events {
+			if e.DurationDays != tt.durations[i] {
+				t.Errorf("%s: event %d lasts %d days, want %d", tt.name, i, e.DurationDays, tt.durations[i])
+			}
+			if e.MaxIntensity < 2.5 || e.Category != 4 || e.Ongoing {
+				t.Errorf("%s: event %d intensity %g category %d ongoing %v", tt.name, i, e.MaxIntensity, e.Category, e.Ongoing)
+			}
+		}
+	}
+}
+
+func TestComputeClimatologyExcludesLaterYears(t *testing.T) {
+	start := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := seasonalSeries(start, 4*366)
+	for i := 3 * 365; i < len(s.Values); i++ {
+		s.Values[i] += 5
+	}
+	base, _ := computeClimatology(s, 2001, 2003)
+	all, _ := computeClimatology(s, 0, 0)
+	if math.Abs(base.Mean[100]-27-2*math.Sin(2*math.Pi*100/366)) > 0.3 {
+		t.Errorf("baseline mean on day 100 is %g, warm year leaked in", base.Mean[100])
+	}
+	if all.Mean[100] <= base.Mean[100]+0.5 {
+		t.Errorf("all-year mean %g should include the warm year (baseline %g)", all.Mean[100], base.Mean[100])
+	}
+}
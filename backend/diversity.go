@@ -0,0 +1,440 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// --- Biodiversity Indices ---
+//
+// Alpha diversity (richness, Shannon, Simpson, Pielou, Chao1 and
+// rarefaction) and beta diversity (Bray-Curtis and Jaccard) over slices
+// of occurrence_data or edna_detections. Occurrence abundances are
+// record counts per species. eDNA abundances are, by ?measure=, the
+// number of samples a taxon was detected in (the default, so Chao1 acts
+// as the incidence-based Chao2), summed reads or detection rows; taxa
+// without a species_id count under their reported scientific name.
+
+const (
+	defaultRarefySteps    = 20
+	maxDiversityGroups    = 500
+	maxBetaGroups         = 200
+	defaultEDNAConfidence = 0.5
+)
+
+// diversitySlice is a parsed set of slice filters and grouping.
+type diversitySlice struct {
+	Source  string `json:"source"`
+	Measure string `json:"measure"`
+	GroupBy string `json:"group_by"`
+	where   []string
+	args    []interface{}
+	group   string
+	taxon   string
+	count   string
+	from    string
+}
+
+func (s *diversitySlice) arg(v interface{}) string {
+	s.args = append(s.args, v)
+	return "$" + strconv.Itoa(len(s.args))
+}
+
+// newDiversitySlice reads ?source=, ?measure=, ?region= (comma list),
+// ?from=, ?to=, ?min_depth=, ?max_depth=, ?min_confidence= (eDNA) and
+// ?group_by= (none, region, year or month).
+func newDiversitySlice(q map[string][]string, defaultGroup string) (*diversitySlice, error) {
+	get := func(k string) string {
+		if v := q[k]; len(v) > 0 {
+			return strings.TrimSpace(v[0])
+		}
+		return ""
+	}
+	s := &diversitySlice{Source: get("source"), Measure: get("measure"), GroupBy: get("group_by")}
+	if s.Source == "" {
+		s.Source = sourceOccurrence
+	}
+	if s.GroupBy == "" {
+		s.GroupBy = defaultGroup
+	}
+
+	var dateCol, regionCol string
+	switch s.Source {
+	case sourceOccurrence:
+		s.from = "occurrence_data o"
+		dateCol, regionCol = "o.eventdate", "o.region"
+		s.where = []string{"o.species_id IS NOT NULL"}
+		s.taxon = "o.species_id::text"
+		if s.Measure == "" {
+			s.Measure = "records"
+		}
+		if s.Measure != "records" {
+			return nil, fmt.Errorf("occurrence abundances are record counts; measure must be records")
+		}
+		s.count = "COUNT(*)"
+	case sourceEDNA:
+		s.from = "edna_detections e"
+		dateCol, regionCol = "e.sampled_on", "e.region"
+		s.taxon = "COALESCE(e.species_id::text, lower(e.scientific_name))"
+		if s.Measure == "" {
+			s.Measure = "samples"
+		}
+		switch s.Measure {
+		case "samples":
+			s.count = "COUNT(DISTINCT e.sample_id)"
+		case "reads":
+			s.count = "COALESCE(SUM(e.read_count), 0)"
+		case "detections":
+			s.count = "COUNT(*)"
+		default:
+			return nil, fmt.Errorf("eDNA measure must be samples, reads or detections")
+		}
+		minConf := defaultEDNAConfidence
+		if v := get("min_confidence"); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || f < 0 || f > 1 {
+				return nil, fmt.Errorf("min_confidence must be between 0 and 1")
+			}
+			minConf = f
+		}
+		s.where = []string{"e.confidence >= " + s.arg(minConf)}
+		if get("min_depth") != "" || get("max_depth") != "" {
+			return nil, fmt.Errorf("eDNA detections carry no depth")
+		}
+	default:
+		return nil, fmt.Errorf("source must be occurrence or edna")
+	}
+
+	switch s.GroupBy {
+	case "none":
+		s.group = "''"
+	case "region":
+		s.group = "COALESCE(" + regionCol + ", '')"
+	case "year":
+		s.group = "COALESCE(to_char(" + dateCol + ", 'YYYY'), '')"
+	case "month":
+		s.group = "COALESCE(to_char(" + dateCol + ", 'YYYY-MM'), '')"
+	default:
+		return nil, fmt.Errorf("group_by must be none, region, year or month")
+	}
+
+	if v := get("region"); v != "" {
+		s.where = append(s.where, regionCol+" = ANY("+s.arg(pq.Array(splitList([]string{v}, ",")))+")")
+	}
+	for _, b := range []struct{ param, op string }{{"from", ">="}, {"to", "<="}} {
+		if v := get(b.param); v != "" {
+			if _, err := time.Parse("2006-01-02", v); err != nil {
+				return nil, fmt.Errorf("%s must be a YYYY-MM-DD date", b.param)
+			}
+			s.where = append(s.where, dateCol+"::date "+b.op+" "+s.arg(v)+"::date")
+		}
+	}
+	for _, b := range []struct{ param, op string }{{"min_depth", ">="}, {"max_depth", "<="}} {
+		if v := get(b.param); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be a number", b.param)
+			}
+			s.where = append(s.where, "o.waterdepth_m "+b.op+" "+s.arg(f))
+		}
+	}
+	return s, nil
+}
+
+// abundances returns taxon counts per group, groups in sorted order.
+func (s *diversitySlice) abundances() ([]string, map[string]map[string]int, error) {
+	rows, err := db.Query("SELECT "+s.group+", "+s.taxon+", "+s.count+" FROM "+s.from+
+		" WHERE "+strings.Join(s.where, " AND ")+" GROUP BY 1, 2", s.args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	groups := map[string]map[string]int{}
+	for rows.Next() {
+		var g, taxon string
+		var n int
+		if err := rows.Scan(&g, &taxon, &n); err != nil {
+			return nil, nil, err
+		}
+		if n <= 0 {
+			continue
+		}
+		if groups[g] == nil {
+			groups[g] = map[string]int{}
+		}
+		groups[g][taxon] += n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	var labels []string
+	for g := range groups {
+		labels = append(labels, g)
+	}
+	sort.Strings(labels)
+	return labels, groups, nil
+}
+
+type RarefactionPoint struct {
+	Sample   int     `json:"sample"`
+	Richness float64 `json:"expected_richness"`
+}
+
+type DiversityIndices struct {
+	Group          string             `json:"group"`
+	Abundance      int                `json:"abundance"`
+	Richness       int                `json:"richness"`
+	Shannon        float64            `json:"shannon"`
+	Simpson        float64            `json:"simpson"`
+	InverseSimpson float64            `json:"inverse_simpson"`
+	Pielou         *float64           `json:"pielou"`
+	Chao1          float64            `json:"chao1"`
+	Singletons     int                `json:"singletons"`
+	Doubletons     int                `json:"doubletons"`
+	Rarefied       *float64           `json:"rarefied_richness,omitempty"`
+	Rarefaction    []RarefactionPoint `json:"rarefaction"`
+}
+
+// lnChoose is ln C(n, k).
+func lnChoose(n, k int) float64 {
+	a, _ := math.Lgamma(float64(n + 1))
+	b, _ := math.Lgamma(float64(k + 1))
+	c, _ := math.Lgamma(float64(n - k + 1))
+	return a - b - c
+}
+
+// rarefy is Hurlbert's expected richness in a subsample of m.
+func rarefy(counts []int, total, m int) float64 {
+	var s float64
+	denom := lnChoose(total, m)
+	for _, n := range counts {
+		if total-n < m {
+			s++
+			continue
+		}
+		s += 1 - math.Exp(lnChoose(total-n, m)-denom)
+	}
+	return s
+}
+
+// diversityIndices computes alpha diversity from taxon counts. Simpson is
+// the unbiased Gini-Simpson 1 - Σn(n-1)/(N(N-1)); Chao1 is the
+// bias-corrected S + F1(F1-1)/(2(F2+1)).
+func diversityIndices(group string, abundance map[string]int, steps int) DiversityIndices {
+	d := DiversityIndices{Group: group, Rarefaction: []RarefactionPoint{}}
+	var counts []int
+	for _, n := range abundance {
+		counts = append(counts, n)
+		d.Abundance += n
+		switch n {
+		case 1:
+			d.Singletons++
+		case 2:
+			d.Doubletons++
+		}
+	}
+	d.Richness = len(counts)
+	if d.Abundance == 0 {
+		return d
+	}
+
+	total := float64(d.Abundance)
+	var sumSq, sumPair float64
+	for _, n := range counts {
+		p := float64(n) / total
+		d.Shannon -= p * math.Log(p)
+		sumSq += p * p
+		sumPair += float64(n) * float64(n-1)
+	}
+	if d.Abundance > 1 {
+		d.Simpson = 1 - sumPair/(total*(total-1))
+	}
+	d.InverseSimpson = 1 / sumSq
+	if d.Richness > 1 {
+		j := d.Shannon / math.Log(float64(d.Richness))
+		d.Pielou = &j
+	}
+	f1, f2 := float64(d.Singletons), float64(d.Doubletons)
+	d.Chao1 = float64(d.Richness) + f1*(f1-1)/(2*(f2+1))
+
+	seen := map[int]bool{}
+	for i := 1; i <= steps; i++ {
+		m := int(math.Round(total * float64(i) / float64(steps)))
+		if m < 1 || seen[m] {
+			continue
+		}
+		seen[m] = true
+		d.Rarefaction = append(d.Rarefaction, RarefactionPoint{Sample: m, Richness: rarefy(counts, d.Abundance, m)})
+	}
+	return d
+}
+
+// getDiversity returns alpha diversity per group of the slice. With more
+// than one group, rarefied_richness compares them at the smallest
+// group's abundance, or at ?rarefy_to=.
+func getDiversity(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	slice, err := newDiversitySlice(q, "none")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	steps := defaultRarefySteps
+	if v := q.Get("steps"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 200 {
+			http.Error(w, "steps must be between 1 and 200", http.StatusBadRequest)
+			return
+		}
+		steps = n
+	}
+	rarefyTo := 0
+	if v := q.Get("rarefy_to"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "rarefy_to must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		rarefyTo = n
+	}
+
+	labels, groups, err := slice.abundances()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(labels) > maxDiversityGroups {
+		http.Error(w, fmt.Sprintf("slice has %d groups; narrow it to %d or fewer", len(labels), maxDiversityGroups), http.StatusBadRequest)
+		return
+	}
+
+	results := []DiversityIndices{}
+	smallest := 0
+	for _, g := range labels {
+		d := diversityIndices(g, groups[g], steps)
+		if smallest == 0 || d.Abundance < smallest {
+			smallest = d.Abundance
+		}
+		results = append(results, d)
+	}
+	if rarefyTo == 0 && len(results) > 1 {
+		rarefyTo = smallest
+	}
+	if rarefyTo > 0 {
+		for i, g := range labels {
+			if results[i].Abundance < rarefyTo {
+				continue
+			}
+			counts := make([]int, 0, len(groups[g]))
+			for _, n := range groups[g] {
+				counts = append(counts, n)
+			}
+			v := rarefy(counts, results[i].Abundance, rarefyTo)
+			results[i].Rarefied = &v
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"source":    slice.Source,
+		"measure":   slice.Measure,
+		"group_by":  slice.GroupBy,
+		"rarefy_to": rarefyTo,
+		"groups":    results,
+	})
+}
+
+// brayCurtis and jaccard are dissimilarities: 0 for identical
+// communities, 1 for communities sharing no taxa.
+func brayCurtis(a, b map[string]int) float64 {
+	var shared, total float64
+	for t, n := range a {
+		shared += math.Min(float64(n), float64(b[t]))
+		total += float64(n)
+	}
+	for _, n := range b {
+		total += float64(n)
+	}
+	if total == 0 {
+		return 0
+	}
+	return 1 - 2*shared/total
+}
+
+func jaccard(a, b map[string]int) float64 {
+	shared := 0
+	for t := range a {
+		if b[t] > 0 {
+			shared++
+		}
+	}
+	union := len(a) + len(b) - shared
+	if union == 0 {
+		return 0
+	}
+	return 1 - float64(shared)/float64(union)
+}
+
+// getBetaDiversity returns Bray-Curtis and Jaccard dissimilarity matrices
+// between the groups of a slice, regions unless ?group_by= says
+// otherwise.
+func getBetaDiversity(w http.ResponseWriter, r *http.Request) {
+	slice, err := newDiversitySlice(r.URL.Query(), "region")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if slice.GroupBy == "none" {
+		http.Error(w, "beta diversity needs a group_by of region, year or month", http.StatusBadRequest)
+		return
+	}
+	labels, groups, err := slice.abundances()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(labels) > maxBetaGroups {
+		http.Error(w, fmt.Sprintf("slice has %d groups; narrow it to %d or fewer", len(labels), maxBetaGroups), http.StatusBadRequest)
+		return
+	}
+
+	n := len(labels)
+	bray := make([][]float64, n)
+	jac := make([][]float64, n)
+	richness := make([]int, n)
+	for i := range labels {
+		bray[i], jac[i] = make([]float64, n), make([]float64, n)
+		richness[i] = len(groups[labels[i]])
+	}
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			a, b := groups[labels[i]], groups[labels[j]]
+			bray[i][j] = brayCurtis(a, b)
+			bray[j][i] = bray[i][j]
+			jac[i][j] = jaccard(a, b)
+			jac[j][i] = jac[i][j]
+		}
+	}
+	if labels == nil {
+		labels = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"source":      slice.Source,
+		"measure":     slice.Measure,
+		"group_by":    slice.GroupBy,
+		"labels":      labels,
+		"richness":    richness,
+		"bray_curtis": bray,
+		"jaccard":     jac,
+	})
+}
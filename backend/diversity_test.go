@@ -0,0 +1,105 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLnChoose(t *testing.T) {
+	tests := []struct {
+		n, k int
+		want float64
+	}{
+		{5, 2, 10},
+		{10, 0, 1},
+		{10, 10, 1},
+		{52, 5, 2598960},
+	}
+	for _, tt := range tests {
+		if got := math.Exp(lnChoose(tt.n, tt.k)); math.Abs(got-tt.want) > 1e-6*tt.want {
+			t.Errorf("C(%d, %d) = %v, want %v", tt.n, tt.k, got, tt.want)
+		}
+	}
+}
+
+func TestRarefy(t *testing.T) {
+	counts := []int{3, 1}
+	// Drawing 1 of 4 always finds one taxon; drawing 2 misses the
+	// singleton with probability C(3,2)/C(4,2) = 1/2.
+	tests := []struct {
+		m    int
+		want float64
+	}{
+		{1, 1},
+		{2, 1.5},
+		{4, 2},
+	}
+	for _, tt := range tests {
+		if got := rarefy(counts, 4, tt.m); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("rarefy(m=%d) = %v, want %v", tt.m, got, tt.want)
+		}
+	}
+}
+
+func TestDiversityIndices(t *testing.T) {
+	d := diversityIndices("all", map[string]int{"a": 10, "b": 10, "c": 10, "d": 10}, 4)
+	if d.Richness != 4 || d.Abundance != 40 {
+		t.Fatalf("richness %d, abundance %d", d.Richness, d.Abundance)
+	}
+	if math.Abs(d.Shannon-math.Log(4)) > 1e-12 {
+		t.Errorf("Shannon = %v, want ln 4", d.Shannon)
+	}
+	if d.Pielou == nil || math.Abs(*d.Pielou-1) > 1e-12 {
+		t.Errorf("Pielou = %v, want 1 for an even community", d.Pielou)
+	}
+	if math.Abs(d.InverseSimpson-4) > 1e-12 {
+		t.Errorf("inverse Simpson = %v, want 4", d.InverseSimpson)
+	}
+	// Unbiased Gini-Simpson: 1 - 4*10*9/(40*39).
+	if want := 1 - 360.0/1560; math.Abs(d.Simpson-want) > 1e-12 {
+		t.Errorf("Simpson = %v, want %v", d.Simpson, want)
+	}
+	if d.Chao1 != 4 {
+		t.Errorf("Chao1 = %v, want 4 with no singletons", d.Chao1)
+	}
+	if len(d.Rarefaction) != 4 || d.Rarefaction[3].Sample != 40 || math.Abs(d.Rarefaction[3].Richness-4) > 1e-9 {
+		t.Errorf("rarefaction curve = %+v", d.Rarefaction)
+	}
+
+	// Three singletons and one doubleton: Chao1 = 5 + 3*2/(2*2).
+	d = diversityIndices("rare", map[string]int{"a": 1, "b": 1, "c": 1, "d": 2, "e": 20}, 10)
+	if d.Singletons != 3 || d.Doubletons != 1 || math.Abs(d.Chao1-6.5) > 1e-12 {
+		t.Errorf("singletons %d, doubletons %d, Chao1 %v", d.Singletons, d.Doubletons, d.Chao1)
+	}
+
+	single := diversityIndices("one", map[string]int{"a": 1}, 5)
+	if single.Pielou != nil || single.Simpson != 0 || len(single.Rarefaction) != 1 {
+		t.Errorf("single individual: %+v", single)
+	}
+	if empty := diversityIndices("none", map[string]int{}, 5); empty.Richness != 0 || len(empty.Rarefaction) != 0 {
+		t.Errorf("empty community: %+v", empty)
+	}
+}
+
+func TestBetaDiversity(t *testing.T) {
+	a := map[string]int{"x": 6, "y": 4}
+	b := map[string]int{"x": 2, "z": 8}
+	// Shared minimum 2 over a total of 20.
+	if got := brayCurtis(a, b); math.Abs(got-0.8) > 1e-12 {
+		t.Errorf("brayCurtis = %v, want 0.8", got)
+	}
+	// One shared taxon of three.
+	if got := jaccard(a, b); math.Abs(got-2.0/3) > 1e-12 {
+		t.Errorf("jaccard = %v, want 2/3", got)
+	}
+	if brayCurtis(a, a) != 0 || jaccard(a, a) != 0 {
+		t.Error("a community should not differ from itself")
+	}
+	if brayCurtis(a, map[string]int{"q": 1}) != 1 || jaccard(a, map[string]int{"q": 1}) != 1 {
+		t.Error("disjoint communities should be fully dissimilar")
+	}
+	empty := map[string]int{}
+	if brayCurtis(empty, empty) != 0 || jaccard(empty, empty) != 0 {
+		t.Error("two empty communities should compare as identical")
+	}
+}
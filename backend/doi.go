@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// --- DOI Minting ---
+//
+// Dataset versions get DOIs of the form <prefix>/mds000123.v2, so the
+// identifier is known before it is registered and re-registering is
+// idempotent. Registration goes through a DOIMinter, the DataCite REST API
+// at DATACITE_API_URL (point it at a local stub in development). Without
+// it no DOIs are minted: an identifier that was never registered must not
+// be handed out as a resolvable doi.org link.
+
+type DOIRegistration struct {
+	DOI string
+	URL string
+	XML []byte
+}
+
+type DOIMinter interface {
+	Name() string
+	Register(ctx context.Context, reg DOIRegistration) error
+}
+
+// minter is nil when DOI registration is not configured.
+var minter DOIMinter
+
+// 10.5072 is DataCite's test prefix; it never resolves.
+func doiPrefix() string {
+	return envOr("DOI_PREFIX", "10.5072")
+}
+
+func publicBaseURL() string {
+	return strings.TrimRight(envOr("PUBLIC_URL", "http://localhost:8080"), "/")
+}
+
+func datasetPublisher() string {
+	return envOr("DATASET_PUBLISHER", "paradoxx6")
+}
+
+func initDOIMinter() {
+	api := os.Getenv("DATACITE_API_URL")
+	if api == "" {
+		log.Println("DATACITE_API_URL not set; DOI minting is disabled")
+		return
+	}
+	minter = &dataCiteMinter{
+		api:        strings.TrimRight(api, "/"),
+		repository: os.Getenv("DATACITE_REPOSITORY_ID"),
+		password:   os.Getenv("DATACITE_PASSWORD"),
+		client:     &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func versionDOI(accession string, version int) string {
+	return doiPrefix() + "/" + strings.ToLower(versionIdentifier(accession, version))
+}
+
+func versionLandingURL(accession string, version int) string {
+	return fmt.Sprintf("%s/api/datasets/%s/versions/%d", publicBaseURL(), accession, version)
+}
+
+// --- DataCite REST API ---
+
+type dataCiteMinter struct {
+	api        string
+	repository string
+	password   string
+	client     *http.Client
+}
+
+func (m *dataCiteMinter) Name() string { return "datacite" }
+
+// Register creates or updates the DOI with PUT /dois/{doi} and publishes
+// it, so the landing page and metadata are findable.
+func (m *dataCiteMinter) Register(ctx context.Context, reg DOIRegistration) error {
+	payload := map[string]interface{}{
+		"data": map[string]interface{}{
+			"type": "dois",
+			"attributes": map[string]interface{}{
+				"doi":   reg.DOI,
+				"event": "publish",
+				"url":   reg.URL,
+				"xml":   base64.StdEncoding.EncodeToString(reg.XML),
+			},
+		},
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, m.api+"/dois/"+reg.DOI, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.api+json")
+	req.SetBasicAuth(m.repository, m.password)
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("DataCite %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// --- Handlers ---
+
+// mintVersionDOI registers a DOI for a version of a public dataset. A
+// version keeps the DOI it was first given.
+func mintVersionDOI(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	if minter == nil {
+		http.Error(w, "DOI registration is not configured", http.StatusServiceUnavailable)
+		return
+	}
+	ds, v := versionForRequest(w, r, user)
+	if v == nil {
+		return
+	}
+	if !ds.canWrite(user) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if v.DOI != "" {
+		http.Error(w, "Version already has DOI "+v.DOI, http.StatusConflict)
+		return
+	}
+	if !ds.IsPublic {
+		http.Error(w, "Only public datasets can be given a DOI", http.StatusConflict)
+		return
+	}
+
+	files, err := loadVersionFiles(v.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	v.Files = files
+	v.DOI = versionDOI(v.Accession, v.Version)
+	xmlDoc, err := dataCiteXML(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	reg := DOIRegistration{DOI: v.DOI, URL: versionLandingURL(v.Accession, v.Version), XML: xmlDoc}
+	if err := minter.Register(r.Context(), reg); err != nil {
+		http.Error(w, "Failed to register DOI: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	res, err := db.Exec("UPDATE dataset_versions SET doi = $2, doi_minted_at = now() WHERE id = $1 AND doi IS NULL", v.ID, v.DOI)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Version was given a DOI concurrently", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"doi":        v.DOI,
+		"identifier": "https://doi.org/" + v.DOI,
+		"url":        reg.URL,
+		"registrar":  minter.Name(),
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDataCiteMinterRegister(t *testing.T) {
+	var got struct {
+		method, path, contentType string
+		user, password            string
+		body                      []byte
+	}
+	status, reply := http.StatusCreated, `{"data":{}}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.method, got.path, got.contentType = r.Method, r.URL.Path, r.Header.Get("Content-Type")
+		got.user, got.password, _ = r.BasicAuth()
+		got.body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+		io.WriteString(w, reply)
+	}))
+	defer srv.Close()
+
+	m := &dataCiteMinter{api: srv.URL, repository: "TEST.REPO", password: "hunter2", client: srv.Client()}
+	reg := DOIRegistration{
+		DOI: "10.5072/mds000123.v2",
+		URL: "https://data.example.org/api/datasets/MDS000123/versions/2",
+		XML: []byte(`<?xml version="1.0"?><resource/>`),
+	}
+	if err := m.Register(context.Background(), reg); err != nil {
+		t.Fatal(err)
+	}
+	if got.method != http.MethodPut || got.path != "/dois/10.5072/mds000123.v2" {
+		t.Errorf("request = %s %s, want PUT /dois/<doi>", got.method, got.path)
+	}
+	if got.contentType != "application/vnd.api+json" {
+		t.Errorf("Content-Type = %q", got.contentType)
+	}
+	if got.user != "TEST.REPO" || got.password != "hunter2" {
+		t.Errorf("basic auth = %q:%q", got.user, got.password)
+	}
+
+	var payload struct {
+		Data struct {
+			Type       string `json:"type"`
+			Attributes struct {
+				DOI   string `json:"doi"`
+				Event string `json:"event"`
+				URL   string `json:"url"`
+				XML   string `json:"xml"`
+			} `json:"attributes"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(got.body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	attrs := payload.Data.Attributes
+	if payload.Data.Type != "dois" || attrs.DOI != reg.DOI || attrs.Event != "publish" || attrs.URL != reg.URL {
+		t.Errorf("payload = %s", got.body)
+	}
+	if xmlDoc, err := base64.StdEncoding.DecodeString(attrs.XML); err != nil || string(xmlDoc) != string(reg.XML) {
+		t.Errorf("xml attribute decodes to %q (%v)", xmlDoc, err)
+	}
+
+	for _, code := range []int{http.StatusUnauthorized, http.StatusUnprocessableEntity, http.StatusInternalServerError} {
+		status, reply = code, `{"errors":[{"title":"This DOI has already been taken"}]}`
+		err := m.Register(context.Background(), reg)
+		if err == nil {
+			t.Errorf("status %d reported as registered", code)
+			continue
+		}
+		if !strings.Contains(err.Error(), http.StatusText(code)) || !strings.Contains(err.Error(), "already been taken") {
+			t.Errorf("status %d: error %q should carry the status and DataCite's message", code, err)
+		}
+	}
+}
@@ -0,0 +1,959 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// --- Environmental Sensor Time Series ---
+//
+// Stations report temperature, salinity, dissolved oxygen and pH. Files are
+// station CSVs or CSVs converted from NetCDF; both go through the
+// "environmental" validation schema, so column names and units are
+// normalised there. Observations are stored long-form, one row per station,
+// parameter, time and depth, and re-ingesting a file overwrites the values
+// it covers.
+
+const envSchema = `
+CREATE TABLE IF NOT EXISTS env_stations (
+	id                        SERIAL PRIMARY KEY,
+	code                      TEXT NOT NULL UNIQUE,
+	name                      TEXT,
+	latitude                  DOUBLE PRECISION,
+	longitude                 DOUBLE PRECISION,
+	depth_m                   DOUBLE PRECISION,
+	region                    TEXT,
+	operator                  TEXT,
+	platform_type             TEXT,
+	expected_interval_minutes INTEGER NOT NULL DEFAULT 60,
+	created_at                TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at                TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS env_observations (
+	station_id  INTEGER NOT NULL REFERENCES env_stations(id) ON DELETE CASCADE,
+	parameter   TEXT NOT NULL,
+	observed_at TIMESTAMPTZ NOT NULL,
+	depth_m     DOUBLE PRECISION NOT NULL DEFAULT 0,
+	value       DOUBLE PRECISION NOT NULL,
+	ingested_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	PRIMARY KEY (station_id, parameter, observed_at, depth_m)
+);`
+
+type EnvParameter struct {
+	Name  string `json:"name"`
+	Label string `json:"label"`
+	Unit  string `json:"unit"`
+}
+
+// envParameters are the fields of the environmental schema that are stored
+// as observations.
+var envParameters = []EnvParameter{
+	{"temperature_c", "Temperature", "°C"},
+	{"salinity_psu", "Salinity", "PSU"},
+	{"dissolved_oxygen_mg_l", "Dissolved oxygen", "mg/L"},
+	{"ph", "pH", ""},
+}
+
+func isEnvParameter(name string) bool {
+	for _, p := range envParameters {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	maxSeriesBuckets   = 10000
+	maxRawObservations = 10000
+)
+
+type EnvStation struct {
+	ID                      int      `json:"id"`
+	Code                    string   `json:"code"`
+	Name                    string   `json:"name"`
+	Latitude                *float64 `json:"latitude"`
+	Longitude               *float64 `json:"longitude"`
+	DepthM                  *float64 `json:"depth_m"`
+	Region                  string   `json:"region"`
+	Operator                string   `json:"operator"`
+	PlatformType            string   `json:"platform_type"`
+	ExpectedIntervalMinutes int      `json:"expected_interval_minutes"`
+	Parameters              []string `json:"parameters"`
+	FirstObservedAt         string   `json:"first_observed_at,omitempty"`
+	LastObservedAt          string   `json:"last_observed_at,omitempty"`
+	ObservationCount        int64    `json:"observation_count"`
+	Stale                   bool     `json:"stale"`
+}
+
+// gapThreshold is how long a station may be silent before it counts as a
+// gap: twice its reporting interval.
+func (s *EnvStation) gapThreshold() time.Duration {
+	return 2 * time.Duration(s.ExpectedIntervalMinutes) * time.Minute
+}
+
+const envStationColumns = `s.id, s.code, COALESCE(s.name, ''), s.latitude, s.longitude, s.depth_m, COALESCE(s.region, ''),
+	COALESCE(s.operator, ''), COALESCE(s.platform_type, ''), s.expected_interval_minutes,
+	COALESCE(o.parameters, '{}'), COALESCE(o.first_at::text, ''), COALESCE(o.last_at::text, ''), COALESCE(o.n, 0), o.last_at`
+
+const envStationFrom = `env_stations s LEFT JOIN (
+	SELECT station_id, array_agg(DISTINCT parameter) AS parameters, MIN(observed_at) AS first_at, MAX(observed_at) AS last_at, COUNT(*) AS n
+	FROM env_observations GROUP BY station_id
+) o ON o.station_id = s.id`
+
+func scanEnvStation(row interface{ Scan(...any) error }) (*EnvStation, error) {
+	var s EnvStation
+	var lat, lon, depth sql.NullFloat64
+	var last sql.NullTime
+	err := row.Scan(&s.ID, &s.Code, &s.Name, &lat, &lon, &depth, &s.Region, &s.Operator, &s.PlatformType,
+		&s.ExpectedIntervalMinutes, pq.Array(&s.Parameters), &s.FirstObservedAt, &s.LastObservedAt, &s.ObservationCount, &last)
+	if err != nil {
+		return nil, err
+	}
+	if lat.Valid {
+		s.Latitude = &lat.Float64
+	}
+	if lon.Valid {
+		s.Longitude = &lon.Float64
+	}
+	if depth.Valid {
+		s.DepthM = &depth.Float64
+	}
+	if s.Parameters == nil {
+		s.Parameters = []string{}
+	}
+	sort.Strings(s.Parameters)
+	s.Stale = last.Valid && time.Since(last.Time) > s.gapThreshold()
+	return &s, nil
+}
+
+func loadEnvStation(code string) (*EnvStation, error) {
+	return scanEnvStation(db.QueryRow("SELECT "+envStationColumns+" FROM "+envStationFrom+" WHERE s.code = $1", code))
+}
+
+// stationForRequest loads the {code} station, writing the error response
+// itself when it returns nil.
+func stationForRequest(w http.ResponseWriter, r *http.Request) *EnvStation {
+	st, err := loadEnvStation(r.PathValue("code"))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Station not found", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+	return st
+}
+
+// --- Ingestion ---
+
+// stationMetadata holds station fields from the upload form or from the
+// comment header of a converted NetCDF file.
+type stationMetadata struct {
+	Code, Name, Region, Operator, PlatformType string
+	Latitude, Longitude, DepthM                *float64
+	ExpectedIntervalMinutes                    int
+}
+
+var stationAttributeKeys = map[string]string{
+	"station": "code", "station_id": "code", "station_code": "code", "platform_code": "code", "site_code": "code", "wmo_platform_code": "code",
+	"station_name": "name", "platform_name": "name", "site_name": "name", "title": "name",
+	"latitude": "latitude", "lat": "latitude", "geospatial_lat_min": "latitude",
+	"longitude": "longitude", "lon": "longitude", "geospatial_lon_min": "longitude",
+	"depth": "depth_m", "depth_m": "depth_m", "geospatial_vertical_min": "depth_m",
+	"region": "region", "sea_area": "region", "area": "region",
+	"operator": "operator", "institution": "operator",
+	"platform_type": "platform_type", "platform": "platform_type", "featuretype": "platform_type",
+	"expected_interval_minutes": "expected_interval_minutes", "sampling_interval_minutes": "expected_interval_minutes",
+}
+
+func (m *stationMetadata) set(key, value string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return
+	}
+	switch key {
+	case "code":
+		m.Code = value
+	case "name":
+		m.Name = value
+	case "region":
+		m.Region = value
+	case "operator":
+		m.Operator = value
+	case "platform_type":
+		m.PlatformType = value
+	case "latitude", "longitude", "depth_m":
+		v, ok := parseCoordinate(value)
+		if !ok {
+			return
+		}
+		switch key {
+		case "latitude":
+			m.Latitude = &v
+		case "longitude":
+			m.Longitude = &v
+		default:
+			m.DepthM = &v
+		}
+	case "expected_interval_minutes":
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			m.ExpectedIntervalMinutes = n
+		}
+	}
+}
+
+// readCommentHeader reads leading "#" lines, as written by NetCDF
+// converters, and returns their "key: value" or ":key = value ;"
+// attributes and the offset where the table starts.
+func readCommentHeader(r io.Reader) (map[string]string, int64) {
+	attrs := map[string]string{}
+	br := bufio.NewReader(r)
+	var offset int64
+	for {
+		line, err := br.ReadString('\n')
+		trimmed := strings.TrimSpace(strings.TrimPrefix(line, "\ufeff"))
+		if !strings.HasPrefix(trimmed, "#") && trimmed != "" {
+			break
+		}
+		offset += int64(len(line))
+		body := strings.TrimSpace(strings.TrimLeft(trimmed, "#"))
+		sep := strings.Index(body, "=")
+		if sep < 0 {
+			sep = strings.Index(body, ":")
+		}
+		if sep > 0 {
+			key := normalizeHeader(strings.TrimLeft(body[:sep], ":"))
+			value := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(body[sep+1:]), ";"))
+			attrs[key] = strings.Trim(value, `"`)
+		}
+		if err != nil {
+			break
+		}
+	}
+	return attrs, offset
+}
+
+// parseCFTimeUnits parses CF-convention time units such as
+// "days since 1950-01-01 00:00:00".
+func parseCFTimeUnits(units string) (time.Duration, time.Time, bool) {
+	step, since, ok := strings.Cut(strings.ToLower(strings.TrimSpace(units)), " since ")
+	if !ok {
+		return 0, time.Time{}, false
+	}
+	var d time.Duration
+	switch strings.TrimSpace(step) {
+	case "seconds", "second", "secs", "sec", "s":
+		d = time.Second
+	case "minutes", "minute", "mins", "min":
+		d = time.Minute
+	case "hours", "hour", "hrs", "hr", "h":
+		d = time.Hour
+	case "days", "day", "d":
+		d = 24 * time.Hour
+	default:
+		return 0, time.Time{}, false
+	}
+	since = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(since), "utc"))
+	epoch, ok := parseDate(strings.ToUpper(since))
+	return d, epoch, ok
+}
+
+var envFieldDefs = findTableSchema("environmental")
+
+func envField(name string) FieldDef {
+	for _, f := range envFieldDefs.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return FieldDef{}
+}
+
+// prepareEnvRows adds a station column when the file has none and turns
+// numeric CF times into timestamps, so the rows validate as the
+// environmental schema.
+func prepareEnvRows(rows [][]string, stationCode, timeUnits string) error {
+	header := rows[0]
+	hasStation, timeCol, headerUnits := false, -1, ""
+	for i, h := range header {
+		if ok, _ := matchHeader(h, envField("station_id")); ok {
+			hasStation = true
+		}
+		if timeCol >= 0 {
+			continue
+		}
+		if ok, _ := matchHeader(h, envField("timestamp")); ok {
+			timeCol = i
+			if open := strings.IndexAny(h, "(["); open > 0 {
+				headerUnits = strings.Trim(h[open:], "()[] ")
+			}
+		}
+	}
+
+	if !hasStation {
+		if stationCode == "" {
+			return fmt.Errorf("the file has no station column; give the station code in the \"station\" field")
+		}
+		rows[0] = append([]string{"station_id"}, header...)
+		for i := 1; i < len(rows); i++ {
+			rows[i] = append([]string{stationCode}, rows[i]...)
+		}
+		if timeCol >= 0 {
+			timeCol++
+		}
+	}
+
+	if timeUnits == "" {
+		timeUnits = headerUnits
+	}
+	if timeCol < 0 || !strings.Contains(strings.ToLower(timeUnits), "since") {
+		return nil
+	}
+	step, epoch, ok := parseCFTimeUnits(timeUnits)
+	if !ok {
+		return fmt.Errorf("unrecognised time units %q", timeUnits)
+	}
+	rows[0][timeCol] = "timestamp"
+	for _, row := range rows[1:] {
+		if timeCol >= len(row) {
+			continue
+		}
+		if v, err := strconv.ParseFloat(strings.TrimSpace(row[timeCol]), 64); err == nil {
+			row[timeCol] = epoch.Add(time.Duration(v * float64(step))).Round(time.Second).UTC().Format(time.RFC3339)
+		}
+	}
+	return nil
+}
+
+// upsertStation creates the station or fills in metadata it lacks; values
+// given explicitly replace stored ones.
+func upsertStation(tx *sql.Tx, m stationMetadata) (int, error) {
+	var interval sql.NullInt64
+	if m.ExpectedIntervalMinutes > 0 {
+		interval = sql.NullInt64{Int64: int64(m.ExpectedIntervalMinutes), Valid: true}
+	}
+	var id int
+	err := tx.QueryRow(`INSERT INTO env_stations (code, name, latitude, longitude, depth_m, region, operator, platform_type, expected_interval_minutes)
+		VALUES ($1, NULLIF($2, ''), $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''), COALESCE($9, 60))
+		ON CONFLICT (code) DO UPDATE SET
+			name = COALESCE(EXCLUDED.name, env_stations.name),
+			latitude = COALESCE(EXCLUDED.latitude, env_stations.latitude),
+			longitude = COALESCE(EXCLUDED.longitude, env_stations.longitude),
+			depth_m = COALESCE(EXCLUDED.depth_m, env_stations.depth_m),
+			region = COALESCE(EXCLUDED.region, env_stations.region),
+			operator = COALESCE(EXCLUDED.operator, env_stations.operator),
+			platform_type = COALESCE(EXCLUDED.platform_type, env_stations.platform_type),
+			expected_interval_minutes = COALESCE($9, env_stations.expected_interval_minutes),
+			updated_at = now()
+		RETURNING id`,
+		m.Code, m.Name, m.Latitude, m.Longitude, m.DepthM, m.Region, m.Operator, m.PlatformType, interval).Scan(&id)
+	return id, err
+}
+
+type EnvIngestResult struct {
+	Stations     []string          `json:"stations"`
+	Observations int               `json:"observations"`
+	Parameters   map[string]int    `json:"parameters"`
+	From         string            `json:"from,omitempty"`
+	To           string            `json:"to,omitempty"`
+	Report       *ValidationReport `json:"report"`
+}
+
+// importEnvRecords stores validated environmental records. meta applies to
+// the station named by meta.Code; other stations only get coordinates from
+// their first record.
+func importEnvRecords(records []map[string]interface{}, meta stationMetadata) (*EnvIngestResult, error) {
+	res := &EnvIngestResult{Stations: []string{}, Parameters: map[string]int{}}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT INTO env_observations (station_id, parameter, observed_at, depth_m, value)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (station_id, parameter, observed_at, depth_m) DO UPDATE SET value = EXCLUDED.value, ingested_at = now()`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	stationIDs := map[string]int{}
+	var first, last time.Time
+	for _, rec := range records {
+		code, _ := rec["station_id"].(string)
+		at, _ := rec["timestamp"].(time.Time)
+		id, ok := stationIDs[code]
+		if !ok {
+			m := stationMetadata{Code: code}
+			if code == meta.Code {
+				m = meta
+			}
+			if lat, ok := rec["latitude"].(float64); ok && m.Latitude == nil {
+				m.Latitude = &lat
+			}
+			if lon, ok := rec["longitude"].(float64); ok && m.Longitude == nil {
+				m.Longitude = &lon
+			}
+			if id, err = upsertStation(tx, m); err != nil {
+				return nil, err
+			}
+			stationIDs[code] = id
+			res.Stations = append(res.Stations, code)
+		}
+
+		depth, _ := rec["depth_m"].(float64)
+		for _, p := range envParameters {
+			value, ok := rec[p.Name].(float64)
+			if !ok {
+				continue
+			}
+			if _, err := stmt.Exec(id, p.Name, at, depth, value); err != nil {
+				return nil, err
+			}
+			res.Observations++
+			res.Parameters[p.Name]++
+		}
+		if first.IsZero() || at.Before(first) {
+			first = at
+		}
+		if at.After(last) {
+			last = at
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	sort.Strings(res.Stations)
+	if !first.IsZero() {
+		res.From, res.To = first.UTC().Format(time.RFC3339), last.UTC().Format(time.RFC3339)
+	}
+	return res, nil
+}
+
+// --- Resampling ---
+
+type SeriesBucket struct {
+	Time  string   `json:"time"`
+	Count int      `json:"count"`
+	Min   *float64 `json:"min"`
+	Max   *float64 `json:"max"`
+	Mean  *float64 `json:"mean"`
+}
+
+type SeriesGap struct {
+	Start           string  `json:"start"`
+	End             string  `json:"end"`
+	DurationMinutes float64 `json:"duration_minutes"`
+	Ongoing         bool    `json:"ongoing"`
+}
+
+type SeriesSummary struct {
+	Count int      `json:"count"`
+	Min   *float64 `json:"min"`
+	Max   *float64 `json:"max"`
+	Mean  *float64 `json:"mean"`
+}
+
+type SeriesResponse struct {
+	Station   string         `json:"station"`
+	Parameter EnvParameter   `json:"parameter"`
+	Interval  string         `json:"interval"`
+	From      string         `json:"from"`
+	To        string         `json:"to"`
+	Summary   SeriesSummary  `json:"summary"`
+	Buckets   []SeriesBucket `json:"buckets"`
+	Gaps      []SeriesGap    `json:"gaps"`
+}
+
+// truncateInterval floors t (in UTC) to the start of its hour, day or month.
+func truncateInterval(t time.Time, interval string) time.Time {
+	t = t.UTC()
+	switch interval {
+	case "hour":
+		return t.Truncate(time.Hour)
+	case "day":
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	case "month":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return t
+}
+
+func nextInterval(t time.Time, interval string) time.Time {
+	switch interval {
+	case "hour":
+		return t.Add(time.Hour)
+	case "day":
+		return t.AddDate(0, 0, 1)
+	case "month":
+		return t.AddDate(0, 1, 0)
+	}
+	return t
+}
+
+// seriesFilter is the WHERE clause shared by the series queries: station,
+// parameter, time range and optional depth range, as $1..$n.
+type seriesFilter struct {
+	where string
+	args  []interface{}
+}
+
+func newSeriesFilter(stationID int, parameter string, from, to time.Time, q map[string][]string) (seriesFilter, error) {
+	f := seriesFilter{
+		where: "station_id = $1 AND parameter = $2 AND observed_at >= $3 AND observed_at < $4",
+		args:  []interface{}{stationID, parameter, from, to},
+	}
+	for _, bound := range []struct{ param, op string }{{"depth_min", ">="}, {"depth_max", "<="}} {
+		raw := ""
+		if v := q[bound.param]; len(v) > 0 {
+			raw = v[0]
+		}
+		if raw == "" {
+			continue
+		}
+		d, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return f, fmt.Errorf("invalid %s", bound.param)
+		}
+		f.args = append(f.args, d)
+		f.where += fmt.Sprintf(" AND depth_m %s $%d", bound.op, len(f.args))
+	}
+	return f, nil
+}
+
+func nullableFloat(v sql.NullFloat64) *float64 {
+	if !v.Valid {
+		return nil
+	}
+	return &v.Float64
+}
+
+func querySeriesBuckets(f seriesFilter, from, to time.Time, interval string) ([]SeriesBucket, error) {
+	rows, err := db.Query(fmt.Sprintf(`SELECT date_trunc('%s', observed_at AT TIME ZONE 'UTC'), COUNT(*), MIN(value), MAX(value), AVG(value)
+		FROM env_observations WHERE %s GROUP BY 1 ORDER BY 1`, interval, f.where), f.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := map[time.Time]SeriesBucket{}
+	for rows.Next() {
+		var t time.Time
+		var b SeriesBucket
+		var mn, mx, mean sql.NullFloat64
+		if err := rows.Scan(&t, &b.Count, &mn, &mx, &mean); err != nil {
+			return nil, err
+		}
+		b.Min, b.Max, b.Mean = nullableFloat(mn), nullableFloat(mx), nullableFloat(mean)
+		found[time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, time.UTC)] = b
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Every bucket in the range is returned, empty ones with a zero count,
+	// so charts show missing data as gaps rather than joining across it.
+	buckets := []SeriesBucket{}
+	for t := truncateInterval(from, interval); t.Before(to); t = nextInterval(t, interval) {
+		b := found[t]
+		b.Time = t.Format(time.RFC3339)
+		buckets = append(buckets, b)
+	}
+	return buckets, nil
+}
+
+func querySeriesRaw(f seriesFilter) ([]SeriesBucket, error) {
+	rows, err := db.Query(fmt.Sprintf(`SELECT observed_at, value FROM env_observations WHERE %s ORDER BY observed_at, depth_m LIMIT %d`,
+		f.where, maxRawObservations), f.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []SeriesBucket{}
+	for rows.Next() {
+		var t time.Time
+		var v float64
+		if err := rows.Scan(&t, &v); err != nil {
+			return nil, err
+		}
+		points = append(points, SeriesBucket{Time: t.UTC().Format(time.RFC3339), Count: 1, Min: &v, Max: &v, Mean: &v})
+	}
+	return points, rows.Err()
+}
+
+// detectGaps finds intervals longer than threshold with no observation,
+// including a trailing gap up to the end of the range when the station
+// has gone quiet.
+func detectGaps(f seriesFilter, to time.Time, threshold time.Duration) ([]SeriesGap, error) {
+	f.args = append(f.args, fmt.Sprintf("%d seconds", int64(threshold.Seconds())))
+	rows, err := db.Query(fmt.Sprintf(`SELECT prev, observed_at FROM (
+			SELECT observed_at, LAG(observed_at) OVER (ORDER BY observed_at) AS prev
+			FROM (SELECT DISTINCT observed_at FROM env_observations WHERE %s) t
+		) g WHERE observed_at - prev > $%d::interval ORDER BY prev`, f.where, len(f.args)), f.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	gaps := []SeriesGap{}
+	for rows.Next() {
+		var start, end time.Time
+		if err := rows.Scan(&start, &end); err != nil {
+			return nil, err
+		}
+		gaps = append(gaps, SeriesGap{Start: start.UTC().Format(time.RFC3339), End: end.UTC().Format(time.RFC3339),
+			DurationMinutes: end.Sub(start).Minutes()})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var last sql.NullTime
+	if err := db.QueryRow("SELECT MAX(observed_at) FROM env_observations WHERE "+f.where, f.args[:len(f.args)-1]...).Scan(&last); err != nil {
+		return nil, err
+	}
+	end := to
+	if now := time.Now(); end.After(now) {
+		end = now
+	}
+	if last.Valid && end.Sub(last.Time) > threshold {
+		gaps = append(gaps, SeriesGap{Start: last.Time.UTC().Format(time.RFC3339), End: end.UTC().Format(time.RFC3339),
+			DurationMinutes: end.Sub(last.Time).Minutes(), Ongoing: true})
+	}
+	return gaps, nil
+}
+
+// --- Handlers ---
+
+func listEnvParameters(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(envParameters)
+}
+
+func listEnvStations(w http.ResponseWriter, r *http.Request) {
+	query := "SELECT " + envStationColumns + " FROM " + envStationFrom
+	args := []interface{}{}
+	if region := r.URL.Query().Get("region"); region != "" {
+		query += " WHERE s.region ILIKE $1"
+		args = append(args, region)
+	}
+	rows, err := db.Query(query+" ORDER BY s.code", args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	stations := []*EnvStation{}
+	for rows.Next() {
+		st, err := scanEnvStation(rows)
+		if err != nil {
+			continue
+		}
+		stations = append(stations, st)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stations)
+}
+
+func getEnvStation(w http.ResponseWriter, r *http.Request) {
+	st := stationForRequest(w, r)
+	if st == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
+
+// saveEnvStation creates or updates a station's metadata from JSON.
+func saveEnvStation(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireRole(w, r, contributorRoles...); !ok {
+		return
+	}
+	var body struct {
+		Code                    string   `json:"code"`
+		Name                    string   `json:"name"`
+		Latitude                *float64 `json:"latitude"`
+		Longitude               *float64 `json:"longitude"`
+		DepthM                  *float64 `json:"depth_m"`
+		Region                  string   `json:"region"`
+		Operator                string   `json:"operator"`
+		PlatformType            string   `json:"platform_type"`
+		ExpectedIntervalMinutes int      `json:"expected_interval_minutes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	body.Code = strings.TrimSpace(body.Code)
+	if body.Code == "" {
+		http.Error(w, "code is required", http.StatusBadRequest)
+		return
+	}
+	if body.Latitude != nil && math.Abs(*body.Latitude) > 90 || body.Longitude != nil && math.Abs(*body.Longitude) > 180 {
+		http.Error(w, "coordinates must be decimal degrees", http.StatusBadRequest)
+		return
+	}
+	if body.ExpectedIntervalMinutes < 0 {
+		http.Error(w, "expected_interval_minutes must be positive", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	_, err = upsertStation(tx, stationMetadata{Code: body.Code, Name: body.Name, Region: body.Region, Operator: body.Operator,
+		PlatformType: body.PlatformType, Latitude: body.Latitude, Longitude: body.Longitude, DepthM: body.DepthM,
+		ExpectedIntervalMinutes: body.ExpectedIntervalMinutes})
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	st, err := loadEnvStation(body.Code)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
+
+// ingestEnvFile imports a station CSV or converted NetCDF file. Station
+// metadata comes from form fields, falling back to the file's comment
+// header. Files with validation errors are rejected with the report unless
+// allow_partial is set, in which case only the valid rows are stored.
+// Files longer than maxTableRows are refused outright so that a series is
+// never stored with its tail silently missing; split long records by
+// period and upload each part.
+func ingestEnvFile(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireRole(w, r, contributorRoles...); !ok {
+		return
+	}
+
+	fields, files, err := readUploadForm(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer func() {
+		for _, f := range files {
+			f.Remove()
+		}
+	}()
+	if len(files) != 1 {
+		http.Error(w, "Upload exactly one file", http.StatusBadRequest)
+		return
+	}
+
+	f, err := os.Open(files[0].Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	attrs, offset := readCommentHeader(f)
+	var meta stationMetadata
+	for key, value := range attrs {
+		meta.set(stationAttributeKeys[key], value)
+	}
+	for key := range fields {
+		meta.set(stationAttributeKeys[normalizeHeader(key)], fields.Get(key))
+	}
+	timeUnits := fields.Get("time_units")
+	if timeUnits == "" {
+		timeUnits = attrs["time_units"]
+	}
+
+	size := files[0].Size - offset
+	rows, err := readTable(io.NewSectionReader(f, offset, size), size, files[0].Name)
+	if err == errTableTooLarge {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if len(rows) < 2 {
+		http.Error(w, "The file has no data rows", http.StatusUnprocessableEntity)
+		return
+	}
+	if err := prepareEnvRows(rows, meta.Code, timeUnits); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	rep, records, err := validateTable(rows, "environmental")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	partial, _ := strconv.ParseBool(fields.Get("allow_partial"))
+	if len(rep.MissingRequired) > 0 || len(records) == 0 || (rep.ErrorCount > 0 && !partial) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(EnvIngestResult{Stations: []string{}, Parameters: map[string]int{}, Report: rep})
+		return
+	}
+
+	res, err := importEnvRecords(records, meta)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res.Report = rep
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(res)
+}
+
+// getEnvSeries returns one parameter of a station resampled by ?interval=
+// hour, day, month or raw, with min/max/mean per bucket and the gaps in
+// the record. ?max_gap= (a Go duration such as "6h") overrides the
+// station's gap threshold.
+func getEnvSeries(w http.ResponseWriter, r *http.Request) {
+	st := stationForRequest(w, r)
+	if st == nil {
+		return
+	}
+	q := r.URL.Query()
+
+	parameter := q.Get("parameter")
+	if !isEnvParameter(parameter) {
+		http.Error(w, "parameter must be one of temperature_c, salinity_psu, dissolved_oxygen_mg_l, ph", http.StatusBadRequest)
+		return
+	}
+	interval := q.Get("interval")
+	if interval == "" {
+		interval = "day"
+	}
+	if interval != "hour" && interval != "day" && interval != "month" && interval != "raw" {
+		http.Error(w, "interval must be hour, day, month or raw", http.StatusBadRequest)
+		return
+	}
+	threshold := st.gapThreshold()
+	if raw := q.Get("max_gap"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			http.Error(w, "max_gap must be a duration such as 90m or 6h", http.StatusBadRequest)
+			return
+		}
+		threshold = d
+	}
+
+	var from, to time.Time
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		if raw := q.Get(p.name); raw != "" {
+			t, ok := parseDate(raw)
+			if !ok {
+				http.Error(w, "invalid "+p.name+" date", http.StatusBadRequest)
+				return
+			}
+			*p.dst = t
+		}
+	}
+	if from.IsZero() || to.IsZero() {
+		var first, last sql.NullTime
+		err := db.QueryRow("SELECT MIN(observed_at), MAX(observed_at) FROM env_observations WHERE station_id = $1 AND parameter = $2",
+			st.ID, parameter).Scan(&first, &last)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !first.Valid {
+			http.Error(w, "No "+parameter+" observations for this station", http.StatusNotFound)
+			return
+		}
+		if from.IsZero() {
+			from = first.Time
+		}
+		if to.IsZero() {
+			to = last.Time.Add(time.Second)
+		}
+	}
+	if !to.After(from) {
+		http.Error(w, "to must be after from", http.StatusBadRequest)
+		return
+	}
+	if interval != "raw" {
+		n := 0
+		for t := truncateInterval(from, interval); t.Before(to) && n <= maxSeriesBuckets; t = nextInterval(t, interval) {
+			n++
+		}
+		if n > maxSeriesBuckets {
+			http.Error(w, fmt.Sprintf("the range has more than %d %s buckets; use a coarser interval", maxSeriesBuckets, interval), http.StatusBadRequest)
+			return
+		}
+	}
+
+	filter, err := newSeriesFilter(st.ID, parameter, from, to, q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := SeriesResponse{Station: st.Code, Interval: interval, From: from.UTC().Format(time.RFC3339), To: to.UTC().Format(time.RFC3339)}
+	for _, p := range envParameters {
+		if p.Name == parameter {
+			resp.Parameter = p
+		}
+	}
+
+	var mn, mx, mean sql.NullFloat64
+	err = db.QueryRow("SELECT COUNT(*), MIN(value), MAX(value), AVG(value) FROM env_observations WHERE "+filter.where, filter.args...).
+		Scan(&resp.Summary.Count, &mn, &mx, &mean)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp.Summary.Min, resp.Summary.Max, resp.Summary.Mean = nullableFloat(mn), nullableFloat(mx), nullableFloat(mean)
+
+	if interval == "raw" {
+		resp.Buckets, err = querySeriesRaw(filter)
+	} else {
+		resp.Buckets, err = querySeriesBuckets(filter, from, to, interval)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if resp.Gaps, err = detectGaps(filter, to, threshold); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
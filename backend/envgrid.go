@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// --- Environmental Grid Layers ---
+//
+// Gridded covariates (SST, salinity, depth, chlorophyll...) for the
+// distribution models. Layers are ESRI ASCII grids in geographic
+// coordinates, kept as uploaded in the object store under env-layers/
+// with their header in env_layers. Parsed grids are cached in memory,
+// since a model fit or a map tile touches every cell.
+
+const envLayerSchema = `
+CREATE TABLE IF NOT EXISTS env_layers (
+	id           SERIAL PRIMARY KEY,
+	name         TEXT NOT NULL UNIQUE,
+	parameter    TEXT NOT NULL,
+	unit         TEXT,
+	description  TEXT,
+	ncols        INTEGER NOT NULL,
+	nrows        INTEGER NOT NULL,
+	xll          DOUBLE PRECISION NOT NULL,
+	yll          DOUBLE PRECISION NOT NULL,
+	cellsize     DOUBLE PRECISION NOT NULL,
+	storage_key  TEXT NOT NULL,
+	submitted_by TEXT NOT NULL,
+	created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);`
+
+const (
+	maxGridCells = 25_000_000
+	// maxCachedGridCells bounds the grid cache at about 400 MB of float32
+	// values.
+	maxCachedGridCells = 4 * maxGridCells
+)
+
+type EnvLayer struct {
+	ID          int     `json:"id"`
+	Name        string  `json:"name"`
+	Parameter   string  `json:"parameter"`
+	Unit        string  `json:"unit"`
+	Description string  `json:"description"`
+	NCols       int     `json:"ncols"`
+	NRows       int     `json:"nrows"`
+	XLL         float64 `json:"xll"`
+	YLL         float64 `json:"yll"`
+	CellSize    float64 `json:"cellsize"`
+	CreatedAt   string  `json:"created_at"`
+	storageKey  string
+}
+
+// Grid is a parsed raster. Values run row by row from the northern edge;
+// NaN marks no data.
+type Grid struct {
+	NCols, NRows       int
+	XLL, YLL, CellSize float64
+	Values             []float32
+}
+
+func (g *Grid) at(col, row int) float64 {
+	if col < 0 || row < 0 || col >= g.NCols || row >= g.NRows {
+		return math.NaN()
+	}
+	return float64(g.Values[row*g.NCols+col])
+}
+
+// cell returns the column and row holding a point.
+func (g *Grid) cell(lon, lat float64) (int, int) {
+	col := int(math.Floor((lon - g.XLL) / g.CellSize))
+	row := g.NRows - 1 - int(math.Floor((lat-g.YLL)/g.CellSize))
+	return col, row
+}
+
+// sample is the nearest-cell value at a point, NaN outside the grid.
+func (g *Grid) sample(lon, lat float64) float64 {
+	col, row := g.cell(lon, lat)
+	return g.at(col, row)
+}
+
+func (g *Grid) center(col, row int) (float64, float64) {
+	return g.XLL + (float64(col)+0.5)*g.CellSize, g.YLL + (float64(g.NRows-row)-0.5)*g.CellSize
+}
+
+// parseASCIIGrid reads an ESRI ASCII grid: a header of ncols, nrows,
+// xllcorner or xllcenter, yllcorner or yllcenter, cellsize and optional
+// NODATA_value, then nrows lines of values from north to south.
+func parseASCIIGrid(r io.Reader) (*Grid, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 1<<20), 64<<20)
+	sc.Split(bufio.ScanWords)
+
+	header := map[string]float64{}
+	var first string
+	for sc.Scan() {
+		key := strings.ToLower(sc.Text())
+		if key == "" || (key[0] >= '0' && key[0] <= '9') || key[0] == '-' || key[0] == '.' {
+			first = sc.Text()
+			break
+		}
+		if !sc.Scan() {
+			return nil, fmt.Errorf("header %s has no value", key)
+		}
+		v, err := strconv.ParseFloat(sc.Text(), 64)
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %q is not a number", key, sc.Text())
+		}
+		header[key] = v
+	}
+	for _, k := range []string{"ncols", "nrows", "cellsize"} {
+		if header[k] <= 0 {
+			return nil, fmt.Errorf("header needs a positive %s", k)
+		}
+	}
+
+	// Check each dimension first so a huge header cannot overflow the
+	// product.
+	if header["ncols"] > maxGridCells || header["nrows"] > maxGridCells {
+		return nil, fmt.Errorf("grid has more than %d cells", maxGridCells)
+	}
+	g := &Grid{NCols: int(header["ncols"]), NRows: int(header["nrows"]), CellSize: header["cellsize"]}
+	if g.NCols*g.NRows > maxGridCells {
+		return nil, fmt.Errorf("grid has more than %d cells", maxGridCells)
+	}
+	x, okX := header["xllcorner"]
+	y, okY := header["yllcorner"]
+	if cx, ok := header["xllcenter"]; ok && !okX {
+		x, okX = cx-g.CellSize/2, true
+	}
+	if cy, ok := header["yllcenter"]; ok && !okY {
+		y, okY = cy-g.CellSize/2, true
+	}
+	if !okX || !okY {
+		return nil, fmt.Errorf("header needs xllcorner/yllcorner or xllcenter/yllcenter")
+	}
+	g.XLL, g.YLL = x, y
+	if g.XLL < -360 || g.XLL+float64(g.NCols)*g.CellSize > 360 || g.YLL < -90 || g.YLL+float64(g.NRows)*g.CellSize > 90.0001 {
+		return nil, fmt.Errorf("grid extent is not in geographic degrees")
+	}
+	nodata, hasNodata := header["nodata_value"]
+
+	g.Values = make([]float32, 0, g.NCols*g.NRows)
+	add := func(tok string) error {
+		v, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return fmt.Errorf("cell %d: %q is not a number", len(g.Values)+1, tok)
+		}
+		if len(g.Values) == g.NCols*g.NRows {
+			return fmt.Errorf("grid has more than ncols x nrows values")
+		}
+		if hasNodata && v == nodata || math.IsNaN(v) {
+			g.Values = append(g.Values, float32(math.NaN()))
+		} else {
+			g.Values = append(g.Values, float32(v))
+		}
+		return nil
+	}
+	if first != "" {
+		if err := add(first); err != nil {
+			return nil, err
+		}
+	}
+	for sc.Scan() {
+		if err := add(sc.Text()); err != nil {
+			return nil, err
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(g.Values) != g.NCols*g.NRows {
+		return nil, fmt.Errorf("grid has %d values, header says %d", len(g.Values), g.NCols*g.NRows)
+	}
+	return g, nil
+}
+
+// gridCache holds parsed grids by layer id, bounded by total cell count
+// so a few large layers cannot exhaust memory.
+var gridCache = newLRUCache[int, *Grid](maxCachedGridCells, func(g *Grid) int { return len(g.Values) })
+
+// loadGrid returns the parsed grid for a layer, reading it from the
+// object store on first use.
+func loadGrid(ctx context.Context, layer *EnvLayer) (*Grid, error) {
+	if g, ok := gridCache.get(layer.ID); ok {
+		return g, nil
+	}
+	body, err := store.Get(ctx, layer.storageKey)
+	if err != nil {
+		return nil, fmt.Errorf("layer %s: %w", layer.Name, err)
+	}
+	defer body.Close()
+	g, err := parseASCIIGrid(body)
+	if err != nil {
+		return nil, fmt.Errorf("layer %s: %w", layer.Name, err)
+	}
+	gridCache.put(layer.ID, g)
+	return g, nil
+}
+
+const envLayerColumns = `id, name, parameter, COALESCE(unit, ''), COALESCE(description, ''), ncols, nrows, xll, yll, cellsize, created_at::text, storage_key`
+
+func scanEnvLayer(row interface{ Scan(...any) error }) (*EnvLayer, error) {
+	var l EnvLayer
+	err := row.Scan(&l.ID, &l.Name, &l.Parameter, &l.Unit, &l.Description, &l.NCols, &l.NRows, &l.XLL, &l.YLL, &l.CellSize, &l.CreatedAt, &l.storageKey)
+	return &l, err
+}
+
+// envLayersByName resolves layer names, or every layer when names is
+// empty, in the order given.
+func envLayersByName(names []string) ([]*EnvLayer, error) {
+	rows, err := db.Query("SELECT " + envLayerColumns + " FROM env_layers ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	byName := map[string]*EnvLayer{}
+	var all []*EnvLayer
+	for rows.Next() {
+		l, err := scanEnvLayer(rows)
+		if err != nil {
+			return nil, err
+		}
+		byName[l.Name] = l
+		all = append(all, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return all, nil
+	}
+	var out []*EnvLayer
+	for _, n := range names {
+		l, ok := byName[n]
+		if !ok {
+			return nil, fmt.Errorf("unknown layer %q", n)
+		}
+		out = append(out, l)
+	}
+	return out, nil
+}
+
+func listEnvLayers(w http.ResponseWriter, r *http.Request) {
+	layers, err := envLayersByName(nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if layers == nil {
+		layers = []*EnvLayer{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(layers)
+}
+
+// uploadEnvLayer stores an ESRI ASCII grid. Form fields: name, parameter,
+// unit, description.
+func uploadEnvLayer(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireRole(w, r, contributorRoles...)
+	if !ok {
+		return
+	}
+	fields, files, err := readUploadForm(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer func() {
+		for _, f := range files {
+			f.Remove()
+		}
+	}()
+	if len(files) != 1 {
+		http.Error(w, "Upload exactly one file", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(fields.Get("name"))
+	parameter := strings.TrimSpace(fields.Get("parameter"))
+	if name == "" || parameter == "" {
+		http.Error(w, "name and parameter are required", http.StatusBadRequest)
+		return
+	}
+
+	f, err := os.Open(files[0].Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	g, err := parseASCIIGrid(f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	key := "env-layers/" + randomID(16) + ".asc"
+	if err := store.Put(r.Context(), key, f, files[0].Size, "text/plain"); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	layer, err := scanEnvLayer(db.QueryRow(`INSERT INTO env_layers (name, parameter, unit, description, ncols, nrows, xll, yll, cellsize, storage_key, submitted_by)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5, $6, $7, $8, $9, $10, $11) RETURNING `+envLayerColumns,
+		name, parameter, fields.Get("unit"), fields.Get("description"), g.NCols, g.NRows, g.XLL, g.YLL, g.CellSize, key, user.ID))
+	if err != nil {
+		store.Delete(r.Context(), key)
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			http.Error(w, "A layer named "+name+" already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	gridCache.put(layer.ID, g)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(layer)
+}
+
+func deleteEnvLayer(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireRole(w, r, moderatorRoles...); !ok {
+		return
+	}
+	var id int
+	var key string
+	err := db.QueryRow("DELETE FROM env_layers WHERE id = $1 RETURNING id, storage_key", r.PathValue("id")).Scan(&id, &key)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Layer not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	store.Delete(r.Context(), key)
+	gridCache.remove(id)
+	clearSDMCache()
+	w.WriteHeader(http.StatusNoContent)
+}
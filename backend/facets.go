@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// --- Species Filters ---
+
+// speciesFilter accumulates the WHERE clauses shared by getSpecies and the
+// facet endpoints. Clauses use "?" as the placeholder for their argument.
+type speciesFilter struct {
+	joinOccurrences bool
+	where           []string
+	args            []interface{}
+}
+
+func (f *speciesFilter) add(clause string, arg interface{}) {
+	f.args = append(f.args, arg)
+	f.where = append(f.where, strings.ReplaceAll(clause, "?", "$"+strconv.Itoa(len(f.args))))
+}
+
+func (f *speciesFilter) from() string {
+	if f.joinOccurrences {
+		return " FROM species_data s INNER JOIN occurrence_data o ON s.id = o.species_id"
+	}
+	return " FROM species_data s"
+}
+
+func (f *speciesFilter) whereSQL() string {
+	return " WHERE " + strings.Join(append([]string{"1=1"}, f.where...), " AND ")
+}
+
+// buildSpeciesFilter turns the getSpecies query parameters into a filter.
+// The filter named by skip is left out, which is how each facet is counted
+// under all the other active filters.
+func buildSpeciesFilter(q url.Values, skip string) *speciesFilter {
+	f := &speciesFilter{}
+
+	// Region Filter
+	if region := q.Get("region"); region != "" && skip != "region" {
+		f.joinOccurrences = true
+		f.add("o.region = ?", region)
+	}
+
+	// Time Filter
+	if timeFilter := q.Get("time"); timeFilter != "" && skip != "time" {
+		f.joinOccurrences = true
+
+		now := time.Now()
+		var dateStr string
+		switch timeFilter {
+		case "24h":
+			dateStr = now.Add(-24 * time.Hour).Format("2006-01-02")
+		case "7d":
+			dateStr = now.AddDate(0, 0, -7).Format("2006-01-02")
+		case "1m":
+			dateStr = now.AddDate(0, -1, 0).Format("2006-01-02")
+		case "1y":
+			dateStr = now.AddDate(-1, 0, 0).Format("2006-01-02")
+		case "5y":
+			dateStr = now.AddDate(-5, 0, 0).Format("2006-01-02")
+		}
+
+		if dateStr != "" {
+			f.add("o.eventdate >= ?", dateStr)
+		}
+	}
+
+	// Class Filter
+	if class := q.Get("class"); class != "" && skip != "class" {
+		f.add("s.class = ?", class)
+	}
+
+	// Conservation Status Filter: IUCN codes or names, comma separated, or
+	// "threatened" for CR, EN and VU. Anything else matches the text exactly.
+	if status := q.Get("conservation_status"); status != "" && skip != "conservation_status" {
+		if categories, err := parseIUCNList(status); err == nil {
+			f.add("s.iucn_category::text = ANY(?)", pq.Array(categories))
+		} else {
+			f.add("s.conservation_status = ?", status)
+		}
+	}
+
+	// Habitat and Diet Filters
+	if habitat := q.Get("habitat_type"); habitat != "" && skip != "habitat_type" {
+		f.add("s.habitat_type = ?", habitat)
+	}
+	if diet := q.Get("diet"); diet != "" && skip != "diet" {
+		f.add("s.diet = ?", diet)
+	}
+
+	// Depth Range Filters
+	if skip != "depth" {
+		if minDepth := q.Get("min_depth"); minDepth != "" {
+			f.add("s.depth_range_min >= ?", minDepth)
+		}
+		if maxDepth := q.Get("max_depth"); maxDepth != "" {
+			f.add("s.depth_range_max <= ?", maxDepth)
+		}
+	}
+
+	// Search Filter
+	if search := q.Get("search"); search != "" && skip != "search" {
+		f.add("(s.vernacularname ILIKE ? OR s.scientific_name ILIKE ?)", "%"+search+"%")
+	}
+
+	return f
+}
+
+// --- Facets ---
+
+type FacetValue struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+type DepthBin struct {
+	Min   float64  `json:"min"`
+	Max   *float64 `json:"max"`
+	Count int      `json:"count"`
+}
+
+type FacetResponse struct {
+	Total              int          `json:"total"`
+	Class              []FacetValue `json:"class"`
+	Region             []FacetValue `json:"region"`
+	ConservationStatus []FacetValue `json:"conservation_status"`
+	HabitatType        []FacetValue `json:"habitat_type"`
+	Diet               []FacetValue `json:"diet"`
+	Depth              []DepthBin   `json:"depth"`
+}
+
+// depthBinEdges are the lower edges of the depth histogram bins in metres;
+// the last bin is open-ended.
+var depthBinEdges = []float64{0, 10, 50, 100, 200, 500, 1000, 2000}
+
+// facetColumns maps a facet name (which is also its filter parameter) to
+// the column it groups by. Conservation status groups by the IUCN
+// category and reports its label, which the filter parses back.
+var facetColumns = map[string]string{
+	"class":               "s.class",
+	"region":              "o.region",
+	"conservation_status": "s.iucn_category",
+	"habitat_type":        "s.habitat_type",
+	"diet":                "s.diet",
+}
+
+// facetCounts counts distinct species per value of the facet column, with
+// every filter except the facet's own applied.
+func facetCounts(q url.Values, facet string) ([]FacetValue, error) {
+	column := facetColumns[facet]
+	f := buildSpeciesFilter(q, facet)
+	if facet == "region" {
+		f.joinOccurrences = true
+	}
+	query := "SELECT " + column + ", COUNT(DISTINCT s.id)" + f.from() + f.whereSQL() +
+		" AND " + column + " IS NOT NULL AND " + column + "::text <> '' GROUP BY " + column + " ORDER BY " + column
+
+	rows, err := db.Query(query, f.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []FacetValue{}
+	for rows.Next() {
+		var v FacetValue
+		if err := rows.Scan(&v.Value, &v.Count); err != nil {
+			continue
+		}
+		if facet == "conservation_status" {
+			v.Value = IUCNCategory(v.Value).Label()
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+// depthHistogram counts species whose depth range overlaps each bin.
+func depthHistogram(q url.Values) ([]DepthBin, error) {
+	f := buildSpeciesFilter(q, "depth")
+	query := "SELECT DISTINCT s.id, COALESCE(s.depth_range_min, 0), COALESCE(s.depth_range_max, s.depth_range_min, 0)" + f.from() + f.whereSQL()
+
+	rows, err := db.Query(query, f.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bins := make([]DepthBin, len(depthBinEdges))
+	for i, lo := range depthBinEdges {
+		bins[i].Min = lo
+		if i+1 < len(depthBinEdges) {
+			hi := depthBinEdges[i+1]
+			bins[i].Max = &hi
+		}
+	}
+	for rows.Next() {
+		var id int
+		var lo, hi float64
+		if err := rows.Scan(&id, &lo, &hi); err != nil {
+			continue
+		}
+		if hi < lo {
+			lo, hi = hi, lo
+		}
+		for i := range bins {
+			binMax := math.Inf(1)
+			if bins[i].Max != nil {
+				binMax = *bins[i].Max
+			}
+			if lo < binMax && hi >= bins[i].Min {
+				bins[i].Count++
+			}
+		}
+	}
+	return bins, rows.Err()
+}
+
+func getFacets(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var resp FacetResponse
+	var err error
+
+	f := buildSpeciesFilter(q, "")
+	if err = db.QueryRow("SELECT COUNT(DISTINCT s.id)"+f.from()+f.whereSQL(), f.args...).Scan(&resp.Total); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	targets := map[string]*[]FacetValue{
+		"class":               &resp.Class,
+		"region":              &resp.Region,
+		"conservation_status": &resp.ConservationStatus,
+		"habitat_type":        &resp.HabitatType,
+		"diet":                &resp.Diet,
+	}
+	for facet, dst := range targets {
+		if *dst, err = facetCounts(q, facet); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if resp.Depth, err = depthHistogram(q); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// facetValueHandler serves the legacy single-field filter endpoints from the
+// facet counts, so they too honour the other active filters.
+func facetValueHandler(facet string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		counts, err := facetCounts(r.URL.Query(), facet)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		values := make([]string, 0, len(counts))
+		for _, c := range counts {
+			values = append(values, c.Value)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(values)
+	}
+}
@@ -0,0 +1,903 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// --- Fisheries Catch and Effort ---
+//
+// catch_records holds landings of one species by one vessel (or a port
+// total when no vessel is given), with optional length-frequency bins.
+// fishing_effort holds trips, days at sea and hours fished, at most one
+// row per trip_id. Effort is not species-specific, so CPUE for a stratum
+// is the species' catch divided by all effort in the same region, gear
+// and month.
+
+const fisheriesSchema = `
+CREATE TABLE IF NOT EXISTS catch_records (
+	id           SERIAL PRIMARY KEY,
+	species_id   INTEGER NOT NULL,
+	landing_date DATE NOT NULL,
+	port         TEXT,
+	region       TEXT,
+	gear         TEXT,
+	vessel_id    TEXT,
+	trip_id      TEXT,
+	weight_kg    DOUBLE PRECISION,
+	count        INTEGER,
+	source       TEXT NOT NULL DEFAULT 'api',
+	submitted_by TEXT NOT NULL,
+	created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS catch_records_species_idx ON catch_records (species_id, landing_date);
+CREATE INDEX IF NOT EXISTS catch_records_date_idx ON catch_records (landing_date);
+
+CREATE TABLE IF NOT EXISTS catch_length_frequencies (
+	catch_id  INTEGER NOT NULL REFERENCES catch_records(id) ON DELETE CASCADE,
+	length_cm DOUBLE PRECISION NOT NULL,
+	count     INTEGER NOT NULL,
+	PRIMARY KEY (catch_id, length_cm)
+);
+
+CREATE TABLE IF NOT EXISTS fishing_effort (
+	id           SERIAL PRIMARY KEY,
+	effort_date  DATE NOT NULL,
+	port         TEXT,
+	region       TEXT,
+	gear         TEXT,
+	vessel_id    TEXT,
+	trip_id      TEXT,
+	trips        INTEGER NOT NULL DEFAULT 1,
+	days_at_sea  DOUBLE PRECISION,
+	hours_fished DOUBLE PRECISION,
+	source       TEXT NOT NULL DEFAULT 'api',
+	submitted_by TEXT NOT NULL,
+	created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS fishing_effort_date_idx ON fishing_effort (effort_date);
+CREATE UNIQUE INDEX IF NOT EXISTS fishing_effort_trip_idx ON fishing_effort (trip_id) WHERE trip_id IS NOT NULL;`
+
+// effortUnits maps ?effort_unit= to the effort column summed for CPUE.
+var effortUnits = map[string]string{"hours": "hours_fished", "days": "days_at_sea", "trips": "trips"}
+
+type LengthBin struct {
+	LengthCm float64 `json:"length_cm"`
+	Count    int     `json:"count"`
+}
+
+type CatchRecord struct {
+	ID                int         `json:"id"`
+	SpeciesID         int         `json:"species_id"`
+	ScientificName    string      `json:"scientific_name"`
+	LandingDate       string      `json:"landing_date"`
+	Port              string      `json:"port,omitempty"`
+	Region            string      `json:"region,omitempty"`
+	Gear              string      `json:"gear,omitempty"`
+	VesselID          string      `json:"vessel_id,omitempty"`
+	TripID            string      `json:"trip_id,omitempty"`
+	WeightKg          *float64    `json:"weight_kg"`
+	Count             *int        `json:"count"`
+	LengthFrequencies []LengthBin `json:"length_frequencies"`
+	Source            string      `json:"source"`
+	CreatedAt         string      `json:"created_at"`
+}
+
+type EffortRecord struct {
+	ID          int      `json:"id"`
+	EffortDate  string   `json:"effort_date"`
+	Port        string   `json:"port,omitempty"`
+	Region      string   `json:"region,omitempty"`
+	Gear        string   `json:"gear,omitempty"`
+	VesselID    string   `json:"vessel_id,omitempty"`
+	TripID      string   `json:"trip_id,omitempty"`
+	Trips       int      `json:"trips"`
+	DaysAtSea   *float64 `json:"days_at_sea"`
+	HoursFished *float64 `json:"hours_fished"`
+	Source      string   `json:"source"`
+	CreatedAt   string   `json:"created_at"`
+}
+
+// fisheryFilter builds the WHERE clause shared by catch, effort and CPUE
+// queries from ?region=, port, gear, vessel_id, from and to. Species
+// filters only apply to catch.
+type fisheryFilter struct {
+	where  []string
+	args   []interface{}
+	offset int
+}
+
+func (f *fisheryFilter) arg(v interface{}) string {
+	f.args = append(f.args, v)
+	return "$" + strconv.Itoa(f.offset+len(f.args))
+}
+
+// newFisheryFilter numbers its placeholders after the first offset ones,
+// so that two filters can share one query.
+func newFisheryFilter(q map[string][]string, alias, dateCol string, species bool, offset int) (*fisheryFilter, error) {
+	f := &fisheryFilter{where: []string{"1=1"}, offset: offset}
+	get := func(k string) string {
+		if v := q[k]; len(v) > 0 {
+			return strings.TrimSpace(v[0])
+		}
+		return ""
+	}
+	for _, col := range []string{"region", "port", "gear"} {
+		if v := get(col); v != "" {
+			f.where = append(f.where, alias+"."+col+" ILIKE "+f.arg(v))
+		}
+	}
+	if v := get("vessel_id"); v != "" {
+		f.where = append(f.where, alias+".vessel_id = "+f.arg(v))
+	}
+	for _, b := range []struct{ param, op string }{{"from", ">="}, {"to", "<="}} {
+		if v := get(b.param); v != "" {
+			if _, err := time.Parse("2006-01-02", v); err != nil {
+				return nil, fmt.Errorf("%s must be a YYYY-MM-DD date", b.param)
+			}
+			f.where = append(f.where, alias+"."+dateCol+" "+b.op+" "+f.arg(v)+"::date")
+		}
+	}
+	if v := get("species_id"); v != "" && species {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("species_id must be an integer")
+		}
+		f.where = append(f.where, alias+".species_id = "+f.arg(id))
+	}
+	return f, nil
+}
+
+func (f *fisheryFilter) sql() string {
+	return strings.Join(f.where, " AND ")
+}
+
+// --- Catch ---
+
+const catchColumns = `c.id, c.species_id, COALESCE(s.scientific_name, ''), c.landing_date::text, COALESCE(c.port, ''),
+	COALESCE(c.region, ''), COALESCE(c.gear, ''), COALESCE(c.vessel_id, ''), COALESCE(c.trip_id, ''), c.weight_kg, c.count,
+	c.source, c.created_at::text`
+
+const catchFrom = `catch_records c LEFT JOIN species_data s ON s.id = c.species_id`
+
+func scanCatchRecord(row interface{ Scan(...any) error }) (*CatchRecord, error) {
+	var c CatchRecord
+	var weight sql.NullFloat64
+	var count sql.NullInt64
+	err := row.Scan(&c.ID, &c.SpeciesID, &c.ScientificName, &c.LandingDate, &c.Port, &c.Region, &c.Gear, &c.VesselID, &c.TripID,
+		&weight, &count, &c.Source, &c.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	c.WeightKg = nullableFloat(weight)
+	if count.Valid {
+		n := int(count.Int64)
+		c.Count = &n
+	}
+	c.LengthFrequencies = []LengthBin{}
+	return &c, nil
+}
+
+// loadLengthFrequencies fills in the length bins of the given records.
+func loadLengthFrequencies(records []*CatchRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	byID := map[int]*CatchRecord{}
+	ids := make([]int64, 0, len(records))
+	for _, c := range records {
+		byID[c.ID] = c
+		ids = append(ids, int64(c.ID))
+	}
+	rows, err := db.Query(`SELECT catch_id, length_cm, count FROM catch_length_frequencies
+		WHERE catch_id = ANY($1) ORDER BY catch_id, length_cm`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var b LengthBin
+		if err := rows.Scan(&id, &b.LengthCm, &b.Count); err != nil {
+			return err
+		}
+		byID[id].LengthFrequencies = append(byID[id].LengthFrequencies, b)
+	}
+	return rows.Err()
+}
+
+// insertCatch stores a catch record and its length bins, returning its id.
+func insertCatch(tx *sql.Tx, c *CatchRecord, userID string) (int, error) {
+	var id int
+	err := tx.QueryRow(`INSERT INTO catch_records (species_id, landing_date, port, region, gear, vessel_id, trip_id, weight_kg,
+			count, source, submitted_by)
+		VALUES ($1, $2::date, NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''), $8, $9, $10, $11)
+		RETURNING id`,
+		c.SpeciesID, c.LandingDate, c.Port, c.Region, c.Gear, c.VesselID, c.TripID, c.WeightKg, c.Count, c.Source, userID).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	for _, b := range c.LengthFrequencies {
+		_, err := tx.Exec(`INSERT INTO catch_length_frequencies (catch_id, length_cm, count) VALUES ($1, $2, $3)
+			ON CONFLICT (catch_id, length_cm) DO UPDATE SET count = catch_length_frequencies.count + EXCLUDED.count`,
+			id, b.LengthCm, b.Count)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return id, nil
+}
+
+// insertEffort records effort once per trip: a record with a trip_id that
+// is already stored replaces that trip's days and hours where given
+// instead of adding a second record.
+func insertEffort(tx *sql.Tx, e *EffortRecord, userID string) (int, error) {
+	var id int
+	err := tx.QueryRow(`INSERT INTO fishing_effort (effort_date, port, region, gear, vessel_id, trip_id, trips, days_at_sea,
+			hours_fished, source, submitted_by)
+		VALUES ($1::date, NULLIF($2, ''), NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''), $7, $8, $9, $10, $11)
+		ON CONFLICT (trip_id) WHERE trip_id IS NOT NULL DO UPDATE SET
+			days_at_sea = COALESCE(EXCLUDED.days_at_sea, fishing_effort.days_at_sea),
+			hours_fished = COALESCE(EXCLUDED.hours_fished, fishing_effort.hours_fished)
+		RETURNING id`,
+		e.EffortDate, e.Port, e.Region, e.Gear, e.VesselID, e.TripID, e.Trips, e.DaysAtSea, e.HoursFished, e.Source, userID).Scan(&id)
+	return id, err
+}
+
+// checkCatch validates a catch record given as JSON, resolving a species
+// name when no species_id is given.
+func checkCatch(c *CatchRecord, speciesName string) error {
+	if c.SpeciesID == 0 && speciesName != "" {
+		if id := guessSpeciesID(speciesName); id != nil {
+			c.SpeciesID = *id
+		}
+	}
+	if c.SpeciesID == 0 {
+		return fmt.Errorf("species_id or a known species name is required")
+	}
+	if exists, err := speciesExists(c.SpeciesID); err != nil || !exists {
+		return fmt.Errorf("unknown species_id %d", c.SpeciesID)
+	}
+	if _, err := time.Parse("2006-01-02", c.LandingDate); err != nil {
+		return fmt.Errorf("landing_date must be a YYYY-MM-DD date")
+	}
+	if c.WeightKg == nil && c.Count == nil && len(c.LengthFrequencies) == 0 {
+		return fmt.Errorf("give weight_kg, count or length_frequencies")
+	}
+	if c.WeightKg != nil && *c.WeightKg < 0 || c.Count != nil && *c.Count < 0 {
+		return fmt.Errorf("weight_kg and count must not be negative")
+	}
+	for _, b := range c.LengthFrequencies {
+		if b.LengthCm <= 0 || b.LengthCm > 500 || b.Count < 0 {
+			return fmt.Errorf("length_frequencies need a length_cm in (0, 500] and a non-negative count")
+		}
+	}
+	return nil
+}
+
+// createCatchRecord adds one catch record. With effort_hours or
+// days_at_sea it also records the effort of the catch's trip_id, once per
+// trip, so every species landed on a trip can carry the same effort
+// without counting it twice.
+func createCatchRecord(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireRole(w, r, contributorRoles...)
+	if !ok {
+		return
+	}
+	var req struct {
+		CatchRecord
+		Species     string   `json:"species"`
+		EffortHours *float64 `json:"effort_hours"`
+		DaysAtSea   *float64 `json:"days_at_sea"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	c := &req.CatchRecord
+	c.Source = "api"
+	if err := checkCatch(c, req.Species); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.EffortHours != nil || req.DaysAtSea != nil {
+		if c.TripID == "" {
+			http.Error(w, "effort_hours and days_at_sea need a trip_id; post effort without a trip to /api/fisheries/effort", http.StatusBadRequest)
+			return
+		}
+		if req.EffortHours != nil && *req.EffortHours < 0 || req.DaysAtSea != nil && *req.DaysAtSea < 0 {
+			http.Error(w, "effort_hours and days_at_sea must not be negative", http.StatusBadRequest)
+			return
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	id, err := insertCatch(tx, c, user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.EffortHours != nil || req.DaysAtSea != nil {
+		e := &EffortRecord{EffortDate: c.LandingDate, Port: c.Port, Region: c.Region, Gear: c.Gear, VesselID: c.VesselID,
+			TripID: c.TripID, Trips: 1, DaysAtSea: req.DaysAtSea, HoursFished: req.EffortHours, Source: "api"}
+		if _, err := insertEffort(tx, e, user.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	created, err := scanCatchRecord(db.QueryRow("SELECT "+catchColumns+" FROM "+catchFrom+" WHERE c.id = $1", id))
+	if err == nil {
+		err = loadLengthFrequencies([]*CatchRecord{created})
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+type CatchImportResult struct {
+	CatchRecords  int               `json:"catch_records"`
+	EffortRecords int               `json:"effort_records"`
+	LengthBins    int               `json:"length_bins"`
+	From          string            `json:"from,omitempty"`
+	To            string            `json:"to,omitempty"`
+	Report        *ValidationReport `json:"report"`
+}
+
+// groupCatchRecords merges validated sheet rows that share species, date,
+// port, region, gear and vessel into one catch record: weights and counts
+// are summed and each length_cm adds its count (or one fish) to that
+// length bin. Effort comes from effort_hours, counted once per trip.
+func groupCatchRecords(records []map[string]interface{}, source string) ([]*CatchRecord, []*EffortRecord) {
+	text := func(rec map[string]interface{}, k string) string {
+		v, _ := rec[k].(string)
+		return v
+	}
+	catches := map[string]*CatchRecord{}
+	bins := map[string]map[float64]int{}
+	efforts := map[string]*EffortRecord{}
+	var keys, effortKeys []string
+
+	for _, rec := range records {
+		date := rec["landing_date"].(time.Time).Format("2006-01-02")
+		speciesID, _ := rec["species_id"].(int)
+		port, region, gear, vessel := text(rec, "port"), text(rec, "region"), text(rec, "gear"), text(rec, "vessel_id")
+
+		tripKey := strings.Join([]string{date, port, region, gear, vessel}, "\x00")
+		key := strconv.Itoa(speciesID) + "\x00" + tripKey
+		c, ok := catches[key]
+		if !ok {
+			c = &CatchRecord{SpeciesID: speciesID, LandingDate: date, Port: port, Region: region, Gear: gear, VesselID: vessel,
+				Source: source, LengthFrequencies: []LengthBin{}}
+			catches[key], bins[key] = c, map[float64]int{}
+			keys = append(keys, key)
+		}
+
+		n, hasCount := rec["count"].(float64)
+		if length, ok := rec["length_cm"].(float64); ok {
+			fish := 1
+			if hasCount {
+				fish = int(n)
+			}
+			bins[key][math.Round(length*10)/10] += fish
+		}
+		if hasCount {
+			total := int(n)
+			if c.Count != nil {
+				total += *c.Count
+			}
+			c.Count = &total
+		}
+		if kg, ok := rec["weight_kg"].(float64); ok {
+			if c.WeightKg != nil {
+				kg += *c.WeightKg
+			}
+			c.WeightKg = &kg
+		}
+
+		if hours, ok := rec["effort_hours"].(float64); ok {
+			e, ok := efforts[tripKey]
+			if !ok {
+				e = &EffortRecord{EffortDate: date, Port: port, Region: region, Gear: gear, VesselID: vessel, Trips: 1, Source: source}
+				efforts[tripKey] = e
+				effortKeys = append(effortKeys, tripKey)
+			}
+			if e.HoursFished == nil || hours > *e.HoursFished {
+				e.HoursFished = &hours
+			}
+		}
+	}
+
+	out := make([]*CatchRecord, 0, len(keys))
+	for _, key := range keys {
+		c := catches[key]
+		for length, count := range bins[key] {
+			c.LengthFrequencies = append(c.LengthFrequencies, LengthBin{LengthCm: length, Count: count})
+		}
+		sort.Slice(c.LengthFrequencies, func(i, j int) bool { return c.LengthFrequencies[i].LengthCm < c.LengthFrequencies[j].LengthCm })
+		out = append(out, c)
+	}
+	effort := make([]*EffortRecord, 0, len(effortKeys))
+	for _, key := range effortKeys {
+		effort = append(effort, efforts[key])
+	}
+	return out, effort
+}
+
+// importCatchFile loads a landings sheet validated against the "catch"
+// schema. Like environmental ingestion, any row error rejects the file
+// with its report unless allow_partial is set. Sheets with lengths are
+// checked for juvenile catch spikes afterwards.
+func importCatchFile(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireRole(w, r, contributorRoles...)
+	if !ok {
+		return
+	}
+
+	fields, files, err := readUploadForm(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer func() {
+		for _, f := range files {
+			f.Remove()
+		}
+	}()
+	if len(files) != 1 {
+		http.Error(w, "Upload exactly one file", http.StatusBadRequest)
+		return
+	}
+
+	f, err := os.Open(files[0].Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	rows, err := readTable(f, files[0].Size, files[0].Name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	rep, records, err := validateTable(rows, "catch")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	partial, _ := strconv.ParseBool(fields.Get("allow_partial"))
+	if len(rep.MissingRequired) > 0 || len(records) == 0 || (rep.ErrorCount > 0 && !partial) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(CatchImportResult{Report: rep})
+		return
+	}
+
+	catches, efforts := groupCatchRecords(records, files[0].Name)
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	res := CatchImportResult{Report: rep}
+	for _, c := range catches {
+		if _, err := insertCatch(tx, c, user.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res.CatchRecords++
+		res.LengthBins += len(c.LengthFrequencies)
+		if res.From == "" || c.LandingDate < res.From {
+			res.From = c.LandingDate
+		}
+		if c.LandingDate > res.To {
+			res.To = c.LandingDate
+		}
+	}
+	for _, e := range efforts {
+		if _, err := insertEffort(tx, e, user.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res.EffortRecords++
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if ids := catchSpeciesIDs(catches); len(ids) > 0 {
+		if _, err := detectJuvenileCatch(ids); err != nil {
+			log.Println("Juvenile catch detection failed:", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(res)
+}
+
+// listCatchRecords returns catch records newest first with their length
+// bins, filtered by ?species_id=, region, port, gear, vessel_id, from and
+// to.
+func listCatchRecords(w http.ResponseWriter, r *http.Request) {
+	f, err := newFisheryFilter(r.URL.Query(), "c", "landing_date", true, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, pageSize := pagination(r)
+	limit, offset := f.arg(pageSize), f.arg((page-1)*pageSize)
+	rows, err := db.Query("SELECT "+catchColumns+" FROM "+catchFrom+" WHERE "+f.sql()+
+		" ORDER BY c.landing_date DESC, c.id DESC LIMIT "+limit+" OFFSET "+offset, f.args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	records := []*CatchRecord{}
+	for rows.Next() {
+		c, err := scanCatchRecord(rows)
+		if err != nil {
+			continue
+		}
+		records = append(records, c)
+	}
+	if err := loadLengthFrequencies(records); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(records)
+}
+
+// --- Effort ---
+
+const effortColumns = `e.id, e.effort_date::text, COALESCE(e.port, ''), COALESCE(e.region, ''), COALESCE(e.gear, ''),
+	COALESCE(e.vessel_id, ''), COALESCE(e.trip_id, ''), e.trips, e.days_at_sea, e.hours_fished, e.source, e.created_at::text`
+
+func scanEffortRecord(row interface{ Scan(...any) error }) (*EffortRecord, error) {
+	var e EffortRecord
+	var days, hours sql.NullFloat64
+	err := row.Scan(&e.ID, &e.EffortDate, &e.Port, &e.Region, &e.Gear, &e.VesselID, &e.TripID, &e.Trips, &days, &hours,
+		&e.Source, &e.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	e.DaysAtSea, e.HoursFished = nullableFloat(days), nullableFloat(hours)
+	return &e, nil
+}
+
+func createEffortRecord(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireRole(w, r, contributorRoles...)
+	if !ok {
+		return
+	}
+	e := EffortRecord{Trips: 1}
+	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if _, err := time.Parse("2006-01-02", e.EffortDate); err != nil {
+		http.Error(w, "effort_date must be a YYYY-MM-DD date", http.StatusBadRequest)
+		return
+	}
+	if e.Trips < 0 || e.DaysAtSea != nil && *e.DaysAtSea < 0 || e.HoursFished != nil && *e.HoursFished < 0 {
+		http.Error(w, "trips, days_at_sea and hours_fished must not be negative", http.StatusBadRequest)
+		return
+	}
+	e.Source = "api"
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	id, err := insertEffort(tx, &e, user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	created, err := scanEffortRecord(db.QueryRow("SELECT "+effortColumns+" FROM fishing_effort e WHERE e.id = $1", id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+func listEffortRecords(w http.ResponseWriter, r *http.Request) {
+	f, err := newFisheryFilter(r.URL.Query(), "e", "effort_date", false, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, pageSize := pagination(r)
+	limit, offset := f.arg(pageSize), f.arg((page-1)*pageSize)
+	rows, err := db.Query("SELECT "+effortColumns+" FROM fishing_effort e WHERE "+f.sql()+
+		" ORDER BY e.effort_date DESC, e.id DESC LIMIT "+limit+" OFFSET "+offset, f.args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	records := []*EffortRecord{}
+	for rows.Next() {
+		e, err := scanEffortRecord(rows)
+		if err != nil {
+			continue
+		}
+		records = append(records, e)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(records)
+}
+
+// --- CPUE ---
+
+type CPUERow struct {
+	SpeciesID      *int     `json:"species_id,omitempty"`
+	ScientificName string   `json:"scientific_name,omitempty"`
+	Region         *string  `json:"region,omitempty"`
+	Gear           *string  `json:"gear,omitempty"`
+	Month          *string  `json:"month,omitempty"`
+	CatchKg        float64  `json:"catch_kg"`
+	CatchCount     int64    `json:"catch_count"`
+	Records        int      `json:"records"`
+	Effort         *float64 `json:"effort"`
+	CPUEKg         *float64 `json:"cpue_kg"`
+	CPUECount      *float64 `json:"cpue_count"`
+}
+
+// cpueDimensions are the ?group_by= options with their expressions on
+// catch_records (c) and fishing_effort (e).
+var cpueDimensions = []struct {
+	name, catchExpr, effortExpr string
+}{
+	{"species", "c.species_id", ""},
+	{"region", "COALESCE(c.region, '')", "COALESCE(e.region, '')"},
+	{"gear", "COALESCE(c.gear, '')", "COALESCE(e.gear, '')"},
+	{"month", "to_char(c.landing_date, 'YYYY-MM')", "to_char(e.effort_date, 'YYYY-MM')"},
+}
+
+type CPUEQuery struct {
+	GroupBy    []string
+	EffortUnit string
+}
+
+// queryCPUE sums catch and effort per stratum and divides them. Strata
+// without recorded effort have a null CPUE.
+func queryCPUE(q map[string][]string, opts CPUEQuery) ([]CPUERow, error) {
+	effortCol, ok := effortUnits[opts.EffortUnit]
+	if !ok {
+		return nil, fmt.Errorf("effort_unit must be hours, days or trips")
+	}
+	groups := map[string]bool{}
+	for _, g := range opts.GroupBy {
+		groups[g] = true
+	}
+
+	var catchSel, effortSel, join, outer []string
+	for _, d := range cpueDimensions {
+		if !groups[d.name] {
+			continue
+		}
+		delete(groups, d.name)
+		catchSel = append(catchSel, d.catchExpr+" AS "+d.name)
+		outer = append(outer, "ca."+d.name)
+		if d.effortExpr != "" {
+			effortSel = append(effortSel, d.effortExpr+" AS "+d.name)
+			join = append(join, "ef."+d.name+" = ca."+d.name)
+		}
+	}
+	for g := range groups {
+		return nil, fmt.Errorf("unknown group_by %q: use species, region, gear or month", g)
+	}
+	if len(join) == 0 {
+		join = []string{"true"}
+	}
+
+	cf, err := newFisheryFilter(q, "c", "landing_date", true, 0)
+	if err != nil {
+		return nil, err
+	}
+	ef, err := newFisheryFilter(q, "e", "effort_date", false, len(cf.args))
+	if err != nil {
+		return nil, err
+	}
+	args := append(cf.args, ef.args...)
+
+	groupSQL := func(sel []string) (string, string) {
+		if len(sel) == 0 {
+			return "", ""
+		}
+		cols := strings.Join(sel, ", ") + ", "
+		var n []string
+		for i := range sel {
+			n = append(n, strconv.Itoa(i+1))
+		}
+		return cols, " GROUP BY " + strings.Join(n, ", ")
+	}
+	catchCols, catchGroup := groupSQL(catchSel)
+	effortCols, effortGroup := groupSQL(effortSel)
+	outerCols := ""
+	if len(outer) > 0 {
+		outerCols = strings.Join(outer, ", ") + ", "
+	}
+	orderSQL := ""
+	if len(outer) > 0 {
+		orderSQL = " ORDER BY " + strings.Join(outer, ", ")
+	}
+
+	query := `WITH ca AS (
+			SELECT ` + catchCols + `COALESCE(SUM(c.weight_kg), 0) AS kg, COALESCE(SUM(c.count), 0) AS n, COUNT(*) AS records
+			FROM catch_records c WHERE ` + cf.sql() + catchGroup + `
+		), ef AS (
+			SELECT ` + effortCols + `SUM(e.` + effortCol + `)::double precision AS effort
+			FROM fishing_effort e WHERE ` + ef.sql() + effortGroup + `
+		)
+		SELECT ` + outerCols + `ca.kg, ca.n, ca.records, ef.effort
+		FROM ca LEFT JOIN ef ON ` + strings.Join(join, " AND ") + `
+		WHERE ca.records > 0` + orderSQL
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []CPUERow{}
+	speciesIDs := map[int]bool{}
+	for rows.Next() {
+		var row CPUERow
+		var speciesID sql.NullInt64
+		var region, gear, month string
+		var effort sql.NullFloat64
+		var dest []interface{}
+		for _, d := range cpueDimensions {
+			if !containsString(opts.GroupBy, d.name) {
+				continue
+			}
+			switch d.name {
+			case "species":
+				dest = append(dest, &speciesID)
+			case "region":
+				dest = append(dest, &region)
+			case "gear":
+				dest = append(dest, &gear)
+			case "month":
+				dest = append(dest, &month)
+			}
+		}
+		dest = append(dest, &row.CatchKg, &row.CatchCount, &row.Records, &effort)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		if containsString(opts.GroupBy, "species") {
+			id := int(speciesID.Int64)
+			row.SpeciesID = &id
+			speciesIDs[id] = true
+		}
+		if containsString(opts.GroupBy, "region") {
+			row.Region = &region
+		}
+		if containsString(opts.GroupBy, "gear") {
+			row.Gear = &gear
+		}
+		if containsString(opts.GroupBy, "month") {
+			row.Month = &month
+		}
+		if effort.Valid && effort.Float64 > 0 {
+			row.Effort = &effort.Float64
+			kg, n := row.CatchKg/effort.Float64, float64(row.CatchCount)/effort.Float64
+			row.CPUEKg, row.CPUECount = &kg, &n
+		} else if effort.Valid {
+			row.Effort = &effort.Float64
+		}
+		out = append(out, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(speciesIDs) > 0 {
+		names, err := speciesNames(speciesIDs)
+		if err != nil {
+			return nil, err
+		}
+		for i := range out {
+			out[i].ScientificName = names[*out[i].SpeciesID]
+		}
+	}
+	return out, nil
+}
+
+func speciesNames(ids map[int]bool) (map[int]string, error) {
+	list := make([]int64, 0, len(ids))
+	for id := range ids {
+		list = append(list, int64(id))
+	}
+	rows, err := db.Query("SELECT id, scientific_name FROM species_data WHERE id = ANY($1)", pq.Array(list))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names := map[int]string{}
+	for rows.Next() {
+		var id int
+		var name sql.NullString
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		names[id] = name.String
+	}
+	return names, rows.Err()
+}
+
+// getCPUE aggregates catch per unit effort by ?group_by= (a comma list of
+// species, region, gear and month; default species,month) with
+// ?effort_unit= hours (default), days or trips, and the usual fishery
+// filters.
+func getCPUE(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	opts := CPUEQuery{GroupBy: []string{"species", "month"}, EffortUnit: "hours"}
+	if v := q.Get("group_by"); v != "" {
+		opts.GroupBy = splitList([]string{v}, ",")
+	}
+	if v := q.Get("effort_unit"); v != "" {
+		opts.EffortUnit = v
+	}
+
+	rows, err := queryCPUE(q, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"group_by":    opts.GroupBy,
+		"effort_unit": opts.EffortUnit,
+		"rows":        rows,
+	})
+}
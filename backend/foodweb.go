@@ -0,0 +1,791 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// --- Food Web ---
+//
+// Predator-prey links parsed from species_data.diet_composition (or
+// diet, when no composition is recorded). Each prey item resolves to a
+// species by scientific or vernacular name, or else to a functional
+// group such as zooplankton or cephalopods, so links from different
+// species meet at shared nodes. Parsed links are rebuilt on demand;
+// links entered by hand (source "manual") survive a rebuild.
+//
+// A regional web holds the species reported in or observed in the
+// region, the prey groups they eat and the links between them. Trophic
+// levels are the recorded trophic_level where there is one, and
+// otherwise 1 + the diet-weighted mean trophic level of the prey.
+
+const foodWebSchema = `
+CREATE TABLE IF NOT EXISTS food_web_links (
+	id              SERIAL PRIMARY KEY,
+	predator_id     INTEGER NOT NULL REFERENCES species_data(id) ON DELETE CASCADE,
+	prey_species_id INTEGER,
+	prey_group      TEXT,
+	prey_label      TEXT NOT NULL,
+	proportion      DOUBLE PRECISION NOT NULL,
+	source          TEXT NOT NULL,
+	submitted_by    TEXT,
+	created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+	CHECK (prey_species_id IS NOT NULL OR prey_group IS NOT NULL),
+	UNIQUE (predator_id, prey_label)
+);
+CREATE INDEX IF NOT EXISTS food_web_links_prey_idx ON food_web_links (prey_species_id);
+
+DO $$ BEGIN
+	DELETE FROM food_web_links l WHERE NOT EXISTS (SELECT 1 FROM species_data s WHERE s.id = l.predator_id);
+	ALTER TABLE food_web_links ADD CONSTRAINT food_web_links_predator_id_fkey
+		FOREIGN KEY (predator_id) REFERENCES species_data(id) ON DELETE CASCADE;
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;`
+
+const (
+	linkSourceComposition = "diet_composition"
+	linkSourceDiet        = "diet"
+	linkSourceManual      = "manual"
+)
+
+// preyGroups map keywords in a prey item to a functional group and its
+// typical trophic level. Earlier entries win, so "fish larvae" is matched
+// before "fish".
+var preyGroups = []struct {
+	group        string
+	trophicLevel float64
+	keywords     []string
+}{
+	{"detritus", 1.0, []string{"detritus", "organic matter", "sediment"}},
+	{"primary producers", 1.0, []string{"phytoplankton", "diatom", "algae", "seaweed", "seagrass", "macrophyte", "plant"}},
+	{"gelatinous zooplankton", 3.0, []string{"jellyfish", "medusa", "salp", "ctenophore", "siphonophore"}},
+	{"fish larvae", 3.0, []string{"fish larvae", "fish eggs", "ichthyoplankton", "larval fish"}},
+	{"zooplankton", 2.1, []string{"zooplankton", "copepod", "krill", "euphausiid", "mysid", "plankton"}},
+	{"cephalopods", 3.6, []string{"squid", "cephalopod", "octopus", "cuttlefish"}},
+	{"crustaceans", 2.5, []string{"crustacean", "prawn", "shrimp", "crab", "lobster", "amphipod", "isopod", "stomatopod"}},
+	{"molluscs", 2.1, []string{"mollusc", "mollusk", "bivalve", "gastropod", "clam", "mussel", "oyster", "snail"}},
+	{"benthic invertebrates", 2.2, []string{"polychaete", "worm", "echinoderm", "sea urchin", "brittle star", "benthic", "invertebrate", "sponge", "coral"}},
+	{"small pelagic fish", 2.8, []string{"small fish", "small pelagic", "sardine", "anchov", "herring", "baitfish", "forage fish"}},
+	{"fishes", 3.5, []string{"fish", "teleost"}},
+	{"seabirds", 4.0, []string{"seabird", "bird"}},
+	{"marine mammals", 4.3, []string{"mammal", "seal", "dolphin", "whale"}},
+	{"sea turtles", 3.0, []string{"turtle"}},
+}
+
+// DietItem is one parsed prey item with its share of the diet.
+type DietItem struct {
+	Label      string  `json:"label"`
+	Proportion float64 `json:"proportion"`
+}
+
+var (
+	dietSplit   = regexp.MustCompile(`[,;/+\n]|\band\b|\s&\s`)
+	dietNumber  = regexp.MustCompile(`(\d+(?:\.\d+)?)(?:\s*[-–]\s*(\d+(?:\.\d+)?))?\s*(%)?`)
+	dietCleanup = regexp.MustCompile(`[()\[\]:=~≈\-–]+|\b(mainly|mostly|primarily|occasionally|also|other|various|some|including|e\.g\.)\b`)
+)
+
+// parseDiet reads a diet composition. A JSON object maps prey to shares;
+// otherwise items are separated by commas, semicolons, slashes or "and",
+// each with an optional percentage, percentage range or fraction. Unquantified items share
+// whatever the quantified ones leave, equally. Shares are normalised to
+// sum to 1.
+func parseDiet(text string) []DietItem {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+
+	var items []DietItem
+	var known []bool
+	var obj map[string]float64
+	if strings.HasPrefix(text, "{") && json.Unmarshal([]byte(text), &obj) == nil {
+		for label, v := range obj {
+			if l := strings.ToLower(strings.TrimSpace(label)); l != "" && v > 0 {
+				items = append(items, DietItem{Label: l, Proportion: v})
+				known = append(known, true)
+			}
+		}
+		sort.Slice(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+	} else {
+		seen := map[string]bool{}
+		for _, part := range dietSplit.Split(text, -1) {
+			share, quantified := 0.0, false
+			if m := dietNumber.FindStringSubmatch(part); m != nil {
+				v, _ := strconv.ParseFloat(m[1], 64)
+				if m[2] != "" {
+					hi, _ := strconv.ParseFloat(m[2], 64)
+					v = (v + hi) / 2
+				}
+				if m[3] == "%" || v > 1 {
+					v /= 100
+				}
+				share, quantified = v, true
+				part = strings.Replace(part, m[0], " ", 1)
+			}
+			label := strings.Join(strings.Fields(dietCleanup.ReplaceAllString(strings.ToLower(part), " ")), " ")
+			label = strings.Trim(label, ". ")
+			if label == "" || seen[label] {
+				continue
+			}
+			seen[label] = true
+			items = append(items, DietItem{Label: label, Proportion: share})
+			known = append(known, quantified)
+		}
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	var quantified float64
+	unknown := 0
+	for i, it := range items {
+		if known[i] {
+			quantified += it.Proportion
+		} else {
+			unknown++
+		}
+	}
+	if unknown > 0 {
+		rest := 1 - quantified
+		if quantified == 0 || rest <= 0 {
+			// Nothing left to share: treat unquantified items as minor.
+			rest = math.Max(rest, 0.05*float64(unknown))
+		}
+		for i := range items {
+			if !known[i] {
+				items[i].Proportion = rest / float64(unknown)
+			}
+		}
+	}
+	var total float64
+	for _, it := range items {
+		total += it.Proportion
+	}
+	for i := range items {
+		items[i].Proportion /= total
+	}
+	return items
+}
+
+// preyGroup returns the functional group for a prey label, or the label
+// itself when no keyword matches.
+func preyGroup(label string) (string, *float64) {
+	for _, g := range preyGroups {
+		for _, k := range g.keywords {
+			if strings.Contains(label, k) {
+				tl := g.trophicLevel
+				return g.group, &tl
+			}
+		}
+	}
+	return label, nil
+}
+
+func groupTrophicLevel(group string) *float64 {
+	for _, g := range preyGroups {
+		if g.group == group {
+			tl := g.trophicLevel
+			return &tl
+		}
+	}
+	return nil
+}
+
+// speciesNameIndex maps lower-case scientific and vernacular names, and
+// vernacular plurals, to species ids.
+func speciesNameIndex() (map[string]int, error) {
+	rows, err := db.Query("SELECT id, COALESCE(lower(scientific_name), ''), COALESCE(lower(vernacularname), '') FROM species_data ORDER BY id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	index := map[string]int{}
+	for rows.Next() {
+		var id int
+		var sci, vern string
+		if err := rows.Scan(&id, &sci, &vern); err != nil {
+			return nil, err
+		}
+		// Descending ids leave the lowest id for a shared name.
+		for _, n := range []string{sci, vern, vern + "s", vern + "es"} {
+			if strings.TrimSpace(n) != "" && n != "s" && n != "es" {
+				index[n] = id
+			}
+		}
+	}
+	return index, rows.Err()
+}
+
+type FoodWebRebuild struct {
+	Predators     int `json:"predators"`
+	Links         int `json:"links"`
+	SpeciesLinks  int `json:"species_links"`
+	GroupLinks    int `json:"group_links"`
+	UnmatchedPrey int `json:"unmatched_prey"`
+}
+
+// rebuildFoodWeb re-parses every species' diet, replacing parsed links.
+func rebuildFoodWeb() (*FoodWebRebuild, error) {
+	names, err := speciesNameIndex()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT id, COALESCE(diet_composition, ''), COALESCE(diet, '') FROM species_data
+		WHERE COALESCE(diet_composition, '') <> '' OR COALESCE(diet, '') <> ''`)
+	if err != nil {
+		return nil, err
+	}
+	type diet struct {
+		id                int
+		composition, text string
+	}
+	var diets []diet
+	for rows.Next() {
+		var d diet
+		if err := rows.Scan(&d.id, &d.composition, &d.text); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		diets = append(diets, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM food_web_links WHERE source <> $1", linkSourceManual); err != nil {
+		return nil, err
+	}
+
+	res := &FoodWebRebuild{}
+	for _, d := range diets {
+		source, items := linkSourceComposition, parseDiet(d.composition)
+		if len(items) == 0 {
+			source, items = linkSourceDiet, parseDiet(d.text)
+		}
+		if len(items) == 0 {
+			continue
+		}
+		res.Predators++
+		for _, it := range items {
+			var preyID *int
+			var group *string
+			var tl *float64
+			if id, ok := names[it.Label]; ok && id != d.id {
+				preyID = &id
+			} else {
+				g, t := preyGroup(it.Label)
+				group, tl = &g, t
+			}
+			// A manual link for the same prey, or a repeated prey item,
+			// inserts nothing and is not counted.
+			inserted, err := tx.Exec(`INSERT INTO food_web_links (predator_id, prey_species_id, prey_group, prey_label, proportion, source)
+				VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (predator_id, prey_label) DO NOTHING`,
+				d.id, preyID, group, it.Label, it.Proportion, source)
+			if err != nil {
+				return nil, err
+			}
+			if n, err := inserted.RowsAffected(); err != nil {
+				return nil, err
+			} else if n == 0 {
+				continue
+			}
+			res.Links++
+			if preyID != nil {
+				res.SpeciesLinks++
+			} else {
+				res.GroupLinks++
+				if tl == nil {
+					res.UnmatchedPrey++
+				}
+			}
+		}
+	}
+	return res, tx.Commit()
+}
+
+// seedFoodWeb builds the web at startup when no parsed links exist yet.
+func seedFoodWeb() {
+	var exists bool
+	if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM food_web_links WHERE source <> $1)", linkSourceManual).Scan(&exists); err != nil || exists {
+		return
+	}
+	if res, err := rebuildFoodWeb(); err != nil {
+		log.Println("Could not build food web:", err)
+	} else if res.Links > 0 {
+		log.Printf("Built food web: %d links for %d predators", res.Links, res.Predators)
+	}
+}
+
+type FoodWebNode struct {
+	ID        string `json:"id"`
+	Kind      string `json:"kind"`
+	Label     string `json:"label"`
+	SpeciesID *int   `json:"species_id,omitempty"`
+	// TrophicLevel is the recorded level; EffectiveTL falls back to the
+	// diet-derived estimate.
+	TrophicLevel *float64 `json:"trophic_level"`
+	ComputedTL   *float64 `json:"computed_trophic_level"`
+	EffectiveTL  *float64 `json:"effective_trophic_level"`
+	Prey         int      `json:"prey"`
+	Predators    int      `json:"predators"`
+	Role         string   `json:"role"`
+}
+
+type FoodWebEdge struct {
+	ID         int     `json:"id"`
+	Source     string  `json:"source"`
+	Target     string  `json:"target"`
+	Weight     float64 `json:"weight"`
+	PreyLabel  string  `json:"prey_label"`
+	LinkSource string  `json:"link_source"`
+}
+
+type FoodWebMetrics struct {
+	Nodes             int      `json:"nodes"`
+	Links             int      `json:"links"`
+	Connectance       float64  `json:"connectance"`
+	LinkDensity       float64  `json:"link_density"`
+	Basal             float64  `json:"basal_fraction"`
+	Intermediate      float64  `json:"intermediate_fraction"`
+	Top               float64  `json:"top_fraction"`
+	MeanTrophicLevel  *float64 `json:"mean_trophic_level"`
+	MaxTrophicLevel   *float64 `json:"max_trophic_level"`
+	MeanTLCatch       *float64 `json:"mean_trophic_level_of_catch"`
+	CatchKgWithTL     float64  `json:"catch_kg_with_trophic_level"`
+	CatchKgWithoutTL  float64  `json:"catch_kg_without_trophic_level"`
+	MeanTLCatchByYear []YearTL `json:"mean_trophic_level_of_catch_by_year"`
+}
+
+type YearTL struct {
+	Year         int     `json:"year"`
+	TrophicLevel float64 `json:"trophic_level"`
+	CatchKg      float64 `json:"catch_kg"`
+}
+
+type FoodWeb struct {
+	Region  string         `json:"region,omitempty"`
+	Nodes   []*FoodWebNode `json:"nodes"`
+	Edges   []FoodWebEdge  `json:"edges"`
+	Metrics FoodWebMetrics `json:"metrics"`
+}
+
+func speciesNodeID(id int) string { return "species:" + strconv.Itoa(id) }
+
+func groupNodeID(group string) string { return "group:" + group }
+
+// buildFoodWeb assembles the web for a region, or for every species with
+// links when region is empty.
+func buildFoodWeb(region string) (*FoodWeb, error) {
+	query := `SELECT s.id, COALESCE(s.scientific_name, ''), COALESCE(s.vernacularname, ''), s.trophic_level FROM species_data s`
+	var args []interface{}
+	if region != "" {
+		query += ` WHERE $1 ILIKE ANY(s.reported_regions)
+			OR EXISTS (SELECT 1 FROM occurrence_data o WHERE o.species_id = s.id AND o.region ILIKE $1)`
+		args = append(args, region)
+	} else {
+		query += ` WHERE EXISTS (SELECT 1 FROM food_web_links l WHERE l.predator_id = s.id OR l.prey_species_id = s.id)`
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	web := &FoodWeb{Region: region, Nodes: []*FoodWebNode{}, Edges: []FoodWebEdge{}}
+	nodes := map[string]*FoodWebNode{}
+	var ids []int64
+	for rows.Next() {
+		var id int
+		var sci, vern string
+		var tl sql.NullFloat64
+		if err := rows.Scan(&id, &sci, &vern, &tl); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		n := &FoodWebNode{ID: speciesNodeID(id), Kind: "species", Label: sci, SpeciesID: &id}
+		if sci == "" {
+			n.Label = vern
+		}
+		if tl.Valid && tl.Float64 > 0 {
+			v := tl.Float64
+			n.TrophicLevel = &v
+		}
+		nodes[n.ID] = n
+		ids = append(ids, int64(id))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(`SELECT id, predator_id, prey_species_id, COALESCE(prey_group, ''), prey_label, proportion, source
+		FROM food_web_links WHERE predator_id = ANY($1) ORDER BY id`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	// diets hold each predator's links for the trophic level estimate.
+	diets := map[string][]FoodWebEdge{}
+	for rows.Next() {
+		var e FoodWebEdge
+		var predator int
+		var preyID sql.NullInt64
+		var group string
+		if err := rows.Scan(&e.ID, &predator, &preyID, &group, &e.PreyLabel, &e.Weight, &e.LinkSource); err != nil {
+			return nil, err
+		}
+		e.Source = speciesNodeID(predator)
+		if preyID.Valid {
+			e.Target = speciesNodeID(int(preyID.Int64))
+		} else {
+			e.Target = groupNodeID(group)
+		}
+		diets[e.Source] = append(diets[e.Source], e)
+		if nodes[e.Target] == nil {
+			if preyID.Valid {
+				// Prey species not in the region: leave the link out of
+				// the graph, but keep it for the trophic level estimate.
+				continue
+			}
+			nodes[e.Target] = &FoodWebNode{ID: e.Target, Kind: "group", Label: group, TrophicLevel: groupTrophicLevel(group)}
+		}
+		web.Edges = append(web.Edges, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	estimateTrophicLevels(nodes, diets)
+
+	for _, e := range web.Edges {
+		nodes[e.Source].Prey++
+		nodes[e.Target].Predators++
+	}
+	for _, n := range nodes {
+		web.Nodes = append(web.Nodes, n)
+	}
+	sort.Slice(web.Nodes, func(i, j int) bool { return web.Nodes[i].ID < web.Nodes[j].ID })
+	web.Metrics = webMetrics(web)
+	if err := catchTrophicLevel(region, nodes, &web.Metrics); err != nil {
+		return nil, err
+	}
+	return web, nil
+}
+
+// estimateTrophicLevels iterates TL = 1 + Σ share·TL(prey) to a fixed
+// point. Prey with neither a recorded level nor a diet to compute one from
+// are unknown and left out; shares are renormalised over the prey that
+// have a level.
+func estimateTrophicLevels(nodes map[string]*FoodWebNode, diets map[string][]FoodWebEdge) {
+	level := func(id string) (float64, bool) {
+		n := nodes[id]
+		if n == nil {
+			return 0, false
+		}
+		if n.TrophicLevel != nil {
+			return *n.TrophicLevel, true
+		}
+		if n.ComputedTL != nil {
+			return *n.ComputedTL, true
+		}
+		return 0, false
+	}
+	for iter := 0; iter < 50; iter++ {
+		changed := false
+		for id, links := range diets {
+			n := nodes[id]
+			if n == nil {
+				continue
+			}
+			var sum, weight float64
+			for _, e := range links {
+				if tl, ok := level(e.Target); ok {
+					sum += e.Weight * tl
+					weight += e.Weight
+				}
+			}
+			if weight == 0 {
+				continue
+			}
+			tl := 1 + sum/weight
+			if n.ComputedTL == nil || math.Abs(*n.ComputedTL-tl) > 1e-6 {
+				n.ComputedTL = &tl
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+	for _, n := range nodes {
+		switch {
+		case n.TrophicLevel != nil:
+			n.EffectiveTL = n.TrophicLevel
+		case n.ComputedTL != nil:
+			n.EffectiveTL = n.ComputedTL
+		}
+	}
+}
+
+// webMetrics counts links among the graph's nodes. Connectance is
+// L/S²; basal nodes have no prey, top nodes no predators.
+func webMetrics(web *FoodWeb) FoodWebMetrics {
+	m := FoodWebMetrics{Nodes: len(web.Nodes), Links: len(web.Edges), MeanTLCatchByYear: []YearTL{}}
+	if m.Nodes == 0 {
+		return m
+	}
+	s := float64(m.Nodes)
+	m.Connectance = float64(m.Links) / (s * s)
+	m.LinkDensity = float64(m.Links) / s
+	var tlSum, tlMax float64
+	tlN := 0
+	for _, n := range web.Nodes {
+		switch {
+		case n.Prey == 0:
+			n.Role = "basal"
+			m.Basal++
+		case n.Predators == 0:
+			n.Role = "top"
+			m.Top++
+		default:
+			n.Role = "intermediate"
+			m.Intermediate++
+		}
+		if n.EffectiveTL != nil {
+			tlSum += *n.EffectiveTL
+			tlMax = math.Max(tlMax, *n.EffectiveTL)
+			tlN++
+		}
+	}
+	m.Basal, m.Intermediate, m.Top = m.Basal/s, m.Intermediate/s, m.Top/s
+	if tlN > 0 {
+		mean := tlSum / float64(tlN)
+		m.MeanTrophicLevel, m.MaxTrophicLevel = &mean, &tlMax
+	}
+	return m
+}
+
+// catchTrophicLevel is the catch-weighted mean trophic level of landings
+// in the region, overall and by year, from species with a known level.
+func catchTrophicLevel(region string, nodes map[string]*FoodWebNode, m *FoodWebMetrics) error {
+	query := `SELECT species_id, EXTRACT(YEAR FROM landing_date)::int, SUM(weight_kg) FROM catch_records WHERE weight_kg > 0`
+	var args []interface{}
+	if region != "" {
+		query += " AND region ILIKE $1"
+		args = append(args, region)
+	}
+	rows, err := db.Query(query+" GROUP BY 1, 2", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	years := map[int]*YearTL{}
+	var weighted float64
+	for rows.Next() {
+		var speciesID, year int
+		var kg float64
+		if err := rows.Scan(&speciesID, &year, &kg); err != nil {
+			return err
+		}
+		n := nodes[speciesNodeID(speciesID)]
+		if n == nil || n.EffectiveTL == nil {
+			m.CatchKgWithoutTL += kg
+			continue
+		}
+		m.CatchKgWithTL += kg
+		weighted += kg * *n.EffectiveTL
+		if years[year] == nil {
+			years[year] = &YearTL{Year: year}
+		}
+		years[year].TrophicLevel += kg * *n.EffectiveTL
+		years[year].CatchKg += kg
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if m.CatchKgWithTL > 0 {
+		v := weighted / m.CatchKgWithTL
+		m.MeanTLCatch = &v
+	}
+	for _, y := range years {
+		y.TrophicLevel /= y.CatchKg
+		m.MeanTLCatchByYear = append(m.MeanTLCatchByYear, *y)
+	}
+	sort.Slice(m.MeanTLCatchByYear, func(i, j int) bool { return m.MeanTLCatchByYear[i].Year < m.MeanTLCatchByYear[j].Year })
+	return nil
+}
+
+// getFoodWeb returns the graph for ?region= with its metrics.
+func getFoodWeb(w http.ResponseWriter, r *http.Request) {
+	web, err := buildFoodWeb(strings.TrimSpace(r.URL.Query().Get("region")))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(web)
+}
+
+func getFoodWebMetrics(w http.ResponseWriter, r *http.Request) {
+	region := strings.TrimSpace(r.URL.Query().Get("region"))
+	web, err := buildFoodWeb(region)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"region": region, "metrics": web.Metrics})
+}
+
+// getSpeciesFoodWeb lists a species' prey and predators across regions.
+func getSpeciesFoodWeb(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid species id", http.StatusBadRequest)
+		return
+	}
+	type link struct {
+		ID         int     `json:"id"`
+		SpeciesID  *int    `json:"species_id,omitempty"`
+		Name       string  `json:"name"`
+		Group      string  `json:"group,omitempty"`
+		Proportion float64 `json:"proportion"`
+		Source     string  `json:"source"`
+	}
+	resp := map[string]interface{}{"species_id": id}
+	for _, q := range []struct {
+		key, query string
+	}{
+		{"prey", `SELECT l.id, l.prey_species_id, COALESCE(s.scientific_name, l.prey_label), COALESCE(l.prey_group, ''), l.proportion, l.source
+			FROM food_web_links l LEFT JOIN species_data s ON s.id = l.prey_species_id WHERE l.predator_id = $1 ORDER BY l.proportion DESC`},
+		{"predators", `SELECT l.id, l.predator_id, COALESCE(s.scientific_name, ''), '', l.proportion, l.source
+			FROM food_web_links l JOIN species_data s ON s.id = l.predator_id WHERE l.prey_species_id = $1 ORDER BY l.proportion DESC`},
+	} {
+		rows, err := db.Query(q.query, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		links := []link{}
+		for rows.Next() {
+			var l link
+			var sp sql.NullInt64
+			if err := rows.Scan(&l.ID, &sp, &l.Name, &l.Group, &l.Proportion, &l.Source); err != nil {
+				rows.Close()
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if sp.Valid {
+				v := int(sp.Int64)
+				l.SpeciesID = &v
+			}
+			links = append(links, l)
+		}
+		rows.Close()
+		resp[q.key] = links
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func rebuildFoodWebHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireRole(w, r, moderatorRoles...); !ok {
+		return
+	}
+	start := time.Now()
+	res, err := rebuildFoodWeb()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"result": res, "duration_ms": time.Since(start).Milliseconds()})
+}
+
+// createFoodWebLink adds a curated link: prey_species_id or prey_group,
+// with the share of the predator's diet.
+func createFoodWebLink(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireRole(w, r, contributorRoles...)
+	if !ok {
+		return
+	}
+	var req struct {
+		PredatorID    int     `json:"predator_id"`
+		PreySpeciesID *int    `json:"prey_species_id"`
+		PreyGroup     string  `json:"prey_group"`
+		Proportion    float64 `json:"proportion"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	req.PreyGroup = strings.ToLower(strings.TrimSpace(req.PreyGroup))
+	if (req.PreySpeciesID == nil) == (req.PreyGroup == "") {
+		http.Error(w, "Give exactly one of prey_species_id and prey_group", http.StatusBadRequest)
+		return
+	}
+	if req.Proportion <= 0 || req.Proportion > 1 {
+		http.Error(w, "proportion must be in (0, 1]", http.StatusBadRequest)
+		return
+	}
+	for _, id := range []*int{&req.PredatorID, req.PreySpeciesID} {
+		if id == nil {
+			continue
+		}
+		if ok, err := speciesExists(*id); err != nil || !ok {
+			http.Error(w, fmt.Sprintf("Species %d not found", *id), http.StatusBadRequest)
+			return
+		}
+	}
+	label := req.PreyGroup
+	var group *string
+	if req.PreySpeciesID != nil {
+		label = "species:" + strconv.Itoa(*req.PreySpeciesID)
+	} else {
+		group = &req.PreyGroup
+	}
+
+	var id int
+	err := db.QueryRow(`INSERT INTO food_web_links (predator_id, prey_species_id, prey_group, prey_label, proportion, source, submitted_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (predator_id, prey_label) DO UPDATE SET proportion = EXCLUDED.proportion, source = EXCLUDED.source, submitted_by = EXCLUDED.submitted_by
+		RETURNING id`, req.PredatorID, req.PreySpeciesID, group, label, req.Proportion, linkSourceManual, user.ID).Scan(&id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "prey_label": label})
+}
+
+func deleteFoodWebLink(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireRole(w, r, moderatorRoles...); !ok {
+		return
+	}
+	res, err := db.Exec("DELETE FROM food_web_links WHERE id = $1", r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Link not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,517 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// --- Polygon Geometry ---
+//
+// Polygons in WGS84 longitude/latitude, read from GeoJSON or ESRI
+// Shapefiles, with point-in-polygon tests, distances and areas on the
+// sphere. There is no PostGIS, so overlays run here.
+
+// MultiPolygon holds polygons as GeoJSON orders them: each polygon's first
+// ring is its outer boundary and the rest are holes.
+type MultiPolygon [][][][2]float64
+
+type BBox struct {
+	MinLon, MinLat, MaxLon, MaxLat float64
+}
+
+func (b BBox) contains(lon, lat float64) bool {
+	return lon >= b.MinLon && lon <= b.MaxLon && lat >= b.MinLat && lat <= b.MaxLat
+}
+
+func (m MultiPolygon) bbox() BBox {
+	b := BBox{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	for _, poly := range m {
+		for _, p := range poly[0] {
+			b.MinLon, b.MaxLon = math.Min(b.MinLon, p[0]), math.Max(b.MaxLon, p[0])
+			b.MinLat, b.MaxLat = math.Min(b.MinLat, p[1]), math.Max(b.MaxLat, p[1])
+		}
+	}
+	return b
+}
+
+// inRing is the even-odd ray casting test.
+func inRing(ring [][2]float64, lon, lat float64) bool {
+	in := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a[1] > lat) != (b[1] > lat) && lon < (b[0]-a[0])*(lat-a[1])/(b[1]-a[1])+a[0] {
+			in = !in
+		}
+	}
+	return in
+}
+
+func (m MultiPolygon) contains(lon, lat float64) bool {
+	for _, poly := range m {
+		if !inRing(poly[0], lon, lat) {
+			continue
+		}
+		inHole := false
+		for _, hole := range poly[1:] {
+			if inRing(hole, lon, lat) {
+				inHole = true
+				break
+			}
+		}
+		if !inHole {
+			return true
+		}
+	}
+	return false
+}
+
+// distanceKm is the distance from a point to the polygon boundary, zero
+// inside. Segments are measured in a local equirectangular projection,
+// which is close enough at the tens of kilometres that matter here.
+func (m MultiPolygon) distanceKm(lon, lat float64) float64 {
+	if m.contains(lon, lat) {
+		return 0
+	}
+	const kmPerDegree = math.Pi * earthRadiusKm / 180
+	kx := math.Cos(lat*math.Pi/180) * kmPerDegree
+	best := math.Inf(1)
+	for _, poly := range m {
+		for _, ring := range poly {
+			for i := 1; i < len(ring); i++ {
+				ax, ay := (ring[i-1][0]-lon)*kx, (ring[i-1][1]-lat)*kmPerDegree
+				bx, by := (ring[i][0]-lon)*kx, (ring[i][1]-lat)*kmPerDegree
+				dx, dy := bx-ax, by-ay
+				t := 0.0
+				if l := dx*dx + dy*dy; l > 0 {
+					t = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/l))
+				}
+				best = math.Min(best, math.Hypot(ax+t*dx, ay+t*dy))
+			}
+		}
+	}
+	return best
+}
+
+const earthRadiusKm = 6371.0088
+
+// ringAreaKm2 is the spherical area of a ring, positive when it runs
+// clockwise.
+func ringAreaKm2(ring [][2]float64) float64 {
+	var sum float64
+	for i := 1; i < len(ring); i++ {
+		l1, l2 := ring[i-1][0]*math.Pi/180, ring[i][0]*math.Pi/180
+		p1, p2 := ring[i-1][1]*math.Pi/180, ring[i][1]*math.Pi/180
+		sum += (l2 - l1) * (2 + math.Sin(p1) + math.Sin(p2))
+	}
+	return sum * earthRadiusKm * earthRadiusKm / 2
+}
+
+func (m MultiPolygon) areaKm2() float64 {
+	var a float64
+	for _, poly := range m {
+		a += math.Abs(ringAreaKm2(poly[0]))
+		for _, hole := range poly[1:] {
+			a -= math.Abs(ringAreaKm2(hole))
+		}
+	}
+	return math.Max(a, 0)
+}
+
+// closeRing validates a ring and closes it if its ends differ.
+func closeRing(ring [][2]float64) ([][2]float64, error) {
+	for _, p := range ring {
+		if math.IsNaN(p[0]) || math.IsNaN(p[1]) || math.Abs(p[0]) > 180 || math.Abs(p[1]) > 90 {
+			return nil, fmt.Errorf("coordinate %v is not WGS84 longitude/latitude", p)
+		}
+	}
+	if len(ring) > 0 && ring[0] != ring[len(ring)-1] {
+		ring = append(ring, ring[0])
+	}
+	if len(ring) < 4 {
+		return nil, fmt.Errorf("ring has fewer than three points")
+	}
+	return ring, nil
+}
+
+// GeoFeature is a polygon feature with its attributes as strings.
+type GeoFeature struct {
+	Properties map[string]string
+	Geometry   MultiPolygon
+}
+
+// parseGeoJSON reads the polygon features of a FeatureCollection, a single
+// Feature or a bare geometry. Features that are not polygons are counted
+// in skipped.
+func parseGeoJSON(data []byte) (features []GeoFeature, skipped int, err error) {
+	var doc struct {
+		Type       string            `json:"type"`
+		Features   []json.RawMessage `json:"features"`
+		Geometry   json.RawMessage   `json:"geometry"`
+		Properties map[string]any    `json:"properties"`
+		CRS        *struct {
+			Properties struct {
+				Name string `json:"name"`
+			} `json:"properties"`
+		} `json:"crs"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, 0, fmt.Errorf("not a GeoJSON document: %w", err)
+	}
+	if doc.CRS != nil && !strings.Contains(doc.CRS.Properties.Name, "4326") && !strings.Contains(doc.CRS.Properties.Name, "CRS84") {
+		return nil, 0, fmt.Errorf("coordinates are in %s; reproject to WGS84 first", doc.CRS.Properties.Name)
+	}
+	switch doc.Type {
+	case "FeatureCollection":
+		for i, raw := range doc.Features {
+			var f struct {
+				Geometry   json.RawMessage `json:"geometry"`
+				Properties map[string]any  `json:"properties"`
+			}
+			if err := json.Unmarshal(raw, &f); err != nil {
+				return nil, 0, fmt.Errorf("feature %d: %w", i+1, err)
+			}
+			g, err := parseGeoJSONGeometry(f.Geometry)
+			if err != nil {
+				return nil, 0, fmt.Errorf("feature %d: %w", i+1, err)
+			}
+			if g == nil {
+				skipped++
+				continue
+			}
+			features = append(features, GeoFeature{stringProperties(f.Properties), g})
+		}
+	case "Feature":
+		g, err := parseGeoJSONGeometry(doc.Geometry)
+		if err != nil {
+			return nil, 0, err
+		}
+		if g == nil {
+			return nil, 1, nil
+		}
+		features = append(features, GeoFeature{stringProperties(doc.Properties), g})
+	default:
+		g, err := parseGeoJSONGeometry(data)
+		if err != nil {
+			return nil, 0, err
+		}
+		if g == nil {
+			return nil, 1, nil
+		}
+		features = append(features, GeoFeature{map[string]string{}, g})
+	}
+	return features, skipped, nil
+}
+
+// parseGeoJSONGeometry returns nil for geometries other than Polygon and
+// MultiPolygon.
+func parseGeoJSONGeometry(raw json.RawMessage) (MultiPolygon, error) {
+	var g struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"`
+	}
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	if err := json.Unmarshal(raw, &g); err != nil {
+		return nil, err
+	}
+	var m MultiPolygon
+	switch g.Type {
+	case "Polygon":
+		var poly [][][]float64
+		if err := json.Unmarshal(g.Coordinates, &poly); err != nil {
+			return nil, fmt.Errorf("bad Polygon coordinates: %w", err)
+		}
+		m = MultiPolygon{nil}
+		for _, ring := range poly {
+			m[0] = append(m[0], toPoints(ring))
+		}
+	case "MultiPolygon":
+		var polys [][][][]float64
+		if err := json.Unmarshal(g.Coordinates, &polys); err != nil {
+			return nil, fmt.Errorf("bad MultiPolygon coordinates: %w", err)
+		}
+		for _, poly := range polys {
+			var rings [][][2]float64
+			for _, ring := range poly {
+				rings = append(rings, toPoints(ring))
+			}
+			m = append(m, rings)
+		}
+	default:
+		return nil, nil
+	}
+	for _, poly := range m {
+		if len(poly) == 0 {
+			return nil, fmt.Errorf("polygon without rings")
+		}
+		for i := range poly {
+			ring, err := closeRing(poly[i])
+			if err != nil {
+				return nil, err
+			}
+			poly[i] = ring
+		}
+	}
+	return m, nil
+}
+
+func toPoints(coords [][]float64) [][2]float64 {
+	pts := make([][2]float64, 0, len(coords))
+	for _, c := range coords {
+		if len(c) < 2 {
+			pts = append(pts, [2]float64{math.NaN(), math.NaN()})
+			continue
+		}
+		pts = append(pts, [2]float64{c[0], c[1]})
+	}
+	return pts
+}
+
+func stringProperties(props map[string]any) map[string]string {
+	out := map[string]string{}
+	for k, v := range props {
+		switch v := v.(type) {
+		case nil:
+		case string:
+			out[k] = v
+		case float64:
+			out[k] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			b, _ := json.Marshal(v)
+			out[k] = string(b)
+		}
+	}
+	return out
+}
+
+// Shapefile shape types with polygon geometry.
+const (
+	shpPolygon  = 5
+	shpPolygonZ = 15
+	shpPolygonM = 25
+)
+
+// parseShapefile reads polygon features from the .shp, with attributes
+// from the .dbf when there is one. A .prj with a projected coordinate
+// system is rejected, since coordinates must be longitude/latitude.
+func parseShapefile(shp, dbf, prj []byte) ([]GeoFeature, int, error) {
+	if bytes.Contains(bytes.ToUpper(prj), []byte("PROJCS")) {
+		return nil, 0, fmt.Errorf("the shapefile uses a projected coordinate system; reproject to WGS84 first")
+	}
+	if len(shp) < 100 || binary.BigEndian.Uint32(shp[0:4]) != 9994 {
+		return nil, 0, fmt.Errorf("not an ESRI shapefile")
+	}
+	switch t := binary.LittleEndian.Uint32(shp[32:36]); t {
+	case shpPolygon, shpPolygonZ, shpPolygonM:
+	default:
+		return nil, 0, fmt.Errorf("shape type %d is not a polygon type", t)
+	}
+	var attrs []map[string]string
+	if dbf != nil {
+		var err error
+		if attrs, err = parseDBF(dbf); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	var features []GeoFeature
+	skipped := 0
+	for off, rec := 100, 0; off+8 <= len(shp); rec++ {
+		length := int(binary.BigEndian.Uint32(shp[off+4:off+8])) * 2
+		body := shp[off+8:]
+		if length > len(body) {
+			return nil, 0, fmt.Errorf("record %d is truncated", rec+1)
+		}
+		body = body[:length]
+		off += 8 + length
+
+		props := map[string]string{}
+		if rec < len(attrs) {
+			props = attrs[rec]
+		}
+		if props == nil {
+			continue // deleted in the .dbf
+		}
+		if len(body) < 4 || binary.LittleEndian.Uint32(body) == 0 {
+			skipped++
+			continue
+		}
+		if len(body) < 44 {
+			return nil, 0, fmt.Errorf("record %d is truncated", rec+1)
+		}
+		numParts := int(binary.LittleEndian.Uint32(body[36:40]))
+		numPoints := int(binary.LittleEndian.Uint32(body[40:44]))
+		pointsAt := 44 + 4*numParts
+		if numParts < 1 || numPoints < 1 || pointsAt+16*numPoints > len(body) {
+			return nil, 0, fmt.Errorf("record %d is malformed", rec+1)
+		}
+		var rings [][][2]float64
+		for p := 0; p < numParts; p++ {
+			start := int(binary.LittleEndian.Uint32(body[44+4*p:]))
+			end := numPoints
+			if p+1 < numParts {
+				end = int(binary.LittleEndian.Uint32(body[48+4*p:]))
+			}
+			if start < 0 || end > numPoints || start >= end {
+				return nil, 0, fmt.Errorf("record %d has bad part offsets", rec+1)
+			}
+			ring := make([][2]float64, 0, end-start)
+			for i := start; i < end; i++ {
+				at := pointsAt + 16*i
+				ring = append(ring, [2]float64{
+					math.Float64frombits(binary.LittleEndian.Uint64(body[at:])),
+					math.Float64frombits(binary.LittleEndian.Uint64(body[at+8:])),
+				})
+			}
+			ring, err := closeRing(ring)
+			if err != nil {
+				return nil, 0, fmt.Errorf("record %d: %w", rec+1, err)
+			}
+			rings = append(rings, ring)
+		}
+		features = append(features, GeoFeature{props, groupRings(rings)})
+	}
+	return features, skipped, nil
+}
+
+// groupRings turns shapefile rings into polygons. Outer rings run
+// clockwise and holes counter-clockwise; each hole goes to the first outer
+// ring containing it.
+func groupRings(rings [][][2]float64) MultiPolygon {
+	var m MultiPolygon
+	var holes [][][2]float64
+	for _, ring := range rings {
+		if ringAreaKm2(ring) >= 0 {
+			m = append(m, [][][2]float64{ring})
+		} else {
+			holes = append(holes, ring)
+		}
+	}
+	if len(m) == 0 {
+		// Wound the wrong way throughout: treat every ring as an outer one.
+		for _, ring := range holes {
+			m = append(m, [][][2]float64{ring})
+		}
+		return m
+	}
+	for _, hole := range holes {
+		placed := false
+		for i := range m {
+			if inRing(m[i][0], hole[0][0], hole[0][1]) {
+				m[i] = append(m[i], hole)
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			m = append(m, [][][2]float64{hole})
+		}
+	}
+	return m
+}
+
+// parseDBF reads the attribute table of a shapefile. Deleted records are
+// returned as nil so record numbers still line up with the .shp.
+func parseDBF(data []byte) ([]map[string]string, error) {
+	if len(data) < 32 {
+		return nil, fmt.Errorf("the .dbf file is truncated")
+	}
+	numRecords := int(binary.LittleEndian.Uint32(data[4:8]))
+	headerLen := int(binary.LittleEndian.Uint16(data[8:10]))
+	recordLen := int(binary.LittleEndian.Uint16(data[10:12]))
+	if numRecords > 0 && recordLen < 1 {
+		return nil, fmt.Errorf("the .dbf file has an empty record length")
+	}
+	if headerLen+numRecords*recordLen > len(data) {
+		return nil, fmt.Errorf("the .dbf file is truncated")
+	}
+	type field struct {
+		name   string
+		length int
+	}
+	var fields []field
+	for off := 32; off+32 <= headerLen && off+32 <= len(data) && data[off] != 0x0D; off += 32 {
+		name := string(bytes.TrimRight(data[off:off+11], "\x00 "))
+		fields = append(fields, field{name, int(data[off+16])})
+	}
+	out := make([]map[string]string, 0, numRecords)
+	for i := 0; i < numRecords; i++ {
+		off := headerLen + i*recordLen
+		rec := data[off : off+recordLen]
+		if rec[0] == '*' {
+			out = append(out, nil)
+			continue
+		}
+		props := map[string]string{}
+		at := 1
+		for _, f := range fields {
+			if at+f.length > len(rec) {
+				break
+			}
+			if v := strings.TrimSpace(string(rec[at : at+f.length])); v != "" {
+				props[f.name] = v
+			}
+			at += f.length
+		}
+		out = append(out, props)
+	}
+	return out, nil
+}
+
+// readShapefileParts collects the .shp, .dbf and .prj from uploaded files,
+// looking inside zip archives.
+func readShapefileParts(files []*spooledFile, open func(*spooledFile) ([]byte, error)) (shp, dbf, prj []byte, err error) {
+	take := func(name string, data []byte) {
+		switch strings.ToLower(filepath.Ext(name)) {
+		case ".shp":
+			shp = data
+		case ".dbf":
+			dbf = data
+		case ".prj":
+			prj = data
+		}
+	}
+	for _, f := range files {
+		data, err := open(f)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if strings.ToLower(filepath.Ext(f.Name)) != ".zip" {
+			take(f.Name, data)
+			continue
+		}
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		for _, zf := range zr.File {
+			ext := strings.ToLower(filepath.Ext(zf.Name))
+			if ext != ".shp" && ext != ".dbf" && ext != ".prj" || strings.HasPrefix(filepath.Base(zf.Name), ".") {
+				continue
+			}
+			rc, err := zf.Open()
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			b, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			take(zf.Name, b)
+		}
+	}
+	if shp == nil {
+		return nil, nil, nil, fmt.Errorf("no .shp file found")
+	}
+	return shp, dbf, prj, nil
+}
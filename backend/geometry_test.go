@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+)
+
+type dbfField struct {
+	name   string
+	length int
+}
+
+// buildDBF writes a dBASE III table with character fields. Records listed
+// in deleted are flagged with '*'.
+func buildDBF(fields []dbfField, records [][]string, deleted map[int]bool) []byte {
+	recordLen := 1
+	for _, f := range fields {
+		recordLen += f.length
+	}
+	headerLen := 32 + 32*len(fields) + 1
+	var b bytes.Buffer
+	head := make([]byte, 32)
+	head[0] = 3
+	binary.LittleEndian.PutUint32(head[4:], uint32(len(records)))
+	binary.LittleEndian.PutUint16(head[8:], uint16(headerLen))
+	binary.LittleEndian.PutUint16(head[10:], uint16(recordLen))
+	b.Write(head)
+	for _, f := range fields {
+		desc := make([]byte, 32)
+		copy(desc, f.name)
+		desc[11] = 'C'
+		desc[16] = byte(f.length)
+		b.Write(desc)
+	}
+	b.WriteByte(0x0D)
+	for i, rec := range records {
+		if deleted[i] {
+			b.WriteByte('*')
+		} else {
+			b.WriteByte(' ')
+		}
+		for j, f := range fields {
+			v := rec[j] + strings.Repeat(" ", f.length)
+			b.WriteString(v[:f.length])
+		}
+	}
+	return b.Bytes()
+}
+
+// buildSHP writes a polygon shapefile; a nil record is a null shape.
+func buildSHP(records [][][][2]float64) []byte {
+	var body bytes.Buffer
+	for i, rings := range records {
+		var content bytes.Buffer
+		w := func(v any) { binary.Write(&content, binary.LittleEndian, v) }
+		if rings == nil {
+			w(int32(0))
+		} else {
+			w(int32(shpPolygon))
+			w([4]float64{})
+			points := 0
+			for _, r := range rings {
+				points += len(r)
+			}
+			w(int32(len(rings)))
+			w(int32(points))
+			start := 0
+			for _, r := range rings {
+				w(int32(start))
+				start += len(r)
+			}
+			for _, r := range rings {
+				for _, p := range r {
+					w(p)
+				}
+			}
+		}
+		binary.Write(&body, binary.BigEndian, int32(i+1))
+		binary.Write(&body, binary.BigEndian, int32(content.Len()/2))
+		body.Write(content.Bytes())
+	}
+
+	head := make([]byte, 100)
+	binary.BigEndian.PutUint32(head[0:], 9994)
+	binary.BigEndian.PutUint32(head[24:], uint32((100+body.Len())/2))
+	binary.LittleEndian.PutUint32(head[28:], 1000)
+	binary.LittleEndian.PutUint32(head[32:], shpPolygon)
+	return append(head, body.Bytes()...)
+}
+
+// Clockwise in longitude/latitude is an outer ring for shapefiles.
+var (
+	outerSquare = [][2]float64{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}
+	holeSquare  = [][2]float64{{4, 4}, {6, 4}, {6, 6}, {4, 6}, {4, 4}}
+	farSquare   = [][2]float64{{20, 0}, {20, 5}, {25, 5}, {25, 0}, {20, 0}}
+)
+
+func TestParseDBF(t *testing.T) {
+	data := buildDBF([]dbfField{{"NAME", 10}, {"IUCN_CAT", 4}},
+		[][]string{{"Reef One", "II"}, {"Gone", "IV"}, {"", "Ia"}}, map[int]bool{1: true})
+	recs, err := parseDBF(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 3 {
+		t.Fatalf("got %d records, want 3", len(recs))
+	}
+	if recs[0]["NAME"] != "Reef One" || recs[0]["IUCN_CAT"] != "II" {
+		t.Errorf("record 1 = %v", recs[0])
+	}
+	if recs[1] != nil {
+		t.Errorf("deleted record = %v, want nil", recs[1])
+	}
+	if _, ok := recs[2]["NAME"]; ok || recs[2]["IUCN_CAT"] != "Ia" {
+		t.Errorf("record 3 = %v, want blank NAME left out", recs[2])
+	}
+
+	header := func(numRecords, headerLen, recordLen int, size int) []byte {
+		b := make([]byte, size)
+		binary.LittleEndian.PutUint32(b[4:], uint32(numRecords))
+		binary.LittleEndian.PutUint16(b[8:], uint16(headerLen))
+		binary.LittleEndian.PutUint16(b[10:], uint16(recordLen))
+		return b
+	}
+	bad := map[string][]byte{
+		"short":                  make([]byte, 20),
+		"header past end":        header(0, 200, 10, 40),
+		"zero record length":     header(2, 32, 0, 64),
+		"records past end":       header(5, 33, 10, 60),
+		"truncated last record":  data[:len(data)-3],
+		"huge record count":      header(1<<30, 33, 100, 64),
+		"fields beyond the file": header(1, 65, 1, 50),
+	}
+	for name, b := range bad {
+		if _, err := parseDBF(b); err == nil {
+			t.Errorf("%s: parsed without error", name)
+		}
+	}
+}
+
+func TestParseShapefile(t *testing.T) {
+	shp := buildSHP([][][][2]float64{{outerSquare, holeSquare}, nil, {farSquare}})
+	dbf := buildDBF([]dbfField{{"NAME", 8}}, [][]string{{"A"}, {"B"}, {"C"}}, nil)
+	features, skipped, err := parseShapefile(shp, dbf, []byte(`GEOGCS["GCS_WGS_1984"]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(features) != 2 || skipped != 1 {
+		t.Fatalf("got %d features, %d skipped; want 2 and 1", len(features), skipped)
+	}
+	if features[0].Properties["NAME"] != "A" || features[1].Properties["NAME"] != "C" {
+		t.Errorf("attributes out of line: %v, %v", features[0].Properties, features[1].Properties)
+	}
+	if g := features[0].Geometry; len(g) != 1 || len(g[0]) != 2 {
+		t.Errorf("first feature should be one polygon with a hole, got %d polygons", len(g))
+	}
+
+	// A deleted .dbf record drops its shape.
+	deleted := buildDBF([]dbfField{{"NAME", 8}}, [][]string{{"A"}, {"B"}, {"C"}}, map[int]bool{0: true})
+	if features, _, err := parseShapefile(shp, deleted, nil); err != nil || len(features) != 1 {
+		t.Errorf("with a deleted record: %d features, err %v", len(features), err)
+	}
+	// Without a .dbf, features have no attributes.
+	if features, _, err := parseShapefile(shp, nil, nil); err != nil || len(features) != 2 || len(features[0].Properties) != 0 {
+		t.Errorf("without a .dbf: %d features, err %v", len(features), err)
+	}
+
+	wrongType := append([]byte{}, shp...)
+	binary.LittleEndian.PutUint32(wrongType[32:], 1)
+	notWGS84 := buildSHP([][][][2]float64{{{{500000, 0}, {500000, 10}, {500010, 10}, {500000, 0}}}})
+	bad := map[string]struct{ shp, prj []byte }{
+		"projected prj": {shp, []byte(`PROJCS["WGS_1984_UTM_Zone_43N",GEOGCS["GCS_WGS_1984"]]`)},
+		"bad magic":     {append([]byte{1}, shp[1:]...), nil},
+		"too short":     {shp[:50], nil},
+		"point type":    {wrongType, nil},
+		"truncated":     {shp[:len(shp)-10], nil},
+		"not lon/lat":   {notWGS84, nil},
+	}
+	for name, tt := range bad {
+		if _, _, err := parseShapefile(tt.shp, nil, tt.prj); err == nil {
+			t.Errorf("%s: parsed without error", name)
+		}
+	}
+}
+
+func reversed(ring [][2]float64) [][2]float64 {
+	out := make([][2]float64, len(ring))
+	for i, p := range ring {
+		out[len(ring)-1-i] = p
+	}
+	return out
+}
+
+func TestGroupRings(t *testing.T) {
+	m := groupRings([][][2]float64{farSquare, outerSquare, holeSquare})
+	if len(m) != 2 {
+		t.Fatalf("got %d polygons, want 2", len(m))
+	}
+	if len(m[0]) != 1 || len(m[1]) != 2 || m[1][1][0] != holeSquare[0] {
+		t.Errorf("hole not placed in the ring that contains it: %d and %d rings", len(m[0]), len(m[1]))
+	}
+
+	// Every ring wound counter-clockwise: all become outer rings.
+	m = groupRings([][][2]float64{reversed(outerSquare), reversed(farSquare)})
+	if len(m) != 2 || len(m[0]) != 1 || len(m[1]) != 1 {
+		t.Errorf("counter-clockwise rings grouped as %v", m)
+	}
+
+	// A hole outside every outer ring stands alone.
+	m = groupRings([][][2]float64{outerSquare, reversed(farSquare)})
+	if len(m) != 2 {
+		t.Errorf("stray hole grouped into %d polygons, want 2", len(m))
+	}
+}
+
+func TestContainsWithHoles(t *testing.T) {
+	m := MultiPolygon{{outerSquare, holeSquare}, {farSquare}}
+	tests := []struct {
+		name     string
+		lon, lat float64
+		want     bool
+	}{
+		{"inside outer", 2, 2, true},
+		{"inside hole", 5, 5, false},
+		{"second polygon", 22, 2, true},
+		{"between polygons", 15, 5, false},
+		{"outside", -1, 5, false},
+	}
+	for _, tt := range tests {
+		if got := m.contains(tt.lon, tt.lat); got != tt.want {
+			t.Errorf("%s: contains(%v, %v) = %v, want %v", tt.name, tt.lon, tt.lat, got, tt.want)
+		}
+	}
+	if !inRing(outerSquare, 5, 5) {
+		t.Error("inRing alone ignores holes, so the hole centre is inside the outer ring")
+	}
+	if d := m.distanceKm(5, 5); d <= 0 || math.Abs(d-111.2) > 1 {
+		t.Errorf("distance from hole centre to its edge = %v km, want about 111", d)
+	}
+}
+
+func TestRingAreaKm2(t *testing.T) {
+	square := [][2]float64{{0, 0}, {0, 1}, {1, 1}, {1, 0}, {0, 0}}
+	// Exact spherical area of a 1° cell at the equator: R² Δλ sin 1°.
+	want := earthRadiusKm * earthRadiusKm * (math.Pi / 180) * math.Sin(math.Pi/180)
+	if got := ringAreaKm2(square); math.Abs(got-want) > 1e-6*want {
+		t.Errorf("clockwise area = %v, want %v", got, want)
+	}
+	if got := ringAreaKm2(reversed(square)); math.Abs(got+want) > 1e-6*want {
+		t.Errorf("counter-clockwise area = %v, want %v", got, -want)
+	}
+
+	m := MultiPolygon{{outerSquare, holeSquare}}
+	if got, want := m.areaKm2(), math.Abs(ringAreaKm2(outerSquare))-math.Abs(ringAreaKm2(holeSquare)); math.Abs(got-want) > 1e-6 {
+		t.Errorf("area with hole = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,867 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// --- Invasive Species ---
+//
+// invasive_watchlist lists species that are invasive or non-native in a
+// region (an empty region watches everywhere). The checker scans new
+// occurrence_data rows, pending citizen sightings and eDNA identifications
+// for watchlisted species recorded in a region that is not among the
+// species' reported_regions, and raises one biological alert per species
+// and region. Later detections are added to the open alert as evidence and
+// can raise its confidence.
+//
+// Each source table carries an invasive_checked flag. The checker claims
+// unchecked rows in batches rather than following an id watermark, so rows
+// whose transactions commit out of id order are still scanned.
+
+const invasiveSchema = `
+CREATE TABLE IF NOT EXISTS invasive_watchlist (
+	id         SERIAL PRIMARY KEY,
+	species_id INTEGER NOT NULL,
+	region     TEXT NOT NULL DEFAULT '',
+	status     TEXT NOT NULL DEFAULT 'invasive',
+	severity   TEXT NOT NULL DEFAULT 'high',
+	notes      TEXT,
+	added_by   TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	UNIQUE (species_id, region)
+);
+
+CREATE TABLE IF NOT EXISTS edna_detections (
+	id              SERIAL PRIMARY KEY,
+	sample_id       TEXT NOT NULL,
+	dataset_id      INTEGER REFERENCES datasets(id) ON DELETE SET NULL,
+	region          TEXT,
+	latitude        DOUBLE PRECISION,
+	longitude       DOUBLE PRECISION,
+	sampled_on      DATE,
+	scientific_name TEXT NOT NULL,
+	species_id      INTEGER,
+	subject_id      TEXT,
+	identity        DOUBLE PRECISION,
+	evalue          DOUBLE PRECISION,
+	bit_score       DOUBLE PRECISION,
+	read_count      INTEGER,
+	confidence      DOUBLE PRECISION NOT NULL DEFAULT 0,
+	submitted_by    TEXT NOT NULL,
+	created_at      TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS edna_detections_species_idx ON edna_detections (species_id);
+
+ALTER TABLE occurrence_data ADD COLUMN IF NOT EXISTS invasive_checked BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE citizen_sightings ADD COLUMN IF NOT EXISTS invasive_checked BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE edna_detections ADD COLUMN IF NOT EXISTS invasive_checked BOOLEAN NOT NULL DEFAULT false;
+CREATE INDEX IF NOT EXISTS occurrence_data_unchecked_idx ON occurrence_data (id) WHERE NOT invasive_checked;
+CREATE INDEX IF NOT EXISTS citizen_sightings_unchecked_idx ON citizen_sightings (id) WHERE NOT invasive_checked;
+CREATE INDEX IF NOT EXISTS edna_detections_unchecked_idx ON edna_detections (id) WHERE NOT invasive_checked;`
+
+const (
+	alertTypeInvasive = "invasive_species"
+
+	sourceOccurrence = "occurrence"
+	sourceCitizen    = "citizen_sighting"
+	sourceEDNA       = "edna"
+
+	// minEDNAIdentity is the BLAST percent identity accepted as a
+	// species-level identification; weaker e-values cost 10% confidence.
+	minEDNAIdentity = 97.0
+	weakEvalue      = 1e-20
+
+	// Confidence of curated occurrence records, of occurrences copied from
+	// verified citizen reports and of pending citizen reports (plus a
+	// little when a photo is attached).
+	occurrenceConfidence      = 0.9
+	verifiedCitizenConfidence = 0.8
+	pendingCitizenConfidence  = 0.5
+	citizenPhotoBonus         = 0.1
+
+	// Alerts at or above confirmedInvasive are titled as confirmed; below
+	// lowConfidenceInvasive their severity is capped at medium.
+	confirmedInvasive     = 0.9
+	lowConfidenceInvasive = 0.7
+
+	maxInvasiveEvidence = 25
+	invasiveInterval    = 10 * time.Minute
+	invasiveClaimBatch  = 5000
+)
+
+// ednaConfidence scores a BLAST identification: percent identity as a
+// fraction, reduced when the e-value is weak or missing. Matches below
+// minEDNAIdentity score zero.
+func ednaConfidence(identity float64, evalue *float64) float64 {
+	if identity < minEDNAIdentity {
+		return 0
+	}
+	c := math.Min(identity, 100) / 100
+	if evalue == nil || *evalue > weakEvalue {
+		c *= 0.9
+	}
+	return math.Round(c*100) / 100
+}
+
+type WatchlistEntry struct {
+	ID             int      `json:"id"`
+	SpeciesID      int      `json:"species_id"`
+	ScientificName string   `json:"scientific_name"`
+	VernacularName string   `json:"vernacular_name,omitempty"`
+	Region         string   `json:"region"`
+	Status         string   `json:"status"`
+	Severity       string   `json:"severity"`
+	Notes          string   `json:"notes,omitempty"`
+	NativeRegions  []string `json:"native_regions"`
+	AddedBy        string   `json:"added_by"`
+	CreatedAt      string   `json:"created_at"`
+}
+
+type InvasiveEvidence struct {
+	Source     string   `json:"source"`
+	RecordID   int      `json:"record_id"`
+	Date       string   `json:"date,omitempty"`
+	Region     string   `json:"region"`
+	Latitude   *float64 `json:"latitude,omitempty"`
+	Longitude  *float64 `json:"longitude,omitempty"`
+	Confidence float64  `json:"confidence"`
+	RecordedBy string   `json:"recorded_by,omitempty"`
+	PhotoURL   string   `json:"photo_url,omitempty"`
+	SampleID   string   `json:"sample_id,omitempty"`
+	DatasetID  *int     `json:"dataset_id,omitempty"`
+	SubjectID  string   `json:"subject_id,omitempty"`
+	Identity   *float64 `json:"identity,omitempty"`
+	Evalue     *float64 `json:"evalue,omitempty"`
+	ReadCount  *int     `json:"read_count,omitempty"`
+}
+
+// invasiveMatch is one record of a watchlisted species outside its
+// native regions.
+type invasiveMatch struct {
+	WatchlistID    int
+	WatchStatus    string
+	WatchSeverity  string
+	SpeciesID      int
+	ScientificName string
+	VernacularName string
+	NativeRegions  []string
+	Evidence       InvasiveEvidence
+}
+
+// invasiveDetails is the details payload of invasive species alerts.
+type invasiveDetails struct {
+	SpeciesID      int                `json:"species_id"`
+	ScientificName string             `json:"scientific_name"`
+	VernacularName string             `json:"vernacular_name,omitempty"`
+	WatchlistID    int                `json:"watchlist_id"`
+	WatchStatus    string             `json:"watch_status"`
+	NativeRegions  []string           `json:"native_regions"`
+	Confidence     float64            `json:"confidence"`
+	Detections     int                `json:"detections"`
+	Sources        []string           `json:"sources"`
+	Evidence       []InvasiveEvidence `json:"evidence"`
+}
+
+// --- Checker ---
+
+// invasiveJoin restricts records with the given species and region columns
+// to watchlisted species found outside their reported_regions. Where both
+// a regional and a global watchlist entry match, DISTINCT ON with ORDER BY
+// w.region DESC keeps the regional one.
+func invasiveJoin(speciesCol, regionCol string) string {
+	return ` JOIN invasive_watchlist w ON w.species_id = ` + speciesCol + ` AND (w.region = '' OR w.region = lower(trim(` + regionCol + `)))
+		JOIN species_data s ON s.id = ` + speciesCol + `
+		WHERE trim(COALESCE(` + regionCol + `, '')) <> ''
+			AND NOT EXISTS (SELECT 1 FROM unnest(s.reported_regions) rr WHERE lower(trim(rr)) = lower(trim(` + regionCol + `)))`
+}
+
+const invasiveMatchColumns = `w.id, w.status, w.severity, s.id, s.scientific_name, COALESCE(s.vernacularname, ''),
+	COALESCE(s.reported_regions, '{}')`
+
+var invasiveSources = []struct {
+	name, table string
+	query       func(since bool) string
+	scan        func(rows *sql.Rows) (invasiveMatch, error)
+}{
+	{
+		name: sourceOccurrence, table: "occurrence_data",
+		query: func(since bool) string {
+			q := `SELECT DISTINCT ON (o.id) ` + invasiveMatchColumns + `, o.id, o.region, COALESCE(o.eventdate::text, ''),
+				o.decimallatitude, o.decimallongitude, COALESCE(o.recordedby, ''), COALESCE(o.source, '')
+				FROM occurrence_data o` + invasiveJoin("o.species_id", "o.region")
+			if since {
+				q += " AND o.eventdate::date >= $1::date"
+			} else {
+				q += " AND o.id = ANY($1)"
+			}
+			return q + " ORDER BY o.id, w.region DESC"
+		},
+		scan: func(rows *sql.Rows) (invasiveMatch, error) {
+			m := invasiveMatch{Evidence: InvasiveEvidence{Source: sourceOccurrence}}
+			var lat, lon sql.NullFloat64
+			var source string
+			err := rows.Scan(&m.WatchlistID, &m.WatchStatus, &m.WatchSeverity, &m.SpeciesID, &m.ScientificName, &m.VernacularName,
+				pq.Array(&m.NativeRegions), &m.Evidence.RecordID, &m.Evidence.Region, &m.Evidence.Date, &lat, &lon, &m.Evidence.RecordedBy, &source)
+			m.Evidence.Latitude, m.Evidence.Longitude = nullableFloat(lat), nullableFloat(lon)
+			m.Evidence.Confidence = occurrenceConfidence
+			if source == occurrenceSourceCitizen {
+				m.Evidence.Confidence = verifiedCitizenConfidence
+			}
+			return m, err
+		},
+	},
+	{
+		name: sourceCitizen, table: "citizen_sightings",
+		query: func(since bool) string {
+			q := `SELECT DISTINCT ON (c.id) ` + invasiveMatchColumns + `, c.id, c.region, c.event_date::text, c.latitude, c.longitude,
+				COALESCE(c.photo_key, '') <> ''
+				FROM citizen_sightings c` + invasiveJoin("c.species_id", "c.region") + ` AND c.status = 'pending'`
+			if since {
+				q += " AND c.event_date >= $1::date"
+			} else {
+				q += " AND c.id = ANY($1)"
+			}
+			return q + " ORDER BY c.id, w.region DESC"
+		},
+		scan: func(rows *sql.Rows) (invasiveMatch, error) {
+			m := invasiveMatch{Evidence: InvasiveEvidence{Source: sourceCitizen}}
+			var lat, lon float64
+			var hasPhoto bool
+			err := rows.Scan(&m.WatchlistID, &m.WatchStatus, &m.WatchSeverity, &m.SpeciesID, &m.ScientificName, &m.VernacularName,
+				pq.Array(&m.NativeRegions), &m.Evidence.RecordID, &m.Evidence.Region, &m.Evidence.Date, &lat, &lon, &hasPhoto)
+			m.Evidence.Latitude, m.Evidence.Longitude = &lat, &lon
+			m.Evidence.RecordedBy = "Citizen report #" + strconv.Itoa(m.Evidence.RecordID) + " (pending review)"
+			m.Evidence.Confidence = pendingCitizenConfidence
+			if hasPhoto {
+				m.Evidence.PhotoURL = fmt.Sprintf("%s/api/sightings/reports/%d/photo", publicBaseURL(), m.Evidence.RecordID)
+				m.Evidence.Confidence += citizenPhotoBonus
+			}
+			return m, err
+		},
+	},
+	{
+		name: sourceEDNA, table: "edna_detections",
+		query: func(since bool) string {
+			q := `SELECT DISTINCT ON (e.id) ` + invasiveMatchColumns + `, e.id, e.region, COALESCE(e.sampled_on::text, ''),
+				e.latitude, e.longitude, e.sample_id, e.dataset_id, COALESCE(e.subject_id, ''), e.identity, e.evalue, e.read_count,
+				e.confidence
+				FROM edna_detections e` + invasiveJoin("e.species_id", "e.region") + ` AND e.confidence > 0`
+			if since {
+				q += " AND COALESCE(e.sampled_on, e.created_at::date) >= $1::date"
+			} else {
+				q += " AND e.id = ANY($1)"
+			}
+			return q + " ORDER BY e.id, w.region DESC"
+		},
+		scan: func(rows *sql.Rows) (invasiveMatch, error) {
+			m := invasiveMatch{Evidence: InvasiveEvidence{Source: sourceEDNA}}
+			var lat, lon, identity, evalue sql.NullFloat64
+			var datasetID, reads sql.NullInt64
+			err := rows.Scan(&m.WatchlistID, &m.WatchStatus, &m.WatchSeverity, &m.SpeciesID, &m.ScientificName, &m.VernacularName,
+				pq.Array(&m.NativeRegions), &m.Evidence.RecordID, &m.Evidence.Region, &m.Evidence.Date, &lat, &lon, &m.Evidence.SampleID,
+				&datasetID, &m.Evidence.SubjectID, &identity, &evalue, &reads, &m.Evidence.Confidence)
+			m.Evidence.Latitude, m.Evidence.Longitude = nullableFloat(lat), nullableFloat(lon)
+			m.Evidence.Identity, m.Evidence.Evalue = nullableFloat(identity), nullableFloat(evalue)
+			if datasetID.Valid {
+				id := int(datasetID.Int64)
+				m.Evidence.DatasetID = &id
+			}
+			if reads.Valid {
+				n := int(reads.Int64)
+				m.Evidence.ReadCount = &n
+			}
+			return m, err
+		},
+	},
+}
+
+type InvasiveCheckOptions struct {
+	// Since rescans records dated on or after this date, ignoring what
+	// earlier runs have already seen (e.g. after extending the watchlist).
+	Since string `json:"since"`
+}
+
+type InvasiveCheckResult struct {
+	Scanned       map[string]int `json:"scanned"`
+	Matches       int            `json:"matches"`
+	AlertsRaised  int            `json:"alerts_raised"`
+	AlertsUpdated int            `json:"alerts_updated"`
+	AlertIDs      []int          `json:"alert_ids"`
+}
+
+// invasiveMu keeps the background checker and on-demand runs from raising
+// duplicate alerts for the same species and region.
+var invasiveMu sync.Mutex
+
+// checkInvasive claims each source's unchecked rows in batches and raises
+// or updates alerts for every match. With Since it instead rescans the
+// records dated on or after that day and leaves the flags alone.
+func checkInvasive(opts InvasiveCheckOptions) (*InvasiveCheckResult, error) {
+	invasiveMu.Lock()
+	defer invasiveMu.Unlock()
+
+	res := &InvasiveCheckResult{Scanned: map[string]int{}, AlertIDs: []int{}}
+	seen := map[int]bool{}
+	raise := func(matches []invasiveMatch) error {
+		for _, m := range matches {
+			id, created, err := raiseInvasiveAlert(m)
+			if err != nil {
+				return err
+			}
+			res.Matches++
+			if id == 0 {
+				continue
+			}
+			if created {
+				res.AlertsRaised++
+			} else if !seen[id] {
+				res.AlertsUpdated++
+			}
+			if !seen[id] {
+				seen[id] = true
+				res.AlertIDs = append(res.AlertIDs, id)
+			}
+		}
+		return nil
+	}
+
+	for _, src := range invasiveSources {
+		if opts.Since != "" {
+			matches, err := invasiveMatches(db.Query, src.query(true), src.scan, opts.Since)
+			if err != nil {
+				return res, fmt.Errorf("%s: %w", src.name, err)
+			}
+			if err := raise(matches); err != nil {
+				return res, err
+			}
+			continue
+		}
+		for {
+			n, err := checkInvasiveBatch(src.table, src.query(false), src.scan, raise)
+			if err != nil {
+				return res, fmt.Errorf("%s: %w", src.name, err)
+			}
+			res.Scanned[src.name] += n
+			if n < invasiveClaimBatch {
+				break
+			}
+		}
+	}
+	return res, nil
+}
+
+// checkInvasiveBatch marks up to invasiveClaimBatch unchecked rows of the
+// table as checked and raises alerts for the matches among them. The
+// flags commit only after the alerts are raised, so a failed run leaves
+// the batch to the next one; rows locked by a concurrent run are skipped.
+func checkInvasiveBatch(table, query string, scan func(*sql.Rows) (invasiveMatch, error), raise func([]invasiveMatch) error) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`UPDATE `+table+` SET invasive_checked = true WHERE id IN (
+		SELECT id FROM `+table+` WHERE NOT invasive_checked ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED) RETURNING id`, invasiveClaimBatch)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(ids) == 0 {
+		return 0, err
+	}
+
+	matches, err := invasiveMatches(tx.Query, query, scan, pq.Array(ids))
+	if err != nil {
+		return 0, err
+	}
+	if err := raise(matches); err != nil {
+		return 0, err
+	}
+	return len(ids), tx.Commit()
+}
+
+func invasiveMatches(query func(string, ...any) (*sql.Rows, error), q string, scan func(*sql.Rows) (invasiveMatch, error), arg any) ([]invasiveMatch, error) {
+	rows, err := query(q, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var matches []invasiveMatch
+	for rows.Next() {
+		m, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, m)
+	}
+	return matches, rows.Err()
+}
+
+// raiseInvasiveAlert adds the match to the open alert for its species and
+// region, or raises a new one. It returns 0 when the record is already
+// part of the open alert's evidence.
+func raiseInvasiveAlert(m invasiveMatch) (int, bool, error) {
+	region := strings.TrimSpace(m.Evidence.Region)
+	d := invasiveDetails{Sources: []string{}, Evidence: []InvasiveEvidence{}}
+	var dedupeKey, startedAt string
+	var raw []byte
+	err := db.QueryRow(`SELECT dedupe_key, started_at::text, details FROM alerts
+		WHERE alert_type = $1 AND species_id = $2 AND lower(region) = lower($3) AND status <> 'resolved'
+		ORDER BY id DESC LIMIT 1`, alertTypeInvasive, m.SpeciesID, region).Scan(&dedupeKey, &startedAt, &raw)
+	switch {
+	case err == sql.ErrNoRows:
+		dedupeKey = fmt.Sprintf("invasive:%d:%s:%d", m.SpeciesID, strings.ToLower(region), time.Now().UnixNano())
+	case err != nil:
+		return 0, false, err
+	default:
+		if err := json.Unmarshal(raw, &d); err != nil {
+			return 0, false, err
+		}
+		for _, e := range d.Evidence {
+			if e.Source == m.Evidence.Source && e.RecordID == m.Evidence.RecordID {
+				return 0, false, nil
+			}
+		}
+	}
+
+	d.SpeciesID, d.ScientificName, d.VernacularName = m.SpeciesID, m.ScientificName, m.VernacularName
+	d.WatchlistID, d.WatchStatus, d.NativeRegions = m.WatchlistID, m.WatchStatus, m.NativeRegions
+	if d.NativeRegions == nil {
+		d.NativeRegions = []string{}
+	}
+	d.Detections++
+	d.Confidence = math.Max(d.Confidence, m.Evidence.Confidence)
+	if !containsString(d.Sources, m.Evidence.Source) {
+		d.Sources = append(d.Sources, m.Evidence.Source)
+	}
+	d.Evidence = append(d.Evidence, m.Evidence)
+	if len(d.Evidence) > maxInvasiveEvidence {
+		d.Evidence = d.Evidence[len(d.Evidence)-maxInvasiveEvidence:]
+	}
+	details, err := json.Marshal(d)
+	if err != nil {
+		return 0, false, err
+	}
+
+	name := m.ScientificName
+	if m.VernacularName != "" {
+		name += " (" + m.VernacularName + ")"
+	}
+	title := "Possible invasive species: " + name
+	severity := m.WatchSeverity
+	if d.Confidence >= confirmedInvasive {
+		title = "Invasive species confirmed: " + name
+	} else if d.Confidence < lowConfidenceInvasive && severityRank(severity) > severityRank(severityMedium) {
+		severity = severityMedium
+	}
+	native := "no recorded native regions"
+	if len(d.NativeRegions) > 0 {
+		native = "native range " + strings.Join(d.NativeRegions, ", ")
+	}
+	message := fmt.Sprintf("%s recorded in %s, outside its %s. %d detection(s) from %s. Confidence: %.0f%%.",
+		name, region, native, d.Detections, strings.Join(d.Sources, ", "), d.Confidence*100)
+
+	if startedAt == "" {
+		startedAt = time.Now().UTC().Format(time.RFC3339)
+		if t, err := time.Parse("2006-01-02", firstN(m.Evidence.Date, 10)); err == nil {
+			startedAt = t.Format(time.RFC3339)
+		}
+	}
+	return raiseAlert(&Alert{
+		Category:  categoryBiological,
+		Severity:  severity,
+		Type:      alertTypeInvasive,
+		Title:     title,
+		Message:   message,
+		Region:    region,
+		StartedAt: startedAt,
+		PeakValue: &d.Confidence,
+		Details:   details,
+		DedupeKey: dedupeKey,
+		SpeciesID: &m.SpeciesID,
+	})
+}
+
+func severityRank(s string) int {
+	for i, v := range alertSeverities {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func invasiveLoop() {
+	for {
+		if _, err := checkInvasive(InvasiveCheckOptions{}); err != nil {
+			log.Println("Invasive species check failed:", err)
+		}
+		time.Sleep(invasiveInterval)
+	}
+}
+
+// --- Watchlist Handlers ---
+
+const watchlistColumns = `w.id, w.species_id, COALESCE(s.scientific_name, ''), COALESCE(s.vernacularname, ''), w.region, w.status,
+	w.severity, COALESCE(w.notes, ''), COALESCE(s.reported_regions, '{}'), w.added_by, w.created_at::text`
+
+const watchlistFrom = `invasive_watchlist w LEFT JOIN species_data s ON s.id = w.species_id`
+
+func scanWatchlistEntry(row interface{ Scan(...any) error }) (*WatchlistEntry, error) {
+	var e WatchlistEntry
+	err := row.Scan(&e.ID, &e.SpeciesID, &e.ScientificName, &e.VernacularName, &e.Region, &e.Status, &e.Severity, &e.Notes,
+		pq.Array(&e.NativeRegions), &e.AddedBy, &e.CreatedAt)
+	if e.NativeRegions == nil {
+		e.NativeRegions = []string{}
+	}
+	return &e, err
+}
+
+// listWatchlist returns the watchlist, optionally for one ?region= (which
+// includes entries watched everywhere) or ?species_id=.
+func listWatchlist(w http.ResponseWriter, r *http.Request) {
+	query := "SELECT " + watchlistColumns + " FROM " + watchlistFrom + " WHERE 1=1"
+	args := []interface{}{}
+	if v := r.URL.Query().Get("region"); v != "" {
+		args = append(args, strings.ToLower(strings.TrimSpace(v)))
+		query += " AND w.region IN ('', $" + strconv.Itoa(len(args)) + ")"
+	}
+	if v := r.URL.Query().Get("species_id"); v != "" {
+		args = append(args, v)
+		query += " AND w.species_id = $" + strconv.Itoa(len(args)) + "::int"
+	}
+	rows, err := db.Query(query+" ORDER BY s.scientific_name, w.region", args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer rows.Close()
+
+	entries := []*WatchlistEntry{}
+	for rows.Next() {
+		e, err := scanWatchlistEntry(rows)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// saveWatchlistEntry adds a species to the watchlist for a region, or
+// updates the existing entry. The species is given by species_id or by a
+// scientific or common name.
+func saveWatchlistEntry(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireRole(w, r, moderatorRoles...)
+	if !ok {
+		return
+	}
+	var req struct {
+		SpeciesID      *int   `json:"species_id"`
+		ScientificName string `json:"scientific_name"`
+		Region         string `json:"region"`
+		Status         string `json:"status"`
+		Severity       string `json:"severity"`
+		Notes          string `json:"notes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if req.SpeciesID == nil && req.ScientificName != "" {
+		req.SpeciesID = guessSpeciesID(req.ScientificName)
+	}
+	if req.SpeciesID == nil {
+		http.Error(w, "species_id or a known scientific_name is required", http.StatusBadRequest)
+		return
+	}
+	if req.Status == "" {
+		req.Status = "invasive"
+	}
+	if req.Status != "invasive" && req.Status != "non_native" {
+		http.Error(w, "status must be invasive or non_native", http.StatusBadRequest)
+		return
+	}
+	if req.Severity == "" {
+		req.Severity = severityHigh
+	}
+	if !validAlertSeverity(req.Severity) {
+		http.Error(w, "severity must be one of "+strings.Join(alertSeverities, ", "), http.StatusBadRequest)
+		return
+	}
+
+	var id int
+	err := db.QueryRow(`INSERT INTO invasive_watchlist (species_id, region, status, severity, notes, added_by)
+		SELECT id, $2, $3, $4, NULLIF($5, ''), $6 FROM species_data WHERE id = $1
+		ON CONFLICT (species_id, region) DO UPDATE SET status = EXCLUDED.status, severity = EXCLUDED.severity, notes = EXCLUDED.notes
+		RETURNING id`,
+		*req.SpeciesID, strings.ToLower(strings.TrimSpace(req.Region)), req.Status, req.Severity, req.Notes, user.ID).Scan(&id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Unknown species_id", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	entry, err := scanWatchlistEntry(db.QueryRow("SELECT "+watchlistColumns+" FROM "+watchlistFrom+" WHERE w.id = $1", id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entry)
+}
+
+func deleteWatchlistEntry(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireRole(w, r, moderatorRoles...); !ok {
+		return
+	}
+	res, err := db.Exec("DELETE FROM invasive_watchlist WHERE id = $1", r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Watchlist entry not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func runInvasiveCheck(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireRole(w, r, moderatorRoles...); !ok {
+		return
+	}
+	var opts InvasiveCheckOptions
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+	}
+	if opts.Since != "" {
+		if _, err := time.Parse("2006-01-02", opts.Since); err != nil {
+			http.Error(w, "since must be a YYYY-MM-DD date", http.StatusBadRequest)
+			return
+		}
+	}
+	res, err := checkInvasive(opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// --- eDNA Handlers ---
+
+type EDNADetection struct {
+	ID             int      `json:"id"`
+	SampleID       string   `json:"sample_id"`
+	DatasetID      *int     `json:"dataset_id,omitempty"`
+	Region         string   `json:"region,omitempty"`
+	Latitude       *float64 `json:"latitude,omitempty"`
+	Longitude      *float64 `json:"longitude,omitempty"`
+	SampledOn      string   `json:"sampled_on,omitempty"`
+	ScientificName string   `json:"scientific_name"`
+	SpeciesID      *int     `json:"species_id"`
+	SubjectID      string   `json:"subject_id,omitempty"`
+	Identity       *float64 `json:"identity,omitempty"`
+	Evalue         *float64 `json:"evalue,omitempty"`
+	BitScore       *float64 `json:"bit_score,omitempty"`
+	ReadCount      *int     `json:"read_count,omitempty"`
+	Confidence     float64  `json:"confidence"`
+	CreatedAt      string   `json:"created_at"`
+}
+
+const ednaColumns = `id, sample_id, dataset_id, COALESCE(region, ''), latitude, longitude, COALESCE(sampled_on::text, ''),
+	scientific_name, species_id, COALESCE(subject_id, ''), identity, evalue, bit_score, read_count, confidence, created_at::text`
+
+func scanEDNADetection(row interface{ Scan(...any) error }) (*EDNADetection, error) {
+	var d EDNADetection
+	var datasetID, speciesID, reads sql.NullInt64
+	var lat, lon, identity, evalue, bits sql.NullFloat64
+	err := row.Scan(&d.ID, &d.SampleID, &datasetID, &d.Region, &lat, &lon, &d.SampledOn, &d.ScientificName, &speciesID,
+		&d.SubjectID, &identity, &evalue, &bits, &reads, &d.Confidence, &d.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range []struct {
+		v   sql.NullInt64
+		dst **int
+	}{{datasetID, &d.DatasetID}, {speciesID, &d.SpeciesID}, {reads, &d.ReadCount}} {
+		if p.v.Valid {
+			n := int(p.v.Int64)
+			*p.dst = &n
+		}
+	}
+	d.Latitude, d.Longitude = nullableFloat(lat), nullableFloat(lon)
+	d.Identity, d.Evalue, d.BitScore = nullableFloat(identity), nullableFloat(evalue), nullableFloat(bits)
+	return &d, nil
+}
+
+// submitEDNADetections records the species identifications of one eDNA
+// sample, typically the top BLAST hit per ASV/OTU with the subject's
+// scientific name, and runs the invasive species check on them right away.
+func submitEDNADetections(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireRole(w, r, contributorRoles...)
+	if !ok {
+		return
+	}
+	var req struct {
+		SampleID   string   `json:"sample_id"`
+		DatasetID  *int     `json:"dataset_id"`
+		Region     string   `json:"region"`
+		Latitude   *float64 `json:"latitude"`
+		Longitude  *float64 `json:"longitude"`
+		SampledOn  string   `json:"sampled_on"`
+		Detections []struct {
+			ScientificName string   `json:"scientific_name"`
+			SpeciesID      *int     `json:"species_id"`
+			SubjectID      string   `json:"subject_id"`
+			Identity       *float64 `json:"identity"`
+			Evalue         *float64 `json:"evalue"`
+			BitScore       *float64 `json:"bit_score"`
+			ReadCount      *int     `json:"read_count"`
+		} `json:"detections"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	req.SampleID = strings.TrimSpace(req.SampleID)
+	if req.SampleID == "" || len(req.Detections) == 0 {
+		http.Error(w, "sample_id and at least one detection are required", http.StatusBadRequest)
+		return
+	}
+	if req.SampledOn != "" {
+		if _, err := time.Parse("2006-01-02", req.SampledOn); err != nil {
+			http.Error(w, "sampled_on must be a YYYY-MM-DD date", http.StatusBadRequest)
+			return
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	saved := []*EDNADetection{}
+	for i, det := range req.Detections {
+		name := strings.TrimSpace(det.ScientificName)
+		if name == "" {
+			http.Error(w, fmt.Sprintf("detection %d: scientific_name is required", i+1), http.StatusBadRequest)
+			return
+		}
+		speciesID := det.SpeciesID
+		if speciesID == nil {
+			speciesID = guessSpeciesID(name)
+		}
+		confidence := 0.0
+		if det.Identity != nil {
+			confidence = ednaConfidence(*det.Identity, det.Evalue)
+		}
+		d, err := scanEDNADetection(tx.QueryRow(`INSERT INTO edna_detections (sample_id, dataset_id, region, latitude, longitude,
+				sampled_on, scientific_name, species_id, subject_id, identity, evalue, bit_score, read_count, confidence, submitted_by)
+			VALUES ($1, $2, NULLIF($3, ''), $4, $5, NULLIF($6, '')::date, $7, $8, NULLIF($9, ''), $10, $11, $12, $13, $14, $15)
+			RETURNING `+ednaColumns,
+			req.SampleID, req.DatasetID, strings.TrimSpace(req.Region), req.Latitude, req.Longitude, req.SampledOn, name, speciesID,
+			det.SubjectID, det.Identity, det.Evalue, det.BitScore, det.ReadCount, confidence, user.ID))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		saved = append(saved, d)
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	check, err := checkInvasive(InvasiveCheckOptions{})
+	if err != nil {
+		log.Println("Invasive species check failed:", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"detections": saved, "invasive_check": check})
+}
+
+// listEDNADetections filters by ?sample_id=, species_id, dataset_id and
+// region.
+func listEDNADetections(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	where := []string{"1=1"}
+	args := []interface{}{}
+	for _, f := range []struct{ param, cond string }{
+		{"sample_id", "sample_id = $%d"},
+		{"species_id", "species_id = $%d::int"},
+		{"dataset_id", "dataset_id = $%d::int"},
+		{"region", "region ILIKE $%d"},
+	} {
+		if v := q.Get(f.param); v != "" {
+			args = append(args, v)
+			where = append(where, fmt.Sprintf(f.cond, len(args)))
+		}
+	}
+	page, pageSize := pagination(r)
+	args = append(args, pageSize, (page-1)*pageSize)
+	rows, err := db.Query(fmt.Sprintf("SELECT "+ednaColumns+" FROM edna_detections WHERE %s ORDER BY id DESC LIMIT $%d OFFSET $%d",
+		strings.Join(where, " AND "), len(args)-1, len(args)), args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer rows.Close()
+
+	detections := []*EDNADetection{}
+	for rows.Next() {
+		d, err := scanEDNADetection(rows)
+		if err != nil {
+			continue
+		}
+		detections = append(detections, d)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(detections)
+}
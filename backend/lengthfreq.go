@@ -0,0 +1,526 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// --- Length Frequencies and Juvenile Catch ---
+//
+// For each species, port and period the share of measured fish shorter
+// than the species' maturity_size is compared with the same port's share
+// over the preceding periods. A period is a spike when the excess is both
+// large (at least minJuvenileExcess) and unlikely by chance: a binomial
+// z-score of at least juvenileZThreshold against the baseline share. Spikes
+// raise fisheries alerts, one per species, port and period.
+//
+// Lengths come from the bins of catch records and from length_samples:
+// port sampling of a landing that is already recorded, linked to its catch
+// record or trip so that sampled fish are never counted as extra catch.
+
+const lengthSampleSchema = `
+CREATE TABLE IF NOT EXISTS length_samples (
+	id           SERIAL PRIMARY KEY,
+	catch_id     INTEGER REFERENCES catch_records(id) ON DELETE CASCADE,
+	trip_id      TEXT,
+	species_id   INTEGER NOT NULL,
+	sampled_on   DATE NOT NULL,
+	port         TEXT,
+	region       TEXT,
+	gear         TEXT,
+	vessel_id    TEXT,
+	length_cm    DOUBLE PRECISION NOT NULL CHECK (length_cm > 0),
+	count        INTEGER NOT NULL CHECK (count > 0),
+	submitted_by TEXT NOT NULL,
+	created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+	CHECK (catch_id IS NOT NULL OR trip_id IS NOT NULL)
+);
+CREATE INDEX IF NOT EXISTS length_samples_species_idx ON length_samples (species_id, sampled_on);`
+
+const (
+	alertTypeJuvenileCatch = "juvenile_catch"
+
+	defaultBaselinePeriods = 6
+	minBaselinePeriods     = 3
+	defaultMinSample       = 30
+	minJuvenileExcess      = 0.1
+	juvenileZThreshold     = 3.0
+	highJuvenileExcess     = 0.3
+)
+
+type JuvenileQuery struct {
+	Period          string
+	BaselinePeriods int
+	MinSample       int
+	SpeciesIDs      []int
+	Port            string
+	From, To        string
+}
+
+// JuvenilePeriod is the length-frequency summary of one species at one
+// port in one period.
+type JuvenilePeriod struct {
+	SpeciesID          int      `json:"species_id"`
+	ScientificName     string   `json:"scientific_name"`
+	Port               string   `json:"port"`
+	Region             string   `json:"region,omitempty"`
+	Period             string   `json:"period"`
+	MaturitySizeCm     float64  `json:"maturity_size_cm"`
+	Measured           int      `json:"measured"`
+	Juveniles          int      `json:"juveniles"`
+	Proportion         float64  `json:"proportion"`
+	MeanLengthCm       float64  `json:"mean_length_cm"`
+	BaselineProportion *float64 `json:"baseline_proportion"`
+	BaselinePeriods    int      `json:"baseline_periods"`
+	ZScore             *float64 `json:"z_score"`
+	Spike              bool     `json:"spike"`
+	AlertID            int      `json:"alert_id,omitempty"`
+}
+
+type JuvenileAnalysis struct {
+	Period          string           `json:"period"`
+	BaselinePeriods int              `json:"baseline_periods"`
+	MinSample       int              `json:"min_sample"`
+	Periods         []JuvenilePeriod `json:"periods"`
+	Spikes          int              `json:"spikes"`
+	NoMaturitySize  []int            `json:"species_without_maturity_size"`
+}
+
+func (q *JuvenileQuery) defaults() error {
+	if q.Period == "" {
+		q.Period = "month"
+	}
+	if q.Period != "month" && q.Period != "week" {
+		return fmt.Errorf("period must be month or week")
+	}
+	if q.BaselinePeriods <= 0 {
+		q.BaselinePeriods = defaultBaselinePeriods
+	}
+	if q.MinSample <= 0 {
+		q.MinSample = defaultMinSample
+	}
+	for _, d := range []string{q.From, q.To} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", d); err != nil {
+			return fmt.Errorf("from and to must be YYYY-MM-DD dates")
+		}
+	}
+	return nil
+}
+
+// analyzeJuveniles summarises length frequencies per species, port and
+// period and marks spikes. The baseline for a period is the pooled share
+// of its port's previous BaselinePeriods periods that met MinSample; From
+// only limits the periods reported, not the baseline.
+func analyzeJuveniles(q JuvenileQuery) (*JuvenileAnalysis, error) {
+	if err := q.defaults(); err != nil {
+		return nil, err
+	}
+	res := &JuvenileAnalysis{Period: q.Period, BaselinePeriods: q.BaselinePeriods, MinSample: q.MinSample,
+		Periods: []JuvenilePeriod{}, NoMaturitySize: []int{}}
+
+	where := []string{"1=1"}
+	args := []interface{}{q.Period}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	if len(q.SpeciesIDs) > 0 {
+		ids := make([]int64, len(q.SpeciesIDs))
+		for i, id := range q.SpeciesIDs {
+			ids[i] = int64(id)
+		}
+		where = append(where, "c.species_id = ANY("+arg(pq.Array(ids))+")")
+	}
+	if q.Port != "" {
+		where = append(where, "c.port ILIKE "+arg(q.Port))
+	}
+	if q.To != "" {
+		where = append(where, "c.landing_date <= "+arg(q.To)+"::date")
+	}
+
+	rows, err := db.Query(`WITH lengths AS (
+			SELECT c.species_id, c.landing_date, c.port, c.region, lf.length_cm, lf.count
+			FROM catch_length_frequencies lf JOIN catch_records c ON c.id = lf.catch_id
+			UNION ALL
+			SELECT species_id, sampled_on, port, region, length_cm, count FROM length_samples
+		)
+		SELECT c.species_id, COALESCE(s.scientific_name, ''), COALESCE(c.port, ''),
+			date_trunc($1, c.landing_date)::date::text, COALESCE(MAX(c.region), ''), COALESCE(s.maturity_size, 0),
+			SUM(c.count), COALESCE(SUM(c.count) FILTER (WHERE c.length_cm < s.maturity_size), 0),
+			SUM(c.length_cm * c.count) / NULLIF(SUM(c.count), 0)
+		FROM lengths c JOIN species_data s ON s.id = c.species_id
+		WHERE `+strings.Join(where, " AND ")+`
+		GROUP BY c.species_id, s.scientific_name, c.port, 4, s.maturity_size
+		ORDER BY c.species_id, c.port, 4`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []JuvenilePeriod
+	skipped := map[int]bool{}
+	for rows.Next() {
+		var p JuvenilePeriod
+		var mean sql.NullFloat64
+		if err := rows.Scan(&p.SpeciesID, &p.ScientificName, &p.Port, &p.Period, &p.Region, &p.MaturitySizeCm, &p.Measured,
+			&p.Juveniles, &mean); err != nil {
+			return nil, err
+		}
+		if p.MaturitySizeCm <= 0 {
+			if !skipped[p.SpeciesID] {
+				skipped[p.SpeciesID] = true
+				res.NoMaturitySize = append(res.NoMaturitySize, p.SpeciesID)
+			}
+			continue
+		}
+		if p.Measured > 0 {
+			p.Proportion = float64(p.Juveniles) / float64(p.Measured)
+		}
+		p.MeanLengthCm = math.Round(mean.Float64*10) / 10
+		all = append(all, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Rows are ordered by species, port and period, so each series is a
+	// contiguous run.
+	for start := 0; start < len(all); {
+		end := start
+		for end < len(all) && all[end].SpeciesID == all[start].SpeciesID && all[end].Port == all[start].Port {
+			end++
+		}
+		series := all[start:end]
+		for i := range series {
+			markJuvenileSpike(series, i, q)
+			if q.From != "" && series[i].Period < q.From && !periodContains(series[i].Period, q.Period, q.From) {
+				continue
+			}
+			res.Periods = append(res.Periods, series[i])
+			if series[i].Spike {
+				res.Spikes++
+			}
+		}
+		start = end
+	}
+	return res, nil
+}
+
+// periodContains reports whether the period starting on start (a date)
+// contains date.
+func periodContains(start, period, date string) bool {
+	s, err1 := time.Parse("2006-01-02", start)
+	d, err2 := time.Parse("2006-01-02", date)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	end := s.AddDate(0, 1, 0)
+	if period == "week" {
+		end = s.AddDate(0, 0, 7)
+	}
+	return !d.Before(s) && d.Before(end)
+}
+
+// markJuvenileSpike sets the baseline, z-score and spike flag of series[i]
+// from up to BaselinePeriods earlier periods with enough fish measured.
+func markJuvenileSpike(series []JuvenilePeriod, i int, q JuvenileQuery) {
+	var measured, juveniles, used int
+	for j := i - 1; j >= 0 && used < q.BaselinePeriods; j-- {
+		if series[j].Measured < q.MinSample {
+			continue
+		}
+		measured += series[j].Measured
+		juveniles += series[j].Juveniles
+		used++
+	}
+	p := &series[i]
+	p.BaselinePeriods = used
+	if used == 0 {
+		return
+	}
+	base := float64(juveniles) / float64(measured)
+	p.BaselineProportion = &base
+	if used < minBaselinePeriods || p.Measured < q.MinSample {
+		return
+	}
+
+	// Floor the baseline variance so that a port that never landed
+	// juveniles before does not make every single juvenile a spike.
+	p0 := math.Min(math.Max(base, 0.01), 0.99)
+	z := (p.Proportion - base) / math.Sqrt(p0*(1-p0)/float64(p.Measured))
+	z = math.Round(z*100) / 100
+	p.ZScore = &z
+	p.Spike = z >= juvenileZThreshold && p.Proportion-base >= minJuvenileExcess
+}
+
+// raiseJuvenileAlerts raises or refreshes an alert for every spike in the
+// analysis and records its id.
+func raiseJuvenileAlerts(res *JuvenileAnalysis) error {
+	for i := range res.Periods {
+		p := &res.Periods[i]
+		if !p.Spike {
+			continue
+		}
+		excess := p.Proportion - *p.BaselineProportion
+		severity := severityMedium
+		if excess >= highJuvenileExcess {
+			severity = severityHigh
+		}
+		place := p.Port
+		if place == "" {
+			place = "unspecified port"
+		}
+		details, _ := json.Marshal(map[string]interface{}{
+			"species_id": p.SpeciesID, "scientific_name": p.ScientificName, "port": p.Port, "period": p.Period,
+			"period_type": res.Period, "maturity_size_cm": p.MaturitySizeCm, "measured": p.Measured, "juveniles": p.Juveniles,
+			"proportion": p.Proportion, "baseline_proportion": *p.BaselineProportion, "baseline_periods": p.BaselinePeriods,
+			"z_score": *p.ZScore, "mean_length_cm": p.MeanLengthCm,
+		})
+		id, _, err := raiseAlert(&Alert{
+			Category: categoryFisheries,
+			Severity: severity,
+			Type:     alertTypeJuvenileCatch,
+			Title:    "Juvenile catch spike: " + p.ScientificName,
+			Message: fmt.Sprintf("%.0f%% of %d %s measured at %s in the %s of %s were below maturity size (%.1f cm), against %.0f%% over the previous %d %ss.",
+				p.Proportion*100, p.Measured, p.ScientificName, place, res.Period, p.Period, p.MaturitySizeCm,
+				*p.BaselineProportion*100, p.BaselinePeriods, res.Period),
+			Region:    p.Region,
+			StartedAt: p.Period,
+			PeakValue: &p.Proportion,
+			Details:   details,
+			DedupeKey: fmt.Sprintf("juvenile:%d:%s:%s:%s", p.SpeciesID, strings.ToLower(p.Port), res.Period, p.Period),
+			SpeciesID: &p.SpeciesID,
+		})
+		if err != nil {
+			return err
+		}
+		p.AlertID = id
+	}
+	return nil
+}
+
+// detectJuvenileCatch re-analyses the given species after new length
+// frequencies arrive and raises alerts for their spikes.
+func detectJuvenileCatch(speciesIDs []int) (*JuvenileAnalysis, error) {
+	res, err := analyzeJuveniles(JuvenileQuery{SpeciesIDs: speciesIDs})
+	if err != nil {
+		return nil, err
+	}
+	return res, raiseJuvenileAlerts(res)
+}
+
+// landingForSample looks up the landing a length sample belongs to: the
+// catch record catchID, or else any catch or effort record of tripID.
+func landingForSample(catchID int, tripID string) (*CatchRecord, error) {
+	var c CatchRecord
+	var err error
+	if catchID != 0 {
+		err = db.QueryRow(`SELECT id, species_id, landing_date::text, COALESCE(port, ''), COALESCE(region, ''), COALESCE(gear, ''),
+				COALESCE(vessel_id, ''), COALESCE(trip_id, '')
+			FROM catch_records WHERE id = $1`, catchID).
+			Scan(&c.ID, &c.SpeciesID, &c.LandingDate, &c.Port, &c.Region, &c.Gear, &c.VesselID, &c.TripID)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("unknown catch_id %d", catchID)
+		}
+		return &c, err
+	}
+	err = db.QueryRow(`SELECT landing_date::text, COALESCE(port, ''), COALESCE(region, ''), COALESCE(gear, ''), COALESCE(vessel_id, '')
+		FROM (SELECT landing_date, port, region, gear, vessel_id, 0 AS pref FROM catch_records WHERE trip_id = $1
+			UNION ALL
+			SELECT effort_date, port, region, gear, vessel_id, 1 FROM fishing_effort WHERE trip_id = $1) t
+		ORDER BY pref, landing_date LIMIT 1`, tripID).
+		Scan(&c.LandingDate, &c.Port, &c.Region, &c.Gear, &c.VesselID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("unknown trip_id %q", tripID)
+	}
+	c.TripID = tripID
+	return &c, err
+}
+
+func catchSpeciesIDs(catches []*CatchRecord) []int {
+	seen := map[int]bool{}
+	var ids []int
+	for _, c := range catches {
+		if len(c.LengthFrequencies) > 0 && !seen[c.SpeciesID] {
+			seen[c.SpeciesID] = true
+			ids = append(ids, c.SpeciesID)
+		}
+	}
+	return ids
+}
+
+// --- Handlers ---
+
+func juvenileQueryFrom(r *http.Request) (JuvenileQuery, error) {
+	v := r.URL.Query()
+	q := JuvenileQuery{Period: v.Get("period"), Port: v.Get("port"), From: v.Get("from"), To: v.Get("to")}
+	q.BaselinePeriods, _ = strconv.Atoi(v.Get("baseline"))
+	q.MinSample, _ = strconv.Atoi(v.Get("min_sample"))
+	if s := v.Get("species_id"); s != "" {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			return q, fmt.Errorf("species_id must be an integer")
+		}
+		q.SpeciesIDs = []int{id}
+	}
+	return q, nil
+}
+
+// getJuvenileAnalysis reports the juvenile share per species, port and
+// ?period= (month or week) with baselines and spikes, without raising
+// alerts. Filters: species_id, port, from, to, baseline (periods) and
+// min_sample (fish measured).
+func getJuvenileAnalysis(w http.ResponseWriter, r *http.Request) {
+	q, err := juvenileQueryFrom(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	res, err := analyzeJuveniles(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// submitLengthFrequencies stores length-frequency samples (one length
+// class of one species each), then analyses the species involved and
+// raises juvenile-catch alerts. Every sample names the landing it was
+// measured from, by catch_id or by trip_id; the species defaults to the
+// catch record's. Samples do not add to landed catch or effort.
+func submitLengthFrequencies(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireRole(w, r, contributorRoles...)
+	if !ok {
+		return
+	}
+	var req struct {
+		Records []struct {
+			CatchID   int     `json:"catch_id"`
+			TripID    string  `json:"trip_id"`
+			SpeciesID int     `json:"species_id"`
+			Species   string  `json:"species"`
+			LengthCm  float64 `json:"length_cm"`
+			Count     int     `json:"count"`
+		} `json:"records"`
+		Period          string `json:"period"`
+		BaselinePeriods int    `json:"baseline_periods"`
+		MinSample       int    `json:"min_sample"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Records) == 0 {
+		http.Error(w, "records is required", http.StatusBadRequest)
+		return
+	}
+
+	type sample struct {
+		landing  *CatchRecord
+		species  int
+		lengthCm float64
+		count    int
+	}
+	samples := make([]sample, 0, len(req.Records))
+	landings := map[string]*CatchRecord{}
+	seen := map[int]bool{}
+	var speciesIDs []int
+	for i, rec := range req.Records {
+		if rec.LengthCm <= 0 || rec.LengthCm > 500 || rec.Count <= 0 {
+			http.Error(w, fmt.Sprintf("record %d: a length_cm in (0, 500] and a positive count are required", i+1), http.StatusBadRequest)
+			return
+		}
+		tripID := strings.TrimSpace(rec.TripID)
+		if rec.CatchID == 0 && tripID == "" {
+			http.Error(w, fmt.Sprintf("record %d: catch_id or trip_id is required", i+1), http.StatusBadRequest)
+			return
+		}
+		key := strconv.Itoa(rec.CatchID) + "\x00" + tripID
+		landing, ok := landings[key]
+		if !ok {
+			var err error
+			if landing, err = landingForSample(rec.CatchID, tripID); err != nil {
+				http.Error(w, fmt.Sprintf("record %d: %v", i+1, err), http.StatusBadRequest)
+				return
+			}
+			landings[key] = landing
+		}
+
+		speciesID := rec.SpeciesID
+		if speciesID == 0 && rec.Species != "" {
+			if id := guessSpeciesID(rec.Species); id != nil {
+				speciesID = *id
+			}
+		}
+		switch {
+		case speciesID == 0 && landing.SpeciesID == 0:
+			http.Error(w, fmt.Sprintf("record %d: species_id or a known species name is required", i+1), http.StatusBadRequest)
+			return
+		case speciesID == 0:
+			speciesID = landing.SpeciesID
+		case landing.SpeciesID != 0 && speciesID != landing.SpeciesID:
+			http.Error(w, fmt.Sprintf("record %d: species does not match catch record %d", i+1, landing.ID), http.StatusBadRequest)
+			return
+		default:
+			if exists, err := speciesExists(speciesID); err != nil || !exists {
+				http.Error(w, fmt.Sprintf("record %d: unknown species_id %d", i+1, speciesID), http.StatusBadRequest)
+				return
+			}
+		}
+		samples = append(samples, sample{landing: landing, species: speciesID, lengthCm: rec.LengthCm, count: rec.Count})
+		if !seen[speciesID] {
+			seen[speciesID] = true
+			speciesIDs = append(speciesIDs, speciesID)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	for _, smp := range samples {
+		l := smp.landing
+		_, err := tx.Exec(`INSERT INTO length_samples (catch_id, trip_id, species_id, sampled_on, port, region, gear, vessel_id,
+				length_cm, count, submitted_by)
+			VALUES (NULLIF($1, 0), NULLIF($2, ''), $3, $4::date, NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''),
+				$9, $10, $11)`,
+			l.ID, l.TripID, smp.species, l.LandingDate, l.Port, l.Region, l.Gear, l.VesselID, smp.lengthCm, smp.count, user.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res, err := analyzeJuveniles(JuvenileQuery{Period: req.Period, BaselinePeriods: req.BaselinePeriods,
+		MinSample: req.MinSample, SpeciesIDs: speciesIDs})
+	if err == nil {
+		err = raiseJuvenileAlerts(res)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"length_samples": len(samples), "analysis": res})
+}
@@ -44,6 +44,7 @@ type Species struct {
 	DepthRangeMin      float64  `json:"depth_range_min"`
 	DepthRangeMax      float64  `json:"depth_range_max"`
 	ConservationStatus string   `json:"conservation_status"`
+	IUCNCategory       string   `json:"iucn_category"`
 	Fecundity          string   `json:"fecundity"`
 	SpawningSeason     string   `json:"spawning_season"`
 	MaturitySize       float64  `json:"maturity_size"`
@@ -128,6 +129,19 @@ func main() {
 		log.Fatal("Could not connect to database:", err)
 	}
 
+	ensureSchema()
+	initObjectStore()
+	initDOIMinter()
+	initNotifiers()
+	backfillDatasetVersions()
+	seedFoodWeb()
+	backfillIUCNCategories()
+	go detectionLoop()
+	go expireUploadsLoop()
+	go ruleLoop()
+	go dispatchLoop()
+	go invasiveLoop()
+
 	imageDir := "E:\\otolith_analysis\\batch_results\\"
 	if _, err := os.Stat(imageDir); os.IsNotExist(err) {
     	log.Fatalf("Image directory not found: %s", imageDir)
@@ -135,15 +149,143 @@ func main() {
 
 http.Handle("/api/images/otoliths/", http.StripPrefix("/api/images/otoliths/", http.FileServer(http.Dir(imageDir))))
 
-	http.HandleFunc("/api/filters/classes", getClasses)
-	http.HandleFunc("/api/filters/regions", getRegions)
-	http.HandleFunc("/api/filters/conservation-status", getConservationStatuses)
+	http.HandleFunc("/api/filters/classes", facetValueHandler("class"))
+	http.HandleFunc("/api/filters/regions", facetValueHandler("region"))
+	http.HandleFunc("/api/filters/conservation-status", facetValueHandler("conservation_status"))
+	http.HandleFunc("/api/filters/facets", getFacets)
 	http.HandleFunc("/api/species", getSpecies)
 	http.HandleFunc("/api/species/", getSpeciesDetail)
+	http.HandleFunc("/api/species/compare", compareSpecies)
+	http.HandleFunc("GET /api/species/{id}/related", getRelatedSpecies)
+	http.HandleFunc("GET /api/species/{id}/sightings", getSpeciesSightings)
+	http.HandleFunc("GET /api/species/{id}/foodweb", getSpeciesFoodWeb)
+	http.HandleFunc("GET /api/species/{id}/conservation", getConservationHistory)
+	http.HandleFunc("POST /api/species/{id}/conservation", createAssessment)
+	http.HandleFunc("GET /api/species/{id}/mpas", getSpeciesMPAs)
 	http.HandleFunc("/api/otoliths", getOtoliths)
 	http.HandleFunc("/api/latest-sighting/", getLatestSighting)
 	http.HandleFunc("/api/blast", handleBlast)
 
+	http.HandleFunc("/api/taxonomy/backbone", handleBackboneImport)
+	http.HandleFunc("/api/taxonomy/resolve", resolveTaxonNames)
+	http.HandleFunc("/api/taxonomy/report", getTaxonomyReport)
+	http.HandleFunc("/api/taxonomy/merge", handleSpeciesMerge)
+
+	http.HandleFunc("POST /api/sightings/reports", submitSightingReport)
+	http.HandleFunc("GET /api/sightings/reports", listSightingReports)
+	http.HandleFunc("GET /api/sightings/reports/{id}/photo", getSightingPhoto)
+	http.HandleFunc("POST /api/sightings/reports/{id}/species", correctSightingSpecies)
+	http.HandleFunc("POST /api/sightings/reports/{id}/verify", verifySightingReport)
+	http.HandleFunc("POST /api/sightings/reports/{id}/reject", rejectSightingReport)
+
+	http.HandleFunc("POST /api/datasets", createDataset)
+	http.HandleFunc("GET /api/datasets", listDatasets)
+	http.HandleFunc("GET /api/datasets/{id}", getDataset)
+	http.HandleFunc("PATCH /api/datasets/{id}", updateDataset)
+	http.HandleFunc("POST /api/datasets/{id}/files", addDatasetFiles)
+	http.HandleFunc("GET /api/datasets/{id}/files/{fileId}", downloadDatasetFile)
+	http.HandleFunc("GET /api/datasets/{id}/files/{fileId}/validation", validateDatasetFile)
+	http.HandleFunc("POST /api/validate", validateUpload)
+	http.HandleFunc("GET /api/datasets/{id}/versions", listDatasetVersions)
+	http.HandleFunc("GET /api/datasets/{id}/versions/{version}", getDatasetVersion)
+	http.HandleFunc("GET /api/datasets/{id}/versions/{version}/citation", getVersionCitation)
+	http.HandleFunc("GET /api/datasets/{id}/versions/{version}/datacite", getVersionDataCite)
+	http.HandleFunc("POST /api/datasets/{id}/versions/{version}/doi", mintVersionDOI)
+
+	http.HandleFunc("GET /api/env/parameters", listEnvParameters)
+	http.HandleFunc("GET /api/env/stations", listEnvStations)
+	http.HandleFunc("POST /api/env/stations", saveEnvStation)
+	http.HandleFunc("GET /api/env/stations/{code}", getEnvStation)
+	http.HandleFunc("GET /api/env/stations/{code}/series", getEnvSeries)
+	http.HandleFunc("GET /api/env/stations/{code}/climatology", getEnvClimatology)
+	http.HandleFunc("POST /api/env/ingest", ingestEnvFile)
+	http.HandleFunc("POST /api/env/detect", runDetectionHandler)
+
+	http.HandleFunc("GET /api/alerts", listAlerts)
+	http.HandleFunc("GET /api/alerts/{id}", getAlert)
+	http.HandleFunc("GET /api/alerts/{id}/deliveries", listAlertDeliveries)
+	http.HandleFunc("POST /api/alerts/{id}/acknowledge", alertTransition(alertAcknowledged))
+	http.HandleFunc("POST /api/alerts/{id}/resolve", alertTransition(alertResolved))
+	http.HandleFunc("GET /api/alerts/rules", listAlertRules)
+	http.HandleFunc("POST /api/alerts/rules", saveAlertRule)
+	http.HandleFunc("PUT /api/alerts/rules/{id}", saveAlertRule)
+	http.HandleFunc("DELETE /api/alerts/rules/{id}", deleteAlertRule)
+	http.HandleFunc("POST /api/alerts/rules/{id}/evaluate", evaluateAlertRuleHandler)
+	http.HandleFunc("GET /api/alerts/subscriptions", listAlertSubscriptions)
+	http.HandleFunc("POST /api/alerts/subscriptions", createAlertSubscription)
+	http.HandleFunc("PUT /api/alerts/subscriptions/{id}", updateAlertSubscription)
+	http.HandleFunc("DELETE /api/alerts/subscriptions/{id}", deleteAlertSubscription)
+	http.HandleFunc("POST /api/alerts/subscriptions/{id}/test", testAlertSubscription)
+
+	http.HandleFunc("GET /api/invasive/watchlist", listWatchlist)
+	http.HandleFunc("POST /api/invasive/watchlist", saveWatchlistEntry)
+	http.HandleFunc("DELETE /api/invasive/watchlist/{id}", deleteWatchlistEntry)
+	http.HandleFunc("POST /api/invasive/check", runInvasiveCheck)
+	http.HandleFunc("GET /api/edna/detections", listEDNADetections)
+	http.HandleFunc("POST /api/edna/detections", submitEDNADetections)
+
+	http.HandleFunc("GET /api/fisheries/catch", listCatchRecords)
+	http.HandleFunc("POST /api/fisheries/catch", createCatchRecord)
+	http.HandleFunc("POST /api/fisheries/catch/import", importCatchFile)
+	http.HandleFunc("GET /api/fisheries/effort", listEffortRecords)
+	http.HandleFunc("POST /api/fisheries/effort", createEffortRecord)
+	http.HandleFunc("GET /api/fisheries/cpue", getCPUE)
+	http.HandleFunc("GET /api/fisheries/juveniles", getJuvenileAnalysis)
+	http.HandleFunc("POST /api/fisheries/length-frequencies", submitLengthFrequencies)
+	http.HandleFunc("POST /api/projections", runProjectionHandler)
+	http.HandleFunc("GET /api/assessment/production", getProductionFit)
+	http.HandleFunc("POST /api/assessment/production", postProductionFit)
+	http.HandleFunc("GET /api/assessment/per-recruit", getPerRecruit)
+	http.HandleFunc("GET /api/env/layers", listEnvLayers)
+	http.HandleFunc("POST /api/env/layers", uploadEnvLayer)
+	http.HandleFunc("DELETE /api/env/layers/{id}", deleteEnvLayer)
+	http.HandleFunc("GET /api/sdm/{species_id}", getSDMModel)
+	http.HandleFunc("GET /api/sdm/{species_id}/suitability", getSDMSuitability)
+	http.HandleFunc("GET /api/sdm/{species_id}/tiles/{z}/{x}/{y}", getSDMTile)
+	http.HandleFunc("GET /api/biodiversity/diversity", getDiversity)
+	http.HandleFunc("GET /api/biodiversity/beta", getBetaDiversity)
+	http.HandleFunc("GET /api/foodweb", getFoodWeb)
+	http.HandleFunc("GET /api/foodweb/metrics", getFoodWebMetrics)
+	http.HandleFunc("POST /api/foodweb/rebuild", rebuildFoodWebHandler)
+	http.HandleFunc("POST /api/foodweb/links", createFoodWebLink)
+	http.HandleFunc("DELETE /api/foodweb/links/{id}", deleteFoodWebLink)
+	http.HandleFunc("GET /api/plankton/tows", listPlanktonTows)
+	http.HandleFunc("POST /api/plankton/tows", createPlanktonTow)
+	http.HandleFunc("GET /api/plankton/tows/{id}", getPlanktonTow)
+	http.HandleFunc("GET /api/plankton/abundance", getPlanktonAbundance)
+	http.HandleFunc("GET /api/plankton/composition", getPlanktonComposition)
+	http.HandleFunc("GET /api/plankton/ratio", getPlanktonRatio)
+	http.HandleFunc("GET /api/aquaculture/farms", listAquaFarms)
+	http.HandleFunc("POST /api/aquaculture/farms", createAquaFarm)
+	http.HandleFunc("GET /api/aquaculture/farms/{id}", getAquaFarm)
+	http.HandleFunc("GET /api/aquaculture/farms/{id}/performance", getFarmPerformance)
+	http.HandleFunc("POST /api/aquaculture/farms/{id}/units", createAquaUnit)
+	http.HandleFunc("POST /api/aquaculture/units/{id}/cohorts", createAquaCohort)
+	http.HandleFunc("GET /api/aquaculture/units/{id}/water-quality", getWaterQuality)
+	http.HandleFunc("POST /api/aquaculture/units/{id}/water-quality", addWaterQuality)
+	http.HandleFunc("GET /api/aquaculture/cohorts", listAquaCohorts)
+	http.HandleFunc("GET /api/aquaculture/cohorts/{id}", getAquaCohort)
+	http.HandleFunc("GET /api/aquaculture/cohorts/{id}/records", getCohortRecords)
+	http.HandleFunc("POST /api/aquaculture/cohorts/{id}/records", addCohortRecords)
+	http.HandleFunc("POST /api/aquaculture/cohorts/{id}/harvest", harvestAquaCohort)
+	http.HandleFunc("GET /api/aquaculture/cohorts/{id}/performance", getCohortPerformance)
+	http.HandleFunc("GET /api/aquaculture/cohorts/{id}/series", getCohortSeries)
+	http.HandleFunc("GET /api/conservation/categories", listIUCNCategories)
+	http.HandleFunc("GET /api/conservation/changes", getStatusChanges)
+	http.HandleFunc("DELETE /api/conservation/assessments/{id}", deleteAssessment)
+	http.HandleFunc("GET /api/mpas", listMPAs)
+	http.HandleFunc("POST /api/mpas", importMPAs)
+	http.HandleFunc("GET /api/mpas/coverage", getMPACoverage)
+	http.HandleFunc("GET /api/mpas/gaps", getProtectionGaps)
+	http.HandleFunc("GET /api/mpas/{id}", getMPA)
+	http.HandleFunc("DELETE /api/mpas/{id}", deleteMPA)
+
+	http.HandleFunc("POST /api/uploads", createUpload)
+	http.HandleFunc("HEAD /api/uploads/{id}", headUpload)
+	http.HandleFunc("PATCH /api/uploads/{id}", patchUpload)
+	http.HandleFunc("DELETE /api/uploads/{id}", deleteUpload)
+	http.HandleFunc("GET /api/uploads/{id}", getUploadStatus)
+
 	log.Println("Server starting on :8080")
 	log.Fatal(http.ListenAndServe(":8080", enableCORS(http.DefaultServeMux)))
 }
@@ -151,10 +293,14 @@ http.Handle("/api/images/otoliths/", http.StripPrefix("/api/images/otoliths/", h
 func enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "http://localhost:5173")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, PATCH, HEAD, DELETE")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+strings.Join(tusHeaders, ", "))
+		w.Header().Set("Access-Control-Expose-Headers", strings.Join(tusHeaders, ", ")+", X-Checksum-SHA256")
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 		if r.Method == "OPTIONS" {
+			if strings.HasPrefix(r.URL.Path, "/api/uploads") {
+				tusOptions(w)
+			}
 			w.WriteHeader(http.StatusOK)
 			return
 		}
@@ -162,70 +308,11 @@ func enableCORS(next http.Handler) http.Handler {
 	})
 }
 
-// --- Handlers ---
-// ... (All handler functions remain unchanged as they were correct) ...
-func getClasses(w http.ResponseWriter, r *http.Request) {
-	rows, err := db.Query("SELECT DISTINCT class FROM species_data WHERE class IS NOT NULL ORDER BY class")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer rows.Close()
-
-	var classes []string
-	for rows.Next() {
-		var class string
-		if err := rows.Scan(&class); err != nil {
-			continue
-		}
-		classes = append(classes, class)
-	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(classes)
-}
-
-func getRegions(w http.ResponseWriter, r *http.Request) {
-	rows, err := db.Query("SELECT DISTINCT region FROM occurrence_data WHERE region IS NOT NULL ORDER BY region")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer rows.Close()
-
-	var regions []string
-	for rows.Next() {
-		var region string
-		if err := rows.Scan(&region); err != nil {
-			continue
-		}
-		regions = append(regions, region)
-	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(regions)
-}
-
-func getConservationStatuses(w http.ResponseWriter, r *http.Request) {
-	rows, err := db.Query("SELECT DISTINCT conservation_status FROM species_data WHERE conservation_status IS NOT NULL ORDER BY conservation_status")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer rows.Close()
+// --- Species Scanning ---
 
-	var statuses []string
-	for rows.Next() {
-		var status string
-		if err := rows.Scan(&status); err != nil {
-			continue
-		}
-		statuses = append(statuses, status)
-	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(statuses)
-}
-
-func getSpecies(w http.ResponseWriter, r *http.Request) {
-	selectFields := `
+// speciesSelectFields is the column list scanned by scanSpecies; queries
+// must alias species_data as s.
+const speciesSelectFields = `
 		s.id, 
 		COALESCE(s.vernacularname, ''), 
 		COALESCE(s.scientific_name, ''), 
@@ -264,87 +351,53 @@ func getSpecies(w http.ResponseWriter, r *http.Request) {
 		COALESCE(s.thermal_tolerance, ''),
 		COALESCE(s.salinity_tolerance, ''),
 		COALESCE(s.metabolic_rate, 0),
-		COALESCE(s.o2_efficiency, 0)`
-
-	baseQuery := "SELECT DISTINCT " + selectFields + " FROM species_data s"
-	joinClause := ""
-	whereClauses := []string{"1=1"}
-	args := []interface{}{}
-	argCount := 1
-
-	// Region Filter
-	if region := r.URL.Query().Get("region"); region != "" {
-		joinClause = " INNER JOIN occurrence_data o ON s.id = o.species_id"
-		whereClauses = append(whereClauses, "o.region = $"+strconv.Itoa(argCount))
-		args = append(args, region)
-		argCount++
-	}
-
-	// Time Filter - FIXED: Now using parameterized query
-	if timeFilter := r.URL.Query().Get("time"); timeFilter != "" {
-		if joinClause == "" {
-			joinClause = " INNER JOIN occurrence_data o ON s.id = o.species_id"
-		}
+		COALESCE(s.o2_efficiency, 0),
+		COALESCE(s.iucn_category::text, '')`
 
-		now := time.Now()
-		var dateStr string
-		switch timeFilter {
-		case "24h":
-			dateStr = now.Add(-24 * time.Hour).Format("2006-01-02")
-		case "7d":
-			dateStr = now.AddDate(0, 0, -7).Format("2006-01-02")
-		case "1m":
-			dateStr = now.AddDate(0, -1, 0).Format("2006-01-02")
-		case "1y":
-			dateStr = now.AddDate(-1, 0, 0).Format("2006-01-02")
-		case "5y":
-			dateStr = now.AddDate(-5, 0, 0).Format("2006-01-02")
-		}
-
-		if dateStr != "" {
-			whereClauses = append(whereClauses, "o.eventdate >= $"+strconv.Itoa(argCount))
-			args = append(args, dateStr)
-			argCount++
-		}
-	}
+func scanSpecies(row interface{ Scan(...any) error }) (Species, error) {
+	var s Species
+	var imageURLs, reportedRegions sql.NullString
 
-	// Class Filter
-	if class := r.URL.Query().Get("class"); class != "" {
-		whereClauses = append(whereClauses, "s.class = $"+strconv.Itoa(argCount))
-		args = append(args, class)
-		argCount++
+	err := row.Scan(&s.ID, &s.VernacularName, &s.ScientificName, &imageURLs, &s.Kingdom, &s.Phylum, &s.Class, &s.Order, &s.Family, &s.Genus, &s.Species, &s.HabitatType, &s.Diet, &reportedRegions, &s.MaxLengthCm, &s.MaxWeightKg, &s.MaxAgeYears, &s.AgeOfMaturityYears, &s.DepthRangeMin, &s.DepthRangeMax, &s.ConservationStatus, &s.Fecundity, &s.SpawningSeason, &s.MaturitySize, &s.SexRatio, &s.Recruitment, &s.MortalityRate, &s.Longevity, &s.DietComposition, &s.TrophicLevel, &s.LarvalSurvival, &s.LarvalDuration, &s.MetamorphosisTiming, &s.MigrationPatterns, &s.HabitatPreference, &s.ThermalTolerance, &s.SalinityTolerance, &s.MetabolicRate, &s.O2Efficiency, &s.IUCNCategory)
+	if err != nil {
+		return s, err
 	}
 
-	// Conservation Status Filter
-	if status := r.URL.Query().Get("conservation_status"); status != "" {
-		whereClauses = append(whereClauses, "s.conservation_status = $"+strconv.Itoa(argCount))
-		args = append(args, status)
-		argCount++
+	if imageURLs.Valid && imageURLs.String != "" {
+		s.ImageURLs = strings.Split(strings.Trim(imageURLs.String, "{}"), ",")
 	}
-
-	// Depth Range Filters
-	if minDepth := r.URL.Query().Get("min_depth"); minDepth != "" {
-		whereClauses = append(whereClauses, "s.depth_range_min >= $"+strconv.Itoa(argCount))
-		args = append(args, minDepth)
-		argCount++
+	if reportedRegions.Valid && reportedRegions.String != "" {
+		s.ReportedRegions = strings.Split(strings.Trim(reportedRegions.String, "{}"), ",")
 	}
+	return s, nil
+}
 
-	if maxDepth := r.URL.Query().Get("max_depth"); maxDepth != "" {
-		whereClauses = append(whereClauses, "s.depth_range_max <= $"+strconv.Itoa(argCount))
-		args = append(args, maxDepth)
-		argCount++
+// querySpecies loads every species matching the given WHERE clause.
+func querySpecies(where string, args ...interface{}) ([]Species, error) {
+	rows, err := db.Query("SELECT "+speciesSelectFields+" FROM species_data s WHERE "+where, args...)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	// Search Filter
-	if search := r.URL.Query().Get("search"); search != "" {
-		whereClauses = append(whereClauses, "(s.vernacularname ILIKE $"+strconv.Itoa(argCount)+" OR s.scientific_name ILIKE $"+strconv.Itoa(argCount)+")")
-		args = append(args, "%"+search+"%")
-		argCount++
+	var speciesList []Species
+	for rows.Next() {
+		s, err := scanSpecies(rows)
+		if err != nil {
+			continue
+		}
+		speciesList = append(speciesList, s)
 	}
+	return speciesList, rows.Err()
+}
 
-	finalQuery := baseQuery + joinClause + " WHERE " + strings.Join(whereClauses, " AND ")
+// --- Handlers ---
+// ... (All handler functions remain unchanged as they were correct) ...
+func getSpecies(w http.ResponseWriter, r *http.Request) {
+	f := buildSpeciesFilter(r.URL.Query(), "")
+	finalQuery := "SELECT DISTINCT " + speciesSelectFields + f.from() + f.whereSQL()
 
-	rows, err := db.Query(finalQuery, args...)
+	rows, err := db.Query(finalQuery, f.args...)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -353,21 +406,10 @@ func getSpecies(w http.ResponseWriter, r *http.Request) {
 
 	var speciesList []Species
 	for rows.Next() {
-		var s Species
-		var imageURLs, reportedRegions sql.NullString
-
-		err := rows.Scan(&s.ID, &s.VernacularName, &s.ScientificName, &imageURLs, &s.Kingdom, &s.Phylum, &s.Class, &s.Order, &s.Family, &s.Genus, &s.Species, &s.HabitatType, &s.Diet, &reportedRegions, &s.MaxLengthCm, &s.MaxWeightKg, &s.MaxAgeYears, &s.AgeOfMaturityYears, &s.DepthRangeMin, &s.DepthRangeMax, &s.ConservationStatus, &s.Fecundity, &s.SpawningSeason, &s.MaturitySize, &s.SexRatio, &s.Recruitment, &s.MortalityRate, &s.Longevity, &s.DietComposition, &s.TrophicLevel, &s.LarvalSurvival, &s.LarvalDuration, &s.MetamorphosisTiming, &s.MigrationPatterns, &s.HabitatPreference, &s.ThermalTolerance, &s.SalinityTolerance, &s.MetabolicRate, &s.O2Efficiency)
-
+		s, err := scanSpecies(rows)
 		if err != nil {
 			continue
 		}
-
-		if imageURLs.Valid && imageURLs.String != "" {
-			s.ImageURLs = strings.Split(strings.Trim(imageURLs.String, "{}"), ",")
-		}
-		if reportedRegions.Valid && reportedRegions.String != "" {
-			s.ReportedRegions = strings.Split(strings.Trim(reportedRegions.String, "{}"), ",")
-		}
 		speciesList = append(speciesList, s)
 	}
 
@@ -378,65 +420,12 @@ func getSpecies(w http.ResponseWriter, r *http.Request) {
 func getSpeciesDetail(w http.ResponseWriter, r *http.Request) {
 	id := strings.TrimPrefix(r.URL.Path, "/api/species/")
 
-	var s Species
-	var imageURLs, reportedRegions sql.NullString
-
-	query := `SELECT 
-		id, 
-		COALESCE(vernacularname, ''), 
-		COALESCE(scientific_name, ''), 
-		image_urls, 
-		COALESCE(kingdom, ''), 
-		COALESCE(phylum, ''), 
-		COALESCE(class, ''), 
-		COALESCE(_order, ''), 
-		COALESCE(family, ''), 
-		COALESCE(genus, ''), 
-		COALESCE(species, ''), 
-		COALESCE(habitat_type, ''), 
-		COALESCE(diet, ''), 
-		reported_regions, 
-		COALESCE(max_length_cm, 0), 
-		COALESCE(max_weight_kg, 0),
-		COALESCE(max_age_years, 0),
-		COALESCE(age_of_maturity_years, 0), 
-		COALESCE(depth_range_min, 0), 
-		COALESCE(depth_range_max, 0), 
-		COALESCE(conservation_status, 'Unknown'),
-		COALESCE(fecundity, ''),
-		COALESCE(spawning_season, ''),
-		COALESCE(maturity_size, 0),
-		COALESCE(sex_ratio, ''),
-		COALESCE(recruitment, ''),
-		COALESCE(mortality_rate, 0),
-		COALESCE(longevity, 0),
-		COALESCE(diet_composition, ''),
-		COALESCE(trophic_level, 0),
-		COALESCE(larval_survival, 0),
-		COALESCE(larval_duration, ''),
-		COALESCE(metamorphosis_timing, ''),
-		COALESCE(migration_patterns, ''),
-		COALESCE(habitat_preference, ''),
-		COALESCE(thermal_tolerance, ''),
-		COALESCE(salinity_tolerance, ''),
-		COALESCE(metabolic_rate, 0),
-		COALESCE(o2_efficiency, 0)
-		FROM species_data WHERE id = $1`
-
-	err := db.QueryRow(query, id).Scan(&s.ID, &s.VernacularName, &s.ScientificName, &imageURLs, &s.Kingdom, &s.Phylum, &s.Class, &s.Order, &s.Family, &s.Genus, &s.Species, &s.HabitatType, &s.Diet, &reportedRegions, &s.MaxLengthCm, &s.MaxWeightKg, &s.MaxAgeYears, &s.AgeOfMaturityYears, &s.DepthRangeMin, &s.DepthRangeMax, &s.ConservationStatus, &s.Fecundity, &s.SpawningSeason, &s.MaturitySize, &s.SexRatio, &s.Recruitment, &s.MortalityRate, &s.Longevity, &s.DietComposition, &s.TrophicLevel, &s.LarvalSurvival, &s.LarvalDuration, &s.MetamorphosisTiming, &s.MigrationPatterns, &s.HabitatPreference, &s.ThermalTolerance, &s.SalinityTolerance, &s.MetabolicRate, &s.O2Efficiency)
-
+	s, err := scanSpecies(db.QueryRow("SELECT "+speciesSelectFields+" FROM species_data s WHERE s.id = $1", id))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
-	if imageURLs.Valid && imageURLs.String != "" {
-		s.ImageURLs = strings.Split(strings.Trim(imageURLs.String, "{}"), ",")
-	}
-	if reportedRegions.Valid && reportedRegions.String != "" {
-		s.ReportedRegions = strings.Split(strings.Trim(reportedRegions.String, "{}"), ",")
-	}
-
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(s)
 }
@@ -469,10 +458,14 @@ func getLatestSighting(w http.ResponseWriter, r *http.Request) {
 	speciesID := strings.TrimPrefix(r.URL.Path, "/api/latest-sighting/")
 	var sighting LatestSighting
 
-	err := db.QueryRow("SELECT eventdate, region, COALESCE(waterdepth_m, 0), recordedby FROM occurrence_data WHERE species_id = $1 ORDER BY eventdate DESC LIMIT 1", speciesID).Scan(&sighting.Date, &sighting.Location, &sighting.WaterDepth, &sighting.RecordedBy)
+	err := db.QueryRow("SELECT eventdate, COALESCE(region, ''), COALESCE(waterdepth_m, 0), COALESCE(recordedby, '') FROM occurrence_data WHERE species_id = $1 ORDER BY eventdate DESC LIMIT 1", speciesID).Scan(&sighting.Date, &sighting.Location, &sighting.WaterDepth, &sighting.RecordedBy)
 
+	if err == sql.ErrNoRows {
+		http.Error(w, "No sightings recorded for this species", http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
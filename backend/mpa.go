@@ -0,0 +1,788 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/lib/pq"
+)
+
+// --- Marine Protected Areas ---
+//
+// MPA boundaries are imported from GeoJSON or zipped ESRI Shapefiles,
+// typically WDPA extracts, whose attribute names are recognised. Each
+// polygon feature becomes one MPA, stored as GeoJSON coordinates with its
+// bounding box and area. Overlays with occurrence records run in memory
+// against a cache of the parsed polygons, checking bounding boxes first.
+//
+// No-take status comes from WDPA's NO_TAKE attribute ("All") or a no_take
+// property; ?no_take=true on the overlay endpoints counts only those MPAs.
+
+const mpaSchema = `
+CREATE TABLE IF NOT EXISTS marine_protected_areas (
+	id               SERIAL PRIMARY KEY,
+	name             TEXT NOT NULL,
+	designation      TEXT,
+	iucn_management  TEXT,
+	no_take          BOOLEAN NOT NULL DEFAULT false,
+	established_year INTEGER,
+	wdpa_id          TEXT UNIQUE,
+	region           TEXT,
+	source           TEXT,
+	area_km2         DOUBLE PRECISION NOT NULL,
+	min_lon          DOUBLE PRECISION NOT NULL,
+	min_lat          DOUBLE PRECISION NOT NULL,
+	max_lon          DOUBLE PRECISION NOT NULL,
+	max_lat          DOUBLE PRECISION NOT NULL,
+	geometry         JSONB NOT NULL,
+	submitted_by     TEXT NOT NULL,
+	created_at       TIMESTAMPTZ NOT NULL DEFAULT now()
+);`
+
+const maxMPAUploadFeatures = 5000
+
+// mpaAttributes are the feature properties read for each column, in order
+// of preference; WDPA names come first.
+var mpaAttributes = map[string][]string{
+	"name":             {"NAME", "name", "Name", "ORIG_NAME", "SITE_NAME"},
+	"designation":      {"DESIG_ENG", "DESIG", "designation", "Designation"},
+	"iucn_management":  {"IUCN_CAT", "iucn_cat", "iucn_category"},
+	"no_take":          {"NO_TAKE", "no_take"},
+	"established_year": {"STATUS_YR", "established_year", "year"},
+	"wdpa_id":          {"WDPAID", "WDPA_PID", "wdpa_id"},
+}
+
+func featureAttribute(props map[string]string, column, override string) string {
+	keys := mpaAttributes[column]
+	if override != "" {
+		keys = append([]string{override}, keys...)
+	}
+	for _, k := range keys {
+		if v := strings.TrimSpace(props[k]); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+type MPA struct {
+	ID              int          `json:"id"`
+	Name            string       `json:"name"`
+	Designation     string       `json:"designation,omitempty"`
+	IUCNManagement  string       `json:"iucn_management,omitempty"`
+	NoTake          bool         `json:"no_take"`
+	EstablishedYear *int         `json:"established_year,omitempty"`
+	WDPAID          string       `json:"wdpa_id,omitempty"`
+	Region          string       `json:"region,omitempty"`
+	Source          string       `json:"source,omitempty"`
+	AreaKm2         float64      `json:"area_km2"`
+	BBox            [4]float64   `json:"bbox"`
+	CreatedAt       string       `json:"created_at"`
+	Geometry        MultiPolygon `json:"-"`
+}
+
+const mpaColumns = `id, name, COALESCE(designation, ''), COALESCE(iucn_management, ''), no_take, established_year, COALESCE(wdpa_id, ''),
+	COALESCE(region, ''), COALESCE(source, ''), area_km2, min_lon, min_lat, max_lon, max_lat, created_at::text`
+
+func scanMPA(row interface{ Scan(...any) error }, geometry bool) (*MPA, error) {
+	var m MPA
+	var year sql.NullInt64
+	var geom []byte
+	dest := []any{&m.ID, &m.Name, &m.Designation, &m.IUCNManagement, &m.NoTake, &year, &m.WDPAID, &m.Region, &m.Source,
+		&m.AreaKm2, &m.BBox[0], &m.BBox[1], &m.BBox[2], &m.BBox[3], &m.CreatedAt}
+	if geometry {
+		dest = append(dest, &geom)
+	}
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	if year.Valid {
+		y := int(year.Int64)
+		m.EstablishedYear = &y
+	}
+	if geometry {
+		if err := json.Unmarshal(geom, &m.Geometry); err != nil {
+			return nil, fmt.Errorf("MPA %d: %w", m.ID, err)
+		}
+	}
+	return &m, nil
+}
+
+func (m *MPA) bbox() BBox {
+	return BBox{m.BBox[0], m.BBox[1], m.BBox[2], m.BBox[3]}
+}
+
+// feature renders the MPA as a GeoJSON Feature.
+func (m *MPA) feature() map[string]interface{} {
+	props := map[string]interface{}{}
+	b, _ := json.Marshal(m)
+	json.Unmarshal(b, &props)
+	return map[string]interface{}{
+		"type":       "Feature",
+		"id":         m.ID,
+		"properties": props,
+		"geometry":   map[string]interface{}{"type": "MultiPolygon", "coordinates": m.Geometry},
+	}
+}
+
+var mpaCache = struct {
+	sync.Mutex
+	mpas []*MPA
+}{}
+
+// loadMPAs returns every MPA with its geometry, from the cache when it is
+// populated.
+func loadMPAs() ([]*MPA, error) {
+	mpaCache.Lock()
+	defer mpaCache.Unlock()
+	if mpaCache.mpas != nil {
+		return mpaCache.mpas, nil
+	}
+	rows, err := db.Query("SELECT " + mpaColumns + ", geometry FROM marine_protected_areas ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	mpas := []*MPA{}
+	for rows.Next() {
+		m, err := scanMPA(rows, true)
+		if err != nil {
+			return nil, err
+		}
+		mpas = append(mpas, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	mpaCache.mpas = mpas
+	return mpas, nil
+}
+
+func clearMPACache() {
+	mpaCache.Lock()
+	mpaCache.mpas = nil
+	mpaCache.Unlock()
+}
+
+// overlayMPAs returns the cached MPAs selected by ?no_take=true and
+// ?mpa_region=.
+func overlayMPAs(q map[string][]string) ([]*MPA, error) {
+	all, err := loadMPAs()
+	if err != nil {
+		return nil, err
+	}
+	get := func(k string) string {
+		if v := q[k]; len(v) > 0 {
+			return strings.TrimSpace(v[0])
+		}
+		return ""
+	}
+	noTake, region := get("no_take") == "true", get("mpa_region")
+	var out []*MPA
+	for _, m := range all {
+		if noTake && !m.NoTake || region != "" && !strings.EqualFold(m.Region, region) {
+			continue
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// containing returns the MPAs among mpas that contain the point.
+func containing(mpas []*MPA, lon, lat float64) []*MPA {
+	var in []*MPA
+	for _, m := range mpas {
+		if m.bbox().contains(lon, lat) && m.Geometry.contains(lon, lat) {
+			in = append(in, m)
+		}
+	}
+	return in
+}
+
+func listMPAs(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("format") == "geojson" {
+		mpas, err := overlayMPAs(map[string][]string{"mpa_region": {q.Get("region")}, "no_take": {q.Get("no_take")}})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		features := []interface{}{}
+		for _, m := range mpas {
+			features = append(features, m.feature())
+		}
+		w.Header().Set("Content-Type", "application/geo+json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"type": "FeatureCollection", "features": features})
+		return
+	}
+
+	where := []string{"1=1"}
+	args := []interface{}{}
+	for _, f := range []struct{ param, cond string }{
+		{"region", "region ILIKE $%d"},
+		{"search", "name ILIKE '%%' || $%d || '%%'"},
+		{"no_take", "no_take = $%d::boolean"},
+	} {
+		if v := strings.TrimSpace(q.Get(f.param)); v != "" {
+			args = append(args, v)
+			where = append(where, fmt.Sprintf(f.cond, len(args)))
+		}
+	}
+	page, pageSize := pagination(r)
+	args = append(args, pageSize, (page-1)*pageSize)
+	rows, err := db.Query(fmt.Sprintf("SELECT "+mpaColumns+" FROM marine_protected_areas WHERE %s ORDER BY name LIMIT $%d OFFSET $%d",
+		strings.Join(where, " AND "), len(args)-1, len(args)), args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer rows.Close()
+	mpas := []*MPA{}
+	for rows.Next() {
+		m, err := scanMPA(rows, false)
+		if err != nil {
+			continue
+		}
+		mpas = append(mpas, m)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(mpas)
+}
+
+func getMPA(w http.ResponseWriter, r *http.Request) {
+	m, err := scanMPA(db.QueryRow("SELECT "+mpaColumns+", geometry FROM marine_protected_areas WHERE id = $1::int", r.PathValue("id")), true)
+	if err == sql.ErrNoRows {
+		http.Error(w, "MPA not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/geo+json")
+	json.NewEncoder(w).Encode(m.feature())
+}
+
+// importMPAs stores the polygon features of an uploaded GeoJSON file, or
+// of a shapefile uploaded as a zip or as its .shp, .dbf and .prj parts.
+// Form fields: name_field names the attribute holding MPA names; region,
+// source and designation apply to every feature. Features whose WDPA id
+// is already stored replace the stored boundary.
+func importMPAs(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireRole(w, r, contributorRoles...)
+	if !ok {
+		return
+	}
+	fields, files, err := readUploadForm(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer func() {
+		for _, f := range files {
+			f.Remove()
+		}
+	}()
+	if len(files) == 0 {
+		http.Error(w, "Upload a GeoJSON file or a shapefile", http.StatusBadRequest)
+		return
+	}
+
+	var features []GeoFeature
+	var skipped int
+	switch ext := strings.ToLower(filepath.Ext(files[0].Name)); {
+	case ext == ".geojson" || ext == ".json":
+		if len(files) != 1 {
+			http.Error(w, "Upload one GeoJSON file at a time", http.StatusBadRequest)
+			return
+		}
+		data, err := os.ReadFile(files[0].Path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		features, skipped, err = parseGeoJSON(data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	default:
+		shp, dbf, prj, err := readShapefileParts(files, func(f *spooledFile) ([]byte, error) { return os.ReadFile(f.Path) })
+		if err == nil {
+			features, skipped, err = parseShapefile(shp, dbf, prj)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	if len(features) == 0 {
+		http.Error(w, "The file has no polygon features", http.StatusUnprocessableEntity)
+		return
+	}
+	if len(features) > maxMPAUploadFeatures {
+		http.Error(w, fmt.Sprintf("The file has %d features; import at most %d at a time", len(features), maxMPAUploadFeatures), http.StatusUnprocessableEntity)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	nameField := strings.TrimSpace(fields.Get("name_field"))
+	base := strings.TrimSuffix(files[0].Name, filepath.Ext(files[0].Name))
+	imported := []*MPA{}
+	for i, f := range features {
+		name := featureAttribute(f.Properties, "name", nameField)
+		if name == "" {
+			name = fmt.Sprintf("%s #%d", base, i+1)
+		}
+		designation := featureAttribute(f.Properties, "designation", "")
+		if designation == "" {
+			designation = strings.TrimSpace(fields.Get("designation"))
+		}
+		var year *int
+		if y, err := strconv.Atoi(featureAttribute(f.Properties, "established_year", "")); err == nil && y > 0 {
+			year = &y
+		}
+		noTake := false
+		switch strings.ToLower(featureAttribute(f.Properties, "no_take", "")) {
+		case "all", "true", "yes", "1":
+			noTake = true
+		}
+		wdpa := featureAttribute(f.Properties, "wdpa_id", "")
+		geom, err := json.Marshal(f.Geometry)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		b := f.Geometry.bbox()
+
+		m, err := scanMPA(tx.QueryRow(`INSERT INTO marine_protected_areas (name, designation, iucn_management, no_take, established_year, wdpa_id,
+				region, source, area_km2, min_lon, min_lat, max_lon, max_lat, geometry, submitted_by)
+			VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), $4, $5, NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''), $9, $10, $11, $12, $13, $14, $15)
+			ON CONFLICT (wdpa_id) DO UPDATE SET name = EXCLUDED.name, designation = EXCLUDED.designation,
+				iucn_management = EXCLUDED.iucn_management, no_take = EXCLUDED.no_take, established_year = EXCLUDED.established_year,
+				region = COALESCE(EXCLUDED.region, marine_protected_areas.region), source = COALESCE(EXCLUDED.source, marine_protected_areas.source),
+				area_km2 = EXCLUDED.area_km2, min_lon = EXCLUDED.min_lon, min_lat = EXCLUDED.min_lat, max_lon = EXCLUDED.max_lon,
+				max_lat = EXCLUDED.max_lat, geometry = EXCLUDED.geometry
+			RETURNING `+mpaColumns,
+			name, designation, featureAttribute(f.Properties, "iucn_management", ""), noTake, year, wdpa,
+			strings.TrimSpace(fields.Get("region")), strings.TrimSpace(fields.Get("source")), f.Geometry.areaKm2(),
+			b.MinLon, b.MinLat, b.MaxLon, b.MaxLat, geom, user.ID), false)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("feature %d: %v", i+1, err), http.StatusBadRequest)
+			return
+		}
+		imported = append(imported, m)
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	clearMPACache()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"imported": imported, "skipped": skipped})
+}
+
+func deleteMPA(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireRole(w, r, moderatorRoles...); !ok {
+		return
+	}
+	res, err := db.Exec("DELETE FROM marine_protected_areas WHERE id = $1::int", r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "MPA not found", http.StatusNotFound)
+		return
+	}
+	clearMPACache()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type occurrencePoint struct {
+	ID        int     `json:"id"`
+	SpeciesID int     `json:"species_id"`
+	Longitude float64 `json:"longitude"`
+	Latitude  float64 `json:"latitude"`
+	EventDate string  `json:"eventdate,omitempty"`
+	Region    string  `json:"region,omitempty"`
+}
+
+// occurrencePoints loads georeferenced occurrences matching the species,
+// ?region=, ?from= and ?to= filters; speciesIDs nil means all species.
+func occurrencePoints(q map[string][]string, speciesIDs []int) ([]occurrencePoint, error) {
+	where := []string{"o.decimallatitude IS NOT NULL", "o.decimallongitude IS NOT NULL", "o.species_id IS NOT NULL"}
+	args := []interface{}{}
+	if speciesIDs != nil {
+		args = append(args, pq.Array(speciesIDs))
+		where = append(where, fmt.Sprintf("o.species_id = ANY($%d)", len(args)))
+	}
+	for _, f := range []struct{ param, cond string }{
+		{"region", "o.region ILIKE $%d"},
+		{"from", "o.eventdate >= $%d"},
+		{"to", "o.eventdate <= $%d"},
+	} {
+		if v := q[f.param]; len(v) > 0 && strings.TrimSpace(v[0]) != "" {
+			args = append(args, strings.TrimSpace(v[0]))
+			where = append(where, fmt.Sprintf(f.cond, len(args)))
+		}
+	}
+	rows, err := db.Query(`SELECT o.id, o.species_id, o.decimallongitude, o.decimallatitude, COALESCE(o.eventdate::text, ''), COALESCE(o.region, '')
+		FROM occurrence_data o WHERE `+strings.Join(where, " AND ")+` ORDER BY o.id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []occurrencePoint
+	for rows.Next() {
+		var p occurrencePoint
+		if err := rows.Scan(&p.ID, &p.SpeciesID, &p.Longitude, &p.Latitude, &p.EventDate, &p.Region); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// getSpeciesMPAs lists the MPAs a species' occurrences fall in, with the
+// number of occurrences in each and the share of all its georeferenced
+// occurrences that are inside at least one.
+func getSpeciesMPAs(w http.ResponseWriter, r *http.Request) {
+	speciesID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid species id", http.StatusBadRequest)
+		return
+	}
+	if exists, err := speciesExists(speciesID); err != nil || !exists {
+		http.Error(w, "Species not found", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	mpas, err := overlayMPAs(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	points, err := occurrencePoints(q, []int{speciesID})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type mpaHit struct {
+		*MPA
+		Occurrences int    `json:"occurrences"`
+		FirstSeen   string `json:"first_seen,omitempty"`
+		LastSeen    string `json:"last_seen,omitempty"`
+	}
+	hits := map[int]*mpaHit{}
+	protected := 0
+	for _, p := range points {
+		in := containing(mpas, p.Longitude, p.Latitude)
+		if len(in) > 0 {
+			protected++
+		}
+		for _, m := range in {
+			h := hits[m.ID]
+			if h == nil {
+				h = &mpaHit{MPA: m}
+				hits[m.ID] = h
+			}
+			h.Occurrences++
+			if p.EventDate != "" && (h.FirstSeen == "" || p.EventDate < h.FirstSeen) {
+				h.FirstSeen = p.EventDate
+			}
+			if p.EventDate > h.LastSeen {
+				h.LastSeen = p.EventDate
+			}
+		}
+	}
+	out := []*mpaHit{}
+	for _, h := range hits {
+		out = append(out, h)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Occurrences > out[j].Occurrences })
+
+	resp := map[string]interface{}{
+		"species_id":  speciesID,
+		"occurrences": len(points),
+		"protected":   protected,
+		"fraction":    nil,
+		"mpas":        out,
+	}
+	if len(points) > 0 {
+		resp["fraction"] = float64(protected) / float64(len(points))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// speciesCategories returns the IUCN category of species, restricted to
+// the categories given when there are any.
+func speciesCategories(categories []string) (map[int]string, error) {
+	query := "SELECT id, COALESCE(iucn_category::text, '') FROM species_data"
+	args := []interface{}{}
+	if categories != nil {
+		query += " WHERE iucn_category::text = ANY($1)"
+		args = append(args, pq.Array(categories))
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[int]string{}
+	for rows.Next() {
+		var id int
+		var c string
+		if err := rows.Scan(&id, &c); err != nil {
+			return nil, err
+		}
+		out[id] = c
+	}
+	return out, rows.Err()
+}
+
+// speciesFilterIDs resolves ?species_id= and ?category= to the species to
+// overlay and their categories. ids is nil when every species applies.
+func speciesFilterIDs(q map[string][]string, defaultCategory string) (ids []int, categories map[int]string, err error) {
+	get := func(k string) string {
+		if v := q[k]; len(v) > 0 {
+			return strings.TrimSpace(v[0])
+		}
+		return ""
+	}
+	var cats []string
+	if v := get("category"); v != "" || defaultCategory != "" {
+		if v == "" {
+			v = defaultCategory
+		}
+		if cats, err = parseIUCNList(v); err != nil {
+			return nil, nil, err
+		}
+	}
+	if categories, err = speciesCategories(cats); err != nil {
+		return nil, nil, err
+	}
+	if v := get("species_id"); v != "" {
+		for _, s := range splitList([]string{v}, ",") {
+			id, err := strconv.Atoi(s)
+			if err != nil {
+				return nil, nil, fmt.Errorf("species_id must be a list of ids")
+			}
+			if _, ok := categories[id]; ok {
+				ids = append(ids, id)
+			}
+		}
+		if ids == nil {
+			ids = []int{}
+		}
+	} else if cats != nil {
+		ids = []int{}
+		for id := range categories {
+			ids = append(ids, id)
+		}
+	}
+	return ids, categories, nil
+}
+
+type SpeciesProtection struct {
+	SpeciesID      int     `json:"species_id"`
+	ScientificName string  `json:"scientific_name"`
+	Category       string  `json:"iucn_category,omitempty"`
+	Occurrences    int     `json:"occurrences"`
+	Protected      int     `json:"protected"`
+	Fraction       float64 `json:"fraction"`
+	MPAs           int     `json:"mpas"`
+}
+
+// getMPACoverage returns, per species, the fraction of georeferenced
+// occurrences inside MPAs, least protected first. Species can be chosen
+// with ?species_id= or by IUCN ?category=, and ?min_occurrences= drops
+// thinly recorded ones.
+func getMPACoverage(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	minOcc := 1
+	if v := q.Get("min_occurrences"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "min_occurrences must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		minOcc = n
+	}
+	ids, categories, err := speciesFilterIDs(q, "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mpas, err := overlayMPAs(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	points, err := occurrencePoints(q, ids)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	bySpecies := map[int]*SpeciesProtection{}
+	inMPAs := map[int]map[int]bool{}
+	for _, p := range points {
+		s := bySpecies[p.SpeciesID]
+		if s == nil {
+			s = &SpeciesProtection{SpeciesID: p.SpeciesID, Category: categories[p.SpeciesID]}
+			bySpecies[p.SpeciesID] = s
+			inMPAs[p.SpeciesID] = map[int]bool{}
+		}
+		s.Occurrences++
+		in := containing(mpas, p.Longitude, p.Latitude)
+		if len(in) > 0 {
+			s.Protected++
+		}
+		for _, m := range in {
+			inMPAs[p.SpeciesID][m.ID] = true
+		}
+	}
+	idSet := map[int]bool{}
+	out := []*SpeciesProtection{}
+	var total, protected int
+	for id, s := range bySpecies {
+		if s.Occurrences < minOcc {
+			continue
+		}
+		s.Fraction = float64(s.Protected) / float64(s.Occurrences)
+		s.MPAs = len(inMPAs[id])
+		idSet[id] = true
+		total += s.Occurrences
+		protected += s.Protected
+		out = append(out, s)
+	}
+	names, err := speciesNames(idSet)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, s := range out {
+		s.ScientificName = names[s.SpeciesID]
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Fraction != out[j].Fraction {
+			return out[i].Fraction < out[j].Fraction
+		}
+		return out[i].Occurrences > out[j].Occurrences
+	})
+
+	resp := map[string]interface{}{"mpas": len(mpas), "species": out, "occurrences": total, "protected": protected, "fraction": nil}
+	if total > 0 {
+		resp["fraction"] = float64(protected) / float64(total)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// getProtectionGaps lists occurrences of threatened species (CR, EN and VU
+// unless ?category= says otherwise) that fall outside every MPA, with the
+// nearest MPA and its distance, farthest first. Paged.
+func getProtectionGaps(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	ids, categories, err := speciesFilterIDs(q, "threatened")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mpas, err := overlayMPAs(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	points, err := occurrencePoints(q, ids)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type gap struct {
+		occurrencePoint
+		ScientificName string   `json:"scientific_name"`
+		Category       string   `json:"iucn_category"`
+		NearestMPA     *int     `json:"nearest_mpa_id"`
+		NearestName    string   `json:"nearest_mpa,omitempty"`
+		DistanceKm     *float64 `json:"distance_km"`
+	}
+	gaps := []*gap{}
+	perSpecies := map[int]int{}
+	idSet := map[int]bool{}
+	for _, p := range points {
+		if len(containing(mpas, p.Longitude, p.Latitude)) > 0 {
+			continue
+		}
+		g := &gap{occurrencePoint: p, Category: categories[p.SpeciesID]}
+		best := math.Inf(1)
+		for _, m := range mpas {
+			if d := m.Geometry.distanceKm(p.Longitude, p.Latitude); d < best {
+				best = d
+				id := m.ID
+				g.NearestMPA, g.NearestName = &id, m.Name
+			}
+		}
+		if g.NearestMPA != nil {
+			g.DistanceKm = &best
+		}
+		gaps = append(gaps, g)
+		perSpecies[p.SpeciesID]++
+		idSet[p.SpeciesID] = true
+	}
+	sort.SliceStable(gaps, func(i, j int) bool {
+		if gaps[i].DistanceKm == nil || gaps[j].DistanceKm == nil {
+			return gaps[j].DistanceKm != nil && gaps[i].DistanceKm == nil
+		}
+		return *gaps[i].DistanceKm > *gaps[j].DistanceKm
+	})
+
+	page, pageSize := pagination(r)
+	start := min((page-1)*pageSize, len(gaps))
+	end := min(start+pageSize, len(gaps))
+	pageGaps := gaps[start:end]
+	names, err := speciesNames(idSet)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, g := range pageGaps {
+		g.ScientificName = names[g.SpeciesID]
+	}
+	bySpecies := []map[string]interface{}{}
+	for id, n := range perSpecies {
+		bySpecies = append(bySpecies, map[string]interface{}{
+			"species_id": id, "scientific_name": names[id], "iucn_category": categories[id], "unprotected": n,
+		})
+	}
+	sort.Slice(bySpecies, func(i, j int) bool { return bySpecies[i]["unprotected"].(int) > bySpecies[j]["unprotected"].(int) })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"total":       len(gaps),
+		"page":        page,
+		"page_size":   pageSize,
+		"species":     bySpecies,
+		"occurrences": pageGaps,
+	})
+}
@@ -0,0 +1,740 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// --- Alert Subscriptions and Delivery ---
+//
+// Users subscribe to alert categories and regions, optionally with a
+// minimum severity, and choose email and/or webhook delivery. New alerts
+// are matched against subscriptions in SQL and queued in alert_deliveries;
+// a dispatcher sends them with exponential backoff. Email goes to
+// SMTP_HOST:SMTP_PORT (a local stand-in such as MailHog works), and is only
+// logged when SMTP_HOST is not set. Webhooks may not reach private or
+// local addresses unless ALERT_WEBHOOK_ALLOW_PRIVATE is set, for receivers
+// on the same network in a closed deployment.
+
+const alertSubscriptionSchema = `
+CREATE TABLE IF NOT EXISTS alert_subscriptions (
+	id             SERIAL PRIMARY KEY,
+	user_id        TEXT NOT NULL,
+	email          TEXT,
+	webhook_url    TEXT,
+	webhook_secret TEXT,
+	categories     TEXT[] NOT NULL DEFAULT '{}',
+	regions        TEXT[] NOT NULL DEFAULT '{}',
+	min_severity   TEXT NOT NULL DEFAULT 'low',
+	channels       TEXT[] NOT NULL DEFAULT '{}',
+	enabled        BOOLEAN NOT NULL DEFAULT true,
+	created_at     TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS alert_subscriptions_user_idx ON alert_subscriptions (user_id);
+
+CREATE TABLE IF NOT EXISTS alert_deliveries (
+	id              SERIAL PRIMARY KEY,
+	alert_id        INTEGER NOT NULL REFERENCES alerts(id) ON DELETE CASCADE,
+	subscription_id INTEGER NOT NULL REFERENCES alert_subscriptions(id) ON DELETE CASCADE,
+	channel         TEXT NOT NULL,
+	target          TEXT NOT NULL,
+	status          TEXT NOT NULL DEFAULT 'pending',
+	attempts        INTEGER NOT NULL DEFAULT 0,
+	last_error      TEXT,
+	next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	sent_at         TIMESTAMPTZ,
+	created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+	UNIQUE (alert_id, subscription_id, channel)
+);
+CREATE INDEX IF NOT EXISTS alert_deliveries_pending_idx ON alert_deliveries (next_attempt_at) WHERE status = 'pending';`
+
+const (
+	channelEmail   = "email"
+	channelWebhook = "webhook"
+
+	deliveryPending = "pending"
+	deliverySent    = "sent"
+	deliveryFailed  = "failed"
+
+	maxDeliveryAttempts = 5
+	deliveryBatchSize   = 50
+	dispatchInterval    = 15 * time.Second
+	smtpTimeout         = 30 * time.Second
+)
+
+// AlertNotifier sends one alert over one channel.
+type AlertNotifier interface {
+	Notify(ctx context.Context, target, secret string, a *Alert) error
+}
+
+var notifiers = map[string]AlertNotifier{}
+
+// allowPrivateWebhooks lifts the public-address check on webhook targets.
+var allowPrivateWebhooks bool
+
+func initNotifiers() {
+	allowPrivateWebhooks, _ = strconv.ParseBool(os.Getenv("ALERT_WEBHOOK_ALLOW_PRIVATE"))
+	notifiers[channelWebhook] = &webhookNotifier{client: &http.Client{
+		Timeout:   15 * time.Second,
+		Transport: &http.Transport{DialContext: publicDialContext, TLSHandshakeTimeout: 10 * time.Second},
+	}}
+
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		notifiers[channelEmail] = logNotifier{}
+		return
+	}
+	notifiers[channelEmail] = &smtpNotifier{
+		addr:     net.JoinHostPort(host, envOr("SMTP_PORT", "25")),
+		host:     host,
+		username: os.Getenv("SMTP_USERNAME"),
+		password: os.Getenv("SMTP_PASSWORD"),
+		from:     envOr("SMTP_FROM", "alerts@localhost"),
+	}
+}
+
+// --- Webhook ---
+
+type webhookNotifier struct {
+	client *http.Client
+}
+
+var errPrivateAddress = errors.New("webhook host resolves to a private or local address")
+
+// publicAddress reports whether ip may be reached by webhooks: anything
+// but loopback, private, link-local, multicast and unspecified addresses.
+func publicAddress(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
+
+// publicDialContext resolves the host itself and dials the resolved IP, so
+// a webhook URL (or a redirect, or a DNS answer that changes between check
+// and connect) cannot reach the backend's own network, unless
+// allowPrivateWebhooks is set.
+func publicDialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if !allowPrivateWebhooks && !publicAddress(ip.IP) {
+			return nil, errPrivateAddress
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no addresses for %s", host)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, network, net.JoinHostPort(ips[0].IP.String(), port))
+}
+
+// Notify posts the alert as JSON. With a secret, the body is signed as
+// X-Alert-Signature: sha256=<hex HMAC>.
+func (n *webhookNotifier) Notify(ctx context.Context, target, secret string, a *Alert) error {
+	body, err := json.Marshal(map[string]interface{}{"event": "alert." + a.Status, "alert": a})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Alert-ID", strconv.Itoa(a.ID))
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req.Header.Set("X-Alert-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	// Only the status is reported: the body is the receiver's and may not
+	// be echoed back to the subscriber or stored in last_error.
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// --- Email ---
+
+type smtpNotifier struct {
+	addr, host         string
+	username, password string
+	from               string
+}
+
+func alertEmail(from, to string, a *Alert) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\nTo: %s\r\n", from, to)
+	fmt.Fprintf(&b, "Subject: [%s] %s\r\n", strings.ToUpper(a.Severity), a.Title)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&b, "%s\r\n\r\n", a.Message)
+	fmt.Fprintf(&b, "Category: %s\r\nSeverity: %s\r\n", a.Category, a.Severity)
+	if a.Region != "" {
+		fmt.Fprintf(&b, "Region: %s\r\n", a.Region)
+	}
+	if a.Station != "" {
+		fmt.Fprintf(&b, "Station: %s\r\n", a.Station)
+	}
+	fmt.Fprintf(&b, "Started: %s\r\n", a.StartedAt)
+	fmt.Fprintf(&b, "\r\n%s/api/alerts/%d\r\n", publicBaseURL(), a.ID)
+	return []byte(b.String())
+}
+
+// Notify sends the alert as it would go through smtp.SendMail, but dials
+// and talks to the server within ctx, and at most smtpTimeout, so a mail
+// server that hangs cannot stall the dispatcher.
+func (n *smtpNotifier) Notify(ctx context.Context, target, secret string, a *Alert) error {
+	ctx, cancel := context.WithTimeout(ctx, smtpTimeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", n.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	// Closing the connection when ctx is cancelled unblocks any read.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	c, err := smtp.NewClient(conn, n.host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: n.host}); err != nil {
+			return err
+		}
+	}
+	if n.username != "" {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+		if err := c.Auth(smtp.PlainAuth("", n.username, n.password, n.host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(n.from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(target); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(alertEmail(n.from, target, a)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+type logNotifier struct{}
+
+func (logNotifier) Notify(ctx context.Context, target, secret string, a *Alert) error {
+	log.Printf("Alert %d for %s (SMTP_HOST not set): %s", a.ID, target, a.Title)
+	return nil
+}
+
+// --- Queue ---
+
+// queueAlertDeliveries queues alert id on every channel of the enabled
+// subscriptions that match it.
+func queueAlertDeliveries(id int, a *Alert) error {
+	rows, err := db.Query("SELECT " + subscriptionColumns + " FROM alert_subscriptions WHERE enabled ORDER BY id")
+	if err != nil {
+		return err
+	}
+	var subs []*AlertSubscription
+	for rows.Next() {
+		s, err := scanSubscription(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		subs = append(subs, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, s := range subs {
+		if !s.matches(a) {
+			continue
+		}
+		for _, ch := range s.Channels {
+			target := s.target(ch)
+			if target == "" {
+				continue
+			}
+			if _, err := db.Exec(`INSERT INTO alert_deliveries (alert_id, subscription_id, channel, target)
+				VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING`, id, s.ID, ch, target); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// dispatchDeliveries sends due deliveries, retrying failures after 1, 2,
+// 4 and 8 minutes before giving up.
+func dispatchDeliveries(ctx context.Context) {
+	rows, err := db.Query(`SELECT d.id, d.alert_id, d.channel, d.target, COALESCE(s.webhook_secret, ''), d.attempts
+		FROM alert_deliveries d JOIN alert_subscriptions s ON s.id = d.subscription_id
+		WHERE d.status = 'pending' AND d.next_attempt_at <= now() ORDER BY d.next_attempt_at LIMIT $1`, deliveryBatchSize)
+	if err != nil {
+		log.Println("Could not load alert deliveries:", err)
+		return
+	}
+	type pending struct {
+		id, alertID, attempts   int
+		channel, target, secret string
+	}
+	var due []pending
+	for rows.Next() {
+		var p pending
+		if rows.Scan(&p.id, &p.alertID, &p.channel, &p.target, &p.secret, &p.attempts) == nil {
+			due = append(due, p)
+		}
+	}
+	rows.Close()
+
+	alerts := map[int]*Alert{}
+	for _, p := range due {
+		a, ok := alerts[p.alertID]
+		if !ok {
+			if a, err = loadAlert(strconv.Itoa(p.alertID)); err != nil {
+				log.Printf("Could not load alert %d: %v", p.alertID, err)
+				continue
+			}
+			alerts[p.alertID] = a
+		}
+
+		err := fmt.Errorf("no notifier for channel %q", p.channel)
+		if n, ok := notifiers[p.channel]; ok {
+			err = n.Notify(ctx, p.target, p.secret, a)
+		}
+		if err == nil {
+			db.Exec("UPDATE alert_deliveries SET status = $2, attempts = attempts + 1, sent_at = now(), last_error = NULL WHERE id = $1",
+				p.id, deliverySent)
+			continue
+		}
+
+		status := deliveryPending
+		if p.attempts+1 >= maxDeliveryAttempts {
+			status = deliveryFailed
+		}
+		backoff := fmt.Sprintf("%d minutes", 1<<p.attempts)
+		db.Exec(`UPDATE alert_deliveries SET status = $2, attempts = attempts + 1, last_error = $3,
+			next_attempt_at = now() + $4::interval WHERE id = $1`, p.id, status, err.Error(), backoff)
+	}
+}
+
+func dispatchLoop() {
+	for {
+		dispatchDeliveries(context.Background())
+		time.Sleep(dispatchInterval)
+	}
+}
+
+// --- Subscriptions ---
+
+type AlertSubscription struct {
+	ID            int      `json:"id"`
+	UserID        string   `json:"user_id"`
+	Email         string   `json:"email,omitempty"`
+	WebhookURL    string   `json:"webhook_url,omitempty"`
+	HasSecret     bool     `json:"has_webhook_secret"`
+	Categories    []string `json:"categories"`
+	Regions       []string `json:"regions"`
+	MinSeverity   string   `json:"min_severity"`
+	Channels      []string `json:"channels"`
+	Enabled       bool     `json:"enabled"`
+	CreatedAt     string   `json:"created_at"`
+	UpdatedAt     string   `json:"updated_at"`
+	webhookSecret string
+}
+
+const subscriptionColumns = `id, user_id, COALESCE(email, ''), COALESCE(webhook_url, ''), COALESCE(webhook_secret, ''), categories,
+	regions, min_severity, channels, enabled, created_at::text, updated_at::text`
+
+func scanSubscription(row interface{ Scan(...any) error }) (*AlertSubscription, error) {
+	var s AlertSubscription
+	err := row.Scan(&s.ID, &s.UserID, &s.Email, &s.WebhookURL, &s.webhookSecret, pq.Array(&s.Categories), pq.Array(&s.Regions),
+		&s.MinSeverity, pq.Array(&s.Channels), &s.Enabled, &s.CreatedAt, &s.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	s.HasSecret = s.webhookSecret != ""
+	for _, list := range []*[]string{&s.Categories, &s.Regions, &s.Channels} {
+		if *list == nil {
+			*list = []string{}
+		}
+	}
+	return &s, nil
+}
+
+type subscriptionRequest struct {
+	Email         *string  `json:"email"`
+	WebhookURL    *string  `json:"webhook_url"`
+	WebhookSecret *string  `json:"webhook_secret"`
+	Categories    []string `json:"categories"`
+	Regions       []string `json:"regions"`
+	MinSeverity   string   `json:"min_severity"`
+	Channels      []string `json:"channels"`
+	Enabled       *bool    `json:"enabled"`
+}
+
+// apply merges the request into s and checks the result. accountEmail is
+// the signed-in user's verified address, the only one email alerts may go
+// to.
+func (req *subscriptionRequest) apply(s *AlertSubscription, accountEmail string) error {
+	if req.Email != nil {
+		email := strings.TrimSpace(*req.Email)
+		if email != "" && !strings.EqualFold(email, accountEmail) {
+			return fmt.Errorf("email alerts can only be sent to your own account address")
+		}
+		s.Email = email
+	}
+	if req.WebhookURL != nil {
+		s.WebhookURL = strings.TrimSpace(*req.WebhookURL)
+	}
+	if req.WebhookSecret != nil {
+		s.webhookSecret = *req.WebhookSecret
+	}
+	if req.Categories != nil {
+		s.Categories = splitList(req.Categories, ",")
+		for _, c := range s.Categories {
+			if !validAlertCategory(c) {
+				return fmt.Errorf("unknown category %q", c)
+			}
+		}
+	}
+	if req.Regions != nil {
+		s.Regions = []string{}
+		for _, r := range splitList(req.Regions, ",;") {
+			s.Regions = append(s.Regions, strings.ToLower(r))
+		}
+	}
+	if req.MinSeverity != "" {
+		if !validAlertSeverity(req.MinSeverity) {
+			return fmt.Errorf("min_severity must be low, medium, high or critical")
+		}
+		s.MinSeverity = req.MinSeverity
+	}
+	if req.Channels != nil {
+		s.Channels = splitList(req.Channels, ",")
+	}
+	if req.Enabled != nil {
+		s.Enabled = *req.Enabled
+	}
+
+	if len(s.Channels) == 0 {
+		return fmt.Errorf("choose at least one channel: email or webhook")
+	}
+	for _, ch := range s.Channels {
+		switch ch {
+		case channelEmail:
+			if !strings.Contains(s.Email, "@") || !strings.EqualFold(s.Email, accountEmail) {
+				return fmt.Errorf("email delivery needs a valid email address")
+			}
+		case channelWebhook:
+			u, err := url.Parse(s.WebhookURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("webhook delivery needs an http(s) webhook_url")
+			}
+			if allowPrivateWebhooks {
+				continue
+			}
+			if ip := net.ParseIP(u.Hostname()); (ip != nil && !publicAddress(ip)) || strings.EqualFold(u.Hostname(), "localhost") {
+				return errPrivateAddress
+			}
+		default:
+			return fmt.Errorf("unknown channel %q", ch)
+		}
+	}
+	return nil
+}
+
+// matches reports whether alert a should go to the subscription. An empty
+// category or region list matches everything; the "critical" category also
+// matches any critical-severity alert.
+func (s *AlertSubscription) matches(a *Alert) bool {
+	if !s.Enabled {
+		return false
+	}
+	if len(s.Categories) > 0 && !slices.Contains(s.Categories, a.Category) &&
+		!(a.Severity == severityCritical && slices.Contains(s.Categories, categoryCritical)) {
+		return false
+	}
+	if len(s.Regions) > 0 && !slices.Contains(s.Regions, strings.ToLower(a.Region)) {
+		return false
+	}
+	rank, floor := slices.Index(alertSeverities, a.Severity), slices.Index(alertSeverities, s.MinSeverity)
+	return rank >= 0 && floor >= 0 && rank >= floor
+}
+
+// target is the address a channel delivers to, or "" if it has none.
+func (s *AlertSubscription) target(channel string) string {
+	switch channel {
+	case channelEmail:
+		return s.Email
+	case channelWebhook:
+		return s.WebhookURL
+	}
+	return ""
+}
+
+// subscriptionForRequest loads the {id} subscription of the caller,
+// writing the error response itself when it returns nil.
+func subscriptionForRequest(w http.ResponseWriter, r *http.Request, user *AuthUser) *AlertSubscription {
+	s, err := scanSubscription(db.QueryRow("SELECT "+subscriptionColumns+" FROM alert_subscriptions WHERE id = $1", r.PathValue("id")))
+	if err == sql.ErrNoRows || (err == nil && s.UserID != user.ID && !user.hasRole(roleAdministrator)) {
+		http.Error(w, "Subscription not found", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+	return s
+}
+
+func listAlertSubscriptions(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	rows, err := db.Query("SELECT "+subscriptionColumns+" FROM alert_subscriptions WHERE user_id = $1 ORDER BY id", user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	subs := []*AlertSubscription{}
+	for rows.Next() {
+		s, err := scanSubscription(rows)
+		if err != nil {
+			continue
+		}
+		subs = append(subs, s)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(subs)
+}
+
+func createAlertSubscription(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	var req subscriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	s := &AlertSubscription{UserID: user.ID, Email: user.Email, Categories: []string{}, Regions: []string{},
+		MinSeverity: severityLow, Channels: []string{channelEmail}, Enabled: true}
+	if err := req.apply(s, user.Email); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var id int
+	err := db.QueryRow(`INSERT INTO alert_subscriptions (user_id, email, webhook_url, webhook_secret, categories, regions, min_severity, channels, enabled)
+		VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), NULLIF($4, ''), $5, $6, $7, $8, $9) RETURNING id`,
+		s.UserID, s.Email, s.WebhookURL, s.webhookSecret, pq.Array(s.Categories), pq.Array(s.Regions), s.MinSeverity,
+		pq.Array(s.Channels), s.Enabled).Scan(&id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	created, err := scanSubscription(db.QueryRow("SELECT "+subscriptionColumns+" FROM alert_subscriptions WHERE id = $1", id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+func updateAlertSubscription(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	s := subscriptionForRequest(w, r, user)
+	if s == nil {
+		return
+	}
+	var req subscriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	// An administrator editing someone else's subscription keeps its
+	// address; they cannot point it anywhere new.
+	accountEmail := user.Email
+	if s.UserID != user.ID {
+		accountEmail = s.Email
+	}
+	if err := req.apply(s, accountEmail); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err := db.Exec(`UPDATE alert_subscriptions SET email = NULLIF($2, ''), webhook_url = NULLIF($3, ''), webhook_secret = NULLIF($4, ''),
+			categories = $5, regions = $6, min_severity = $7, channels = $8, enabled = $9, updated_at = now()
+		WHERE id = $1`, s.ID, s.Email, s.WebhookURL, s.webhookSecret, pq.Array(s.Categories), pq.Array(s.Regions), s.MinSeverity,
+		pq.Array(s.Channels), s.Enabled)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	updated, err := scanSubscription(db.QueryRow("SELECT "+subscriptionColumns+" FROM alert_subscriptions WHERE id = $1", s.ID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+func deleteAlertSubscription(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	s := subscriptionForRequest(w, r, user)
+	if s == nil {
+		return
+	}
+	if _, err := db.Exec("DELETE FROM alert_subscriptions WHERE id = $1", s.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// testAlertSubscription sends a sample alert over each of the
+// subscription's channels right away and reports the outcome per channel,
+// for checking a webhook receiver or mail server.
+func testAlertSubscription(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	s := subscriptionForRequest(w, r, user)
+	if s == nil {
+		return
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	sample := &Alert{Category: categorySystem, Severity: severityLow, Type: "test", Title: "Test alert",
+		Message: "This is a test of your alert subscription.", StartedAt: now, Status: alertOpen,
+		Details: json.RawMessage("{}"), CreatedAt: now, UpdatedAt: now}
+
+	results := map[string]string{}
+	for _, ch := range s.Channels {
+		target := s.target(ch)
+		n, ok := notifiers[ch]
+		if !ok {
+			results[ch] = "no notifier configured"
+			continue
+		}
+		if err := n.Notify(r.Context(), target, s.webhookSecret, sample); err != nil {
+			results[ch] = err.Error()
+		} else {
+			results[ch] = "sent"
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// listAlertDeliveries shows the delivery log of one alert.
+func listAlertDeliveries(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireRole(w, r, moderatorRoles...); !ok {
+		return
+	}
+	rows, err := db.Query(`SELECT id, subscription_id, channel, target, status, attempts, COALESCE(last_error, ''),
+			COALESCE(sent_at::text, ''), created_at::text
+		FROM alert_deliveries WHERE alert_id = $1 ORDER BY id`, r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer rows.Close()
+
+	type delivery struct {
+		ID             int    `json:"id"`
+		SubscriptionID int    `json:"subscription_id"`
+		Channel        string `json:"channel"`
+		Target         string `json:"target"`
+		Status         string `json:"status"`
+		Attempts       int    `json:"attempts"`
+		LastError      string `json:"last_error,omitempty"`
+		SentAt         string `json:"sent_at,omitempty"`
+		CreatedAt      string `json:"created_at"`
+	}
+	deliveries := []delivery{}
+	for rows.Next() {
+		var d delivery
+		if err := rows.Scan(&d.ID, &d.SubscriptionID, &d.Channel, &d.Target, &d.Status, &d.Attempts, &d.LastError,
+			&d.SentAt, &d.CreatedAt); err != nil {
+			continue
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
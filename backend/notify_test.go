@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testAlert() *Alert {
+	return &Alert{ID: 42, Category: categoryEnvironmental, Severity: severityHigh, Type: "marine_heatwave",
+		Title: "Heatwave at KOCHI-1", Message: "SST 2.1 °C above the 90th percentile.", Region: "Kerala",
+		Station: "KOCHI-1", StartedAt: "2026-05-01", Status: alertOpen, Details: json.RawMessage("{}")}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var body []byte
+	var header http.Header
+	status := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		header = r.Header
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	n := &webhookNotifier{client: &http.Client{Transport: &http.Transport{DialContext: publicDialContext}}}
+	defer func(v bool) { allowPrivateWebhooks = v }(allowPrivateWebhooks)
+
+	allowPrivateWebhooks = false
+	if err := n.Notify(context.Background(), srv.URL, "", testAlert()); !errors.Is(err, errPrivateAddress) {
+		t.Fatalf("loopback receiver reached without opt-in: %v", err)
+	}
+
+	allowPrivateWebhooks = true
+	if err := n.Notify(context.Background(), srv.URL, "s3cret", testAlert()); err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Event string `json:"event"`
+		Alert Alert  `json:"alert"`
+	}
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Event != "alert.open" || got.Alert.ID != 42 || got.Alert.Title != "Heatwave at KOCHI-1" {
+		t.Errorf("payload = %s", body)
+	}
+	if header.Get("X-Alert-ID") != "42" || header.Get("Content-Type") != "application/json" {
+		t.Errorf("headers = %v", header)
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); header.Get("X-Alert-Signature") != want {
+		t.Errorf("signature = %q, want %q", header.Get("X-Alert-Signature"), want)
+	}
+
+	if err := n.Notify(context.Background(), srv.URL, "", testAlert()); err != nil || header.Get("X-Alert-Signature") != "" {
+		t.Errorf("unsigned delivery: err %v, signature %q", err, header.Get("X-Alert-Signature"))
+	}
+	status = http.StatusInternalServerError
+	if err := n.Notify(context.Background(), srv.URL, "", testAlert()); err == nil {
+		t.Error("500 from the receiver reported as sent")
+	}
+}
+
+func TestSubscriptionApplyPrivateWebhook(t *testing.T) {
+	defer func(v bool) { allowPrivateWebhooks = v }(allowPrivateWebhooks)
+	for _, target := range []string{"http://127.0.0.1:9000/hook", "http://localhost/hook", "http://10.0.0.5/hook"} {
+		req := subscriptionRequest{WebhookURL: &target, Channels: []string{channelWebhook}}
+		allowPrivateWebhooks = false
+		if err := req.apply(&AlertSubscription{}, ""); !errors.Is(err, errPrivateAddress) {
+			t.Errorf("%s accepted without opt-in: %v", target, err)
+		}
+		allowPrivateWebhooks = true
+		if err := req.apply(&AlertSubscription{}, ""); err != nil {
+			t.Errorf("%s rejected with opt-in: %v", target, err)
+		}
+	}
+}
+
+// fakeSMTP accepts one connection and plays a minimal SMTP server,
+// sending the envelope and message it received on the returned channel.
+func fakeSMTP(t *testing.T) (string, <-chan []string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	got := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { io.WriteString(conn, s+"\r\n") }
+		var lines []string
+		reply("220 fake ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			switch cmd := strings.ToUpper(strings.Fields(line + " x")[0]); cmd {
+			case "EHLO", "HELO", "MAIL", "RCPT":
+				lines = append(lines, line)
+				reply("250 ok")
+			case "DATA":
+				reply("354 go ahead")
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					lines = append(lines, strings.TrimRight(l, "\r\n"))
+				}
+				reply("250 queued")
+			case "QUIT":
+				reply("221 bye")
+				got <- lines
+				return
+			default:
+				reply("502 unknown")
+			}
+		}
+	}()
+	return ln.Addr().String(), got
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	addr, got := fakeSMTP(t)
+	n := &smtpNotifier{addr: addr, host: "127.0.0.1", from: "alerts@example.org"}
+	if err := n.Notify(context.Background(), "ops@example.org", "", testAlert()); err != nil {
+		t.Fatal(err)
+	}
+	lines := <-got
+	msg := strings.Join(lines, "\n")
+	for _, want := range []string{
+		"MAIL FROM:<alerts@example.org>",
+		"RCPT TO:<ops@example.org>",
+		"To: ops@example.org",
+		"Subject: [HIGH] Heatwave at KOCHI-1",
+		"SST 2.1 °C above the 90th percentile.",
+		"Region: Kerala",
+		"Station: KOCHI-1",
+		"/api/alerts/42",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message missing %q:\n%s", want, msg)
+		}
+	}
+}
+
+func TestSMTPNotifierHonoursContext(t *testing.T) {
+	// A server that accepts but never greets.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			defer conn.Close()
+			io.Copy(io.Discard, conn)
+		}
+	}()
+
+	n := &smtpNotifier{addr: ln.Addr().String(), host: "127.0.0.1", from: "alerts@example.org"}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := n.Notify(ctx, "ops@example.org", "", testAlert()); err == nil {
+		t.Fatal("silent server reported as sent")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Notify took %v after the context expired", d)
+	}
+}
+
+func TestSubscriptionMatches(t *testing.T) {
+	a := testAlert() // environmental, high, Kerala
+	tests := []struct {
+		name string
+		sub  AlertSubscription
+		want bool
+	}{
+		{"everything", AlertSubscription{MinSeverity: severityLow}, true},
+		{"category", AlertSubscription{Categories: []string{categoryEnvironmental}, MinSeverity: severityLow}, true},
+		{"other category", AlertSubscription{Categories: []string{categoryFisheries}, MinSeverity: severityLow}, false},
+		{"critical category, high alert", AlertSubscription{Categories: []string{categoryCritical}, MinSeverity: severityLow}, false},
+		{"region, any case", AlertSubscription{Regions: []string{"kerala"}, MinSeverity: severityLow}, true},
+		{"other region", AlertSubscription{Regions: []string{"goa"}, MinSeverity: severityLow}, false},
+		{"severity at floor", AlertSubscription{MinSeverity: severityHigh}, true},
+		{"severity below floor", AlertSubscription{MinSeverity: severityCritical}, false},
+		{"unknown floor", AlertSubscription{MinSeverity: "urgent"}, false},
+	}
+	for _, tt := range tests {
+		tt.sub.Enabled = true
+		if got := tt.sub.matches(a); got != tt.want {
+			t.Errorf("%s: matches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if (&AlertSubscription{MinSeverity: severityLow}).matches(a) {
+		t.Error("disabled subscription matched")
+	}
+	// The critical category takes any critical alert, whatever its own
+	// category; a region filter excludes an alert with no region.
+	crit := &Alert{Category: categoryBiological, Severity: severityCritical}
+	if !(&AlertSubscription{Enabled: true, Categories: []string{categoryCritical}, MinSeverity: severityLow}).matches(crit) {
+		t.Error("critical category did not match a critical alert")
+	}
+	if (&AlertSubscription{Enabled: true, Regions: []string{"kerala"}, MinSeverity: severityLow}).matches(crit) {
+		t.Error("region filter matched an alert without a region")
+	}
+}
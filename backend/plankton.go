@@ -0,0 +1,751 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Plankton Tows ---
+//
+// A tow filters a known volume of water at a station; its counts list
+// taxa with the number counted, the fraction of the sample that was
+// counted and optionally the biovolume of the counted organisms.
+// Abundance and biovolume per m³ are the counted value / subsample
+// fraction / volume filtered. Taxa are linked to species_data
+// by name where possible and tagged phytoplankton or zooplankton, from
+// the request, the linked species' kingdom and phylum, or the taxon
+// name.
+//
+// Averages over several tows include zeros for tows where a taxon was
+// not found, so a rare taxon is not inflated by the tows it turned up in.
+
+const planktonSchema = `
+CREATE TABLE IF NOT EXISTS plankton_tows (
+	id                 SERIAL PRIMARY KEY,
+	station            TEXT NOT NULL,
+	station_id         INTEGER REFERENCES env_stations(id) ON DELETE SET NULL,
+	region             TEXT,
+	latitude           DOUBLE PRECISION,
+	longitude          DOUBLE PRECISION,
+	tow_date           DATE NOT NULL,
+	depth_min_m        DOUBLE PRECISION,
+	depth_max_m        DOUBLE PRECISION,
+	volume_filtered_m3 DOUBLE PRECISION NOT NULL CHECK (volume_filtered_m3 > 0),
+	net_type           TEXT,
+	mesh_um            DOUBLE PRECISION,
+	dataset_id         INTEGER REFERENCES datasets(id) ON DELETE SET NULL,
+	submitted_by       TEXT NOT NULL,
+	created_at         TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS plankton_tows_date_idx ON plankton_tows (tow_date);
+
+CREATE TABLE IF NOT EXISTS plankton_counts (
+	id                 SERIAL PRIMARY KEY,
+	tow_id             INTEGER NOT NULL REFERENCES plankton_tows(id) ON DELETE CASCADE,
+	taxon              TEXT NOT NULL,
+	species_id         INTEGER,
+	category           TEXT NOT NULL CHECK (category IN ('phytoplankton', 'zooplankton')),
+	life_stage         TEXT,
+	count              DOUBLE PRECISION NOT NULL CHECK (count >= 0),
+	subsample_fraction DOUBLE PRECISION NOT NULL DEFAULT 1 CHECK (subsample_fraction > 0 AND subsample_fraction <= 1),
+	biovolume_mm3      DOUBLE PRECISION
+);
+CREATE INDEX IF NOT EXISTS plankton_counts_tow_idx ON plankton_counts (tow_id);`
+
+const (
+	categoryPhytoplankton = "phytoplankton"
+	categoryZooplankton   = "zooplankton"
+)
+
+// zooplanktonTaxa are phyla and name fragments of heterotrophic protists
+// and animals. They are checked before phytoplanktonTaxa because Chromista
+// and Protozoa hold both: foraminifera and ciliates are zooplankton,
+// diatoms and coccolithophores are not.
+var zooplanktonTaxa = []string{
+	"foraminifera", "ciliophora", "radiozoa", "cercozoa", "amoebozoa", "choanozoa", "ciliate", "tintinnid", "radiolaria",
+	"acantharia", "globigerin",
+}
+
+// phytoplanktonTaxa are kingdoms, phyla and name fragments of primary
+// producers.
+var phytoplanktonTaxa = []string{
+	"plantae", "bacillariophyta", "dinoflagellata", "myzozoa", "cyanobacteria", "haptophyta", "ochrophyta",
+	"chlorophyta", "cryptophyta", "euglenozoa", "diatom", "dinoflagellate", "coccolith", "phyto", "chaetoceros",
+	"thalassiosira", "skeletonema", "nitzschia", "pseudo-nitzschia", "ceratium", "tripos", "noctiluca", "trichodesmium",
+}
+
+type PlanktonCount struct {
+	ID                int      `json:"id"`
+	Taxon             string   `json:"taxon"`
+	SpeciesID         *int     `json:"species_id"`
+	Category          string   `json:"category"`
+	LifeStage         string   `json:"life_stage,omitempty"`
+	Count             float64  `json:"count"`
+	SubsampleFraction float64  `json:"subsample_fraction"`
+	BiovolumeMm3      *float64 `json:"biovolume_mm3,omitempty"`
+	AbundancePerM3    float64  `json:"abundance_per_m3"`
+	BiovolumePerM3    *float64 `json:"biovolume_mm3_per_m3,omitempty"`
+}
+
+type PlanktonTow struct {
+	ID               int              `json:"id"`
+	Station          string           `json:"station"`
+	StationID        *int             `json:"station_id,omitempty"`
+	Region           string           `json:"region,omitempty"`
+	Latitude         *float64         `json:"latitude,omitempty"`
+	Longitude        *float64         `json:"longitude,omitempty"`
+	TowDate          string           `json:"tow_date"`
+	DepthMinM        *float64         `json:"depth_min_m,omitempty"`
+	DepthMaxM        *float64         `json:"depth_max_m,omitempty"`
+	VolumeFilteredM3 float64          `json:"volume_filtered_m3"`
+	NetType          string           `json:"net_type,omitempty"`
+	MeshUm           *float64         `json:"mesh_um,omitempty"`
+	DatasetID        *int             `json:"dataset_id,omitempty"`
+	CreatedAt        string           `json:"created_at"`
+	PhytoPerM3       float64          `json:"phytoplankton_per_m3"`
+	ZooPerM3         float64          `json:"zooplankton_per_m3"`
+	Counts           []*PlanktonCount `json:"counts,omitempty"`
+}
+
+const planktonTowColumns = `t.id, t.station, t.station_id, COALESCE(t.region, ''), t.latitude, t.longitude, t.tow_date::text,
+	t.depth_min_m, t.depth_max_m, t.volume_filtered_m3, COALESCE(t.net_type, ''), t.mesh_um, t.dataset_id, t.created_at::text,
+	COALESCE((SELECT SUM(c.count / c.subsample_fraction) FROM plankton_counts c WHERE c.tow_id = t.id AND c.category = 'phytoplankton'), 0) / t.volume_filtered_m3,
+	COALESCE((SELECT SUM(c.count / c.subsample_fraction) FROM plankton_counts c WHERE c.tow_id = t.id AND c.category = 'zooplankton'), 0) / t.volume_filtered_m3`
+
+func scanPlanktonTow(row interface{ Scan(...any) error }) (*PlanktonTow, error) {
+	var t PlanktonTow
+	var stationID, datasetID sql.NullInt64
+	var lat, lon, dmin, dmax, mesh sql.NullFloat64
+	err := row.Scan(&t.ID, &t.Station, &stationID, &t.Region, &lat, &lon, &t.TowDate, &dmin, &dmax, &t.VolumeFilteredM3,
+		&t.NetType, &mesh, &datasetID, &t.CreatedAt, &t.PhytoPerM3, &t.ZooPerM3)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range []struct {
+		v   sql.NullInt64
+		dst **int
+	}{{stationID, &t.StationID}, {datasetID, &t.DatasetID}} {
+		if p.v.Valid {
+			n := int(p.v.Int64)
+			*p.dst = &n
+		}
+	}
+	t.Latitude, t.Longitude = nullableFloat(lat), nullableFloat(lon)
+	t.DepthMinM, t.DepthMaxM, t.MeshUm = nullableFloat(dmin), nullableFloat(dmax), nullableFloat(mesh)
+	return &t, nil
+}
+
+func loadPlanktonCounts(t *PlanktonTow) error {
+	rows, err := db.Query(`SELECT id, taxon, species_id, category, COALESCE(life_stage, ''), count, subsample_fraction, biovolume_mm3
+		FROM plankton_counts WHERE tow_id = $1 ORDER BY category, taxon`, t.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	t.Counts = []*PlanktonCount{}
+	for rows.Next() {
+		var c PlanktonCount
+		var speciesID sql.NullInt64
+		var bio sql.NullFloat64
+		if err := rows.Scan(&c.ID, &c.Taxon, &speciesID, &c.Category, &c.LifeStage, &c.Count, &c.SubsampleFraction, &bio); err != nil {
+			return err
+		}
+		if speciesID.Valid {
+			id := int(speciesID.Int64)
+			c.SpeciesID = &id
+		}
+		c.AbundancePerM3 = c.Count / c.SubsampleFraction / t.VolumeFilteredM3
+		if bio.Valid {
+			c.BiovolumeMm3 = &bio.Float64
+			v := bio.Float64 / c.SubsampleFraction / t.VolumeFilteredM3
+			c.BiovolumePerM3 = &v
+		}
+		t.Counts = append(t.Counts, &c)
+	}
+	return rows.Err()
+}
+
+// planktonCategory picks the category for a count: the one given, else
+// from the linked species' kingdom and phylum, else from the taxon name.
+func planktonCategory(given, taxon string, speciesID *int) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(given)) {
+	case categoryPhytoplankton, "phyto":
+		return categoryPhytoplankton, nil
+	case categoryZooplankton, "zoo":
+		return categoryZooplankton, nil
+	case "":
+	default:
+		return "", fmt.Errorf("category must be phytoplankton or zooplankton")
+	}
+	names := []string{strings.ToLower(taxon)}
+	var kingdom, phylum string
+	if speciesID != nil {
+		if err := db.QueryRow("SELECT COALESCE(lower(kingdom), ''), COALESCE(lower(phylum), '') FROM species_data WHERE id = $1",
+			*speciesID).Scan(&kingdom, &phylum); err == nil {
+			if kingdom == "animalia" {
+				return categoryZooplankton, nil
+			}
+			names = append(names, kingdom, phylum)
+		}
+	}
+	if containsAny(names, zooplanktonTaxa) {
+		return categoryZooplankton, nil
+	}
+	if containsAny(names, phytoplanktonTaxa) {
+		return categoryPhytoplankton, nil
+	}
+	// Other linked species default to zooplankton, except protists of an
+	// unlisted phylum, which could be either.
+	if speciesID != nil && kingdom != "chromista" && kingdom != "protozoa" {
+		return categoryZooplankton, nil
+	}
+	return "", fmt.Errorf("cannot tell whether %q is phytoplankton or zooplankton; give a category", taxon)
+}
+
+// containsAny reports whether any non-empty name contains one of the
+// fragments.
+func containsAny(names, fragments []string) bool {
+	for _, n := range names {
+		for _, f := range fragments {
+			if n != "" && strings.Contains(n, f) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// createPlanktonTow records a tow with its taxon counts.
+func createPlanktonTow(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireRole(w, r, contributorRoles...)
+	if !ok {
+		return
+	}
+	var req struct {
+		Station          string   `json:"station"`
+		Region           string   `json:"region"`
+		Latitude         *float64 `json:"latitude"`
+		Longitude        *float64 `json:"longitude"`
+		TowDate          string   `json:"tow_date"`
+		DepthMinM        *float64 `json:"depth_min_m"`
+		DepthMaxM        *float64 `json:"depth_max_m"`
+		VolumeFilteredM3 float64  `json:"volume_filtered_m3"`
+		NetType          string   `json:"net_type"`
+		MeshUm           *float64 `json:"mesh_um"`
+		DatasetID        *int     `json:"dataset_id"`
+		Counts           []struct {
+			Taxon             string   `json:"taxon"`
+			SpeciesID         *int     `json:"species_id"`
+			Category          string   `json:"category"`
+			LifeStage         string   `json:"life_stage"`
+			Count             float64  `json:"count"`
+			SubsampleFraction *float64 `json:"subsample_fraction"`
+			BiovolumeMm3      *float64 `json:"biovolume_mm3"`
+		} `json:"counts"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	req.Station = strings.TrimSpace(req.Station)
+	switch {
+	case req.Station == "" || len(req.Counts) == 0:
+		http.Error(w, "station and at least one count are required", http.StatusBadRequest)
+		return
+	case req.VolumeFilteredM3 <= 0:
+		http.Error(w, "volume_filtered_m3 must be positive", http.StatusBadRequest)
+		return
+	case req.DepthMinM != nil && req.DepthMaxM != nil && *req.DepthMinM > *req.DepthMaxM:
+		http.Error(w, "depth_min_m is below depth_max_m", http.StatusBadRequest)
+		return
+	}
+	if _, err := time.Parse("2006-01-02", req.TowDate); err != nil {
+		http.Error(w, "tow_date must be a YYYY-MM-DD date", http.StatusBadRequest)
+		return
+	}
+
+	// Link the station to a sensor station with the same code, taking its
+	// position and region when the tow has none.
+	var stationID *int
+	var id int
+	var lat, lon sql.NullFloat64
+	var region string
+	if err := db.QueryRow("SELECT id, latitude, longitude, COALESCE(region, '') FROM env_stations WHERE code = $1", req.Station).
+		Scan(&id, &lat, &lon, &region); err == nil {
+		stationID = &id
+		if req.Latitude == nil && req.Longitude == nil {
+			req.Latitude, req.Longitude = nullableFloat(lat), nullableFloat(lon)
+		}
+		if strings.TrimSpace(req.Region) == "" {
+			req.Region = region
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var towID int
+	err = tx.QueryRow(`INSERT INTO plankton_tows (station, station_id, region, latitude, longitude, tow_date, depth_min_m, depth_max_m,
+			volume_filtered_m3, net_type, mesh_um, dataset_id, submitted_by)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6::date, $7, $8, $9, NULLIF($10, ''), $11, $12, $13) RETURNING id`,
+		req.Station, stationID, strings.TrimSpace(req.Region), req.Latitude, req.Longitude, req.TowDate, req.DepthMinM, req.DepthMaxM,
+		req.VolumeFilteredM3, strings.TrimSpace(req.NetType), req.MeshUm, req.DatasetID, user.ID).Scan(&towID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for i, c := range req.Counts {
+		taxon := strings.TrimSpace(c.Taxon)
+		if taxon == "" {
+			http.Error(w, fmt.Sprintf("count %d: taxon is required", i+1), http.StatusBadRequest)
+			return
+		}
+		if c.Count < 0 || (c.SubsampleFraction != nil && (*c.SubsampleFraction <= 0 || *c.SubsampleFraction > 1)) {
+			http.Error(w, fmt.Sprintf("count %d: count must be non-negative and subsample_fraction in (0, 1]", i+1), http.StatusBadRequest)
+			return
+		}
+		speciesID := c.SpeciesID
+		if speciesID == nil {
+			speciesID = guessSpeciesID(taxon)
+		}
+		category, err := planktonCategory(c.Category, taxon, speciesID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("count %d: %v", i+1, err), http.StatusBadRequest)
+			return
+		}
+		fraction := 1.0
+		if c.SubsampleFraction != nil {
+			fraction = *c.SubsampleFraction
+		}
+		if _, err := tx.Exec(`INSERT INTO plankton_counts (tow_id, taxon, species_id, category, life_stage, count, subsample_fraction, biovolume_mm3)
+			VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8)`,
+			towID, taxon, speciesID, category, strings.TrimSpace(c.LifeStage), c.Count, fraction, c.BiovolumeMm3); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	t, err := scanPlanktonTow(db.QueryRow("SELECT "+planktonTowColumns+" FROM plankton_tows t WHERE t.id = $1", towID))
+	if err == nil {
+		err = loadPlanktonCounts(t)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(t)
+}
+
+// planktonFilter reads ?station=, ?region=, ?dataset_id=, ?from= and ?to=
+// into conditions on plankton_tows t.
+func planktonFilter(q map[string][]string) ([]string, []interface{}, error) {
+	get := func(k string) string {
+		if v := q[k]; len(v) > 0 {
+			return strings.TrimSpace(v[0])
+		}
+		return ""
+	}
+	where := []string{"1=1"}
+	args := []interface{}{}
+	for _, f := range []struct{ param, cond string }{
+		{"station", "t.station ILIKE $%d"},
+		{"region", "t.region ILIKE $%d"},
+		{"dataset_id", "t.dataset_id = $%d::int"},
+		{"from", "t.tow_date >= $%d::date"},
+		{"to", "t.tow_date <= $%d::date"},
+	} {
+		if v := get(f.param); v != "" {
+			if f.param == "from" || f.param == "to" {
+				if _, err := time.Parse("2006-01-02", v); err != nil {
+					return nil, nil, fmt.Errorf("%s must be a YYYY-MM-DD date", f.param)
+				}
+			}
+			args = append(args, v)
+			where = append(where, fmt.Sprintf(f.cond, len(args)))
+		}
+	}
+	return where, args, nil
+}
+
+func listPlanktonTows(w http.ResponseWriter, r *http.Request) {
+	where, args, err := planktonFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, pageSize := pagination(r)
+	args = append(args, pageSize, (page-1)*pageSize)
+	rows, err := db.Query(fmt.Sprintf("SELECT "+planktonTowColumns+" FROM plankton_tows t WHERE %s ORDER BY t.tow_date DESC, t.id DESC LIMIT $%d OFFSET $%d",
+		strings.Join(where, " AND "), len(args)-1, len(args)), args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer rows.Close()
+	tows := []*PlanktonTow{}
+	for rows.Next() {
+		t, err := scanPlanktonTow(rows)
+		if err != nil {
+			continue
+		}
+		tows = append(tows, t)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tows)
+}
+
+func getPlanktonTow(w http.ResponseWriter, r *http.Request) {
+	t, err := scanPlanktonTow(db.QueryRow("SELECT "+planktonTowColumns+" FROM plankton_tows t WHERE t.id = $1", r.PathValue("id")))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Tow not found", http.StatusNotFound)
+		return
+	}
+	if err == nil {
+		err = loadPlanktonCounts(t)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+// towTaxon is one taxon's density in one tow.
+type towTaxon struct {
+	tow       int
+	period    string
+	key       string
+	name      string
+	speciesID *int
+	category  string
+	phylum    string
+	perM3     float64
+	bioPerM3  *float64
+}
+
+// planktonDensities returns the tows in the slice, each with its period
+// label, and the per-taxon densities within them. Taxa are keyed by
+// species when linked and by lower-cased name otherwise.
+func planktonDensities(q map[string][]string, interval string) (map[int]string, []towTaxon, error) {
+	where, args, err := planktonFilter(q)
+	if err != nil {
+		return nil, nil, err
+	}
+	period := "''"
+	if interval != "" {
+		period = "to_char(date_trunc('" + interval + "', t.tow_date), 'YYYY-MM-DD')"
+	}
+	whereSQL := strings.Join(where, " AND ")
+
+	tows := map[int]string{}
+	rows, err := db.Query("SELECT t.id, "+period+" FROM plankton_tows t WHERE "+whereSQL, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var id int
+		var p string
+		if err := rows.Scan(&id, &p); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		tows[id] = p
+	}
+	rows.Close()
+
+	rows, err = db.Query(`SELECT t.id, `+period+`, COALESCE(c.species_id::text, lower(c.taxon)), COALESCE(s.scientific_name, MIN(c.taxon)),
+			c.species_id, c.category, COALESCE(s.phylum, ''),
+			SUM(c.count / c.subsample_fraction) / t.volume_filtered_m3,
+			SUM(c.biovolume_mm3 / c.subsample_fraction) / t.volume_filtered_m3
+		FROM plankton_counts c JOIN plankton_tows t ON t.id = c.tow_id LEFT JOIN species_data s ON s.id = c.species_id
+		WHERE `+whereSQL+`
+		GROUP BY t.id, 2, 3, s.scientific_name, c.species_id, c.category, s.phylum, t.volume_filtered_m3`, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	var out []towTaxon
+	for rows.Next() {
+		var tt towTaxon
+		var speciesID sql.NullInt64
+		var bio sql.NullFloat64
+		if err := rows.Scan(&tt.tow, &tt.period, &tt.key, &tt.name, &speciesID, &tt.category, &tt.phylum, &tt.perM3, &bio); err != nil {
+			return nil, nil, err
+		}
+		if speciesID.Valid {
+			id := int(speciesID.Int64)
+			tt.speciesID = &id
+		}
+		tt.bioPerM3 = nullableFloat(bio)
+		out = append(out, tt)
+	}
+	return tows, out, rows.Err()
+}
+
+var planktonIntervals = map[string]bool{"week": true, "month": true, "quarter": true, "year": true}
+
+type PlanktonTaxonAbundance struct {
+	Key          string   `json:"key"`
+	Taxon        string   `json:"taxon"`
+	SpeciesID    *int     `json:"species_id"`
+	Category     string   `json:"category"`
+	Tows         int      `json:"tows"`
+	Occurrence   float64  `json:"frequency_of_occurrence"`
+	MeanPerM3    float64  `json:"mean_per_m3"`
+	MaxPerM3     float64  `json:"max_per_m3"`
+	MeanBioPerM3 *float64 `json:"mean_biovolume_mm3_per_m3,omitempty"`
+}
+
+// getPlanktonAbundance returns mean abundance per m³ of each taxon over
+// the tows in the slice; ?category= narrows to one category.
+func getPlanktonAbundance(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	tows, densities, err := planktonDensities(q, "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	category := q.Get("category")
+	taxa := map[string]*PlanktonTaxonAbundance{}
+	bio := map[string]float64{}
+	for _, d := range densities {
+		if category != "" && d.category != category {
+			continue
+		}
+		a := taxa[d.key+"|"+d.category]
+		if a == nil {
+			a = &PlanktonTaxonAbundance{Key: d.key, Taxon: d.name, SpeciesID: d.speciesID, Category: d.category}
+			taxa[d.key+"|"+d.category] = a
+		}
+		a.Tows++
+		a.MeanPerM3 += d.perM3
+		a.MaxPerM3 = math.Max(a.MaxPerM3, d.perM3)
+		if d.bioPerM3 != nil {
+			bio[d.key+"|"+d.category] += *d.bioPerM3
+			a.MeanBioPerM3 = new(float64)
+		}
+	}
+	out := []*PlanktonTaxonAbundance{}
+	n := float64(len(tows))
+	for k, a := range taxa {
+		a.MeanPerM3 /= n
+		a.Occurrence = float64(a.Tows) / n
+		if a.MeanBioPerM3 != nil {
+			*a.MeanBioPerM3 = bio[k] / n
+		}
+		out = append(out, a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].MeanPerM3 > out[j].MeanPerM3 })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"tows": len(tows), "taxa": out})
+}
+
+type CompositionPeriod struct {
+	Period     string             `json:"period"`
+	Tows       int                `json:"tows"`
+	TotalPerM3 float64            `json:"total_per_m3"`
+	PerM3      map[string]float64 `json:"per_m3"`
+	Share      map[string]float64 `json:"share"`
+}
+
+// getPlanktonComposition returns mean density per m³ by period
+// (?interval=week, month, quarter or year) broken down by ?level=
+// (taxon, category or phylum). With taxa, the ?top= most abundant overall
+// are kept and the rest pooled as "other".
+func getPlanktonComposition(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	interval := q.Get("interval")
+	if interval == "" {
+		interval = "month"
+	}
+	if !planktonIntervals[interval] {
+		http.Error(w, "interval must be week, month, quarter or year", http.StatusBadRequest)
+		return
+	}
+	level := q.Get("level")
+	if level == "" {
+		level = "taxon"
+	}
+	if level != "taxon" && level != "category" && level != "phylum" {
+		http.Error(w, "level must be taxon, category or phylum", http.StatusBadRequest)
+		return
+	}
+	top := 10
+	if v := q.Get("top"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			http.Error(w, "top must be between 1 and 100", http.StatusBadRequest)
+			return
+		}
+		top = n
+	}
+
+	tows, densities, err := planktonDensities(q, interval)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	label := func(d towTaxon) string {
+		switch level {
+		case "category":
+			return d.category
+		case "phylum":
+			if d.phylum == "" {
+				return "unassigned"
+			}
+			return d.phylum
+		}
+		return d.name
+	}
+
+	keep := map[string]bool{}
+	if level == "taxon" {
+		totals := map[string]float64{}
+		for _, d := range densities {
+			totals[label(d)] += d.perM3
+		}
+		var names []string
+		for n := range totals {
+			names = append(names, n)
+		}
+		sort.Slice(names, func(i, j int) bool { return totals[names[i]] > totals[names[j]] })
+		for i, n := range names {
+			if i < top {
+				keep[n] = true
+			}
+		}
+	}
+
+	periods := map[string]*CompositionPeriod{}
+	for _, p := range tows {
+		if periods[p] == nil {
+			periods[p] = &CompositionPeriod{Period: p, PerM3: map[string]float64{}, Share: map[string]float64{}}
+		}
+		periods[p].Tows++
+	}
+	for _, d := range densities {
+		l := label(d)
+		if level == "taxon" && !keep[l] {
+			l = "other"
+		}
+		periods[d.period].PerM3[l] += d.perM3
+	}
+	out := []*CompositionPeriod{}
+	for _, p := range periods {
+		for l, v := range p.PerM3 {
+			p.PerM3[l] = v / float64(p.Tows)
+			p.TotalPerM3 += p.PerM3[l]
+		}
+		for l, v := range p.PerM3 {
+			if p.TotalPerM3 > 0 {
+				p.Share[l] = v / p.TotalPerM3
+			}
+		}
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Period < out[j].Period })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"interval": interval, "level": level, "periods": out})
+}
+
+type PlanktonRatio struct {
+	Period        string   `json:"period"`
+	Tows          int      `json:"tows"`
+	PhytoPerM3    float64  `json:"phytoplankton_per_m3"`
+	ZooPerM3      float64  `json:"zooplankton_per_m3"`
+	Ratio         *float64 `json:"ratio"`
+	PhytoBioPerM3 *float64 `json:"phytoplankton_biovolume_mm3_per_m3,omitempty"`
+	ZooBioPerM3   *float64 `json:"zooplankton_biovolume_mm3_per_m3,omitempty"`
+	BiovolumeRate *float64 `json:"biovolume_ratio,omitempty"`
+}
+
+// getPlanktonRatio returns phytoplankton to zooplankton ratios by period,
+// from mean densities (cells and individuals per m³ respectively) and,
+// where counts carry it, from biovolume.
+func getPlanktonRatio(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	interval := q.Get("interval")
+	if interval == "" {
+		interval = "month"
+	}
+	if !planktonIntervals[interval] {
+		http.Error(w, "interval must be week, month, quarter or year", http.StatusBadRequest)
+		return
+	}
+	tows, densities, err := planktonDensities(q, interval)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	periods := map[string]*PlanktonRatio{}
+	for _, p := range tows {
+		if periods[p] == nil {
+			periods[p] = &PlanktonRatio{Period: p}
+		}
+		periods[p].Tows++
+	}
+	addBio := func(dst **float64, v *float64) {
+		if v == nil {
+			return
+		}
+		if *dst == nil {
+			*dst = new(float64)
+		}
+		**dst += *v
+	}
+	for _, d := range densities {
+		p := periods[d.period]
+		if d.category == categoryPhytoplankton {
+			p.PhytoPerM3 += d.perM3
+			addBio(&p.PhytoBioPerM3, d.bioPerM3)
+		} else {
+			p.ZooPerM3 += d.perM3
+			addBio(&p.ZooBioPerM3, d.bioPerM3)
+		}
+	}
+	out := []*PlanktonRatio{}
+	for _, p := range periods {
+		n := float64(p.Tows)
+		p.PhytoPerM3 /= n
+		p.ZooPerM3 /= n
+		if p.ZooPerM3 > 0 {
+			v := p.PhytoPerM3 / p.ZooPerM3
+			p.Ratio = &v
+		}
+		for _, b := range []*float64{p.PhytoBioPerM3, p.ZooBioPerM3} {
+			if b != nil {
+				*b /= n
+			}
+		}
+		if p.PhytoBioPerM3 != nil && p.ZooBioPerM3 != nil && *p.ZooBioPerM3 > 0 {
+			v := *p.PhytoBioPerM3 / *p.ZooBioPerM3
+			p.BiovolumeRate = &v
+		}
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Period < out[j].Period })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"interval": interval, "periods": out})
+}
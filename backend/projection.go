@@ -0,0 +1,583 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Population Projections ---
+//
+// An annual age-structured model with Beverton-Holt recruitment, built
+// from the species' life-history fields:
+//
+//   - ages run to longevity; natural mortality M is mortality_rate, or
+//     Then et al. (2015) from longevity when missing;
+//   - length-at-age follows von Bertalanffy with Linf = max_length_cm and
+//     k = 3/longevity, and weight scales with length cubed;
+//   - maturity and fishery selectivity are logistic around
+//     age_of_maturity_years;
+//   - steepness comes from the maximum reproductive rate, fecundity times
+//     larval_survival times unfished spawners per recruit.
+//
+// Scenario effects: temperature rise (reached linearly by the final year)
+// raises M with a Q10 of 2 and scales recruitment by a thermal performance
+// curve built from thermal_tolerance; acidification scales recruitment,
+// three times as strongly for calcifying phyla; fishing effort and MPA
+// coverage scale fishing mortality. Uncertainty bands come from Monte
+// Carlo runs with lognormal recruitment deviations and perturbed M and
+// steepness. Biomass is reported relative to unfished biomass B0.
+
+const (
+	defaultProjectionYears = 30
+	maxProjectionYears     = 100
+	defaultProjectionRuns  = 200
+	maxProjectionRuns      = 1000
+	maxProjectionAges      = 100
+
+	defaultSteepness    = 0.7
+	defaultDepletion    = 0.4
+	recruitmentSigma    = 0.5
+	mortalitySigma      = 0.2
+	steepnessSigma      = 0.05
+	mortalityQ10        = 2.0
+	acidSensitivity     = 1.0
+	calcifierMultiplier = 3.0
+)
+
+var calcifierPhyla = map[string]bool{"mollusca": true, "echinodermata": true, "cnidaria": true, "arthropoda": true, "bryozoa": true}
+
+// ProjectionScenario holds the PredictionsTab sliders. FishingEffortPct
+// is relative to current effort (100 = status quo) and MPACoveragePct is
+// the share of the stock's habitat closed to fishing.
+type ProjectionScenario struct {
+	SpeciesID            int      `json:"species_id"`
+	Years                int      `json:"years"`
+	Runs                 int      `json:"runs"`
+	Seed                 int64    `json:"seed"`
+	TemperatureRiseC     float64  `json:"temperature_rise_c"`
+	PHChange             float64  `json:"ph_change"`
+	FishingEffortPct     *float64 `json:"fishing_effort_pct"`
+	MPACoveragePct       float64  `json:"mpa_coverage_pct"`
+	EffortDisplacement   float64  `json:"effort_displacement"`
+	CurrentF             *float64 `json:"current_f"`
+	BaselineTemperatureC *float64 `json:"baseline_temperature_c"`
+}
+
+// lifeHistory is the per-age schedule derived from a species record.
+type lifeHistory struct {
+	Ages           int       `json:"ages"`
+	M              float64   `json:"natural_mortality"`
+	K              float64   `json:"growth_k"`
+	LinfCm         float64   `json:"linf_cm"`
+	WmaxKg         float64   `json:"wmax_kg"`
+	AgeMaturity    float64   `json:"age_of_maturity"`
+	Fecundity      float64   `json:"fecundity"`
+	LarvalSurvival float64   `json:"larval_survival"`
+	Steepness      float64   `json:"steepness"`
+	ThermalOptC    *float64  `json:"thermal_optimum_c,omitempty"`
+	ThermalMaxC    *float64  `json:"thermal_max_c,omitempty"`
+	Calcifier      bool      `json:"calcifier"`
+	Weight         []float64 `json:"weight_at_age_kg"`
+	Maturity       []float64 `json:"maturity_at_age"`
+	Selectivity    []float64 `json:"selectivity_at_age"`
+	Assumptions    []string  `json:"assumptions"`
+}
+
+var numberPattern = regexp.MustCompile(`(\d[\d,]*(?:\.\d+)?)\s*(million|thousand|lakh|k\b|m\b)?`)
+
+// parseNumbers reads the numbers in free text such as "50,000-200,000
+// eggs" or "1.2 million", applying word multipliers.
+func parseNumbers(s string) []float64 {
+	var out []float64
+	for _, m := range numberPattern.FindAllStringSubmatch(strings.ToLower(s), -1) {
+		v, err := strconv.ParseFloat(strings.ReplaceAll(m[1], ",", ""), 64)
+		if err != nil {
+			continue
+		}
+		switch m[2] {
+		case "million", "m":
+			v *= 1e6
+		case "thousand", "k":
+			v *= 1e3
+		case "lakh":
+			v *= 1e5
+		}
+		out = append(out, v)
+	}
+	return out
+}
+
+// perRecruit sums survivorship times a per-age quantity under
+// fishing mortality f, with the last age as a plus group.
+func (lh *lifeHistory) perRecruit(m, f float64, quantity []float64) float64 {
+	survival, total := 1.0, 0.0
+	for a := 0; a < lh.Ages; a++ {
+		z := m + f*lh.Selectivity[a]
+		if a == lh.Ages-1 {
+			total += survival * quantity[a] / (1 - math.Exp(-z))
+			break
+		}
+		total += survival * quantity[a]
+		survival *= math.Exp(-z)
+	}
+	return total
+}
+
+func (lh *lifeHistory) spawningBiomass() []float64 {
+	q := make([]float64, lh.Ages)
+	for a := range q {
+		q[a] = lh.Maturity[a] * lh.Weight[a]
+	}
+	return q
+}
+
+func newLifeHistory(s Species) *lifeHistory {
+	lh := &lifeHistory{Assumptions: []string{}}
+	note := func(format string, args ...interface{}) {
+		lh.Assumptions = append(lh.Assumptions, fmt.Sprintf(format, args...))
+	}
+
+	longevity := s.Longevity
+	if longevity <= 0 {
+		longevity = s.MaxAgeYears
+	}
+	if longevity <= 0 {
+		longevity = 10
+		note("longevity unknown; assumed 10 years")
+	}
+	lh.Ages = min(int(math.Ceil(longevity))+1, maxProjectionAges)
+
+	lh.M = s.MortalityRate
+	if lh.M <= 0 || lh.M > 5 {
+		lh.M = 4.899 * math.Pow(longevity, -0.916)
+		note("natural mortality estimated from longevity (Then et al. 2015): M = %.3f", lh.M)
+	}
+
+	lh.K = 3 / longevity
+	lh.LinfCm = s.MaxLengthCm
+	if lh.LinfCm <= 0 {
+		lh.LinfCm = 50
+		note("max length unknown; assumed 50 cm")
+	}
+	lh.WmaxKg = s.MaxWeightKg
+	if lh.WmaxKg <= 0 {
+		lh.WmaxKg = 0.01 * math.Pow(lh.LinfCm, 3) / 1000
+		note("max weight estimated as 0.01 L^3 g")
+	}
+
+	lh.AgeMaturity = s.AgeOfMaturityYears
+	if lh.AgeMaturity <= 0 {
+		lh.AgeMaturity = math.Max(1, longevity/4)
+		note("age of maturity unknown; assumed a quarter of longevity (%.1f years)", lh.AgeMaturity)
+	}
+
+	lh.Weight = make([]float64, lh.Ages)
+	lh.Maturity = make([]float64, lh.Ages)
+	lh.Selectivity = make([]float64, lh.Ages)
+	for a := 0; a < lh.Ages; a++ {
+		rel := 1 - math.Exp(-lh.K*(float64(a)+0.5))
+		lh.Weight[a] = lh.WmaxKg * rel * rel * rel
+		lh.Maturity[a] = 1 / (1 + math.Exp(-2*(float64(a)-lh.AgeMaturity)))
+		lh.Selectivity[a] = 1 / (1 + math.Exp(-2*(float64(a)-(lh.AgeMaturity-1))))
+	}
+
+	// Maximum lifetime reproductive rate: recruits per recruit at low
+	// density, from eggs per recruit (half the spawners are female) and
+	// larval survival. Beverton-Holt steepness follows as alpha/(4+alpha).
+	lh.Steepness = defaultSteepness
+	if f := parseNumbers(s.Fecundity); len(f) > 0 {
+		lh.Fecundity = f[0]
+		if len(f) > 1 {
+			lh.Fecundity = (f[0] + f[1]) / 2
+		}
+	}
+	lh.LarvalSurvival = s.LarvalSurvival
+	if lh.LarvalSurvival > 1 && lh.LarvalSurvival <= 100 {
+		lh.LarvalSurvival /= 100
+		note("larval_survival read as a percentage")
+	}
+	if lh.Fecundity > 0 && lh.LarvalSurvival > 0 {
+		eggs := make([]float64, lh.Ages)
+		for a := range eggs {
+			eggs[a] = 0.5 * lh.Maturity[a] * lh.Fecundity * lh.Weight[a] / lh.WmaxKg
+		}
+		alpha := lh.LarvalSurvival * lh.perRecruit(lh.M, 0, eggs)
+		if alpha > 1 {
+			lh.Steepness = math.Min(math.Max(alpha/(4+alpha), 0.25), 0.95)
+		} else {
+			note("fecundity x larval survival cannot replace spawners; steepness set to %.2f", defaultSteepness)
+		}
+	} else {
+		note("fecundity or larval survival unknown; steepness set to %.2f", defaultSteepness)
+	}
+
+	temps := parseNumbers(s.ThermalTolerance)
+	switch {
+	case len(temps) >= 2:
+		lo, hi := math.Min(temps[0], temps[1]), math.Max(temps[0], temps[1])
+		opt := lo + (hi-lo)*2/3
+		lh.ThermalOptC, lh.ThermalMaxC = &opt, &hi
+	case len(temps) == 1:
+		hi := temps[0]
+		opt := hi - 5
+		lh.ThermalOptC, lh.ThermalMaxC = &opt, &hi
+		note("thermal tolerance has one value; read as the upper limit with an optimum 5 °C below")
+	default:
+		note("thermal tolerance unknown; temperature only acts through natural mortality")
+	}
+
+	lh.Calcifier = calcifierPhyla[strings.ToLower(strings.TrimSpace(s.Phylum))]
+	return lh
+}
+
+// thermalPerformance is a Gaussian rising to the optimum and falling to
+// about 2% at the upper tolerance limit.
+func (lh *lifeHistory) thermalPerformance(t float64) float64 {
+	if lh.ThermalOptC == nil {
+		return 1
+	}
+	opt, hi := *lh.ThermalOptC, *lh.ThermalMaxC
+	width := math.Max((hi-opt)/2, 0.5)
+	if t < opt {
+		width *= 2
+	}
+	d := (t - opt) / width
+	return math.Exp(-d * d)
+}
+
+type projectionYear struct {
+	biomass, ssb, catch, recruits float64
+}
+
+// equilibriumRecruits is Beverton-Holt equilibrium recruitment (R0 = 1)
+// under fishing mortality f, zero when f crashes the stock.
+func (lh *lifeHistory) equilibriumRecruits(m, h, f float64) float64 {
+	sb := lh.spawningBiomass()
+	spr0 := lh.perRecruit(m, 0, sb)
+	sprF := lh.perRecruit(m, f, sb)
+	return math.Max(0, (4*h*sprF-spr0*(1-h))/(sprF*(5*h-1)))
+}
+
+// fishingForDepletion finds by bisection the fishing mortality that holds
+// equilibrium spawning biomass at the given fraction of unfished.
+func (lh *lifeHistory) fishingForDepletion(depletion float64) float64 {
+	sb := lh.spawningBiomass()
+	spr0 := lh.perRecruit(lh.M, 0, sb)
+	lo, hi := 0.0, 5.0
+	for i := 0; i < 60; i++ {
+		f := (lo + hi) / 2
+		d := lh.equilibriumRecruits(lh.M, lh.Steepness, f) * lh.perRecruit(lh.M, f, sb) / spr0
+		if d > depletion {
+			lo = f
+		} else {
+			hi = f
+		}
+	}
+	return (lo + hi) / 2
+}
+
+// projectRun simulates one trajectory. Year 0 is the equilibrium under the
+// current fishing mortality at the baseline temperature.
+func (lh *lifeHistory) projectRun(sc ProjectionScenario, currentF, t0 float64, m, h float64, devs []float64) []projectionYear {
+	sb := lh.spawningBiomass()
+	spr0 := lh.perRecruit(m, 0, sb)
+	r0 := 1.0
+	s0 := r0 * spr0
+
+	req := lh.equilibriumRecruits(m, h, currentF)
+	n := make([]float64, lh.Ages)
+	if req > 0 {
+		survival := 1.0
+		for a := 0; a < lh.Ages; a++ {
+			z := m + currentF*lh.Selectivity[a]
+			n[a] = req * survival
+			if a == lh.Ages-1 {
+				n[a] /= 1 - math.Exp(-z)
+			}
+			survival *= math.Exp(-z)
+		}
+	}
+
+	effort := 100.0
+	if sc.FishingEffortPct != nil {
+		effort = *sc.FishingEffortPct
+	}
+	closed := sc.MPACoveragePct / 100 * (1 - sc.EffortDisplacement)
+	f := currentF * effort / 100 * (1 - closed)
+
+	sensitivity := acidSensitivity
+	if lh.Calcifier {
+		sensitivity *= calcifierMultiplier
+	}
+
+	out := make([]projectionYear, sc.Years+1)
+	record := func(y int, catch, rec float64) {
+		var b, s float64
+		for a := 0; a < lh.Ages; a++ {
+			b += n[a] * lh.Weight[a]
+			s += n[a] * lh.Weight[a] * lh.Maturity[a]
+		}
+		out[y] = projectionYear{biomass: b, ssb: s, catch: catch, recruits: rec}
+	}
+	record(0, 0, req)
+
+	for y := 1; y <= sc.Years; y++ {
+		ramp := float64(y) / float64(sc.Years)
+		dt := sc.TemperatureRiseC * ramp
+		my := m * math.Pow(mortalityQ10, dt/10)
+		envRec := lh.thermalPerformance(t0+dt) / lh.thermalPerformance(t0) * math.Exp(sensitivity*sc.PHChange*ramp)
+
+		var ssb float64
+		for a := 0; a < lh.Ages; a++ {
+			ssb += n[a] * lh.Weight[a] * lh.Maturity[a]
+		}
+		rec := 0.0
+		if ssb > 0 {
+			rec = 4 * h * r0 * ssb / (s0*(1-h) + ssb*(5*h-1))
+		}
+		rec *= envRec * math.Exp(devs[y-1])
+
+		var catch float64
+		next := make([]float64, lh.Ages)
+		for a := 0; a < lh.Ages; a++ {
+			fa := f * lh.Selectivity[a]
+			z := my + fa
+			if z > 0 {
+				catch += n[a] * lh.Weight[a] * fa / z * (1 - math.Exp(-z))
+			}
+			survivors := n[a] * math.Exp(-z)
+			if a == lh.Ages-1 {
+				next[a] += survivors
+			} else {
+				next[a+1] += survivors
+			}
+		}
+		next[0] = rec
+		n = next
+		record(y, catch, rec)
+	}
+	return out
+}
+
+type ProjectionPoint struct {
+	Year     int     `json:"year"`
+	Median   float64 `json:"median"`
+	P05      float64 `json:"p05"`
+	P25      float64 `json:"p25"`
+	P75      float64 `json:"p75"`
+	P95      float64 `json:"p95"`
+	SSB      float64 `json:"ssb_median"`
+	Catch    float64 `json:"catch_median"`
+	Recruits float64 `json:"recruits_median"`
+}
+
+type ProjectionTrajectory struct {
+	// Biomass is total biomass over unfished biomass B0 with 50% and 90%
+	// bands; SSB is relative to unfished SSB and catch to the unfished
+	// biomass.
+	Points []ProjectionPoint `json:"points"`
+	// FinalChange is the median final biomass over the median start.
+	FinalChange float64 `json:"final_change"`
+	// ProbBelow20 is the share of runs ending below 20% of B0.
+	ProbBelow20 float64 `json:"prob_below_20pct_b0"`
+	FishingMort float64 `json:"fishing_mortality"`
+}
+
+// project runs the scenario Runs times and summarises the trajectories.
+// Scenario and baseline share random draws so their difference reflects
+// the scenario, not the noise.
+func (lh *lifeHistory) project(sc ProjectionScenario, currentF, t0 float64, draws []projectionDraw) ProjectionTrajectory {
+	sb := lh.spawningBiomass()
+	years := sc.Years + 1
+	biomass := make([][]float64, years)
+	ssb := make([][]float64, years)
+	catch := make([][]float64, years)
+	recruits := make([][]float64, years)
+	below := 0
+	for _, d := range draws {
+		b0 := lh.perRecruit(d.m, 0, lh.Weight)
+		s0 := lh.perRecruit(d.m, 0, sb)
+		run := lh.projectRun(sc, currentF, t0, d.m, d.h, d.devs)
+		for y, p := range run {
+			biomass[y] = append(biomass[y], p.biomass/b0)
+			ssb[y] = append(ssb[y], p.ssb/s0)
+			catch[y] = append(catch[y], p.catch/b0)
+			recruits[y] = append(recruits[y], p.recruits)
+		}
+		if run[sc.Years].biomass/b0 < 0.2 {
+			below++
+		}
+	}
+
+	median := func(v []float64) float64 {
+		sort.Float64s(v)
+		return percentile(v, 0.5)
+	}
+	t := ProjectionTrajectory{Points: make([]ProjectionPoint, years)}
+	for y := 0; y < years; y++ {
+		sort.Float64s(biomass[y])
+		t.Points[y] = ProjectionPoint{
+			Year:     y,
+			Median:   percentile(biomass[y], 0.5),
+			P05:      percentile(biomass[y], 0.05),
+			P25:      percentile(biomass[y], 0.25),
+			P75:      percentile(biomass[y], 0.75),
+			P95:      percentile(biomass[y], 0.95),
+			SSB:      median(ssb[y]),
+			Catch:    median(catch[y]),
+			Recruits: median(recruits[y]),
+		}
+	}
+	if t.Points[0].Median > 0 {
+		t.FinalChange = t.Points[sc.Years].Median/t.Points[0].Median - 1
+	}
+	t.ProbBelow20 = float64(below) / float64(len(draws))
+
+	effort := 100.0
+	if sc.FishingEffortPct != nil {
+		effort = *sc.FishingEffortPct
+	}
+	t.FishingMort = currentF * effort / 100 * (1 - sc.MPACoveragePct/100*(1-sc.EffortDisplacement))
+	return t
+}
+
+type projectionDraw struct {
+	m, h float64
+	devs []float64
+}
+
+type ProjectionResult struct {
+	SpeciesID      int                  `json:"species_id"`
+	ScientificName string               `json:"scientific_name"`
+	Scenario       ProjectionScenario   `json:"scenario"`
+	LifeHistory    *lifeHistory         `json:"life_history"`
+	CurrentF       float64              `json:"current_f"`
+	BaselineTempC  *float64             `json:"baseline_temperature_c,omitempty"`
+	Projection     ProjectionTrajectory `json:"projection"`
+	StatusQuo      ProjectionTrajectory `json:"status_quo"`
+}
+
+func (sc *ProjectionScenario) validate() error {
+	if sc.Years == 0 {
+		sc.Years = defaultProjectionYears
+	}
+	if sc.Runs == 0 {
+		sc.Runs = defaultProjectionRuns
+	}
+	if sc.Seed == 0 {
+		sc.Seed = time.Now().UnixNano()
+	}
+	switch {
+	case sc.Years < 1 || sc.Years > maxProjectionYears:
+		return fmt.Errorf("years must be between 1 and %d", maxProjectionYears)
+	case sc.Runs < 1 || sc.Runs > maxProjectionRuns:
+		return fmt.Errorf("runs must be between 1 and %d", maxProjectionRuns)
+	case sc.FishingEffortPct != nil && (*sc.FishingEffortPct < 0 || *sc.FishingEffortPct > 500):
+		return fmt.Errorf("fishing_effort_pct must be between 0 and 500")
+	case sc.MPACoveragePct < 0 || sc.MPACoveragePct > 100:
+		return fmt.Errorf("mpa_coverage_pct must be between 0 and 100")
+	case sc.EffortDisplacement < 0 || sc.EffortDisplacement > 1:
+		return fmt.Errorf("effort_displacement must be between 0 and 1")
+	case sc.PHChange < -1.5 || sc.PHChange > 0.5:
+		return fmt.Errorf("ph_change must be between -1.5 and 0.5")
+	case sc.TemperatureRiseC < -5 || sc.TemperatureRiseC > 10:
+		return fmt.Errorf("temperature_rise_c must be between -5 and 10")
+	case sc.CurrentF != nil && (*sc.CurrentF < 0 || *sc.CurrentF > 5):
+		return fmt.Errorf("current_f must be between 0 and 5")
+	}
+	return nil
+}
+
+// runProjection projects the scenario alongside the status quo (current
+// effort, no MPA change, today's climate). Without current_f, the stock
+// is taken to be fully exploited, fished down to 40% of unfished spawning
+// biomass.
+func runProjection(s Species, sc ProjectionScenario) *ProjectionResult {
+	lh := newLifeHistory(s)
+	var currentF float64
+	if sc.CurrentF != nil {
+		currentF = *sc.CurrentF
+	} else {
+		currentF = lh.fishingForDepletion(defaultDepletion)
+		lh.Assumptions = append(lh.Assumptions, fmt.Sprintf("current fishing mortality F = %.3f, holding spawning biomass at %.0f%% of unfished", currentF, defaultDepletion*100))
+	}
+	t0 := 0.0
+	if sc.BaselineTemperatureC != nil {
+		t0 = *sc.BaselineTemperatureC
+	} else if lh.ThermalOptC != nil {
+		t0 = *lh.ThermalOptC
+		lh.Assumptions = append(lh.Assumptions, "stock assumed to live at its thermal optimum today")
+	}
+
+	rng := rand.New(rand.NewSource(sc.Seed))
+	draws := make([]projectionDraw, sc.Runs)
+	for i := range draws {
+		d := projectionDraw{
+			m:    lh.M * math.Exp(rng.NormFloat64()*mortalitySigma-mortalitySigma*mortalitySigma/2),
+			h:    math.Min(math.Max(lh.Steepness+rng.NormFloat64()*steepnessSigma, 0.21), 0.99),
+			devs: make([]float64, sc.Years),
+		}
+		if sc.Runs == 1 {
+			d.m, d.h = lh.M, lh.Steepness
+		} else {
+			for y := range d.devs {
+				d.devs[y] = rng.NormFloat64()*recruitmentSigma - recruitmentSigma*recruitmentSigma/2
+			}
+		}
+		draws[i] = d
+	}
+
+	statusQuo := ProjectionScenario{Years: sc.Years, Runs: sc.Runs, Seed: sc.Seed}
+	res := &ProjectionResult{
+		SpeciesID:      s.ID,
+		ScientificName: s.ScientificName,
+		Scenario:       sc,
+		LifeHistory:    lh,
+		CurrentF:       currentF,
+		Projection:     lh.project(sc, currentF, t0, draws),
+		StatusQuo:      lh.project(statusQuo, currentF, t0, draws),
+	}
+	if lh.ThermalOptC != nil || sc.BaselineTemperatureC != nil {
+		res.BaselineTempC = &t0
+	}
+	return res
+}
+
+// runProjectionHandler runs a scenario for one species.
+func runProjectionHandler(w http.ResponseWriter, r *http.Request) {
+	var sc ProjectionScenario
+	if err := json.NewDecoder(r.Body).Decode(&sc); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if sc.SpeciesID == 0 {
+		http.Error(w, "species_id is required", http.StatusBadRequest)
+		return
+	}
+	if err := sc.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s, err := scanSpecies(db.QueryRow("SELECT "+speciesSelectFields+" FROM species_data s WHERE s.id = $1", sc.SpeciesID))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Species not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(runProjection(s, sc))
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseNumbers(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []float64
+	}{
+		{"1.2 million", []float64{1.2e6}},
+		{"50,000-200,000 eggs", []float64{50000, 200000}},
+		{"3 lakh", []float64{3e5}},
+		{"about 20k per spawning", []float64{20000}},
+		{"18-28 °C", []float64{18, 28}},
+		{"unknown", nil},
+	}
+	for _, tt := range tests {
+		got := parseNumbers(tt.in)
+		if len(got) != len(tt.want) {
+			t.Errorf("parseNumbers(%q) = %v, want %v", tt.in, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("parseNumbers(%q) = %v, want %v", tt.in, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func testSpecies() Species {
+	return Species{
+		ScientificName:     "Testus piscis",
+		Phylum:             "Chordata",
+		Longevity:          12,
+		MortalityRate:      0.3,
+		MaxLengthCm:        60,
+		MaxWeightKg:        2.5,
+		AgeOfMaturityYears: 3,
+		Fecundity:          "100,000-300,000 eggs",
+		LarvalSurvival:     0.001,
+		ThermalTolerance:   "20-30",
+	}
+}
+
+func TestPerRecruitUnfished(t *testing.T) {
+	lh := newLifeHistory(testSpecies())
+	ones := make([]float64, lh.Ages)
+	for a := range ones {
+		ones[a] = 1
+	}
+	// With unit quantities and no fishing, the plus group makes the sum
+	// the full geometric series 1/(1-exp(-M)).
+	want := 1 / (1 - math.Exp(-lh.M))
+	if got := lh.perRecruit(lh.M, 0, ones); math.Abs(got-want) > 1e-9 {
+		t.Errorf("perRecruit = %v, want %v", got, want)
+	}
+	if fished := lh.perRecruit(lh.M, 0.5, ones); fished >= want {
+		t.Errorf("fished perRecruit %v not below unfished %v", fished, want)
+	}
+}
+
+func TestNewLifeHistory(t *testing.T) {
+	lh := newLifeHistory(testSpecies())
+	if lh.Ages != 13 {
+		t.Errorf("Ages = %d, want 13", lh.Ages)
+	}
+	if lh.M != 0.3 {
+		t.Errorf("M = %v, want the recorded 0.3", lh.M)
+	}
+	if lh.Fecundity != 200000 {
+		t.Errorf("Fecundity = %v, want the midpoint 200000", lh.Fecundity)
+	}
+	if lh.Steepness <= 0.25 || lh.Steepness > 0.95 {
+		t.Errorf("Steepness = %v outside (0.25, 0.95]", lh.Steepness)
+	}
+	if lh.ThermalOptC == nil || math.Abs(*lh.ThermalOptC-26.6667) > 1e-3 || *lh.ThermalMaxC != 30 {
+		t.Errorf("thermal optimum/max = %v/%v, want 26.67/30", lh.ThermalOptC, lh.ThermalMaxC)
+	}
+	if lh.Calcifier {
+		t.Error("chordate flagged as calcifier")
+	}
+
+	// Missing fields fall back to documented defaults and say so.
+	bare := newLifeHistory(Species{Phylum: "Mollusca"})
+	if bare.Ages != 11 || bare.LinfCm != 50 || bare.Steepness != defaultSteepness || !bare.Calcifier {
+		t.Errorf("defaults: ages %d, linf %v, h %v, calcifier %v", bare.Ages, bare.LinfCm, bare.Steepness, bare.Calcifier)
+	}
+	want := 4.899 * math.Pow(10, -0.916)
+	if math.Abs(bare.M-want) > 1e-12 {
+		t.Errorf("M from longevity = %v, want %v", bare.M, want)
+	}
+	if len(bare.Assumptions) == 0 {
+		t.Error("no assumptions recorded for a bare species")
+	}
+}
+
+func TestThermalPerformance(t *testing.T) {
+	lh := newLifeHistory(testSpecies())
+	if got := lh.thermalPerformance(*lh.ThermalOptC); got != 1 {
+		t.Errorf("performance at optimum = %v, want 1", got)
+	}
+	if got := lh.thermalPerformance(*lh.ThermalMaxC); math.Abs(got-math.Exp(-4)) > 1e-12 {
+		t.Errorf("performance at upper limit = %v, want exp(-4)", got)
+	}
+	if lh.thermalPerformance(*lh.ThermalOptC+1) >= lh.thermalPerformance(*lh.ThermalOptC-1) {
+		t.Error("performance should fall faster above the optimum than below")
+	}
+	if got := newLifeHistory(Species{}).thermalPerformance(40); got != 1 {
+		t.Errorf("performance without tolerance = %v, want 1", got)
+	}
+}
+
+func TestEquilibriumAndDepletion(t *testing.T) {
+	lh := newLifeHistory(testSpecies())
+	if got := lh.equilibriumRecruits(lh.M, lh.Steepness, 0); math.Abs(got-1) > 1e-9 {
+		t.Errorf("unfished equilibrium recruits = %v, want R0 = 1", got)
+	}
+	if got := lh.equilibriumRecruits(lh.M, lh.Steepness, 5); got != 0 {
+		t.Errorf("equilibrium recruits at F = 5 = %v, want 0 (crashed)", got)
+	}
+
+	sb := lh.spawningBiomass()
+	spr0 := lh.perRecruit(lh.M, 0, sb)
+	for _, depletion := range []float64{0.2, 0.4, 0.6} {
+		f := lh.fishingForDepletion(depletion)
+		got := lh.equilibriumRecruits(lh.M, lh.Steepness, f) * lh.perRecruit(lh.M, f, sb) / spr0
+		if math.Abs(got-depletion) > 1e-6 {
+			t.Errorf("fishingForDepletion(%v) = %v gives depletion %v", depletion, f, got)
+		}
+	}
+}
+
+func TestProjectRunStaysAtEquilibrium(t *testing.T) {
+	lh := newLifeHistory(testSpecies())
+	f := lh.fishingForDepletion(defaultDepletion)
+	sc := ProjectionScenario{Years: 20}
+	run := lh.projectRun(sc, f, *lh.ThermalOptC, lh.M, lh.Steepness, make([]float64, sc.Years))
+	for y := 1; y <= sc.Years; y++ {
+		if math.Abs(run[y].biomass/run[0].biomass-1) > 1e-6 {
+			t.Fatalf("year %d biomass %v drifted from equilibrium %v", y, run[y].biomass, run[0].biomass)
+		}
+	}
+}
+
+func TestRunProjection(t *testing.T) {
+	closed := 0.0
+	sc := ProjectionScenario{Years: 15, Runs: 50, Seed: 7, FishingEffortPct: &closed}
+	if err := sc.validate(); err != nil {
+		t.Fatal(err)
+	}
+	res := runProjection(testSpecies(), sc)
+	again := runProjection(testSpecies(), sc)
+	if res.Projection.Points[sc.Years] != again.Projection.Points[sc.Years] {
+		t.Error("projection not reproducible for a fixed seed")
+	}
+	if res.Projection.FinalChange <= 0 {
+		t.Errorf("closing the fishery changed biomass by %v, want a rise", res.Projection.FinalChange)
+	}
+	if math.Abs(res.StatusQuo.Points[0].Median-res.Projection.Points[0].Median) > 1e-12 {
+		t.Error("scenario and status quo should start from the same state")
+	}
+	for _, p := range res.Projection.Points {
+		if p.P05 > p.P25 || p.P25 > p.Median || p.Median > p.P75 || p.P75 > p.P95 {
+			t.Fatalf("year %d bands out of order: %+v", p.Year, p)
+		}
+	}
+}
+
+func TestProjectionScenarioValidate(t *testing.T) {
+	sc := ProjectionScenario{}
+	if err := sc.validate(); err != nil {
+		t.Fatalf("defaults rejected: %v", err)
+	}
+	if sc.Years != defaultProjectionYears || sc.Runs != defaultProjectionRuns || sc.Seed == 0 {
+		t.Errorf("defaults not filled: %+v", sc)
+	}
+	bad := []ProjectionScenario{
+		{Years: maxProjectionYears + 1},
+		{Runs: maxProjectionRuns + 1},
+		{MPACoveragePct: 120},
+		{EffortDisplacement: 2},
+		{PHChange: -2},
+		{TemperatureRiseC: 11},
+	}
+	for _, sc := range bad {
+		if err := sc.validate(); err == nil {
+			t.Errorf("validate(%+v) accepted", sc)
+		}
+	}
+}
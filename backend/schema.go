@@ -0,0 +1,38 @@
+package main
+
+import "log"
+
+// --- Schema ---
+
+// schemaStatements holds the DDL for tables owned by the backend. The core
+// species_data, occurrence_data and otolith_metadata tables come from the
+// database dump and are not created here.
+var schemaStatements = []string{
+	taxonomySchema,
+	citizenSchema,
+	datasetSchema,
+	tusSchema,
+	datasetVersionSchema,
+	envSchema,
+	envClimatologySchema,
+	alertSchema,
+	alertRuleSchema,
+	alertSubscriptionSchema,
+	invasiveSchema,
+	fisheriesSchema,
+	lengthSampleSchema,
+	envLayerSchema,
+	foodWebSchema,
+	planktonSchema,
+	aquacultureSchema,
+	conservationSchema,
+	mpaSchema,
+}
+
+func ensureSchema() {
+	for _, stmt := range schemaStatements {
+		if _, err := db.Exec(stmt); err != nil {
+			log.Fatal("Could not apply schema:", err)
+		}
+	}
+}
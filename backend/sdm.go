@@ -0,0 +1,609 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Species Distribution Models ---
+//
+// Presence/background logistic regression on the environmental grid
+// layers. Presences are a species' occurrence_data coordinates thinned
+// to one per cell of the first layer; background points are random
+// cells with data in every layer. Each layer enters as a linear and a
+// quadratic term on standardised values, so suitability can peak
+// inside a layer's range. Background points are down-weighted to the
+// total weight of the presences and the coefficients carry a ridge
+// penalty, which makes the fit behave like MaxEnt with linear and
+// quadratic features. Suitability is the fitted probability, a relative
+// index rather than probability of presence.
+//
+// Fitted models are cached per species, layer set and sampling options,
+// so map tiles reuse one fit.
+
+const (
+	defaultBackgroundPoints = 10000
+	maxBackgroundPoints     = 50000
+	minPresences            = 10
+	sdmRidge                = 1.0
+	sdmCacheTTL             = time.Hour
+	maxCachedSDMs           = 64
+	defaultGeoJSONCells     = 20000
+	maxGeoJSONCells         = 200000
+	maxSuitabilityPNG       = 2048
+	tileSize                = 256
+)
+
+type ResponsePoint struct {
+	Value       float64 `json:"value"`
+	Suitability float64 `json:"suitability"`
+}
+
+type SDMLayer struct {
+	Name      string  `json:"name"`
+	Parameter string  `json:"parameter"`
+	Unit      string  `json:"unit"`
+	Mean      float64 `json:"mean"`
+	SD        float64 `json:"sd"`
+	Linear    float64 `json:"linear"`
+	Quadratic float64 `json:"quadratic"`
+	// Response varies this layer over the background's 1st to 99th
+	// percentile with the others held at their background mean.
+	Response []ResponsePoint `json:"response"`
+}
+
+type SDMModel struct {
+	SpeciesID      int        `json:"species_id"`
+	ScientificName string     `json:"scientific_name"`
+	Layers         []SDMLayer `json:"layers"`
+	Intercept      float64    `json:"intercept"`
+	Occurrences    int        `json:"occurrences"`
+	Presences      int        `json:"presences"`
+	Background     int        `json:"background"`
+	Seed           int64      `json:"seed"`
+	TrainAUC       float64    `json:"train_auc"`
+	// TestAUC is from a 25% presence and background holdout, when there
+	// are at least 20 presences.
+	TestAUC  *float64   `json:"test_auc"`
+	FittedAt time.Time  `json:"fitted_at"`
+	Extent   [4]float64 `json:"extent"`
+	grids    []*Grid
+}
+
+type SDMOptions struct {
+	Layers     []string
+	Background int
+	Seed       int64
+}
+
+func (o SDMOptions) key(speciesID int) string {
+	return fmt.Sprintf("%d|%s|%d|%d", speciesID, strings.Join(o.Layers, ","), o.Background, o.Seed)
+}
+
+// features expands raw layer values into standardised linear and
+// quadratic terms after a leading intercept.
+func (m *SDMModel) features(env []float64, out []float64) []float64 {
+	out = append(out[:0], 1)
+	for i, l := range m.Layers {
+		z := (env[i] - l.Mean) / l.SD
+		out = append(out, z, z*z)
+	}
+	return out
+}
+
+func (m *SDMModel) predict(env []float64) float64 {
+	eta := m.Intercept
+	for i, l := range m.Layers {
+		z := (env[i] - l.Mean) / l.SD
+		eta += l.Linear*z + l.Quadratic*z*z
+	}
+	return 1 / (1 + math.Exp(-eta))
+}
+
+// envAt samples every layer at a point into env, reporting false when any
+// layer has no data there.
+func (m *SDMModel) envAt(lon, lat float64, env []float64) bool {
+	for i, g := range m.grids {
+		v := g.sample(lon, lat)
+		if math.IsNaN(v) {
+			return false
+		}
+		env[i] = v
+	}
+	return true
+}
+
+// suitabilityAt is NaN outside the layers' common coverage.
+func (m *SDMModel) suitabilityAt(lon, lat float64, env []float64) float64 {
+	if !m.envAt(lon, lat, env) {
+		return math.NaN()
+	}
+	return m.predict(env)
+}
+
+// fitLogistic fits weighted logistic regression with a ridge penalty on
+// all but the intercept by iteratively reweighted least squares.
+func fitLogistic(x [][]float64, y, weights []float64, ridge float64) []float64 {
+	p := len(x[0])
+	beta := make([]float64, p)
+	for iter := 0; iter < 50; iter++ {
+		h := make([][]float64, p)
+		for i := range h {
+			h[i] = make([]float64, p)
+		}
+		g := make([]float64, p)
+		for n, row := range x {
+			eta := 0.0
+			for j, v := range row {
+				eta += beta[j] * v
+			}
+			mu := 1 / (1 + math.Exp(-eta))
+			wt := weights[n] * math.Max(mu*(1-mu), 1e-10)
+			r := weights[n] * (y[n] - mu)
+			for j, vj := range row {
+				g[j] += r * vj
+				for k := j; k < p; k++ {
+					h[j][k] += wt * vj * row[k]
+				}
+			}
+		}
+		for j := 0; j < p; j++ {
+			for k := 0; k < j; k++ {
+				h[j][k] = h[k][j]
+			}
+			if j > 0 {
+				h[j][j] += ridge
+				g[j] -= ridge * beta[j]
+			}
+		}
+		step := solveLinear(h, g)
+		if step == nil {
+			break
+		}
+		change := 0.0
+		for j := range beta {
+			beta[j] += step[j]
+			change = math.Max(change, math.Abs(step[j]))
+		}
+		if change < 1e-8 {
+			break
+		}
+	}
+	return beta
+}
+
+// solveLinear solves a x = b by Gaussian elimination with partial
+// pivoting, returning nil for a singular system.
+func solveLinear(a [][]float64, b []float64) []float64 {
+	n := len(b)
+	m := make([][]float64, n)
+	for i := range a {
+		m[i] = append(append([]float64{}, a[i]...), b[i])
+	}
+	for c := 0; c < n; c++ {
+		pivot := c
+		for r := c + 1; r < n; r++ {
+			if math.Abs(m[r][c]) > math.Abs(m[pivot][c]) {
+				pivot = r
+			}
+		}
+		if math.Abs(m[pivot][c]) < 1e-12 {
+			return nil
+		}
+		m[c], m[pivot] = m[pivot], m[c]
+		for r := c + 1; r < n; r++ {
+			f := m[r][c] / m[c][c]
+			for k := c; k <= n; k++ {
+				m[r][k] -= f * m[c][k]
+			}
+		}
+	}
+	x := make([]float64, n)
+	for r := n - 1; r >= 0; r-- {
+		s := m[r][n]
+		for k := r + 1; k < n; k++ {
+			s -= m[r][k] * x[k]
+		}
+		x[r] = s / m[r][r]
+	}
+	return x
+}
+
+// auc is the probability a presence scores above a background point.
+func auc(presence, background []float64) float64 {
+	type scored struct {
+		v        float64
+		presence bool
+	}
+	all := make([]scored, 0, len(presence)+len(background))
+	for _, v := range presence {
+		all = append(all, scored{v, true})
+	}
+	for _, v := range background {
+		all = append(all, scored{v, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].v < all[j].v })
+	var rankSum float64
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].presence {
+				rankSum += rank
+			}
+		}
+		i = j
+	}
+	np, nb := float64(len(presence)), float64(len(background))
+	return (rankSum - np*(np+1)/2) / (np * nb)
+}
+
+// fitCoefficients standardises on the background and fits the model's
+// coefficients to the given presence and background environments.
+func (m *SDMModel) fitCoefficients(pres, bg [][]float64) {
+	nl := len(m.Layers)
+	for i := 0; i < nl; i++ {
+		var sum, sq float64
+		for _, e := range bg {
+			sum += e[i]
+		}
+		mean := sum / float64(len(bg))
+		for _, e := range bg {
+			sq += (e[i] - mean) * (e[i] - mean)
+		}
+		sd := math.Sqrt(sq / float64(len(bg)))
+		if sd == 0 {
+			sd = 1
+		}
+		m.Layers[i].Mean, m.Layers[i].SD = mean, sd
+	}
+
+	var x [][]float64
+	var y, wts []float64
+	bgWeight := float64(len(pres)) / float64(len(bg))
+	for _, e := range pres {
+		x = append(x, m.features(e, nil))
+		y = append(y, 1)
+		wts = append(wts, 1)
+	}
+	for _, e := range bg {
+		x = append(x, m.features(e, nil))
+		y = append(y, 0)
+		wts = append(wts, bgWeight)
+	}
+	beta := fitLogistic(x, y, wts, sdmRidge)
+	m.Intercept = beta[0]
+	for i := range m.Layers {
+		m.Layers[i].Linear, m.Layers[i].Quadratic = beta[1+2*i], beta[2+2*i]
+	}
+}
+
+func (m *SDMModel) scores(envs [][]float64) []float64 {
+	out := make([]float64, len(envs))
+	for i, e := range envs {
+		out[i] = m.predict(e)
+	}
+	return out
+}
+
+// fitSDM loads the layers and occurrences and fits the model.
+func fitSDM(ctx context.Context, speciesID int, opts SDMOptions) (*SDMModel, error) {
+	layers, err := envLayersByName(opts.Layers)
+	if err != nil {
+		return nil, err
+	}
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("no environmental layers have been uploaded")
+	}
+	m := &SDMModel{SpeciesID: speciesID, Seed: opts.Seed, FittedAt: time.Now().UTC()}
+	for _, l := range layers {
+		g, err := loadGrid(ctx, l)
+		if err != nil {
+			return nil, err
+		}
+		m.grids = append(m.grids, g)
+		m.Layers = append(m.Layers, SDMLayer{Name: l.Name, Parameter: l.Parameter, Unit: l.Unit})
+	}
+	ref := m.grids[0]
+	m.Extent = [4]float64{ref.XLL, ref.YLL, ref.XLL + float64(ref.NCols)*ref.CellSize, ref.YLL + float64(ref.NRows)*ref.CellSize}
+
+	if err := db.QueryRow("SELECT COALESCE(scientific_name, '') FROM species_data WHERE id = $1", speciesID).Scan(&m.ScientificName); err != nil {
+		return nil, fmt.Errorf("species %d not found", speciesID)
+	}
+	rows, err := db.Query(`SELECT decimallatitude, decimallongitude FROM occurrence_data
+		WHERE species_id = $1 AND decimallatitude IS NOT NULL AND decimallongitude IS NOT NULL`, speciesID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	seen := map[int]bool{}
+	var pres [][]float64
+	for rows.Next() {
+		var lat, lon float64
+		if err := rows.Scan(&lat, &lon); err != nil {
+			return nil, err
+		}
+		m.Occurrences++
+		col, row := ref.cell(lon, lat)
+		idx := row*ref.NCols + col
+		if col < 0 || row < 0 || col >= ref.NCols || row >= ref.NRows || seen[idx] {
+			continue
+		}
+		env := make([]float64, len(m.grids))
+		if !m.envAt(lon, lat, env) {
+			continue
+		}
+		seen[idx] = true
+		pres = append(pres, env)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(pres) < minPresences {
+		return nil, fmt.Errorf("need at least %d occurrences in distinct cells with data in every layer, have %d of %d", minPresences, len(pres), m.Occurrences)
+	}
+
+	rng := rand.New(rand.NewSource(opts.Seed))
+	var bg [][]float64
+	for tries := 0; len(bg) < opts.Background && tries < opts.Background*20; tries++ {
+		col, row := rng.Intn(ref.NCols), rng.Intn(ref.NRows)
+		lon, lat := ref.center(col, row)
+		env := make([]float64, len(m.grids))
+		if m.envAt(lon, lat, env) {
+			bg = append(bg, env)
+		}
+	}
+	if len(bg) < 10*minPresences {
+		return nil, fmt.Errorf("layers share too few cells with data to sample background points")
+	}
+	m.Presences, m.Background = len(pres), len(bg)
+
+	if len(pres) >= 20 {
+		rng.Shuffle(len(pres), func(i, j int) { pres[i], pres[j] = pres[j], pres[i] })
+		tp, tb := len(pres)/4, len(bg)/4
+		m.fitCoefficients(pres[tp:], bg[tb:])
+		test := auc(m.scores(pres[:tp]), m.scores(bg[:tb]))
+		m.TestAUC = &test
+	}
+	m.fitCoefficients(pres, bg)
+	m.TrainAUC = auc(m.scores(pres), m.scores(bg))
+
+	env := make([]float64, len(m.Layers))
+	for i := range m.Layers {
+		vals := make([]float64, len(bg))
+		for j, e := range bg {
+			vals[j] = e[i]
+		}
+		sort.Float64s(vals)
+		lo, hi := percentile(vals, 0.01), percentile(vals, 0.99)
+		for k := range env {
+			env[k] = m.Layers[k].Mean
+		}
+		for s := 0; s <= 20; s++ {
+			env[i] = lo + (hi-lo)*float64(s)/20
+			m.Layers[i].Response = append(m.Layers[i].Response, ResponsePoint{Value: env[i], Suitability: m.predict(env)})
+		}
+	}
+	return m, nil
+}
+
+var sdmCache = newLRUCache[string, *SDMModel](maxCachedSDMs, nil)
+
+func clearSDMCache() {
+	sdmCache.clear()
+}
+
+// cachedSDM returns a fit younger than sdmCacheTTL, or fits a new one.
+func cachedSDM(ctx context.Context, speciesID int, opts SDMOptions, refit bool) (*SDMModel, error) {
+	key := opts.key(speciesID)
+	m, ok := sdmCache.get(key)
+	if ok && !refit && time.Since(m.FittedAt) < sdmCacheTTL {
+		return m, nil
+	}
+	m, err := fitSDM(ctx, speciesID, opts)
+	if err != nil {
+		return nil, err
+	}
+	sdmCache.put(key, m)
+	return m, nil
+}
+
+// sdmRequest reads the species and ?layers=, ?background=, ?seed= and
+// ?refit= and returns the model, writing the error response itself.
+// Refitting is expensive, so ?refit=true needs a contributor.
+func sdmRequest(w http.ResponseWriter, r *http.Request) (*SDMModel, bool) {
+	speciesID, err := strconv.Atoi(r.PathValue("species_id"))
+	if err != nil {
+		http.Error(w, "Invalid species id", http.StatusBadRequest)
+		return nil, false
+	}
+	q := r.URL.Query()
+	opts := SDMOptions{Background: defaultBackgroundPoints, Seed: 1}
+	if v := q.Get("layers"); v != "" {
+		opts.Layers = splitList([]string{v}, ",")
+	}
+	if v := q.Get("background"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 100 || n > maxBackgroundPoints {
+			http.Error(w, fmt.Sprintf("background must be between 100 and %d", maxBackgroundPoints), http.StatusBadRequest)
+			return nil, false
+		}
+		opts.Background = n
+	}
+	if v := q.Get("seed"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, "seed must be an integer", http.StatusBadRequest)
+			return nil, false
+		}
+		opts.Seed = n
+	}
+	refit, _ := strconv.ParseBool(q.Get("refit"))
+	if refit {
+		if _, ok := requireRole(w, r, contributorRoles...); !ok {
+			return nil, false
+		}
+	}
+
+	m, err := cachedSDM(r.Context(), speciesID, opts, refit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return nil, false
+	}
+	return m, true
+}
+
+// suitabilityRamp is viridis at five stops.
+var suitabilityRamp = []color.NRGBA{
+	{68, 1, 84, 255}, {59, 82, 139, 255}, {33, 145, 140, 255}, {94, 201, 98, 255}, {253, 231, 37, 255},
+}
+
+func suitabilityColor(v float64) color.NRGBA {
+	if math.IsNaN(v) {
+		return color.NRGBA{}
+	}
+	pos := math.Min(math.Max(v, 0), 1) * float64(len(suitabilityRamp)-1)
+	i := min(int(pos), len(suitabilityRamp)-2)
+	t := pos - float64(i)
+	a, b := suitabilityRamp[i], suitabilityRamp[i+1]
+	mix := func(x, y uint8) uint8 { return uint8(float64(x) + t*(float64(y)-float64(x)) + 0.5) }
+	return color.NRGBA{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), 200}
+}
+
+func writePNG(w http.ResponseWriter, img image.Image) {
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	png.Encode(w, img)
+}
+
+func getSDMModel(w http.ResponseWriter, r *http.Request) {
+	m, ok := sdmRequest(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m)
+}
+
+// getSDMSuitability returns the suitability grid over the first layer's
+// extent, as GeoJSON cell polygons (?format=geojson, coarsened to at most
+// ?max_cells cells) or as one PNG image (?format=png).
+func getSDMSuitability(w http.ResponseWriter, r *http.Request) {
+	m, ok := sdmRequest(w, r)
+	if !ok {
+		return
+	}
+	ref := m.grids[0]
+	env := make([]float64, len(m.grids))
+	q := r.URL.Query()
+
+	switch q.Get("format") {
+	case "png":
+		stride := int(math.Ceil(float64(max(ref.NCols, ref.NRows)) / maxSuitabilityPNG))
+		img := image.NewNRGBA(image.Rect(0, 0, (ref.NCols+stride-1)/stride, (ref.NRows+stride-1)/stride))
+		for py := 0; py < img.Rect.Dy(); py++ {
+			for px := 0; px < img.Rect.Dx(); px++ {
+				lon, lat := ref.center(px*stride+stride/2, py*stride+stride/2)
+				img.SetNRGBA(px, py, suitabilityColor(m.suitabilityAt(lon, lat, env)))
+			}
+		}
+		w.Header().Set("X-Extent", fmt.Sprintf("%g,%g,%g,%g", m.Extent[0], m.Extent[1], m.Extent[2], m.Extent[3]))
+		writePNG(w, img)
+		return
+	case "", "geojson":
+	default:
+		http.Error(w, "format must be geojson or png", http.StatusBadRequest)
+		return
+	}
+
+	maxCells := defaultGeoJSONCells
+	if v := q.Get("max_cells"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxGeoJSONCells {
+			http.Error(w, fmt.Sprintf("max_cells must be between 1 and %d", maxGeoJSONCells), http.StatusBadRequest)
+			return
+		}
+		maxCells = n
+	}
+	stride := max(1, int(math.Ceil(math.Sqrt(float64(ref.NCols*ref.NRows)/float64(maxCells)))))
+	size := ref.CellSize * float64(stride)
+
+	type feature struct {
+		Type       string             `json:"type"`
+		Geometry   map[string]any     `json:"geometry"`
+		Properties map[string]float64 `json:"properties"`
+	}
+	features := []feature{}
+	for row := 0; row < ref.NRows; row += stride {
+		for col := 0; col < ref.NCols; col += stride {
+			lon, lat := ref.center(col+stride/2, row+stride/2)
+			s := m.suitabilityAt(lon, lat, env)
+			if math.IsNaN(s) {
+				continue
+			}
+			west := ref.XLL + float64(col)*ref.CellSize
+			north := ref.YLL + float64(ref.NRows-row)*ref.CellSize
+			east, south := math.Min(west+size, m.Extent[2]), math.Max(north-size, m.Extent[1])
+			features = append(features, feature{
+				Type: "Feature",
+				Geometry: map[string]any{
+					"type":        "Polygon",
+					"coordinates": [][][2]float64{{{west, south}, {east, south}, {east, north}, {west, north}, {west, south}}},
+				},
+				Properties: map[string]float64{"suitability": math.Round(s*1e4) / 1e4},
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"type":      "FeatureCollection",
+		"features":  features,
+		"cell_size": size,
+		"model":     m,
+	})
+}
+
+// getSDMTile renders a 256-pixel web mercator tile, /tiles/{z}/{x}/{y}.png.
+func getSDMTile(w http.ResponseWriter, r *http.Request) {
+	z, errZ := strconv.Atoi(r.PathValue("z"))
+	x, errX := strconv.Atoi(r.PathValue("x"))
+	y, errY := strconv.Atoi(strings.TrimSuffix(r.PathValue("y"), ".png"))
+	if errZ != nil || errX != nil || errY != nil || z < 0 || z > 22 || x < 0 || y < 0 || x >= 1<<z || y >= 1<<z {
+		http.Error(w, "Invalid tile coordinates", http.StatusBadRequest)
+		return
+	}
+	m, ok := sdmRequest(w, r)
+	if !ok {
+		return
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, tileSize, tileSize))
+	env := make([]float64, len(m.grids))
+	n := float64(int(1) << z)
+	for py := 0; py < tileSize; py++ {
+		lat := math.Atan(math.Sinh(math.Pi*(1-2*(float64(y)+(float64(py)+0.5)/tileSize)/n))) * 180 / math.Pi
+		if lat < m.Extent[1] || lat > m.Extent[3] {
+			continue
+		}
+		for px := 0; px < tileSize; px++ {
+			lon := (float64(x)+(float64(px)+0.5)/tileSize)/n*360 - 180
+			img.SetNRGBA(px, py, suitabilityColor(m.suitabilityAt(lon, lat, env)))
+		}
+	}
+	writePNG(w, img)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestAUC(t *testing.T) {
+	tests := []struct {
+		name                 string
+		presence, background []float64
+		want                 float64
+	}{
+		{"perfect", []float64{0.8, 0.9}, []float64{0.1, 0.2, 0.3}, 1},
+		{"inverted", []float64{0.1}, []float64{0.5, 0.6}, 0},
+		{"all tied", []float64{0.5, 0.5}, []float64{0.5, 0.5}, 0.5},
+		// Pairs: 0.6>0.2, 0.6<0.7, 0.4>0.2, 0.4<0.7, so 2 of 4.
+		{"mixed", []float64{0.6, 0.4}, []float64{0.2, 0.7}, 0.5},
+		// Pairs: 0.5>0.1, 0.5=0.5 counts half, 0.9 beats both: 3.5 of 4.
+		{"ties count half", []float64{0.5, 0.9}, []float64{0.1, 0.5}, 0.875},
+	}
+	for _, tt := range tests {
+		if got := auc(tt.presence, tt.background); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("%s: auc = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSolveLinear(t *testing.T) {
+	// Needs a row swap: the first pivot is zero.
+	a := [][]float64{{0, 2, 1}, {1, 1, 1}, {2, 1, 3}}
+	b := []float64{7, 6, 13}
+	x := solveLinear(a, b)
+	want := []float64{1, 2, 3}
+	for i := range want {
+		if math.Abs(x[i]-want[i]) > 1e-12 {
+			t.Fatalf("solveLinear = %v, want %v", x, want)
+		}
+	}
+	if a[0][0] != 0 || b[0] != 7 {
+		t.Error("solveLinear modified its inputs")
+	}
+	if x := solveLinear([][]float64{{1, 2}, {2, 4}}, []float64{1, 2}); x != nil {
+		t.Errorf("singular system solved as %v, want nil", x)
+	}
+}
+
+func TestFitLogisticRecoversCoefficients(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	const b0, b1 = -0.5, 1.5
+	var x [][]float64
+	var y, w []float64
+	for i := 0; i < 20000; i++ {
+		v := rng.NormFloat64()
+		p := 1 / (1 + math.Exp(-(b0 + b1*v)))
+		obs := 0.0
+		if rng.Float64() < p {
+			obs = 1
+		}
+		x = append(x, []float64{1, v})
+		y = append(y, obs)
+		w = append(w, 1)
+	}
+	beta := fitLogistic(x, y, w, 0)
+	if math.Abs(beta[0]-b0) > 0.1 || math.Abs(beta[1]-b1) > 0.1 {
+		t.Errorf("fitLogistic = %v, want about [%v %v]", beta, b0, b1)
+	}
+
+	// The ridge shrinks the slope but leaves the intercept unpenalised.
+	ridged := fitLogistic(x, y, w, 5000)
+	if math.Abs(ridged[1]) >= math.Abs(beta[1]) {
+		t.Errorf("ridge slope %v not shrunk from %v", ridged[1], beta[1])
+	}
+}
+
+func TestParseASCIIGrid(t *testing.T) {
+	g, err := parseASCIIGrid(strings.NewReader(`ncols 3
+nrows 2
+xllcenter 10.5
+yllcenter 20.5
+cellsize 1
+NODATA_value -9999
+1 2 3
+4 -9999 6
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.XLL != 10 || g.YLL != 20 || g.NCols != 3 || g.NRows != 2 {
+		t.Errorf("header read as %+v", g)
+	}
+	// Rows run north to south, so the first row is the northern one.
+	if v := g.sample(10.5, 21.5); v != 1 {
+		t.Errorf("north-west cell = %v, want 1", v)
+	}
+	if v := g.sample(12.5, 20.5); v != 6 {
+		t.Errorf("south-east cell = %v, want 6", v)
+	}
+	if v := g.sample(11.5, 20.5); !math.IsNaN(v) {
+		t.Errorf("nodata cell = %v, want NaN", v)
+	}
+
+	bad := map[string]string{
+		"huge dimension": "ncols 1e300\nnrows 1\nxllcorner 0\nyllcorner 0\ncellsize 1\n1",
+		"too many cells": "ncols 10000\nnrows 10000\nxllcorner 0\nyllcorner 0\ncellsize 0.001\n1",
+		"short":          "ncols 2\nnrows 2\nxllcorner 0\nyllcorner 0\ncellsize 1\n1 2 3",
+		"projected":      "ncols 2\nnrows 1\nxllcorner 500000\nyllcorner 0\ncellsize 30\n1 2",
+		"no corner":      "ncols 2\nnrows 1\ncellsize 1\n1 2",
+	}
+	for name, src := range bad {
+		if _, err := parseASCIIGrid(strings.NewReader(src)); err == nil {
+			t.Errorf("%s: parsed without error", name)
+		}
+	}
+}
+
+func TestLRUCache(t *testing.T) {
+	c := newLRUCache[string, int](10, func(v int) int { return v })
+	c.put("a", 4)
+	c.put("b", 4)
+	c.get("a") // a is now more recent than b
+	c.put("c", 4)
+	if _, ok := c.get("b"); ok {
+		t.Error("least recently used entry was not evicted")
+	}
+	for _, k := range []string{"a", "c"} {
+		if _, ok := c.get(k); !ok {
+			t.Errorf("%s evicted", k)
+		}
+	}
+
+	c.put("a", 2) // replacing an entry releases its old cost
+	if c.used != 6 {
+		t.Errorf("used = %d after replacement, want 6", c.used)
+	}
+	c.put("big", 50)
+	if v, ok := c.get("big"); !ok || v != 50 || c.order.Len() != 1 {
+		t.Errorf("oversized entry: ok %v, entries %d", ok, c.order.Len())
+	}
+	c.remove("big")
+	if c.used != 0 || len(c.items) != 0 {
+		t.Errorf("remove left used %d, %d items", c.used, len(c.items))
+	}
+
+	counted := newLRUCache[int, string](2, nil)
+	counted.put(1, "x")
+	counted.put(2, "y")
+	counted.put(3, "z")
+	if _, ok := counted.get(1); ok {
+		t.Error("count-bounded cache kept three entries")
+	}
+	counted.clear()
+	if _, ok := counted.get(3); ok || counted.used != 0 {
+		t.Error("clear left entries behind")
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Sighting History ---
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+type Sighting struct {
+	Date       string   `json:"date"`
+	Location   string   `json:"location"`
+	WaterDepth float64  `json:"water_depth"`
+	RecordedBy string   `json:"recorded_by"`
+	Latitude   *float64 `json:"latitude"`
+	Longitude  *float64 `json:"longitude"`
+}
+
+type TimelinePoint struct {
+	Period  string `json:"period"`
+	Count   int    `json:"count"`
+	Regions int    `json:"regions"`
+}
+
+type RegionSightings struct {
+	Region    string `json:"region"`
+	Count     int    `json:"count"`
+	FirstSeen string `json:"first_seen"`
+	LastSeen  string `json:"last_seen"`
+}
+
+type SightingHistory struct {
+	SpeciesID int               `json:"species_id"`
+	Total     int               `json:"total"`
+	Page      int               `json:"page"`
+	PageSize  int               `json:"page_size"`
+	FirstSeen string            `json:"first_seen"`
+	LastSeen  string            `json:"last_seen"`
+	Interval  string            `json:"interval"`
+	Sightings []Sighting        `json:"sightings"`
+	Timeline  []TimelinePoint   `json:"timeline"`
+	Regions   []RegionSightings `json:"regions"`
+}
+
+// pagination reads page and page_size, clamping them to sane values.
+func pagination(r *http.Request) (page, pageSize int) {
+	page, pageSize = 1, defaultPageSize
+	if p, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil && p > 0 {
+		page = p
+	}
+	if ps, err := strconv.Atoi(r.URL.Query().Get("page_size")); err == nil && ps > 0 {
+		pageSize = min(ps, maxPageSize)
+	}
+	return page, pageSize
+}
+
+func speciesExists(id int) (bool, error) {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM species_data WHERE id = $1)", id).Scan(&exists)
+	return exists, err
+}
+
+func getSpeciesSightings(w http.ResponseWriter, r *http.Request) {
+	speciesID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid species id", http.StatusBadRequest)
+		return
+	}
+	exists, err := speciesExists(speciesID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Species not found", http.StatusNotFound)
+		return
+	}
+
+	q := r.URL.Query()
+	interval := q.Get("interval")
+	periodFormat := "YYYY-MM"
+	if interval == "year" {
+		periodFormat = "YYYY"
+	} else {
+		interval = "month"
+	}
+
+	// Undated records cannot be placed in a history; counting them would
+	// make the total disagree with the sightings listed.
+	where := []string{"species_id = $1", "eventdate IS NOT NULL"}
+	args := []interface{}{speciesID}
+	if region := q.Get("region"); region != "" {
+		args = append(args, region)
+		where = append(where, "region = $"+strconv.Itoa(len(args)))
+	}
+	for _, f := range []struct{ param, op string }{{"from", ">="}, {"to", "<="}} {
+		if v := q.Get(f.param); v != "" {
+			if _, err := time.Parse("2006-01-02", v); err != nil {
+				http.Error(w, f.param+" must be a YYYY-MM-DD date", http.StatusBadRequest)
+				return
+			}
+			args = append(args, v)
+			where = append(where, "eventdate::date "+f.op+" $"+strconv.Itoa(len(args))+"::date")
+		}
+	}
+	whereSQL := " WHERE " + strings.Join(where, " AND ")
+
+	page, pageSize := pagination(r)
+	history := SightingHistory{
+		SpeciesID: speciesID,
+		Page:      page,
+		PageSize:  pageSize,
+		Interval:  interval,
+		Sightings: []Sighting{},
+		Timeline:  []TimelinePoint{},
+		Regions:   []RegionSightings{},
+	}
+
+	var firstSeen, lastSeen sql.NullString
+	err = db.QueryRow("SELECT COUNT(*), MIN(eventdate)::text, MAX(eventdate)::text FROM occurrence_data"+whereSQL, args...).
+		Scan(&history.Total, &firstSeen, &lastSeen)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	history.FirstSeen, history.LastSeen = firstSeen.String, lastSeen.String
+
+	// An empty history is a normal answer, not an error.
+	if history.Total == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(history)
+		return
+	}
+
+	pageArgs := append(append([]interface{}{}, args...), pageSize, (page-1)*pageSize)
+	rows, err := db.Query(`SELECT eventdate::text, COALESCE(region, ''), COALESCE(waterdepth_m, 0), COALESCE(recordedby, ''),
+		decimallatitude, decimallongitude FROM occurrence_data`+whereSQL+
+		" ORDER BY eventdate DESC LIMIT $"+strconv.Itoa(len(args)+1)+" OFFSET $"+strconv.Itoa(len(args)+2), pageArgs...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var s Sighting
+		var lat, lon sql.NullFloat64
+		if err := rows.Scan(&s.Date, &s.Location, &s.WaterDepth, &s.RecordedBy, &lat, &lon); err != nil {
+			continue
+		}
+		if lat.Valid && lon.Valid {
+			s.Latitude, s.Longitude = &lat.Float64, &lon.Float64
+		}
+		history.Sightings = append(history.Sightings, s)
+	}
+	rows.Close()
+
+	rows, err = db.Query("SELECT to_char(eventdate::date, '"+periodFormat+"') AS period, COUNT(*), COUNT(DISTINCT region) FROM occurrence_data"+
+		whereSQL+" GROUP BY period ORDER BY period", args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var t TimelinePoint
+		if err := rows.Scan(&t.Period, &t.Count, &t.Regions); err != nil {
+			continue
+		}
+		history.Timeline = append(history.Timeline, t)
+	}
+	rows.Close()
+
+	rows, err = db.Query("SELECT region, COUNT(*), COALESCE(MIN(eventdate)::text, ''), COALESCE(MAX(eventdate)::text, '') FROM occurrence_data"+
+		whereSQL+" AND region IS NOT NULL GROUP BY region ORDER BY MAX(eventdate) DESC", args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var rs RegionSightings
+		if err := rows.Scan(&rs.Region, &rs.Count, &rs.FirstSeen, &rs.LastSeen); err != nil {
+			continue
+		}
+		history.Regions = append(history.Regions, rs)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
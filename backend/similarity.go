@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// --- Related Species ---
+//
+// Similarity is a weighted mean of independent components, each in [0, 1].
+// A component is skipped when either species lacks the data for it, and the
+// remaining weights are renormalised, so sparse rows are not penalised for
+// missing fields.
+
+const defaultRelatedLimit = 10
+
+var similarityWeights = map[string]float64{
+	"taxonomy": 0.35,
+	"habitat":  0.15,
+	"diet":     0.15,
+	"depth":    0.15,
+	"traits":   0.20,
+}
+
+// taxonomicRanks lists the ranks from most to least specific with the
+// similarity awarded for sharing that rank.
+var taxonomicRanks = []struct {
+	name  string
+	value func(Species) string
+	score float64
+}{
+	{"genus", func(s Species) string { return s.Genus }, 1.0},
+	{"family", func(s Species) string { return s.Family }, 0.75},
+	{"order", func(s Species) string { return s.Order }, 0.5},
+	{"class", func(s Species) string { return s.Class }, 0.25},
+	{"phylum", func(s Species) string { return s.Phylum }, 0.1},
+}
+
+type SimilarityComponent struct {
+	Score  float64 `json:"score"`
+	Weight float64 `json:"weight"`
+	Reason string  `json:"reason"`
+}
+
+type RelatedSpecies struct {
+	Species            SpeciesRef                     `json:"species"`
+	ConservationStatus string                         `json:"conservation_status"`
+	ImageURL           string                         `json:"image_url,omitempty"`
+	Score              float64                        `json:"score"`
+	Components         map[string]SimilarityComponent `json:"components"`
+}
+
+type RelatedSpeciesResponse struct {
+	Species SpeciesRef       `json:"species"`
+	Related []RelatedSpecies `json:"related"`
+}
+
+func taxonomicSimilarity(a, b Species) (float64, string, bool) {
+	known := false
+	for _, rank := range taxonomicRanks {
+		va, vb := strings.TrimSpace(rank.value(a)), strings.TrimSpace(rank.value(b))
+		if va == "" || vb == "" {
+			continue
+		}
+		known = true
+		if strings.EqualFold(va, vb) {
+			return rank.score, fmt.Sprintf("same %s %s", rank.name, va), true
+		}
+	}
+	return 0, "no shared rank below kingdom", known
+}
+
+// tokenJaccard compares free-text fields such as "Carnivore, Piscivore" as
+// sets of lower-cased words.
+func tokenJaccard(a, b string) (float64, bool) {
+	tokens := func(s string) map[string]bool {
+		set := map[string]bool{}
+		for _, t := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+		}) {
+			if len(t) > 2 && t != "and" {
+				set[t] = true
+			}
+		}
+		return set
+	}
+	ta, tb := tokens(a), tokens(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0, false
+	}
+	shared := 0
+	for t := range ta {
+		if tb[t] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(ta)+len(tb)-shared), true
+}
+
+// depthOverlap is the intersection over union of the two depth ranges.
+func depthOverlap(a, b Species) (float64, bool) {
+	if a.DepthRangeMax <= 0 || b.DepthRangeMax <= 0 {
+		return 0, false
+	}
+	inter := math.Min(a.DepthRangeMax, b.DepthRangeMax) - math.Max(a.DepthRangeMin, b.DepthRangeMin)
+	union := math.Max(a.DepthRangeMax, b.DepthRangeMax) - math.Min(a.DepthRangeMin, b.DepthRangeMin)
+	if union <= 0 {
+		return 1, true
+	}
+	return math.Max(inter, 0) / union, true
+}
+
+// traitSimilarity averages 1 - |a-b|/max(a,b) over the numeric traits both
+// species have values for.
+func traitSimilarity(a, b Species) (float64, string, bool) {
+	pairs := []struct {
+		name string
+		a, b float64
+	}{
+		{"trophic_level", a.TrophicLevel, b.TrophicLevel},
+		{"max_length_cm", a.MaxLengthCm, b.MaxLengthCm},
+		{"longevity", a.Longevity, b.Longevity},
+	}
+	total, n := 0.0, 0
+	var used []string
+	for _, p := range pairs {
+		if p.a <= 0 || p.b <= 0 {
+			continue
+		}
+		total += 1 - math.Abs(p.a-p.b)/math.Max(p.a, p.b)
+		n++
+		used = append(used, p.name)
+	}
+	if n == 0 {
+		return 0, "", false
+	}
+	return total / float64(n), "compared " + strings.Join(used, ", "), true
+}
+
+func speciesSimilarity(a, b Species) (float64, map[string]SimilarityComponent) {
+	components := map[string]SimilarityComponent{}
+	put := func(name string, score float64, reason string) {
+		components[name] = SimilarityComponent{Score: math.Round(score*1000) / 1000, Weight: similarityWeights[name], Reason: reason}
+	}
+
+	if score, reason, ok := taxonomicSimilarity(a, b); ok {
+		put("taxonomy", score, reason)
+	}
+	if a.HabitatType != "" && b.HabitatType != "" {
+		if strings.EqualFold(a.HabitatType, b.HabitatType) {
+			put("habitat", 1, "both "+a.HabitatType)
+		} else if score, ok := tokenJaccard(a.HabitatType, b.HabitatType); ok {
+			put("habitat", score, fmt.Sprintf("%s vs %s", a.HabitatType, b.HabitatType))
+		}
+	}
+	if score, ok := tokenJaccard(a.Diet, b.Diet); ok {
+		put("diet", score, fmt.Sprintf("%s vs %s", a.Diet, b.Diet))
+	}
+	if score, ok := depthOverlap(a, b); ok {
+		put("depth", score, fmt.Sprintf("depth ranges overlap %.0f%%", score*100))
+	}
+	if score, reason, ok := traitSimilarity(a, b); ok {
+		put("traits", score, reason)
+	}
+
+	total, weight := 0.0, 0.0
+	for _, c := range components {
+		total += c.Score * c.Weight
+		weight += c.Weight
+	}
+	if weight == 0 {
+		return 0, components
+	}
+	return math.Round(total/weight*1000) / 1000, components
+}
+
+func getRelatedSpecies(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid species id", http.StatusBadRequest)
+		return
+	}
+	limit := defaultRelatedLimit
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+
+	all, err := querySpecies("1=1")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var target *Species
+	for i := range all {
+		if all[i].ID == id {
+			target = &all[i]
+			break
+		}
+	}
+	if target == nil {
+		http.Error(w, "Species not found", http.StatusNotFound)
+		return
+	}
+
+	resp := RelatedSpeciesResponse{
+		Species: SpeciesRef{ID: target.ID, VernacularName: target.VernacularName, ScientificName: target.ScientificName},
+		Related: []RelatedSpecies{},
+	}
+	for _, s := range all {
+		if s.ID == target.ID {
+			continue
+		}
+		score, components := speciesSimilarity(*target, s)
+		if score == 0 {
+			continue
+		}
+		rel := RelatedSpecies{
+			Species:            SpeciesRef{ID: s.ID, VernacularName: s.VernacularName, ScientificName: s.ScientificName},
+			ConservationStatus: s.ConservationStatus,
+			Score:              score,
+			Components:         components,
+		}
+		if len(s.ImageURLs) > 0 {
+			rel.ImageURL = s.ImageURLs[0]
+		}
+		resp.Related = append(resp.Related, rel)
+	}
+
+	sort.SliceStable(resp.Related, func(i, j int) bool {
+		return resp.Related[i].Score > resp.Related[j].Score
+	})
+	if len(resp.Related) > limit {
+		resp.Related = resp.Related[:limit]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"git.amrita.edu/itsriteshs/sih-paradoxx6/backend/assessment"
+)
+
+// --- Stock Assessment ---
+//
+// HTTP side of the assessment package. Surplus production fits run on
+// annual catch and CPUE from the fisheries tables, or on a series posted
+// by the caller; per-recruit analysis takes its growth, maturity and
+// mortality from the species record, the same derivation the projection
+// engine uses, with query overrides for every input.
+
+const (
+	maxProductionBody  = 1 << 20
+	maxProductionYears = 200
+)
+
+type ProductionRequest struct {
+	Model            string                   `json:"model"`
+	InitialDepletion *float64                 `json:"initial_depletion"`
+	Series           []assessment.Observation `json:"series"`
+}
+
+type ProductionResponse struct {
+	SpeciesID      int                         `json:"species_id,omitempty"`
+	ScientificName string                      `json:"scientific_name,omitempty"`
+	EffortUnit     string                      `json:"effort_unit,omitempty"`
+	CatchUnit      string                      `json:"catch_unit"`
+	Series         []assessment.Observation    `json:"series"`
+	Fits           []*assessment.ProductionFit `json:"fits"`
+	// Best is the model with the lowest AIC.
+	Best  assessment.Model `json:"best"`
+	Notes []string         `json:"notes"`
+}
+
+func productionModels(name string) ([]assessment.Model, error) {
+	switch name {
+	case "", "both":
+		return assessment.Models, nil
+	case string(assessment.Schaefer), string(assessment.Fox):
+		return []assessment.Model{assessment.Model(name)}, nil
+	}
+	return nil, fmt.Errorf("model must be schaefer, fox or both")
+}
+
+// annualSeries folds monthly CPUE strata into years. Annual CPUE is the
+// catch from months with recorded effort over that effort, so months
+// without effort add to the catch but not to the index. The models need
+// catch for every year, so years with no records inside the span get a
+// catch interpolated linearly between their neighbours and no index: a
+// year nobody reported is not a year nothing was caught.
+func annualSeries(rows []CPUERow) ([]assessment.Observation, []string) {
+	type year struct{ catch, indexedCatch, effort float64 }
+	years := map[int]*year{}
+	for _, row := range rows {
+		if row.Month == nil || len(*row.Month) < 4 {
+			continue
+		}
+		y, err := strconv.Atoi((*row.Month)[:4])
+		if err != nil {
+			continue
+		}
+		if years[y] == nil {
+			years[y] = &year{}
+		}
+		years[y].catch += row.CatchKg
+		if row.Effort != nil && *row.Effort > 0 {
+			years[y].indexedCatch += row.CatchKg
+			years[y].effort += *row.Effort
+		}
+	}
+
+	var keys []int
+	for y := range years {
+		keys = append(keys, y)
+	}
+	sort.Ints(keys)
+	var series []assessment.Observation
+	var notes []string
+	for i, y := range keys {
+		if i > 0 {
+			prev := keys[i-1]
+			for gap := prev + 1; gap < y; gap++ {
+				t := float64(gap-prev) / float64(y-prev)
+				catch := years[prev].catch + t*(years[y].catch-years[prev].catch)
+				series = append(series, assessment.Observation{Year: gap, Catch: catch})
+				notes = append(notes, fmt.Sprintf("no catch recorded in %d; interpolated as %.0f kg", gap, catch))
+			}
+		}
+		o := assessment.Observation{Year: y, Catch: years[y].catch}
+		if years[y].effort > 0 && years[y].indexedCatch > 0 {
+			cpue := years[y].indexedCatch / years[y].effort
+			o.Index = &cpue
+		}
+		series = append(series, o)
+	}
+	return series, notes
+}
+
+func fitProductionModels(models []assessment.Model, series []assessment.Observation, depletion *float64, resp *ProductionResponse) error {
+	best := math.Inf(1)
+	for _, m := range models {
+		fit, err := assessment.FitProduction(m, series, assessment.FitOptions{InitialDepletion: depletion})
+		if err != nil {
+			return err
+		}
+		resp.Fits = append(resp.Fits, fit)
+		if fit.AIC < best {
+			best, resp.Best = fit.AIC, m
+		}
+	}
+	return nil
+}
+
+// getProductionFit fits surplus production models to a species' recorded
+// catch and CPUE. Accepts the fisheries filters (region, port, gear,
+// vessel_id, from, to) plus effort_unit, model and initial_depletion.
+func getProductionFit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	speciesID, err := strconv.Atoi(q.Get("species_id"))
+	if err != nil {
+		http.Error(w, "species_id is required", http.StatusBadRequest)
+		return
+	}
+	models, err := productionModels(q.Get("model"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var depletion *float64
+	if v := q.Get("initial_depletion"); v != "" {
+		d, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			http.Error(w, "initial_depletion must be a number", http.StatusBadRequest)
+			return
+		}
+		depletion = &d
+	}
+	unit := "hours"
+	if v := q.Get("effort_unit"); v != "" {
+		unit = v
+	}
+
+	s, err := scanSpecies(db.QueryRow("SELECT "+speciesSelectFields+" FROM species_data s WHERE s.id = $1", speciesID))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Species not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := queryCPUE(q, CPUEQuery{GroupBy: []string{"month"}, EffortUnit: unit})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	series, notes := annualSeries(rows)
+
+	resp := ProductionResponse{
+		SpeciesID:      speciesID,
+		ScientificName: s.ScientificName,
+		EffortUnit:     unit,
+		CatchUnit:      "kg",
+		Series:         series,
+		Fits:           []*assessment.ProductionFit{},
+		Notes:          append([]string{}, notes...),
+	}
+	if err := fitProductionModels(models, series, depletion, &resp); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// postProductionFit fits surplus production models to a caller-supplied
+// series, e.g. historical landings that predate the catch tables. Fits
+// are costly, so the caller must be signed in and the series is capped at
+// maxProductionYears.
+func postProductionFit(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireUser(w, r); !ok {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxProductionBody)
+	var req ProductionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Series) > maxProductionYears {
+		http.Error(w, fmt.Sprintf("series may have at most %d years", maxProductionYears), http.StatusBadRequest)
+		return
+	}
+	models, err := productionModels(req.Model)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := ProductionResponse{CatchUnit: "as supplied", Series: req.Series, Fits: []*assessment.ProductionFit{}, Notes: []string{}}
+	if err := fitProductionModels(models, req.Series, req.InitialDepletion, &resp); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// speciesPerRecruitInputs maps a species record onto per-recruit inputs
+// through the projection engine's life-history derivation: t0 = -0.5,
+// weight proportional to length cubed and capture from a year before
+// maturity.
+func speciesPerRecruitInputs(s Species) (assessment.LifeHistory, []string) {
+	lh := newLifeHistory(s)
+	return assessment.LifeHistory{
+		Linf:        lh.LinfCm,
+		K:           lh.K,
+		T0:          -0.5,
+		A:           lh.WmaxKg / math.Pow(lh.LinfCm, 3),
+		B:           3,
+		M:           lh.M,
+		MaxAge:      float64(lh.Ages - 1),
+		AgeMaturity: lh.AgeMaturity,
+		AgeCapture:  math.Max(0, lh.AgeMaturity-1),
+		Steepness:   lh.Steepness,
+	}, lh.Assumptions
+}
+
+// getPerRecruit returns YPR and SPR curves with F0.1, Fmax, F40%, F30%
+// and, through the steepness, Fmsy. Query parameters linf, k, t0, a, b,
+// m, max_age, age_maturity, age_capture and steepness override the
+// species-derived inputs; f_max and steps set the F grid.
+func getPerRecruit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var lh assessment.LifeHistory
+	var notes []string
+	resp := map[string]interface{}{}
+	if v := q.Get("species_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "species_id must be an integer", http.StatusBadRequest)
+			return
+		}
+		s, err := scanSpecies(db.QueryRow("SELECT "+speciesSelectFields+" FROM species_data s WHERE s.id = $1", id))
+		if err == sql.ErrNoRows {
+			http.Error(w, "Species not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		lh, notes = speciesPerRecruitInputs(s)
+		resp["species_id"] = s.ID
+		resp["scientific_name"] = s.ScientificName
+	}
+
+	for _, o := range []struct {
+		name string
+		dest *float64
+	}{
+		{"linf", &lh.Linf}, {"k", &lh.K}, {"t0", &lh.T0}, {"a", &lh.A}, {"b", &lh.B}, {"m", &lh.M},
+		{"max_age", &lh.MaxAge}, {"age_maturity", &lh.AgeMaturity}, {"age_capture", &lh.AgeCapture}, {"steepness", &lh.Steepness},
+	} {
+		if v := q.Get(o.name); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				http.Error(w, o.name+" must be a number", http.StatusBadRequest)
+				return
+			}
+			*o.dest = f
+		}
+	}
+
+	fmax := math.Max(2, 5*lh.M)
+	if v := q.Get("f_max"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f <= 0 || f > 10 {
+			http.Error(w, "f_max must be a number between 0 and 10", http.StatusBadRequest)
+			return
+		}
+		fmax = f
+	}
+	steps := 100
+	if v := q.Get("steps"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 2 || n > 1000 {
+			http.Error(w, "steps must be between 2 and 1000", http.StatusBadRequest)
+			return
+		}
+		steps = n
+	}
+
+	res, err := assessment.PerRecruit(lh, fmax, steps)
+	if err != nil {
+		msg := err.Error()
+		if q.Get("species_id") == "" {
+			msg += " (pass species_id or linf, k, a, b, m and max_age)"
+		}
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	if notes == nil {
+		notes = []string{}
+	}
+	resp["result"] = res
+	resp["assumptions"] = notes
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
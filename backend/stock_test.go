@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestAnnualSeries(t *testing.T) {
+	row := func(month string, catch float64, effort float64) CPUERow {
+		r := CPUERow{Month: &month, CatchKg: catch}
+		if effort > 0 {
+			r.Effort = &effort
+		}
+		return r
+	}
+	rows := []CPUERow{
+		row("2001-01", 100, 10),
+		row("2001-06", 100, 0),
+		row("2004-03", 400, 20),
+		row("2005-03", 300, 30),
+	}
+	series, notes := annualSeries(rows)
+
+	tests := []struct {
+		year  int
+		catch float64
+		index float64 // 0 means no index
+	}{
+		{2001, 200, 10},
+		{2002, 266.67, 0},
+		{2003, 333.33, 0},
+		{2004, 400, 20},
+		{2005, 300, 10},
+	}
+	if len(series) != len(tests) {
+		t.Fatalf("got %d years, want %d: %+v", len(series), len(tests), series)
+	}
+	for i, tt := range tests {
+		o := series[i]
+		if o.Year != tt.year || o.Catch < tt.catch-0.01 || o.Catch > tt.catch+0.01 {
+			t.Errorf("year %d: got year %d catch %g, want catch %g", tt.year, o.Year, o.Catch, tt.catch)
+		}
+		switch {
+		case tt.index == 0 && o.Index != nil:
+			t.Errorf("year %d: got index %g for a year without records", tt.year, *o.Index)
+		case tt.index != 0 && (o.Index == nil || *o.Index != tt.index):
+			t.Errorf("year %d: got index %v, want %g", tt.year, o.Index, tt.index)
+		}
+	}
+	if len(notes) != 2 {
+		t.Errorf("got notes %q, want one per interpolated year", notes)
+	}
+}
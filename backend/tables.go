@@ -0,0 +1,334 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Tabular File Reading ---
+//
+// Survey sheets arrive as CSV, TSV or Excel workbooks. readTable returns
+// the first sheet as rows of strings, header first. Files with more than
+// maxTableRows data rows are rejected rather than cut short, so a report
+// never calls a partial file ready for import.
+
+const maxTableRows = 200000
+
+var errTableTooLarge = fmt.Errorf("the file has more than %d data rows; split it into smaller files", maxTableRows)
+
+func readTable(r io.ReaderAt, size int64, name string) ([][]string, error) {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".xlsx":
+		return readXLSX(r, size)
+	case ".xls":
+		return nil, fmt.Errorf("legacy .xls workbooks are not supported; save the sheet as .xlsx or CSV")
+	default:
+		return readDelimited(io.NewSectionReader(r, 0, size))
+	}
+}
+
+// readDelimited sniffs the delimiter from the header line: tab, semicolon
+// or comma, whichever occurs most.
+func readDelimited(r io.Reader) ([][]string, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(4096)
+	firstLine, _, _ := bytes.Cut(head, []byte("\n"))
+	delim := ','
+	best := bytes.Count(firstLine, []byte(","))
+	for _, d := range []rune{'\t', ';'} {
+		if n := bytes.Count(firstLine, []byte(string(d))); n > best {
+			delim, best = d, n
+		}
+	}
+
+	reader := csv.NewReader(br)
+	reader.Comma = delim
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+
+	var rows [][]string
+	for {
+		rec, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", len(rows)+1, err)
+		}
+		if len(rows) > maxTableRows {
+			return nil, errTableTooLarge
+		}
+		rows = append(rows, rec)
+	}
+	if len(rows) > 0 && len(rows[0]) > 0 {
+		rows[0][0] = strings.TrimPrefix(rows[0][0], "\ufeff")
+	}
+	return rows, nil
+}
+
+type xlsxSharedStrings struct {
+	Items []struct {
+		Text string `xml:"t"`
+		Runs []struct {
+			Text string `xml:"t"`
+		} `xml:"r"`
+	} `xml:"si"`
+}
+
+type xlsxWorkbook struct {
+	Properties struct {
+		Date1904 bool `xml:"date1904,attr"`
+	} `xml:"workbookPr"`
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Items []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxStyles struct {
+	NumFmts []struct {
+		ID   int    `xml:"numFmtId,attr"`
+		Code string `xml:"formatCode,attr"`
+	} `xml:"numFmts>numFmt"`
+	CellXfs []struct {
+		NumFmtID int `xml:"numFmtId,attr"`
+	} `xml:"cellXfs>xf"`
+}
+
+type xlsxWorksheet struct {
+	Rows []struct {
+		Cells []struct {
+			Ref    string `xml:"r,attr"`
+			Type   string `xml:"t,attr"`
+			Style  int    `xml:"s,attr"`
+			Value  string `xml:"v"`
+			Inline struct {
+				Text string `xml:"t"`
+			} `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+func readZipXML(zr *zip.Reader, name string, v interface{}) (bool, error) {
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return true, err
+		}
+		defer rc.Close()
+		return true, xml.NewDecoder(rc).Decode(v)
+	}
+	return false, nil
+}
+
+// xlsxColumn converts the letters of a cell reference such as "AB12" to a
+// zero-based column index.
+func xlsxColumn(ref string) int {
+	col := 0
+	for _, c := range ref {
+		if c < 'A' || c > 'Z' {
+			break
+		}
+		col = col*26 + int(c-'A'+1)
+	}
+	return col - 1
+}
+
+// Day zero of Excel's 1900 date system (adjusted for its fictitious 29
+// February 1900) and of the 1904 system used by old Mac workbooks.
+var (
+	excelEpoch     = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	excelEpoch1904 = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// isDateFormat reports whether a number format displays a calendar date:
+// the built-in date formats, or a custom code with a day or year part
+// once quoted text and [colour]/[locale] sections are removed.
+func isDateFormat(id int, custom map[int]string) bool {
+	if (id >= 14 && id <= 17) || id == 22 || (id >= 27 && id <= 36) || (id >= 50 && id <= 58) {
+		return true
+	}
+	code, ok := custom[id]
+	if !ok {
+		return false
+	}
+	var b strings.Builder
+	quoted, bracket := false, false
+	for _, c := range strings.ToLower(code) {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '[':
+			bracket = true
+		case c == ']':
+			bracket = false
+		case !bracket:
+			b.WriteRune(c)
+		}
+	}
+	return strings.ContainsAny(b.String(), "dy")
+}
+
+// dateStyles returns, per cell style index, whether the style formats
+// numbers as dates.
+func dateStyles(zr *zip.Reader) ([]bool, error) {
+	var styles xlsxStyles
+	if _, err := readZipXML(zr, "xl/styles.xml", &styles); err != nil {
+		return nil, err
+	}
+	custom := map[int]string{}
+	for _, f := range styles.NumFmts {
+		custom[f.ID] = f.Code
+	}
+	dates := make([]bool, len(styles.CellXfs))
+	for i, xf := range styles.CellXfs {
+		dates[i] = isDateFormat(xf.NumFmtID, custom)
+	}
+	return dates, nil
+}
+
+// excelDate converts a date-formatted serial number to an ISO date, with
+// the time of day when it has one.
+func excelDate(value string, epoch time.Time) string {
+	serial, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return value
+	}
+	t := epoch.Add(time.Duration(serial * 24 * float64(time.Hour))).Round(time.Second)
+	if serial == float64(int64(serial)) {
+		return t.Format("2006-01-02")
+	}
+	return t.Format("2006-01-02T15:04:05")
+}
+
+// firstSheetPath finds the part holding the first sheet in workbook
+// order. Sheets are not necessarily stored as sheet1.xml: reordering or
+// deleting sheets in Excel leaves the first one under another name.
+func firstSheetPath(zr *zip.Reader, wb *xlsxWorkbook) (string, error) {
+	found, err := readZipXML(zr, "xl/workbook.xml", wb)
+	if err != nil {
+		return "", err
+	}
+	if !found || len(wb.Sheets) == 0 {
+		return "", fmt.Errorf("workbook has no worksheets")
+	}
+	var rels xlsxRelationships
+	if _, err := readZipXML(zr, "xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return "", err
+	}
+	for _, rel := range rels.Items {
+		if rel.ID != wb.Sheets[0].RID {
+			continue
+		}
+		if strings.HasPrefix(rel.Target, "/") {
+			return strings.TrimPrefix(rel.Target, "/"), nil
+		}
+		return path.Join("xl", rel.Target), nil
+	}
+	return "", fmt.Errorf("workbook does not say where sheet %q is stored", wb.Sheets[0].Name)
+}
+
+// readXLSX reads the first worksheet of a workbook. Numbers in cells
+// formatted as dates are converted to ISO dates here, since only the cell
+// style tells a date serial from an ordinary number such as a year.
+func readXLSX(r io.ReaderAt, size int64) ([][]string, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("not a valid .xlsx file: %w", err)
+	}
+	var wb xlsxWorkbook
+	sheetPath, err := firstSheetPath(zr, &wb)
+	if err != nil {
+		return nil, err
+	}
+	epoch := excelEpoch
+	if wb.Properties.Date1904 {
+		epoch = excelEpoch1904
+	}
+	dates, err := dateStyles(zr)
+	if err != nil {
+		return nil, err
+	}
+
+	var shared xlsxSharedStrings
+	if _, err := readZipXML(zr, "xl/sharedStrings.xml", &shared); err != nil {
+		return nil, err
+	}
+	strs := make([]string, len(shared.Items))
+	for i, item := range shared.Items {
+		if item.Text != "" || len(item.Runs) == 0 {
+			strs[i] = item.Text
+			continue
+		}
+		var b strings.Builder
+		for _, run := range item.Runs {
+			b.WriteString(run.Text)
+		}
+		strs[i] = b.String()
+	}
+
+	var sheet xlsxWorksheet
+	found, err := readZipXML(zr, sheetPath, &sheet)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("workbook has no first worksheet")
+	}
+	if len(sheet.Rows) > maxTableRows+1 {
+		return nil, errTableTooLarge
+	}
+
+	rows := make([][]string, 0, len(sheet.Rows))
+	for _, row := range sheet.Rows {
+		var out []string
+		for i, c := range row.Cells {
+			col := i
+			if c.Ref != "" {
+				col = xlsxColumn(c.Ref)
+			}
+			for len(out) < col {
+				out = append(out, "")
+			}
+			value := c.Value
+			switch c.Type {
+			case "s":
+				if idx, err := strconv.Atoi(c.Value); err == nil && idx < len(strs) {
+					value = strs[idx]
+				}
+			case "inlineStr":
+				value = c.Inline.Text
+			case "b":
+				value = map[string]string{"1": "true", "0": "false"}[c.Value]
+			case "", "n":
+				if c.Style < len(dates) && dates[c.Style] && value != "" {
+					value = excelDate(value, epoch)
+				}
+			}
+			out = append(out, value)
+		}
+		rows = append(rows, out)
+	}
+	return rows, nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// buildXLSX zips the given parts into a workbook.
+func buildXLSX(t *testing.T, parts map[string]string) *bytes.Reader {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, body := range parts {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(body))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+const (
+	xlsxMain = `xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"`
+	xlsxRels = `xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"`
+)
+
+func xlsxSheet(rows string) string {
+	return `<worksheet ` + xlsxMain + `><sheetData>` + rows + `</sheetData></worksheet>`
+}
+
+func readTestXLSX(t *testing.T, parts map[string]string) ([][]string, error) {
+	r := buildXLSX(t, parts)
+	return readTable(r, r.Size(), "survey.xlsx")
+}
+
+func TestReadXLSXFirstSheet(t *testing.T) {
+	// The first sheet in workbook order is stored as sheet3.xml.
+	parts := map[string]string{
+		"xl/workbook.xml": `<workbook ` + xlsxMain + ` ` + xlsxRels + `><sheets>
+			<sheet name="Summary" sheetId="3" r:id="rId3"/>
+			<sheet name="Old" sheetId="1" r:id="rId1"/>
+		</sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+			<Relationship Id="rId1" Target="worksheets/sheet1.xml"/>
+			<Relationship Id="rId3" Target="worksheets/sheet3.xml"/>
+		</Relationships>`,
+		"xl/sharedStrings.xml":     `<sst ` + xlsxMain + `><si><t>site</t></si><si><r><t>Kochi </t></r><r><t>North</t></r></si></sst>`,
+		"xl/worksheets/sheet1.xml": xlsxSheet(`<row><c r="A1" t="inlineStr"><is><t>wrong sheet</t></is></c></row>`),
+		"xl/worksheets/sheet3.xml": xlsxSheet(`
+			<row><c r="A1" t="s"><v>0</v></c><c r="C1" t="inlineStr"><is><t>checked</t></is></c></row>
+			<row><c r="A2" t="s"><v>1</v></c><c r="C2" t="b"><v>1</v></c></row>`),
+	}
+	rows, err := readTestXLSX(t, parts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"site", "", "checked"}, {"Kochi North", "", "true"}}
+	if fmt.Sprint(rows) != fmt.Sprint(want) {
+		t.Errorf("rows = %q, want %q", rows, want)
+	}
+
+	// An absolute target is taken from the package root.
+	parts["xl/_rels/workbook.xml.rels"] = strings.Replace(parts["xl/_rels/workbook.xml.rels"],
+		`Target="worksheets/sheet3.xml"`, `Target="/xl/worksheets/sheet3.xml"`, 1)
+	if rows, err := readTestXLSX(t, parts); err != nil || rows[0][0] != "site" {
+		t.Errorf("absolute target: rows %q, err %v", rows, err)
+	}
+
+	parts["xl/_rels/workbook.xml.rels"] = `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"/>`
+	if _, err := readTestXLSX(t, parts); err == nil {
+		t.Error("workbook without a relationship for its first sheet read without error")
+	}
+	delete(parts, "xl/workbook.xml")
+	if _, err := readTestXLSX(t, parts); err == nil {
+		t.Error("package without a workbook read without error")
+	}
+}
+
+func TestReadXLSXDates(t *testing.T) {
+	parts := map[string]string{
+		"xl/workbook.xml": `<workbook ` + xlsxMain + ` ` + xlsxRels + `><sheets><sheet name="Data" sheetId="1" r:id="rId1"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+			<Relationship Id="rId1" Target="worksheets/sheet1.xml"/></Relationships>`,
+		"xl/styles.xml": `<styleSheet ` + xlsxMain + `>
+			<numFmts>
+				<numFmt numFmtId="164" formatCode="yyyy-mm-dd hh:mm"/>
+				<numFmt numFmtId="165" formatCode="0.0&quot; days&quot;"/>
+				<numFmt numFmtId="166" formatCode="[Red]0.00"/>
+			</numFmts>
+			<cellXfs>
+				<xf numFmtId="0"/><xf numFmtId="14"/><xf numFmtId="164"/><xf numFmtId="165"/><xf numFmtId="166"/>
+			</cellXfs></styleSheet>`,
+		// Built-in date, plain number, custom date-time, quoted "days"
+		// and a [Red] colour section.
+		"xl/worksheets/sheet1.xml": xlsxSheet(`<row>
+			<c r="A1" s="1"><v>45000</v></c>
+			<c r="B1"><v>2023</v></c>
+			<c r="C1" s="2"><v>45000.5</v></c>
+			<c r="D1" s="3"><v>5</v></c>
+			<c r="E1" s="4"><v>1.25</v></c>
+			<c r="F1" s="1"/>
+		</row>`),
+	}
+	rows, err := readTestXLSX(t, parts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"2023-03-15", "2023", "2023-03-15T12:00:00", "5", "1.25", ""}
+	if fmt.Sprint(rows[0]) != fmt.Sprint(want) {
+		t.Errorf("cells = %q, want %q", rows[0], want)
+	}
+
+	parts["xl/workbook.xml"] = strings.Replace(parts["xl/workbook.xml"], "<sheets>", `<workbookPr date1904="1"/><sheets>`, 1)
+	rows, err = readTestXLSX(t, parts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows[0][0] != "2027-03-16" {
+		t.Errorf("1904 date system: serial 45000 = %s, want 2027-03-16", rows[0][0])
+	}
+}
+
+func TestReadTableRowLimit(t *testing.T) {
+	csvRows := func(n int) []byte {
+		var b bytes.Buffer
+		b.WriteString("a,b\n")
+		for i := 0; i < n; i++ {
+			b.WriteString("1,2\n")
+		}
+		return b.Bytes()
+	}
+	full := csvRows(maxTableRows)
+	rows, err := readTable(bytes.NewReader(full), int64(len(full)), "full.csv")
+	if err != nil || len(rows) != maxTableRows+1 {
+		t.Errorf("exactly %d data rows: %d rows, err %v", maxTableRows, len(rows), err)
+	}
+	big := csvRows(maxTableRows + 1)
+	if _, err := readTable(bytes.NewReader(big), int64(len(big)), "big.csv"); err != errTableTooLarge {
+		t.Errorf("CSV over the limit: err %v, want errTableTooLarge", err)
+	}
+
+	parts := map[string]string{
+		"xl/workbook.xml": `<workbook ` + xlsxMain + ` ` + xlsxRels + `><sheets><sheet name="Data" sheetId="1" r:id="rId1"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+			<Relationship Id="rId1" Target="worksheets/sheet1.xml"/></Relationships>`,
+		"xl/worksheets/sheet1.xml": xlsxSheet(strings.Repeat("<row/>", maxTableRows+2)),
+	}
+	if _, err := readTestXLSX(t, parts); err != errTableTooLarge {
+		t.Errorf("workbook over the limit: err %v, want errTableTooLarge", err)
+	}
+}
+
+func TestReadDelimited(t *testing.T) {
+	tests := []struct {
+		name, in string
+		want     [][]string
+	}{
+		{"comma with BOM", "\ufeffsite,depth\nA,\"1,5\"\n", [][]string{{"site", "depth"}, {"A", "1,5"}}},
+		{"semicolon", "site;depth;note\nA;1,5;x, y\n", [][]string{{"site", "depth", "note"}, {"A", "1,5", "x, y"}}},
+		{"tab", "site\tdepth\nA\t 2\n", [][]string{{"site", "depth"}, {"A", "2"}}},
+		{"ragged", "a,b,c\n1\n", [][]string{{"a", "b", "c"}, {"1"}}},
+	}
+	for _, tt := range tests {
+		rows, err := readTable(strings.NewReader(tt.in), int64(len(tt.in)), "sheet.csv")
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if fmt.Sprint(rows) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: rows = %q, want %q", tt.name, rows, tt.want)
+		}
+	}
+	if _, err := readTable(strings.NewReader(""), 0, "old.xls"); err == nil {
+		t.Error("legacy .xls accepted")
+	}
+}
@@ -0,0 +1,811 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+// --- Taxonomic Name Reconciliation ---
+//
+// A WoRMS or GBIF backbone dump is loaded into taxon_backbone and every
+// species_data name is resolved against it: exact canonical match first,
+// then a fuzzy match within the same genus prefix. Synonyms are followed to
+// their accepted AphiaID so that duplicate species rows can be found and
+// merged.
+
+const taxonomySchema = `
+CREATE TABLE IF NOT EXISTS taxon_backbone (
+	aphia_id          BIGINT PRIMARY KEY,
+	scientific_name   TEXT NOT NULL,
+	canonical_name    TEXT NOT NULL,
+	authority         TEXT,
+	rank              TEXT,
+	status            TEXT,
+	accepted_aphia_id BIGINT,
+	kingdom           TEXT,
+	phylum            TEXT,
+	class             TEXT,
+	_order            TEXT,
+	family            TEXT,
+	genus             TEXT,
+	source            TEXT,
+	imported_at       TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS taxon_backbone_canonical_idx ON taxon_backbone (lower(canonical_name));
+CREATE INDEX IF NOT EXISTS taxon_backbone_genus_idx ON taxon_backbone (lower(genus));
+
+CREATE TABLE IF NOT EXISTS species_name_match (
+	species_id        INTEGER PRIMARY KEY,
+	aphia_id          BIGINT,
+	accepted_aphia_id BIGINT,
+	match_type        TEXT NOT NULL,
+	score             DOUBLE PRECISION NOT NULL DEFAULT 0,
+	matched_at        TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS species_merge_log (
+	id          SERIAL PRIMARY KEY,
+	target_id   INTEGER NOT NULL,
+	merged_id   INTEGER NOT NULL,
+	merged_name TEXT,
+	merged_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);`
+
+const (
+	matchExact   = "exact"
+	matchSynonym = "synonym"
+	matchFuzzy   = "fuzzy"
+	matchNone    = "none"
+
+	// fuzzyThreshold is the minimum normalised edit similarity for a fuzzy
+	// match to be accepted.
+	fuzzyThreshold = 0.85
+	importBatch    = 5000
+)
+
+// speciesReference is a column that holds a species_data id. unique names
+// the other columns of a unique key that includes it, if any: rows of the
+// merged species that would collide on that key are dropped in favour of
+// the target's (or the oldest) row before repointing.
+type speciesReference struct {
+	table, column string
+	unique        []string
+}
+
+// speciesReferenceTables lists every column that must be repointed when
+// duplicate species are merged. Tables that reference species add
+// themselves here.
+var speciesReferenceTables = []speciesReference{
+	{table: "occurrence_data", column: "species_id"},
+	{table: "otolith_metadata", column: "species_id"},
+	{table: "citizen_sightings", column: "species_id"},
+	{table: "alert_rules", column: "species_id"},
+	{table: "alerts", column: "species_id"},
+	{table: "invasive_watchlist", column: "species_id", unique: []string{"region"}},
+	{table: "edna_detections", column: "species_id"},
+	{table: "catch_records", column: "species_id"},
+	{table: "length_samples", column: "species_id"},
+	{table: "food_web_links", column: "predator_id", unique: []string{"prey_label"}},
+	{table: "food_web_links", column: "prey_species_id"},
+	{table: "plankton_counts", column: "species_id"},
+	{table: "aqua_cohorts", column: "species_id"},
+	{table: "conservation_assessments", column: "species_id", unique: []string{"scope", "region", "assessed_on"}},
+}
+
+var (
+	errMergeSpeciesMissing = errors.New("target or duplicate species not found")
+	errNoAcceptedName      = errors.New("target species has no accepted name; reconcile names with POST /api/taxonomy/report first")
+)
+
+type TaxonRecord struct {
+	AphiaID         int64  `json:"aphia_id"`
+	ScientificName  string `json:"scientific_name"`
+	CanonicalName   string `json:"canonical_name"`
+	Authority       string `json:"authority"`
+	Rank            string `json:"rank"`
+	Status          string `json:"status"`
+	AcceptedAphiaID int64  `json:"accepted_aphia_id"`
+	Kingdom         string `json:"kingdom"`
+	Phylum          string `json:"phylum"`
+	Class           string `json:"class"`
+	Order           string `json:"order"`
+	Family          string `json:"family"`
+	Genus           string `json:"genus"`
+}
+
+type NameMatch struct {
+	Query         string       `json:"query"`
+	CanonicalName string       `json:"canonical_name"`
+	MatchType     string       `json:"match_type"`
+	Score         float64      `json:"score"`
+	Matched       *TaxonRecord `json:"matched,omitempty"`
+	Accepted      *TaxonRecord `json:"accepted,omitempty"`
+}
+
+type SpeciesReconciliation struct {
+	SpeciesID      int       `json:"species_id"`
+	ScientificName string    `json:"scientific_name"`
+	Match          NameMatch `json:"match"`
+	RankConflicts  []string  `json:"rank_conflicts,omitempty"`
+}
+
+type DuplicateGroup struct {
+	AcceptedAphiaID int64  `json:"accepted_aphia_id"`
+	AcceptedName    string `json:"accepted_name"`
+	SpeciesIDs      []int  `json:"species_ids"`
+}
+
+type ReconciliationReport struct {
+	Total      int                     `json:"total"`
+	Exact      int                     `json:"exact"`
+	Synonyms   int                     `json:"synonyms"`
+	Fuzzy      int                     `json:"fuzzy"`
+	Unmatched  int                     `json:"unmatched"`
+	Species    []SpeciesReconciliation `json:"species"`
+	Duplicates []DuplicateGroup        `json:"duplicates"`
+}
+
+type MergeRequest struct {
+	TargetID          int   `json:"target_id"`
+	DuplicateIDs      []int `json:"duplicate_ids"`
+	ApplyAcceptedName bool  `json:"apply_accepted_name"`
+}
+
+// --- Name normalisation ---
+
+// canonicalName reduces a free-text scientific name to "Genus epithet
+// [infraspecific]" by dropping authorship, years, qualifiers and extra
+// whitespace.
+func canonicalName(name string) string {
+	fields := strings.Fields(strings.ReplaceAll(name, "_", " "))
+	var parts []string
+	for i, f := range fields {
+		if i == 0 {
+			f = strings.Trim(f, "()[],.")
+			if f == "" || !unicode.IsLetter(rune(f[0])) {
+				return ""
+			}
+			parts = append(parts, strings.ToUpper(f[:1])+strings.ToLower(f[1:]))
+			continue
+		}
+		lower := strings.ToLower(f)
+		switch lower {
+		case "cf.", "cf", "aff.", "aff", "?":
+			continue
+		case "sp.", "sp", "spp.", "spp", "indet.":
+			return strings.Join(parts, " ")
+		case "subsp.", "ssp.", "var.", "f.":
+			continue
+		}
+		r := rune(f[0])
+		if !unicode.IsLower(r) || strings.ContainsAny(f, "(),0123456789&") {
+			break
+		}
+		parts = append(parts, lower)
+	}
+	return strings.Join(parts, " ")
+}
+
+// nameSimilarity returns 1 - levenshtein(a, b) / max(len(a), len(b)).
+func nameSimilarity(a, b string) float64 {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 && len(rb) == 0 {
+		return 1
+	}
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return 1 - float64(prev[len(rb)])/float64(max(len(ra), len(rb)))
+}
+
+// parseTaxonID accepts plain integers as well as WoRMS LSIDs such as
+// urn:lsid:marinespecies.org:taxname:126436.
+func parseTaxonID(s string) (int64, bool) {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndex(s, ":"); i >= 0 {
+		s = s[i+1:]
+	}
+	id, err := strconv.ParseInt(s, 10, 64)
+	return id, err == nil
+}
+
+// --- Backbone import ---
+
+// backboneColumns maps the header names used by WoRMS and GBIF Darwin Core
+// exports onto our fields.
+var backboneColumns = map[string]string{
+	"taxonid":                  "id",
+	"aphiaid":                  "id",
+	"id":                       "id",
+	"scientificname":           "name",
+	"canonicalname":            "canonical",
+	"scientificnameauthorship": "authority",
+	"authority":                "authority",
+	"taxonrank":                "rank",
+	"rank":                     "rank",
+	"taxonomicstatus":          "status",
+	"status":                   "status",
+	"acceptednameusageid":      "accepted",
+	"valid_aphiaid":            "accepted",
+	"kingdom":                  "kingdom",
+	"phylum":                   "phylum",
+	"class":                    "class",
+	"order":                    "order",
+	"family":                   "family",
+	"genus":                    "genus",
+}
+
+func isAcceptedStatus(status string) bool {
+	switch status {
+	case "", "accepted", "valid", "doubtful":
+		return true
+	}
+	return false
+}
+
+func importBackbone(r io.Reader, source string) (imported, skipped int, err error) {
+	reader := csv.NewReader(r)
+	reader.Comma = '\t'
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return 0, 0, fmt.Errorf("reading header: %w", err)
+	}
+	cols := map[string]int{}
+	for i, h := range header {
+		key := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		if field, ok := backboneColumns[key]; ok {
+			if _, seen := cols[field]; !seen {
+				cols[field] = i
+			}
+		}
+	}
+	if _, ok := cols["id"]; !ok {
+		return 0, 0, fmt.Errorf("dump has no taxonID column")
+	}
+	if _, ok := cols["name"]; !ok {
+		return 0, 0, fmt.Errorf("dump has no scientificName column")
+	}
+	get := func(rec []string, field string) string {
+		if i, ok := cols[field]; ok && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+
+	const upsert = `INSERT INTO taxon_backbone
+		(aphia_id, scientific_name, canonical_name, authority, rank, status, accepted_aphia_id, kingdom, phylum, class, _order, family, genus, source)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		ON CONFLICT (aphia_id) DO UPDATE SET
+			scientific_name = EXCLUDED.scientific_name, canonical_name = EXCLUDED.canonical_name,
+			authority = EXCLUDED.authority, rank = EXCLUDED.rank, status = EXCLUDED.status,
+			accepted_aphia_id = EXCLUDED.accepted_aphia_id, kingdom = EXCLUDED.kingdom,
+			phylum = EXCLUDED.phylum, class = EXCLUDED.class, _order = EXCLUDED._order,
+			family = EXCLUDED.family, genus = EXCLUDED.genus, source = EXCLUDED.source,
+			imported_at = now()`
+
+	var tx *sql.Tx
+	var stmt *sql.Stmt
+	begin := func() error {
+		var err error
+		if tx, err = db.Begin(); err != nil {
+			return err
+		}
+		stmt, err = tx.Prepare(upsert)
+		return err
+	}
+	commit := func() error {
+		stmt.Close()
+		return tx.Commit()
+	}
+	if err := begin(); err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	for {
+		rec, readErr := reader.Read()
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			skipped++
+			continue
+		}
+		id, ok := parseTaxonID(get(rec, "id"))
+		name := get(rec, "name")
+		if !ok || name == "" {
+			skipped++
+			continue
+		}
+		canonical := get(rec, "canonical")
+		if canonical == "" {
+			canonical = canonicalName(name)
+		}
+		status := strings.ToLower(get(rec, "status"))
+		accepted, ok := parseTaxonID(get(rec, "accepted"))
+		if !ok || isAcceptedStatus(status) {
+			accepted = id
+		}
+		genus := get(rec, "genus")
+		if genus == "" {
+			genus = strings.SplitN(canonical, " ", 2)[0]
+		}
+
+		if _, err = stmt.Exec(id, name, canonical, get(rec, "authority"), strings.ToLower(get(rec, "rank")), status, accepted,
+			get(rec, "kingdom"), get(rec, "phylum"), get(rec, "class"), get(rec, "order"), get(rec, "family"), genus, source); err != nil {
+			return imported, skipped, err
+		}
+		imported++
+		if imported%importBatch == 0 {
+			if err = commit(); err != nil {
+				return imported, skipped, err
+			}
+			if err = begin(); err != nil {
+				return imported, skipped, err
+			}
+		}
+	}
+	err = commit()
+	return imported, skipped, err
+}
+
+// --- Resolution ---
+
+const taxonColumns = `aphia_id, scientific_name, canonical_name, COALESCE(authority, ''), COALESCE(rank, ''),
+	COALESCE(status, ''), COALESCE(accepted_aphia_id, aphia_id), COALESCE(kingdom, ''), COALESCE(phylum, ''),
+	COALESCE(class, ''), COALESCE(_order, ''), COALESCE(family, ''), COALESCE(genus, '')`
+
+func scanTaxon(row interface{ Scan(...any) error }) (*TaxonRecord, error) {
+	var t TaxonRecord
+	err := row.Scan(&t.AphiaID, &t.ScientificName, &t.CanonicalName, &t.Authority, &t.Rank, &t.Status, &t.AcceptedAphiaID,
+		&t.Kingdom, &t.Phylum, &t.Class, &t.Order, &t.Family, &t.Genus)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// followAccepted walks synonym links until it reaches an accepted taxon.
+func followAccepted(t *TaxonRecord) (*TaxonRecord, error) {
+	for hops := 0; hops < 5 && t.AcceptedAphiaID != t.AphiaID; hops++ {
+		next, err := scanTaxon(db.QueryRow("SELECT "+taxonColumns+" FROM taxon_backbone WHERE aphia_id = $1", t.AcceptedAphiaID))
+		if err == sql.ErrNoRows {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		t = next
+	}
+	return t, nil
+}
+
+func resolveName(name string) (NameMatch, error) {
+	m := NameMatch{Query: name, CanonicalName: canonicalName(name), MatchType: matchNone}
+	if m.CanonicalName == "" {
+		return m, nil
+	}
+
+	matched, err := scanTaxon(db.QueryRow("SELECT "+taxonColumns+` FROM taxon_backbone
+		WHERE lower(canonical_name) = lower($1)
+		ORDER BY (accepted_aphia_id = aphia_id) DESC, aphia_id LIMIT 1`, m.CanonicalName))
+	if err != nil && err != sql.ErrNoRows {
+		return m, err
+	}
+	if matched != nil {
+		m.MatchType, m.Score = matchExact, 1
+	} else {
+		if matched, m.Score, err = fuzzyCandidate(m.CanonicalName); err != nil {
+			return m, err
+		}
+		if matched == nil {
+			return m, nil
+		}
+		m.MatchType = matchFuzzy
+	}
+
+	accepted, err := followAccepted(matched)
+	if err != nil {
+		return m, err
+	}
+	if accepted.AphiaID != matched.AphiaID && m.MatchType == matchExact {
+		m.MatchType = matchSynonym
+	}
+	m.Matched, m.Accepted = matched, accepted
+	return m, nil
+}
+
+// fuzzyCandidate compares the name against backbone entries that share its
+// first three letters and have a similar length, returning the closest one
+// above fuzzyThreshold.
+func fuzzyCandidate(canonical string) (*TaxonRecord, float64, error) {
+	if len(canonical) < 4 {
+		return nil, 0, nil
+	}
+	rows, err := db.Query("SELECT "+taxonColumns+` FROM taxon_backbone
+		WHERE lower(canonical_name) LIKE lower($1) || '%'
+		AND length(canonical_name) BETWEEN $2 AND $3
+		LIMIT 5000`, canonical[:3], len(canonical)-3, len(canonical)+3)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var best *TaxonRecord
+	bestScore := 0.0
+	for rows.Next() {
+		t, err := scanTaxon(rows)
+		if err != nil {
+			continue
+		}
+		score := nameSimilarity(canonical, t.CanonicalName)
+		if score > bestScore || (score == bestScore && best != nil && t.AcceptedAphiaID == t.AphiaID && best.AcceptedAphiaID != best.AphiaID) {
+			best, bestScore = t, score
+		}
+	}
+	if bestScore < fuzzyThreshold {
+		return nil, 0, rows.Err()
+	}
+	return best, bestScore, rows.Err()
+}
+
+// rankConflicts reports which of the free-text ranks stored on the species
+// row disagree with the accepted backbone classification.
+func rankConflicts(s Species, t *TaxonRecord) []string {
+	var conflicts []string
+	check := func(rank, ours, theirs string) {
+		if ours != "" && theirs != "" && !strings.EqualFold(strings.TrimSpace(ours), theirs) {
+			conflicts = append(conflicts, fmt.Sprintf("%s: %q vs backbone %q", rank, ours, theirs))
+		}
+	}
+	check("kingdom", s.Kingdom, t.Kingdom)
+	check("phylum", s.Phylum, t.Phylum)
+	check("class", s.Class, t.Class)
+	check("order", s.Order, t.Order)
+	check("family", s.Family, t.Family)
+	check("genus", s.Genus, t.Genus)
+	return conflicts
+}
+
+func buildReconciliationReport() (*ReconciliationReport, error) {
+	rows, err := db.Query(`SELECT id, COALESCE(scientific_name, ''), COALESCE(kingdom, ''), COALESCE(phylum, ''),
+		COALESCE(class, ''), COALESCE(_order, ''), COALESCE(family, ''), COALESCE(genus, '')
+		FROM species_data ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	var species []Species
+	for rows.Next() {
+		var s Species
+		if err := rows.Scan(&s.ID, &s.ScientificName, &s.Kingdom, &s.Phylum, &s.Class, &s.Order, &s.Family, &s.Genus); err != nil {
+			continue
+		}
+		species = append(species, s)
+	}
+	rows.Close()
+
+	report := &ReconciliationReport{Species: []SpeciesReconciliation{}, Duplicates: []DuplicateGroup{}}
+	groups := map[int64]*DuplicateGroup{}
+	for _, s := range species {
+		m, err := resolveName(s.ScientificName)
+		if err != nil {
+			return nil, err
+		}
+		rec := SpeciesReconciliation{SpeciesID: s.ID, ScientificName: s.ScientificName, Match: m}
+		report.Total++
+		switch m.MatchType {
+		case matchExact:
+			report.Exact++
+		case matchSynonym:
+			report.Synonyms++
+		case matchFuzzy:
+			report.Fuzzy++
+		default:
+			report.Unmatched++
+		}
+		if m.Accepted != nil {
+			rec.RankConflicts = rankConflicts(s, m.Accepted)
+			g, ok := groups[m.Accepted.AphiaID]
+			if !ok {
+				g = &DuplicateGroup{AcceptedAphiaID: m.Accepted.AphiaID, AcceptedName: m.Accepted.ScientificName}
+				groups[m.Accepted.AphiaID] = g
+			}
+			g.SpeciesIDs = append(g.SpeciesIDs, s.ID)
+		}
+		report.Species = append(report.Species, rec)
+	}
+	for _, g := range groups {
+		if len(g.SpeciesIDs) > 1 {
+			report.Duplicates = append(report.Duplicates, *g)
+		}
+	}
+	sort.Slice(report.Duplicates, func(i, j int) bool {
+		return report.Duplicates[i].AcceptedName < report.Duplicates[j].AcceptedName
+	})
+	return report, nil
+}
+
+func saveNameMatches(report *ReconciliationReport) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT INTO species_name_match (species_id, aphia_id, accepted_aphia_id, match_type, score)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (species_id) DO UPDATE SET aphia_id = EXCLUDED.aphia_id, accepted_aphia_id = EXCLUDED.accepted_aphia_id,
+			match_type = EXCLUDED.match_type, score = EXCLUDED.score, matched_at = now()`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, s := range report.Species {
+		var aphiaID, acceptedID sql.NullInt64
+		if s.Match.Matched != nil {
+			aphiaID = sql.NullInt64{Int64: s.Match.Matched.AphiaID, Valid: true}
+			acceptedID = sql.NullInt64{Int64: s.Match.Accepted.AphiaID, Valid: true}
+		}
+		if _, err := stmt.Exec(s.SpeciesID, aphiaID, acceptedID, s.Match.MatchType, s.Match.Score); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func mergeSpecies(req MergeRequest) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock every species involved so a concurrent merge or delete cannot
+	// change them underneath us, and refuse if any of them is gone.
+	all := pq.Array(append([]int{req.TargetID}, req.DuplicateIDs...))
+	rows, err := tx.Query("SELECT id FROM species_data WHERE id = ANY($1) FOR UPDATE", all)
+	if err != nil {
+		return err
+	}
+	found := map[int]bool{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		found[id] = true
+	}
+	rows.Close()
+	if !found[req.TargetID] {
+		return errMergeSpeciesMissing
+	}
+	for _, id := range req.DuplicateIDs {
+		if !found[id] {
+			return errMergeSpeciesMissing
+		}
+	}
+
+	ids := pq.Array(req.DuplicateIDs)
+	for _, ref := range speciesReferenceTables {
+		if len(ref.unique) > 0 {
+			key := strings.Join(ref.unique, ", ")
+			_, err := tx.Exec(fmt.Sprintf(`DELETE FROM %[1]s WHERE id IN (
+				SELECT id FROM (SELECT id, row_number() OVER (PARTITION BY %[3]s ORDER BY %[2]s = $1 DESC, id) AS n
+					FROM %[1]s WHERE %[2]s = ANY($2)) ranked
+				WHERE n > 1)`, ref.table, ref.column, key), req.TargetID, all)
+			if err != nil {
+				return fmt.Errorf("deduplicating %s: %w", ref.table, err)
+			}
+		}
+		_, err := tx.Exec(fmt.Sprintf("UPDATE %s SET %s = $1 WHERE %[2]s = ANY($2)", ref.table, ref.column), req.TargetID, ids)
+		if err != nil {
+			return fmt.Errorf("repointing %s.%s: %w", ref.table, ref.column, err)
+		}
+	}
+
+	// The target may have inherited a newer global assessment.
+	var assessed bool
+	if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM conservation_assessments WHERE species_id = $1 AND scope = 'global')",
+		req.TargetID).Scan(&assessed); err != nil {
+		return err
+	}
+	if assessed {
+		if err := refreshCurrentStatus(tx, req.TargetID); err != nil {
+			return err
+		}
+	}
+
+	// Union the array columns so no regions or images are lost.
+	_, err = tx.Exec(`UPDATE species_data SET
+		reported_regions = (SELECT array_agg(DISTINCT r) FROM species_data, unnest(reported_regions) r WHERE id = ANY($2)),
+		image_urls = (SELECT array_agg(DISTINCT u) FROM species_data, unnest(image_urls) u WHERE id = ANY($2))
+		WHERE id = $1`, req.TargetID, all)
+	if err != nil {
+		return err
+	}
+
+	if req.ApplyAcceptedName {
+		res, err := tx.Exec(`UPDATE species_data s SET scientific_name = b.canonical_name,
+			class = COALESCE(NULLIF(b.class, ''), s.class), _order = COALESCE(NULLIF(b._order, ''), s._order),
+			family = COALESCE(NULLIF(b.family, ''), s.family), genus = COALESCE(NULLIF(b.genus, ''), s.genus)
+			FROM species_name_match m JOIN taxon_backbone b ON b.aphia_id = m.accepted_aphia_id
+			WHERE m.species_id = s.id AND s.id = $1`, req.TargetID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return errNoAcceptedName
+		}
+	}
+
+	if _, err := tx.Exec(`INSERT INTO species_merge_log (target_id, merged_id, merged_name)
+		SELECT $1, id, scientific_name FROM species_data WHERE id = ANY($2)`, req.TargetID, ids); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM species_name_match WHERE species_id = ANY($1)", ids); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM species_data WHERE id = ANY($1)", ids); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// --- Handlers ---
+
+func handleBackboneImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := requireRole(w, r, moderatorRoles...); !ok {
+		return
+	}
+	source := r.URL.Query().Get("source")
+	if source == "" {
+		source = "worms"
+	}
+
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "Failed to get file", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	imported, skipped, err := importBackbone(body, source)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"imported": imported, "skipped": skipped})
+}
+
+func resolveTaxonNames(w http.ResponseWriter, r *http.Request) {
+	names := r.URL.Query()["name"]
+	if len(names) == 0 {
+		http.Error(w, "name parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	matches := []NameMatch{}
+	for _, name := range names {
+		m, err := resolveName(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		matches = append(matches, m)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(matches)
+}
+
+// getTaxonomyReport resolves every species on GET; a POST additionally
+// stores the matches in species_name_match. Both resolve each species in
+// turn, so the report is for moderators only.
+func getTaxonomyReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := requireRole(w, r, moderatorRoles...); !ok {
+		return
+	}
+
+	report, err := buildReconciliationReport()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if r.Method == "POST" {
+		if err := saveNameMatches(report); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func handleSpeciesMerge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := requireRole(w, r, moderatorRoles...); !ok {
+		return
+	}
+
+	var req MergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.TargetID == 0 || len(req.DuplicateIDs) == 0 {
+		http.Error(w, "target_id and duplicate_ids are required", http.StatusBadRequest)
+		return
+	}
+	for _, id := range req.DuplicateIDs {
+		if id == req.TargetID {
+			http.Error(w, "target_id cannot also be a duplicate", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := mergeSpecies(req); err == errMergeSpeciesMissing {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err == errNoAcceptedName {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"target_id": req.TargetID, "merged": req.DuplicateIDs})
+}
@@ -0,0 +1,600 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// --- Resumable Uploads (tus 1.0.0) ---
+//
+// Implements the tus core protocol with the creation, creation-defer-length,
+// checksum, expiration and termination extensions. Chunks are appended to a
+// staging file on local disk, since object stores cannot append; once the
+// last byte arrives the file is hashed and moved to the object store, and
+// attached to a dataset when the upload metadata names one.
+
+const tusSchema = `
+CREATE TABLE IF NOT EXISTS resumable_uploads (
+	id              TEXT PRIMARY KEY,
+	owner_id        TEXT NOT NULL,
+	upload_length   BIGINT,
+	upload_offset   BIGINT NOT NULL DEFAULT 0,
+	metadata        JSONB NOT NULL DEFAULT '{}',
+	status          TEXT NOT NULL DEFAULT 'in_progress',
+	object_key      TEXT,
+	sha256          TEXT,
+	dataset_file_id INTEGER,
+	created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+	expires_at      TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS resumable_uploads_expiry_idx ON resumable_uploads (status, expires_at);`
+
+const (
+	tusVersion       = "1.0.0"
+	tusExtensions    = "creation,creation-defer-length,checksum,expiration,termination"
+	tusChecksumAlgos = "sha1,sha256,md5"
+	tusMaxSize       = 50 << 30
+	tusExpiry        = 24 * time.Hour
+	tusSweepInterval = time.Hour
+
+	uploadInProgress = "in_progress"
+	uploadComplete   = "complete"
+	uploadFailed     = "failed"
+	uploadExpired    = "expired"
+	uploadTerminated = "terminated"
+
+	// statusChecksumMismatch is the tus checksum extension's status code.
+	statusChecksumMismatch = 460
+)
+
+// tusHeaders are the request and response headers browsers need CORS
+// permission for.
+var tusHeaders = []string{"Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata", "Upload-Defer-Length", "Upload-Checksum", "Upload-Expires", "Location"}
+
+// uploadLocks serialises PATCH requests per upload within this process.
+var uploadLocks sync.Map
+
+type ResumableUpload struct {
+	ID            string            `json:"id"`
+	OwnerID       string            `json:"owner_id"`
+	Length        *int64            `json:"length"`
+	Offset        int64             `json:"offset"`
+	Metadata      map[string]string `json:"metadata"`
+	Status        string            `json:"status"`
+	SHA256        string            `json:"sha256,omitempty"`
+	DatasetFileID *int              `json:"dataset_file_id,omitempty"`
+	CreatedAt     time.Time         `json:"created_at"`
+	ExpiresAt     time.Time         `json:"expires_at"`
+}
+
+func stagingPath(id string) string {
+	return filepath.Join(uploadDir(), "tus", id)
+}
+
+func tusOptions(w http.ResponseWriter) {
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(tusMaxSize, 10))
+	w.Header().Set("Tus-Checksum-Algorithm", tusChecksumAlgos)
+}
+
+// parseUploadMetadata decodes "key base64value,key2 base64value2".
+func parseUploadMetadata(header string) (map[string]string, error) {
+	meta := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Upload-Metadata value for %q", key)
+		}
+		meta[key] = string(value)
+	}
+	return meta, nil
+}
+
+func checksumHash(algo string) hash.Hash {
+	switch algo {
+	case "sha1":
+		return sha1.New()
+	case "sha256":
+		return sha256.New()
+	case "md5":
+		return md5.New()
+	}
+	return nil
+}
+
+func scanUpload(row interface{ Scan(...any) error }) (*ResumableUpload, error) {
+	var u ResumableUpload
+	var length sql.NullInt64
+	var datasetFileID sql.NullInt64
+	var meta []byte
+	var sum sql.NullString
+	err := row.Scan(&u.ID, &u.OwnerID, &length, &u.Offset, &meta, &u.Status, &sum, &datasetFileID, &u.CreatedAt, &u.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if length.Valid {
+		u.Length = &length.Int64
+	}
+	if datasetFileID.Valid {
+		id := int(datasetFileID.Int64)
+		u.DatasetFileID = &id
+	}
+	u.SHA256 = sum.String
+	json.Unmarshal(meta, &u.Metadata)
+	return &u, nil
+}
+
+const uploadColumns = `id, owner_id, upload_length, upload_offset, metadata, status, sha256, dataset_file_id, created_at, expires_at`
+
+// uploadForRequest loads the {id} upload owned by user, writing the error
+// response itself when it returns nil.
+func uploadForRequest(w http.ResponseWriter, r *http.Request, user *AuthUser) *ResumableUpload {
+	u, err := scanUpload(db.QueryRow("SELECT "+uploadColumns+" FROM resumable_uploads WHERE id = $1", r.PathValue("id")))
+	if err == sql.ErrNoRows || (err == nil && u.OwnerID != user.ID) {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+	if u.Status == uploadExpired || u.Status == uploadTerminated {
+		http.Error(w, "Upload is "+u.Status, http.StatusGone)
+		return nil
+	}
+	return u
+}
+
+// tusRequest checks the protocol version and authenticates the caller.
+func tusRequest(w http.ResponseWriter, r *http.Request) (*AuthUser, bool) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "Unsupported tus version", http.StatusPreconditionFailed)
+		return nil, false
+	}
+	return requireUser(w, r)
+}
+
+func createUpload(w http.ResponseWriter, r *http.Request) {
+	user, ok := tusRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var length sql.NullInt64
+	if v := r.Header.Get("Upload-Length"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid Upload-Length", http.StatusBadRequest)
+			return
+		}
+		if n > tusMaxSize {
+			http.Error(w, "Upload exceeds Tus-Max-Size", http.StatusRequestEntityTooLarge)
+			return
+		}
+		length = sql.NullInt64{Int64: n, Valid: true}
+	} else if r.Header.Get("Upload-Defer-Length") != "1" {
+		http.Error(w, "Upload-Length or Upload-Defer-Length is required", http.StatusBadRequest)
+		return
+	}
+
+	meta, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, ok := meta["filename"]; !ok {
+		meta["filename"] = "upload"
+	}
+	metaJSON, _ := json.Marshal(meta)
+
+	id := randomID(16)
+	if err := os.MkdirAll(filepath.Dir(stagingPath(id)), 0o755); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	f, err := os.Create(stagingPath(id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	f.Close()
+
+	expires := time.Now().Add(tusExpiry)
+	_, err = db.Exec(`INSERT INTO resumable_uploads (id, owner_id, upload_length, metadata, expires_at) VALUES ($1, $2, $3, $4, $5)`,
+		id, user.ID, length, metaJSON, expires)
+	if err != nil {
+		os.Remove(stagingPath(id))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", "/api/uploads/"+id)
+	w.Header().Set("Upload-Expires", expires.UTC().Format(http.TimeFormat))
+
+	// An empty file is complete as soon as it is created.
+	if length.Valid && length.Int64 == 0 {
+		u, err := scanUpload(db.QueryRow("SELECT "+uploadColumns+" FROM resumable_uploads WHERE id = $1", id))
+		if err == nil {
+			err = finishUpload(r.Context(), u, user)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func headUpload(w http.ResponseWriter, r *http.Request) {
+	user, ok := tusRequest(w, r)
+	if !ok {
+		return
+	}
+	u := uploadForRequest(w, r, user)
+	if u == nil {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	if u.Length != nil {
+		w.Header().Set("Upload-Length", strconv.FormatInt(*u.Length, 10))
+	} else {
+		w.Header().Set("Upload-Defer-Length", "1")
+	}
+	var pairs []string
+	for k, v := range u.Metadata {
+		pairs = append(pairs, k+" "+base64.StdEncoding.EncodeToString([]byte(v)))
+	}
+	w.Header().Set("Upload-Metadata", strings.Join(pairs, ","))
+	w.Header().Set("Upload-Expires", u.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+}
+
+func patchUpload(w http.ResponseWriter, r *http.Request) {
+	user, ok := tusRequest(w, r)
+	if !ok {
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	lock, _ := uploadLocks.LoadOrStore(r.PathValue("id"), &sync.Mutex{})
+	mu := lock.(*sync.Mutex)
+	if !mu.TryLock() {
+		http.Error(w, "Upload is locked by another request", http.StatusLocked)
+		return
+	}
+	defer mu.Unlock()
+
+	u := uploadForRequest(w, r, user)
+	if u == nil {
+		return
+	}
+	if u.Status != uploadInProgress {
+		http.Error(w, "Upload is already "+u.Status, http.StatusForbidden)
+		return
+	}
+	if time.Now().After(u.ExpiresAt) {
+		http.Error(w, "Upload has expired", http.StatusGone)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+	if offset != u.Offset {
+		http.Error(w, "Upload-Offset does not match the current offset", http.StatusConflict)
+		return
+	}
+
+	if u.Length == nil {
+		if v := r.Header.Get("Upload-Length"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < u.Offset || n > tusMaxSize {
+				http.Error(w, "Invalid Upload-Length", http.StatusBadRequest)
+				return
+			}
+			u.Length = &n
+		}
+	}
+
+	var checksum hash.Hash
+	var expectedSum []byte
+	if v := r.Header.Get("Upload-Checksum"); v != "" {
+		algo, encoded, _ := strings.Cut(v, " ")
+		if checksum = checksumHash(algo); checksum == nil {
+			http.Error(w, "Unsupported checksum algorithm", http.StatusBadRequest)
+			return
+		}
+		if expectedSum, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			http.Error(w, "Invalid Upload-Checksum", http.StatusBadRequest)
+			return
+		}
+	}
+
+	f, err := os.OpenFile(stagingPath(u.ID), os.O_WRONLY, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Seek(u.Offset, io.SeekStart); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	remaining := int64(tusMaxSize) - u.Offset
+	if u.Length != nil {
+		remaining = *u.Length - u.Offset
+	}
+	var dst io.Writer = f
+	if checksum != nil {
+		dst = io.MultiWriter(f, checksum)
+	}
+	written, copyErr := io.Copy(dst, io.LimitReader(r.Body, remaining+1))
+	if written > remaining {
+		f.Truncate(u.Offset)
+		http.Error(w, "Chunk extends past Upload-Length", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// A checksummed chunk is all-or-nothing: discard it unless it arrived
+	// whole and matches. Without a checksum, keep whatever was received so
+	// the client can resume after a disconnect.
+	if checksum != nil && (copyErr != nil || !bytes.Equal(checksum.Sum(nil), expectedSum)) {
+		f.Truncate(u.Offset)
+		http.Error(w, "Checksum Mismatch", statusChecksumMismatch)
+		return
+	}
+	if err := f.Sync(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	u.Offset += written
+	u.ExpiresAt = time.Now().Add(tusExpiry)
+	_, err = db.Exec(`UPDATE resumable_uploads SET upload_offset = $2, upload_length = $3, expires_at = $4, updated_at = now() WHERE id = $1`,
+		u.ID, u.Offset, u.Length, u.ExpiresAt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if u.Length != nil && u.Offset == *u.Length {
+		if err := finishUpload(r.Context(), u, user); err != nil {
+			http.Error(w, "Failed to store upload: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	w.Header().Set("Upload-Expires", u.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finishUpload hashes the staged file, verifies the optional whole-file
+// sha256 from the metadata, moves it to the object store and, if requested,
+// attaches it to a dataset the user may write to.
+func finishUpload(ctx context.Context, u *ResumableUpload, user *AuthUser) error {
+	f, err := os.Open(stagingPath(u.ID))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if want := u.Metadata["sha256"]; want != "" && !strings.EqualFold(want, sum) {
+		db.Exec("UPDATE resumable_uploads SET status = $2, sha256 = $3, updated_at = now() WHERE id = $1", u.ID, uploadFailed, sum)
+		f.Close()
+		os.Remove(stagingPath(u.ID))
+		return fmt.Errorf("file checksum mismatch: expected %s, got %s", want, sum)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	name := path.Base(u.Metadata["filename"])
+	contentType := u.Metadata["filetype"]
+	var objectKey string
+	var datasetFileID sql.NullInt64
+
+	if ref := u.Metadata["dataset_id"]; ref != "" {
+		ds, err := loadDataset(ref)
+		if err != nil || !ds.canWrite(user) {
+			return fmt.Errorf("dataset %s not found or not writable", ref)
+		}
+		// Store the object before opening the transaction, as
+		// createDataset does, and delete it unless the row commits.
+		key, err := putDatasetObject(ctx, ds.Accession, name, contentType, f, size)
+		if err != nil {
+			deleteObjects([]string{key})
+			return err
+		}
+		committed := false
+		defer func() {
+			if !committed {
+				deleteObjects([]string{key})
+			}
+		}()
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		df, err := recordDatasetFile(tx, ds, key, name, contentType, size, sum)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE datasets SET updated_at = now() WHERE id = $1", ds.ID); err != nil {
+			return err
+		}
+		if _, err := recordDatasetVersion(tx, ds.ID, user.ID, "Added "+name+" by resumable upload"); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		committed = true
+		objectKey = key
+		datasetFileID = sql.NullInt64{Int64: int64(df.ID), Valid: true}
+	} else {
+		objectKey = "uploads/" + u.ID + "/" + name
+		if err := store.Put(ctx, objectKey, f, size, contentType); err != nil {
+			return err
+		}
+	}
+
+	_, err = db.Exec(`UPDATE resumable_uploads SET status = $2, object_key = $3, sha256 = $4, dataset_file_id = $5, updated_at = now()
+		WHERE id = $1`, u.ID, uploadComplete, objectKey, sum, datasetFileID)
+	if err != nil {
+		return err
+	}
+	os.Remove(stagingPath(u.ID))
+	return nil
+}
+
+func deleteUpload(w http.ResponseWriter, r *http.Request) {
+	user, ok := tusRequest(w, r)
+	if !ok {
+		return
+	}
+	u := uploadForRequest(w, r, user)
+	if u == nil {
+		return
+	}
+	if u.Status == uploadComplete {
+		http.Error(w, "Completed uploads cannot be terminated", http.StatusForbidden)
+		return
+	}
+
+	if _, err := db.Exec("UPDATE resumable_uploads SET status = $2, updated_at = now() WHERE id = $1", u.ID, uploadTerminated); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	os.Remove(stagingPath(u.ID))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getUploadStatus is a plain JSON view of an upload for the UI; it is not
+// part of the tus protocol.
+func getUploadStatus(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	u := uploadForRequest(w, r, user)
+	if u == nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(u)
+}
+
+// expireUploads marks abandoned uploads as expired and deletes their
+// staging files, then removes any staging file whose upload is no longer
+// in progress (failed, or left behind by a crash).
+func expireUploads() {
+	rows, err := db.Query(`UPDATE resumable_uploads SET status = $1, updated_at = now()
+		WHERE status = $2 AND expires_at < now() RETURNING id`, uploadExpired, uploadInProgress)
+	if err != nil {
+		log.Println("Expiring uploads:", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			os.Remove(stagingPath(id))
+			uploadLocks.Delete(id)
+		}
+	}
+	removeStaleStaging()
+}
+
+func removeStaleStaging() {
+	entries, err := os.ReadDir(filepath.Join(uploadDir(), "tus"))
+	if err != nil {
+		return
+	}
+	ids := make([]string, 0, len(entries))
+	for _, e := range entries {
+		// Skip files just created, whose upload row may not be committed.
+		if info, err := e.Info(); err != nil || e.IsDir() || time.Since(info.ModTime()) < time.Minute {
+			continue
+		}
+		ids = append(ids, e.Name())
+	}
+	if len(ids) == 0 {
+		return
+	}
+	rows, err := db.Query("SELECT id FROM resumable_uploads WHERE id = ANY($1) AND status = $2", pq.Array(ids), uploadInProgress)
+	if err != nil {
+		log.Println("Sweeping upload staging files:", err)
+		return
+	}
+	defer rows.Close()
+	active := map[string]bool{}
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			active[id] = true
+		}
+	}
+	if rows.Err() != nil {
+		return
+	}
+	for _, id := range ids {
+		if !active[id] {
+			os.Remove(stagingPath(id))
+			uploadLocks.Delete(id)
+		}
+	}
+}
+
+func expireUploadsLoop() {
+	for {
+		expireUploads()
+		time.Sleep(tusSweepInterval)
+	}
+}
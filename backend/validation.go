@@ -0,0 +1,751 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// --- Tabular Dataset Validation ---
+//
+// validateTable profiles an uploaded sheet, maps its columns onto one of the
+// known table schemas (by alias, with unit suffixes such as "Depth (ft)"
+// converted to our canonical units), and checks every row. Nothing is
+// imported; the report lists row-level errors and warnings so the uploader
+// can fix the sheet first.
+
+const (
+	fieldText      = "text"
+	fieldNumber    = "number"
+	fieldInteger   = "integer"
+	fieldDate      = "date"
+	fieldLatitude  = "latitude"
+	fieldLongitude = "longitude"
+	fieldSpecies   = "species"
+
+	severityError   = "error"
+	severityWarning = "warning"
+
+	maxReportedIssues = 1000
+	maxProfileSamples = 5
+	maxProfileTracked = 1000
+)
+
+// unitConversion converts a value in some unit to the field's canonical
+// unit as value*Factor + Offset.
+type unitConversion struct {
+	Factor float64
+	Offset float64
+}
+
+type FieldDef struct {
+	Name     string
+	Type     string
+	Required bool
+	Min, Max *float64
+	Aliases  []string
+	Units    map[string]unitConversion
+}
+
+type TableSchema struct {
+	Name   string
+	Fields []FieldDef
+}
+
+func bound(v float64) *float64 { return &v }
+
+var (
+	depthUnits  = map[string]unitConversion{"m": {1, 0}, "meters": {1, 0}, "metres": {1, 0}, "ft": {0.3048, 0}, "fathom": {1.8288, 0}}
+	lengthUnits = map[string]unitConversion{"cm": {1, 0}, "mm": {0.1, 0}, "m": {100, 0}, "in": {2.54, 0}}
+	weightUnits = map[string]unitConversion{"kg": {1, 0}, "g": {0.001, 0}, "t": {1000, 0}, "tonnes": {1000, 0}, "lb": {0.45359237, 0}}
+	tempUnits   = map[string]unitConversion{"c": {1, 0}, "degc": {1, 0}, "deg_c": {1, 0}, "degree_celsius": {1, 0}, "celsius": {1, 0}, "f": {5.0 / 9, -160.0 / 9}, "k": {1, -273.15}, "kelvin": {1, -273.15}}
+	oxygenUnits = map[string]unitConversion{"mg_l": {1, 0}, "mgl": {1, 0}, "ml_l": {1.429, 0}, "mll": {1.429, 0}, "umol_kg": {0.032, 0}, "micromole_kg": {0.032, 0}, "umol_l": {0.032, 0}}
+)
+
+var speciesAliases = []string{"species", "scientific_name", "scientificname", "species_name", "taxon", "taxon_name", "vernacular_name", "common_name"}
+
+var tableSchemas = []TableSchema{
+	{Name: "occurrence", Fields: []FieldDef{
+		{Name: "species", Type: fieldSpecies, Required: true, Aliases: speciesAliases},
+		{Name: "event_date", Type: fieldDate, Required: true, Aliases: []string{"event_date", "eventdate", "date", "observation_date", "sighting_date", "survey_date"}},
+		{Name: "latitude", Type: fieldLatitude, Required: true, Aliases: []string{"latitude", "lat", "decimallatitude", "decimal_latitude", "y"}},
+		{Name: "longitude", Type: fieldLongitude, Required: true, Aliases: []string{"longitude", "lon", "long", "lng", "decimallongitude", "decimal_longitude", "x"}},
+		{Name: "region", Type: fieldText, Aliases: []string{"region", "locality", "location", "area", "site"}},
+		{Name: "water_depth_m", Type: fieldNumber, Min: bound(0), Max: bound(11000), Units: depthUnits, Aliases: []string{"depth", "water_depth", "waterdepth", "waterdepth_m", "depth_m"}},
+		{Name: "recorded_by", Type: fieldText, Aliases: []string{"recorded_by", "recordedby", "observer", "collector"}},
+		{Name: "individual_count", Type: fieldInteger, Min: bound(0), Aliases: []string{"individual_count", "individualcount", "count", "abundance", "number"}},
+	}},
+	{Name: "environmental", Fields: []FieldDef{
+		{Name: "station_id", Type: fieldText, Required: true, Aliases: []string{"station_id", "station", "stationid", "site_id", "buoy", "platform"}},
+		{Name: "timestamp", Type: fieldDate, Required: true, Aliases: []string{"timestamp", "time", "datetime", "date_time", "date", "observed_at"}},
+		{Name: "latitude", Type: fieldLatitude, Aliases: []string{"latitude", "lat"}},
+		{Name: "longitude", Type: fieldLongitude, Aliases: []string{"longitude", "lon", "long", "lng"}},
+		{Name: "depth_m", Type: fieldNumber, Min: bound(0), Max: bound(11000), Units: depthUnits, Aliases: []string{"depth", "depth_m", "pressure_depth", "depth_below_sea_surface"}},
+		{Name: "temperature_c", Type: fieldNumber, Min: bound(-3), Max: bound(40), Units: tempUnits, Aliases: []string{"temperature", "temp", "sst", "water_temperature", "temperature_c", "sea_water_temperature", "temp_adjusted"}},
+		{Name: "salinity_psu", Type: fieldNumber, Min: bound(0), Max: bound(45), Units: map[string]unitConversion{"psu": {1, 0}, "ppt": {1, 0}, "pss_78": {1, 0}, "1e_3": {1, 0}}, Aliases: []string{"salinity", "sal", "psal", "salinity_psu", "sea_water_salinity", "psal_adjusted"}},
+		{Name: "dissolved_oxygen_mg_l", Type: fieldNumber, Min: bound(0), Max: bound(20), Units: oxygenUnits, Aliases: []string{"dissolved_oxygen", "oxygen", "do", "doxy", "o2", "dissolved_oxygen_mg_l", "doxy_adjusted", "oxygen_concentration"}},
+		{Name: "ph", Type: fieldNumber, Min: bound(6), Max: bound(9.5), Aliases: []string{"ph", "ph_total", "ph_nbs", "ph_in_situ_total", "sea_water_ph_reported_on_total_scale"}},
+	}},
+	{Name: "catch", Fields: []FieldDef{
+		{Name: "species", Type: fieldSpecies, Required: true, Aliases: speciesAliases},
+		{Name: "landing_date", Type: fieldDate, Required: true, Aliases: []string{"landing_date", "date", "catch_date", "trip_date"}},
+		{Name: "port", Type: fieldText, Aliases: []string{"port", "landing_centre", "landing_center", "harbour", "harbor"}},
+		{Name: "region", Type: fieldText, Aliases: []string{"region", "fishing_area", "fishing_zone", "zone", "area"}},
+		{Name: "gear", Type: fieldText, Aliases: []string{"gear", "gear_type", "method", "fishing_gear"}},
+		{Name: "vessel_id", Type: fieldText, Aliases: []string{"vessel_id", "vessel", "boat", "boat_id", "registration"}},
+		{Name: "weight_kg", Type: fieldNumber, Min: bound(0), Units: weightUnits, Aliases: []string{"weight", "catch_weight", "landings", "weight_kg", "catch"}},
+		{Name: "count", Type: fieldInteger, Min: bound(0), Aliases: []string{"count", "number", "numbers", "individuals"}},
+		{Name: "length_cm", Type: fieldNumber, Min: bound(0), Max: bound(500), Units: lengthUnits, Aliases: []string{"length", "total_length", "fork_length", "length_cm", "tl"}},
+		{Name: "effort_hours", Type: fieldNumber, Min: bound(0), Aliases: []string{"effort", "effort_hours", "fishing_hours", "hours_fished"}},
+	}},
+}
+
+func findTableSchema(name string) *TableSchema {
+	for i := range tableSchemas {
+		if tableSchemas[i].Name == name {
+			return &tableSchemas[i]
+		}
+	}
+	return nil
+}
+
+type ColumnProfile struct {
+	Index        int      `json:"index"`
+	Header       string   `json:"header"`
+	MappedField  string   `json:"mapped_field,omitempty"`
+	Unit         string   `json:"unit,omitempty"`
+	InferredType string   `json:"inferred_type"`
+	NonEmpty     int      `json:"non_empty"`
+	Distinct     int      `json:"distinct"`
+	Samples      []string `json:"samples"`
+	Min          *float64 `json:"min,omitempty"`
+	Max          *float64 `json:"max,omitempty"`
+}
+
+type ValidationIssue struct {
+	Row        int    `json:"row"`
+	Column     string `json:"column,omitempty"`
+	Field      string `json:"field,omitempty"`
+	Value      string `json:"value,omitempty"`
+	Severity   string `json:"severity"`
+	Message    string `json:"message"`
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
+type SpeciesResolutionSummary struct {
+	Resolved   int      `json:"resolved"`
+	Corrected  int      `json:"corrected"`
+	Unresolved int      `json:"unresolved"`
+	Unknown    []string `json:"unknown_names"`
+}
+
+type ValidationReport struct {
+	Schema          string                    `json:"schema"`
+	SchemaScores    map[string]int            `json:"schema_scores"`
+	RowCount        int                       `json:"row_count"`
+	ValidRows       int                       `json:"valid_rows"`
+	ErrorCount      int                       `json:"error_count"`
+	WarningCount    int                       `json:"warning_count"`
+	Columns         []ColumnProfile           `json:"columns"`
+	UnmappedColumns []string                  `json:"unmapped_columns"`
+	MissingRequired []string                  `json:"missing_required"`
+	Species         *SpeciesResolutionSummary `json:"species,omitempty"`
+	Issues          []ValidationIssue         `json:"issues"`
+	IssuesTruncated bool                      `json:"issues_truncated"`
+	ReadyForImport  bool                      `json:"ready_for_import"`
+}
+
+func (rep *ValidationReport) add(issue ValidationIssue) {
+	if issue.Severity == severityError {
+		rep.ErrorCount++
+	} else {
+		rep.WarningCount++
+	}
+	if len(rep.Issues) >= maxReportedIssues {
+		rep.IssuesTruncated = true
+		return
+	}
+	rep.Issues = append(rep.Issues, issue)
+}
+
+// --- Header mapping ---
+
+func normalizeHeader(h string) string {
+	var b strings.Builder
+	lastUnderscore := true
+	for _, r := range strings.ToLower(strings.TrimSpace(h)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			lastUnderscore = false
+		} else if !lastUnderscore {
+			b.WriteByte('_')
+			lastUnderscore = true
+		}
+	}
+	return strings.Trim(b.String(), "_")
+}
+
+// matchHeader reports whether a column header names the field, returning
+// the unit given as "Depth (ft)" or "temp_f" if any.
+func matchHeader(header string, field FieldDef) (bool, string) {
+	raw := strings.TrimSpace(header)
+	unit := ""
+	if i := strings.IndexAny(raw, "(["); i > 0 {
+		if j := strings.IndexAny(raw[i:], ")]"); j > 0 {
+			unit = normalizeHeader(raw[i+1 : i+j])
+			raw = raw[:i]
+		}
+	}
+	base := normalizeHeader(raw)
+
+	for _, alias := range field.Aliases {
+		if base == alias {
+			if unit != "" {
+				if _, ok := field.Units[unit]; !ok && len(field.Units) > 0 {
+					return false, ""
+				}
+			}
+			return true, unit
+		}
+	}
+	if unit == "" && len(field.Units) > 0 {
+		for u := range field.Units {
+			if stem, ok := strings.CutSuffix(base, "_"+u); ok {
+				for _, alias := range field.Aliases {
+					if stem == alias {
+						return true, u
+					}
+				}
+			}
+		}
+	}
+	return false, ""
+}
+
+type columnMapping struct {
+	Field  FieldDef
+	Column int
+	Unit   string
+}
+
+func mapColumns(header []string, schema *TableSchema) []columnMapping {
+	var mappings []columnMapping
+	used := map[int]bool{}
+	for _, field := range schema.Fields {
+		for i, h := range header {
+			if used[i] {
+				continue
+			}
+			if ok, unit := matchHeader(h, field); ok {
+				mappings = append(mappings, columnMapping{Field: field, Column: i, Unit: unit})
+				used[i] = true
+				break
+			}
+		}
+	}
+	return mappings
+}
+
+// --- Value parsing ---
+
+func parseNumber(s string) (float64, bool) {
+	s = strings.ReplaceAll(strings.TrimSpace(s), " ", "")
+	if strings.Count(s, ",") == 1 && !strings.Contains(s, ".") {
+		if _, frac, _ := strings.Cut(s, ","); len(frac) != 3 {
+			s = strings.Replace(s, ",", ".", 1)
+		}
+	}
+	s = strings.ReplaceAll(s, ",", "")
+	v, err := strconv.ParseFloat(s, 64)
+	return v, err == nil && !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// parseCoordinate accepts decimal degrees with an optional hemisphere
+// letter, e.g. "12.5 S".
+func parseCoordinate(s string) (float64, bool) {
+	s = strings.ToUpper(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), "°")))
+	sign := 1.0
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'S', 'W':
+			sign, s = -1, s[:n-1]
+		case 'N', 'E':
+			s = s[:n-1]
+		}
+	}
+	v, ok := parseNumber(strings.TrimSuffix(strings.TrimSpace(s), "°"))
+	return sign * v, ok
+}
+
+var dateLayouts = []string{
+	"2006-01-02", time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02 15:04",
+	"02/01/2006", "02-01-2006", "02.01.2006", "2006/01/02", "01/02/2006",
+	"02/01/2006 15:04", "02-Jan-2006", "2 Jan 2006", "Jan 2, 2006", "January 2, 2006",
+}
+
+// parseDate accepts the common textual layouts. Bare numbers are never
+// dates here: a year such as "2023" would otherwise read as an Excel serial,
+// and readXLSX already converts cells that are formatted as dates.
+func parseDate(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// --- Species resolution ---
+
+type speciesMatch struct {
+	ID         int
+	Name       string
+	Corrected  bool
+	Suggestion string
+}
+
+// speciesResolver resolves sheet values against species_data, caching the
+// answer for each distinct spelling.
+type speciesResolver struct {
+	byName map[string]speciesMatch
+	names  []speciesMatch
+	cache  map[string]*speciesMatch
+}
+
+func newSpeciesResolver() (*speciesResolver, error) {
+	rows, err := db.Query("SELECT id, COALESCE(scientific_name, ''), COALESCE(vernacularname, '') FROM species_data")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := &speciesResolver{byName: map[string]speciesMatch{}, cache: map[string]*speciesMatch{}}
+	for rows.Next() {
+		var id int
+		var scientific, vernacular string
+		if err := rows.Scan(&id, &scientific, &vernacular); err != nil {
+			continue
+		}
+		for _, name := range []string{scientific, vernacular} {
+			if name == "" {
+				continue
+			}
+			m := speciesMatch{ID: id, Name: scientific}
+			res.byName[strings.ToLower(name)] = m
+			res.names = append(res.names, speciesMatch{ID: id, Name: name})
+		}
+	}
+	return res, rows.Err()
+}
+
+func (res *speciesResolver) resolve(value string) *speciesMatch {
+	key := strings.ToLower(strings.Join(strings.Fields(value), " "))
+	if m, ok := res.cache[key]; ok {
+		return m
+	}
+	var result *speciesMatch
+
+	if m, ok := res.byName[key]; ok {
+		result = &m
+	} else if m, ok := res.byName[strings.ToLower(canonicalName(value))]; ok {
+		m.Corrected, m.Suggestion = true, m.Name
+		result = &m
+	} else if nm, err := resolveName(value); err == nil && nm.Accepted != nil {
+		if m, ok := res.byName[strings.ToLower(nm.Accepted.CanonicalName)]; ok {
+			m.Corrected, m.Suggestion = true, m.Name
+			result = &m
+		}
+	}
+	if result == nil {
+		best, bestScore := speciesMatch{}, 0.0
+		for _, candidate := range res.names {
+			if score := nameSimilarity(key, candidate.Name); score > bestScore {
+				best, bestScore = candidate, score
+			}
+		}
+		if bestScore >= fuzzyThreshold {
+			m := res.byName[strings.ToLower(best.Name)]
+			m.Corrected, m.Suggestion = true, best.Name
+			result = &m
+		}
+	}
+
+	res.cache[key] = result
+	return result
+}
+
+// --- Validation ---
+
+func profileColumn(index int, header string, rows [][]string) ColumnProfile {
+	p := ColumnProfile{Index: index, Header: header, Samples: []string{}}
+	seen := map[string]bool{}
+	allNumeric, allInteger, allDates := true, true, true
+	for _, row := range rows {
+		if index >= len(row) || strings.TrimSpace(row[index]) == "" {
+			continue
+		}
+		v := strings.TrimSpace(row[index])
+		p.NonEmpty++
+		if !seen[v] && len(seen) < maxProfileTracked {
+			seen[v] = true
+			if len(p.Samples) < maxProfileSamples {
+				p.Samples = append(p.Samples, v)
+			}
+		}
+		if n, ok := parseNumber(v); ok {
+			if p.Min == nil || n < *p.Min {
+				p.Min = bound(n)
+			}
+			if p.Max == nil || n > *p.Max {
+				p.Max = bound(n)
+			}
+			allInteger = allInteger && n == math.Trunc(n)
+		} else {
+			allNumeric, allInteger = false, false
+		}
+		if allDates {
+			_, allDates = parseDate(v)
+		}
+	}
+	p.Distinct = len(seen)
+
+	switch {
+	case p.NonEmpty == 0:
+		p.InferredType = "empty"
+	case allInteger:
+		p.InferredType = fieldInteger
+	case allNumeric:
+		p.InferredType = fieldNumber
+	case allDates:
+		p.InferredType = fieldDate
+	default:
+		p.InferredType = fieldText
+	}
+	if !allNumeric {
+		p.Min, p.Max = nil, nil
+	}
+	return p
+}
+
+// validateTable checks rows (header first) against the named schema, or
+// the best-matching one when schemaName is empty. It also returns the
+// normalised values of every row without errors, keyed by field name, for
+// importers to reuse.
+func validateTable(rows [][]string, schemaName string) (*ValidationReport, []map[string]interface{}, error) {
+	if len(rows) == 0 {
+		return nil, nil, fmt.Errorf("the file is empty")
+	}
+	header, data := rows[0], rows[1:]
+
+	rep := &ValidationReport{SchemaScores: map[string]int{}, RowCount: len(data), Issues: []ValidationIssue{}, UnmappedColumns: []string{}, MissingRequired: []string{}}
+
+	var schema *TableSchema
+	if schemaName != "" {
+		if schema = findTableSchema(schemaName); schema == nil {
+			return nil, nil, fmt.Errorf("unknown schema %q", schemaName)
+		}
+	}
+	var mappings []columnMapping
+	var chosen *TableSchema
+	bestScore := -1
+	for i := range tableSchemas {
+		ts := &tableSchemas[i]
+		m := mapColumns(header, ts)
+		score := 0
+		for _, cm := range m {
+			score++
+			if cm.Field.Required {
+				score += 2
+			}
+		}
+		rep.SchemaScores[ts.Name] = score
+		if ts == schema || (schema == nil && score > bestScore) {
+			chosen, mappings, bestScore = ts, m, score
+		}
+	}
+	schema = chosen
+	rep.Schema = schema.Name
+
+	mappedColumns := map[int]columnMapping{}
+	mappedFields := map[string]bool{}
+	for _, cm := range mappings {
+		mappedColumns[cm.Column] = cm
+		mappedFields[cm.Field.Name] = true
+	}
+	for i, h := range header {
+		p := profileColumn(i, h, data)
+		if cm, ok := mappedColumns[i]; ok {
+			p.MappedField, p.Unit = cm.Field.Name, cm.Unit
+		} else {
+			rep.UnmappedColumns = append(rep.UnmappedColumns, h)
+		}
+		rep.Columns = append(rep.Columns, p)
+	}
+	for _, f := range schema.Fields {
+		if f.Required && !mappedFields[f.Name] {
+			rep.MissingRequired = append(rep.MissingRequired, f.Name)
+			rep.add(ValidationIssue{Row: 1, Field: f.Name, Severity: severityError,
+				Message: fmt.Sprintf("no column maps to required field %s (expected one of: %s)", f.Name, strings.Join(f.Aliases, ", "))})
+		}
+	}
+
+	var resolver *speciesResolver
+	unknownSpecies := map[string]bool{}
+	if mappedFields["species"] {
+		var err error
+		if resolver, err = newSpeciesResolver(); err != nil {
+			return nil, nil, err
+		}
+		rep.Species = &SpeciesResolutionSummary{Unknown: []string{}}
+	}
+
+	now := time.Now()
+	var records []map[string]interface{}
+	for i, row := range data {
+		rowNum := i + 2
+		rowErrors := 0
+		record := map[string]interface{}{}
+		issue := func(cm columnMapping, value, severity, msg, suggestion string) {
+			if severity == severityError {
+				rowErrors++
+			}
+			rep.add(ValidationIssue{Row: rowNum, Column: header[cm.Column], Field: cm.Field.Name, Value: value,
+				Severity: severity, Message: msg, Suggestion: suggestion})
+		}
+
+		blank := true
+		for _, v := range row {
+			if strings.TrimSpace(v) != "" {
+				blank = false
+				break
+			}
+		}
+		if blank {
+			continue
+		}
+
+		for _, cm := range mappings {
+			value := ""
+			if cm.Column < len(row) {
+				value = strings.TrimSpace(row[cm.Column])
+			}
+			f := cm.Field
+			if value == "" || strings.EqualFold(value, "na") || strings.EqualFold(value, "null") {
+				if f.Required {
+					issue(cm, value, severityError, f.Name+" is required", "")
+				}
+				continue
+			}
+
+			switch f.Type {
+			case fieldText:
+				record[f.Name] = value
+
+			case fieldNumber, fieldInteger:
+				n, ok := parseNumber(value)
+				if !ok {
+					issue(cm, value, severityError, "not a number", "")
+					continue
+				}
+				if f.Type == fieldInteger && n != math.Trunc(n) {
+					issue(cm, value, severityError, "must be a whole number", "")
+					continue
+				}
+				if conv, ok := f.Units[cm.Unit]; ok && cm.Unit != "" {
+					n = n*conv.Factor + conv.Offset
+				}
+				if f.Min != nil && n < *f.Min || f.Max != nil && n > *f.Max {
+					lo, hi := "-∞", "∞"
+					if f.Min != nil {
+						lo = strconv.FormatFloat(*f.Min, 'g', -1, 64)
+					}
+					if f.Max != nil {
+						hi = strconv.FormatFloat(*f.Max, 'g', -1, 64)
+					}
+					issue(cm, value, severityError, fmt.Sprintf("%g is outside the plausible range [%s, %s]", n, lo, hi), "")
+					continue
+				}
+				record[f.Name] = n
+
+			case fieldDate:
+				t, ok := parseDate(value)
+				if !ok {
+					issue(cm, value, severityError, "unrecognised date", "use YYYY-MM-DD")
+					continue
+				}
+				if t.After(now) {
+					issue(cm, value, severityError, "date is in the future", "")
+					continue
+				}
+				if t.Year() < 1800 {
+					issue(cm, value, severityWarning, "date is before 1800", "")
+				}
+				record[f.Name] = t
+
+			case fieldLatitude, fieldLongitude:
+				c, ok := parseCoordinate(value)
+				limit := 90.0
+				if f.Type == fieldLongitude {
+					limit = 180
+				}
+				if !ok || math.Abs(c) > limit {
+					issue(cm, value, severityError, fmt.Sprintf("%s must be decimal degrees within ±%g", f.Type, limit), "")
+					continue
+				}
+				record[f.Name] = c
+
+			case fieldSpecies:
+				m := resolver.resolve(value)
+				switch {
+				case m == nil:
+					rep.Species.Unresolved++
+					if !unknownSpecies[value] {
+						unknownSpecies[value] = true
+						rep.Species.Unknown = append(rep.Species.Unknown, value)
+					}
+					issue(cm, value, severityError, "species not found in species_data", "")
+					continue
+				case m.Corrected:
+					rep.Species.Corrected++
+					issue(cm, value, severityWarning, "species name resolved to a different spelling", m.Suggestion)
+				default:
+					rep.Species.Resolved++
+				}
+				record["species_id"] = m.ID
+				record[f.Name] = m.Name
+			}
+		}
+
+		_, hasLat := record["latitude"]
+		_, hasLon := record["longitude"]
+		if hasLat != hasLon {
+			rep.add(ValidationIssue{Row: rowNum, Severity: severityError, Message: "latitude and longitude must be given together"})
+			rowErrors++
+		} else if hasLat && record["latitude"] == 0.0 && record["longitude"] == 0.0 {
+			rep.add(ValidationIssue{Row: rowNum, Severity: severityWarning, Message: "coordinates are 0,0, which is usually a missing value"})
+		}
+
+		if rowErrors == 0 {
+			rep.ValidRows++
+			records = append(records, record)
+		}
+	}
+
+	if rep.Species != nil {
+		sort.Strings(rep.Species.Unknown)
+	}
+	rep.ReadyForImport = rep.ErrorCount == 0 && rep.RowCount > 0
+	return rep, records, nil
+}
+
+// validateFile reads a spooled or downloaded table and validates it.
+func validateFile(f *os.File, name, schemaName string) (*ValidationReport, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := readTable(f, info.Size(), name)
+	if err != nil {
+		return nil, err
+	}
+	rep, _, err := validateTable(rows, schemaName)
+	return rep, err
+}
+
+// --- Handlers ---
+
+func validateUpload(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireUser(w, r); !ok {
+		return
+	}
+
+	fields, files, err := readUploadForm(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer func() {
+		for _, f := range files {
+			f.Remove()
+		}
+	}()
+	if len(files) != 1 {
+		http.Error(w, "Upload exactly one file", http.StatusBadRequest)
+		return
+	}
+
+	f, err := os.Open(files[0].Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	rep, err := validateFile(f, files[0].Name, fields.Get("schema"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rep)
+}
+
+// validateDatasetFile validates a file already stored in a dataset.
+func validateDatasetFile(w http.ResponseWriter, r *http.Request) {
+	ds := datasetForRequest(w, r, optionalUser(r))
+	if ds == nil {
+		return
+	}
+
+	var name, key string
+	err := db.QueryRow("SELECT file_name, object_key FROM dataset_files WHERE id = $1 AND dataset_id = $2",
+		r.PathValue("fileId"), ds.ID).Scan(&name, &key)
+	if err == sql.ErrNoRows {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	body, err := store.Get(r.Context(), key)
+	if err != nil {
+		http.Error(w, "Failed to read file: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	tmp, err := os.CreateTemp("", "validate-*")
+	if err != nil {
+		body.Close()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	_, err = io.Copy(tmp, body)
+	body.Close()
+	if err != nil {
+		http.Error(w, "Failed to read file: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	rep, err := validateFile(tmp, name, r.URL.Query().Get("schema"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rep)
+}
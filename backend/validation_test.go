@@ -0,0 +1,131 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestMatchHeader(t *testing.T) {
+	env := findTableSchema("environmental")
+	field := func(name string) FieldDef {
+		for _, f := range env.Fields {
+			if f.Name == name {
+				return f
+			}
+		}
+		t.Fatalf("no field %s", name)
+		return FieldDef{}
+	}
+	tests := []struct {
+		header, field string
+		ok            bool
+		unit          string
+	}{
+		{"Depth (ft)", "depth_m", true, "ft"},
+		{" DEPTH [m] ", "depth_m", true, "m"},
+		{"temp_f", "temperature_c", true, "f"},
+		{"Sea Water Temperature", "temperature_c", true, ""},
+		{"Depth (furlongs)", "depth_m", false, ""},
+		{"Lat", "latitude", true, ""},
+		{"pH (total)", "ph", true, "total"},
+		{"Station-ID", "station_id", true, ""},
+		{"Salinity", "temperature_c", false, ""},
+	}
+	for _, tt := range tests {
+		ok, unit := matchHeader(tt.header, field(tt.field))
+		if ok != tt.ok || unit != tt.unit {
+			t.Errorf("matchHeader(%q, %s) = %v, %q; want %v, %q", tt.header, tt.field, ok, unit, tt.ok, tt.unit)
+		}
+	}
+}
+
+func TestValidateTable(t *testing.T) {
+	rows := [][]string{
+		{"Station", "Date", "Lat", "Lon", "Temp (F)", "Depth (ft)", "Salinity", "Notes"},
+		{"B1", "2024-05-01", "12.5 N", "74.2E", "77", "10", "35", "PN"},
+		{"B1", "2024-05-02", "95", "74.2", "77", "10", "35", ""},
+		{"B1", "2024-05-03", "12.5", "-190", "77", "10", "35", ""},
+		{"B1", "2024-05-04", "12.5", "74.2", "200", "10", "35", ""},
+		{"B1", "2024-05-05", "12.5", "", "77", "10", "35", ""},
+		{"", "", "", "", "", "", "", ""},
+		{"B1", "01/06/2024", "0", "0", "na", "-3", "35", ""},
+		{"", "2999-01-01", "", "", "", "", "", ""},
+	}
+	rep, records, err := validateTable(rows, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Schema != "environmental" {
+		t.Fatalf("schema = %s, want environmental chosen by score %v", rep.Schema, rep.SchemaScores)
+	}
+	if rep.RowCount != 8 || rep.ValidRows != 1 || len(records) != 1 || rep.ReadyForImport {
+		t.Errorf("rows %d, valid %d, records %d, ready %v", rep.RowCount, rep.ValidRows, len(records), rep.ReadyForImport)
+	}
+
+	// Fahrenheit and feet are converted to the canonical units.
+	rec := records[0]
+	if math.Abs(rec["temperature_c"].(float64)-25) > 1e-9 || math.Abs(rec["depth_m"].(float64)-3.048) > 1e-9 {
+		t.Errorf("converted record = %v", rec)
+	}
+	if rec["latitude"] != 12.5 || rec["longitude"] != 74.2 || rec["station_id"] != "B1" {
+		t.Errorf("record = %v", rec)
+	}
+	if strings.Join(rep.UnmappedColumns, ",") != "Notes" {
+		t.Errorf("unmapped columns = %v", rep.UnmappedColumns)
+	}
+	if c := rep.Columns[4]; c.MappedField != "temperature_c" || c.Unit != "f" {
+		t.Errorf("temperature column = %+v", c)
+	}
+
+	want := []struct {
+		row             int
+		field, severity string
+		message         string
+	}{
+		// A rejected coordinate leaves the pair incomplete.
+		{3, "latitude", severityError, "latitude must be decimal degrees within ±90"},
+		{3, "", severityError, "latitude and longitude must be given together"},
+		{4, "longitude", severityError, "longitude must be decimal degrees within ±180"},
+		{4, "", severityError, "latitude and longitude must be given together"},
+		{5, "temperature_c", severityError, "outside the plausible range [-3, 40]"},
+		{6, "", severityError, "latitude and longitude must be given together"},
+		{8, "depth_m", severityError, "outside the plausible range [0, 11000]"},
+		{8, "", severityWarning, "coordinates are 0,0"},
+		{9, "station_id", severityError, "station_id is required"},
+		{9, "timestamp", severityError, "date is in the future"},
+	}
+	if len(rep.Issues) != len(want) {
+		t.Fatalf("got %d issues, want %d: %+v", len(rep.Issues), len(want), rep.Issues)
+	}
+	for i, w := range want {
+		got := rep.Issues[i]
+		if got.Row != w.row || got.Field != w.field || got.Severity != w.severity || !strings.Contains(got.Message, w.message) {
+			t.Errorf("issue %d = %+v, want row %d %s %s %q", i, got, w.row, w.field, w.severity, w.message)
+		}
+	}
+	if rep.ErrorCount != 9 || rep.WarningCount != 1 {
+		t.Errorf("errors %d, warnings %d; want 9 and 1", rep.ErrorCount, rep.WarningCount)
+	}
+}
+
+func TestValidateTableSchemaErrors(t *testing.T) {
+	if _, _, err := validateTable(nil, ""); err == nil {
+		t.Error("empty file validated")
+	}
+	if _, _, err := validateTable([][]string{{"a"}}, "plankton"); err == nil {
+		t.Error("unknown schema accepted")
+	}
+
+	// Forcing a schema the header does not fit reports its required fields.
+	rep, _, err := validateTable([][]string{{"Date", "Temperature"}, {"2024-05-01", "28"}}, "environmental")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(rep.MissingRequired, ",") != "station_id" || rep.ReadyForImport {
+		t.Errorf("missing required = %v, ready %v", rep.MissingRequired, rep.ReadyForImport)
+	}
+	if rep.Issues[0].Row != 1 || rep.Issues[0].Field != "station_id" {
+		t.Errorf("first issue = %+v, want the missing field on the header row", rep.Issues[0])
+	}
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// --- Dataset Versions ---
+//
+// Every change to a dataset's metadata or files records an immutable
+// version: a snapshot of the citable metadata plus the exact set of files,
+// identified by a SHA-256 content hash. Files are never replaced in place,
+// so a version keeps pointing at the bytes it was created with. A version
+// can be given a DOI once; until then its persistent identifier is the
+// accession plus version number (MDS000123.v2).
+
+const datasetVersionSchema = `
+CREATE TABLE IF NOT EXISTS dataset_versions (
+	id            SERIAL PRIMARY KEY,
+	dataset_id    INTEGER NOT NULL REFERENCES datasets(id) ON DELETE CASCADE,
+	version       INTEGER NOT NULL,
+	content_hash  TEXT NOT NULL,
+	metadata      JSONB NOT NULL,
+	change_note   TEXT,
+	created_by    TEXT NOT NULL,
+	created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+	doi           TEXT UNIQUE,
+	doi_minted_at TIMESTAMPTZ,
+	UNIQUE (dataset_id, version)
+);
+
+CREATE TABLE IF NOT EXISTS dataset_version_files (
+	version_id INTEGER NOT NULL REFERENCES dataset_versions(id) ON DELETE CASCADE,
+	file_id    INTEGER NOT NULL REFERENCES dataset_files(id),
+	PRIMARY KEY (version_id, file_id)
+);`
+
+// VersionMetadata is the part of a dataset that is cited and hashed.
+// Visibility is deliberately left out: publishing a dataset does not change
+// its content.
+type VersionMetadata struct {
+	Title           string   `json:"title"`
+	Description     string   `json:"description"`
+	Authors         []string `json:"authors"`
+	Keywords        []string `json:"keywords"`
+	CollectionStart string   `json:"collection_start,omitempty"`
+	CollectionEnd   string   `json:"collection_end,omitempty"`
+}
+
+type DatasetVersion struct {
+	ID          int             `json:"id"`
+	DatasetID   int             `json:"dataset_id"`
+	Accession   string          `json:"accession"`
+	Version     int             `json:"version"`
+	ContentHash string          `json:"content_hash"`
+	Identifier  string          `json:"identifier"`
+	DOI         string          `json:"doi,omitempty"`
+	DOIMintedAt string          `json:"doi_minted_at,omitempty"`
+	ChangeNote  string          `json:"change_note"`
+	CreatedBy   string          `json:"created_by"`
+	CreatedAt   string          `json:"created_at"`
+	Metadata    VersionMetadata `json:"metadata"`
+	FileCount   int             `json:"file_count"`
+	TotalSize   int64           `json:"total_size"`
+	Files       []DatasetFile   `json:"files,omitempty"`
+}
+
+func versionIdentifier(accession string, version int) string {
+	return fmt.Sprintf("%s.v%d", accession, version)
+}
+
+// contentHash hashes the metadata and the (name, digest, size) of every
+// file in a canonical order, so identical content always hashes the same.
+func contentHash(meta VersionMetadata, files []DatasetFile) string {
+	type fileEntry struct {
+		Name   string `json:"name"`
+		SHA256 string `json:"sha256"`
+		Size   int64  `json:"size"`
+	}
+	entries := make([]fileEntry, len(files))
+	for i, f := range files {
+		entries[i] = fileEntry{f.FileName, strings.ToLower(f.SHA256), f.SizeBytes}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Name != entries[j].Name {
+			return entries[i].Name < entries[j].Name
+		}
+		return entries[i].SHA256 < entries[j].SHA256
+	})
+
+	canonical, _ := json.Marshal(struct {
+		Metadata VersionMetadata `json:"metadata"`
+		Files    []fileEntry     `json:"files"`
+	}{meta, entries})
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:])
+}
+
+// recordDatasetVersion snapshots the dataset inside tx. When nothing has
+// changed since the latest version, that version is returned instead of a
+// new one.
+func recordDatasetVersion(tx *sql.Tx, datasetID int, userID, note string) (*DatasetVersion, error) {
+	var meta VersionMetadata
+	var accession string
+	err := tx.QueryRow(`SELECT accession, title, COALESCE(description, ''), authors, keywords,
+			COALESCE(collection_start::text, ''), COALESCE(collection_end::text, '')
+		FROM datasets WHERE id = $1 FOR UPDATE`, datasetID).Scan(&accession, &meta.Title, &meta.Description,
+		pq.Array(&meta.Authors), pq.Array(&meta.Keywords), &meta.CollectionStart, &meta.CollectionEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`SELECT id, file_name, COALESCE(content_type, ''), size_bytes, sha256, uploaded_at::text
+		FROM dataset_files WHERE dataset_id = $1 ORDER BY id`, datasetID)
+	if err != nil {
+		return nil, err
+	}
+	files := []DatasetFile{}
+	for rows.Next() {
+		var f DatasetFile
+		if err := rows.Scan(&f.ID, &f.FileName, &f.ContentType, &f.SizeBytes, &f.SHA256, &f.UploadedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	hash := contentHash(meta, files)
+	latest, err := scanDatasetVersion(tx.QueryRow("SELECT "+versionColumns+` FROM dataset_versions v JOIN datasets d ON d.id = v.dataset_id
+		WHERE v.dataset_id = $1 ORDER BY v.version DESC LIMIT 1`, datasetID))
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if latest != nil && latest.ContentHash == hash {
+		return latest, nil
+	}
+
+	metaJSON, _ := json.Marshal(meta)
+	v := &DatasetVersion{DatasetID: datasetID, Accession: accession, ContentHash: hash, ChangeNote: note,
+		CreatedBy: userID, Metadata: meta, Files: files, FileCount: len(files)}
+	err = tx.QueryRow(`INSERT INTO dataset_versions (dataset_id, version, content_hash, metadata, change_note, created_by)
+		VALUES ($1, (SELECT COALESCE(MAX(version), 0) + 1 FROM dataset_versions WHERE dataset_id = $1), $2, $3, $4, $5)
+		RETURNING id, version, created_at::text`, datasetID, hash, metaJSON, note, userID).Scan(&v.ID, &v.Version, &v.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if _, err := tx.Exec("INSERT INTO dataset_version_files (version_id, file_id) VALUES ($1, $2)", v.ID, f.ID); err != nil {
+			return nil, err
+		}
+		v.TotalSize += f.SizeBytes
+	}
+	v.Identifier = versionIdentifier(accession, v.Version)
+	return v, nil
+}
+
+// backfillDatasetVersions gives datasets created before versioning existed
+// their first version.
+func backfillDatasetVersions() {
+	rows, err := db.Query(`SELECT id, owner_id FROM datasets d
+		WHERE NOT EXISTS (SELECT 1 FROM dataset_versions v WHERE v.dataset_id = d.id)`)
+	if err != nil {
+		log.Println("Could not backfill dataset versions:", err)
+		return
+	}
+	type pending struct {
+		id    int
+		owner string
+	}
+	var todo []pending
+	for rows.Next() {
+		var p pending
+		if rows.Scan(&p.id, &p.owner) == nil {
+			todo = append(todo, p)
+		}
+	}
+	rows.Close()
+
+	for _, p := range todo {
+		tx, err := db.Begin()
+		if err != nil {
+			log.Println("Could not backfill dataset versions:", err)
+			return
+		}
+		if _, err := recordDatasetVersion(tx, p.id, p.owner, "Initial version"); err != nil {
+			tx.Rollback()
+			log.Printf("Could not version dataset %d: %v", p.id, err)
+			continue
+		}
+		tx.Commit()
+	}
+}
+
+const versionColumns = `v.id, v.dataset_id, d.accession, v.version, v.content_hash, COALESCE(v.doi, ''),
+	COALESCE(v.doi_minted_at::text, ''), COALESCE(v.change_note, ''), v.created_by, v.created_at::text, v.metadata,
+	(SELECT COUNT(*) FROM dataset_version_files vf WHERE vf.version_id = v.id),
+	(SELECT COALESCE(SUM(f.size_bytes), 0) FROM dataset_version_files vf JOIN dataset_files f ON f.id = vf.file_id WHERE vf.version_id = v.id)`
+
+func scanDatasetVersion(row interface{ Scan(...any) error }) (*DatasetVersion, error) {
+	var v DatasetVersion
+	var meta []byte
+	err := row.Scan(&v.ID, &v.DatasetID, &v.Accession, &v.Version, &v.ContentHash, &v.DOI, &v.DOIMintedAt,
+		&v.ChangeNote, &v.CreatedBy, &v.CreatedAt, &meta, &v.FileCount, &v.TotalSize)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(meta, &v.Metadata); err != nil {
+		return nil, err
+	}
+	if v.Metadata.Authors == nil {
+		v.Metadata.Authors = []string{}
+	}
+	if v.Metadata.Keywords == nil {
+		v.Metadata.Keywords = []string{}
+	}
+	v.Identifier = versionIdentifier(v.Accession, v.Version)
+	if v.DOI != "" {
+		v.Identifier = "https://doi.org/" + v.DOI
+	}
+	return &v, nil
+}
+
+func loadVersionFiles(versionID int) ([]DatasetFile, error) {
+	rows, err := db.Query(`SELECT f.id, f.file_name, COALESCE(f.content_type, ''), f.size_bytes, f.sha256, f.uploaded_at::text
+		FROM dataset_version_files vf JOIN dataset_files f ON f.id = vf.file_id
+		WHERE vf.version_id = $1 ORDER BY f.id`, versionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []DatasetFile{}
+	for rows.Next() {
+		var f DatasetFile
+		if err := rows.Scan(&f.ID, &f.FileName, &f.ContentType, &f.SizeBytes, &f.SHA256, &f.UploadedAt); err != nil {
+			continue
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// versionForRequest loads the {version} of the {id} dataset, accepting a
+// version number or "latest". It writes the error response itself when it
+// returns nil.
+func versionForRequest(w http.ResponseWriter, r *http.Request, user *AuthUser) (*Dataset, *DatasetVersion) {
+	ds := datasetForRequest(w, r, user)
+	if ds == nil {
+		return nil, nil
+	}
+
+	ref := r.PathValue("version")
+	query := "SELECT " + versionColumns + " FROM dataset_versions v JOIN datasets d ON d.id = v.dataset_id WHERE v.dataset_id = $1"
+	args := []interface{}{ds.ID}
+	if ref == "latest" {
+		query += " ORDER BY v.version DESC LIMIT 1"
+	} else {
+		n, err := strconv.Atoi(strings.TrimPrefix(ref, "v"))
+		if err != nil {
+			http.Error(w, "Invalid version", http.StatusBadRequest)
+			return nil, nil
+		}
+		query += " AND v.version = $2"
+		args = append(args, n)
+	}
+
+	v, err := scanDatasetVersion(db.QueryRow(query, args...))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Version not found", http.StatusNotFound)
+		return nil, nil
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, nil
+	}
+	return ds, v
+}
+
+// --- Handlers ---
+
+func listDatasetVersions(w http.ResponseWriter, r *http.Request) {
+	ds := datasetForRequest(w, r, optionalUser(r))
+	if ds == nil {
+		return
+	}
+
+	rows, err := db.Query("SELECT "+versionColumns+` FROM dataset_versions v JOIN datasets d ON d.id = v.dataset_id
+		WHERE v.dataset_id = $1 ORDER BY v.version DESC`, ds.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	versions := []*DatasetVersion{}
+	for rows.Next() {
+		v, err := scanDatasetVersion(rows)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, v)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions)
+}
+
+func getDatasetVersion(w http.ResponseWriter, r *http.Request) {
+	_, v := versionForRequest(w, r, optionalUser(r))
+	if v == nil {
+		return
+	}
+	files, err := loadVersionFiles(v.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	v.Files = files
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// updateDataset changes dataset metadata from a JSON body. Omitted fields
+// keep their value; the result goes through the same checks as an upload.
+func updateDataset(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	ds := datasetForRequest(w, r, user)
+	if ds == nil {
+		return
+	}
+	if !ds.canWrite(user) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var body struct {
+		Title           *string   `json:"title"`
+		Description     *string   `json:"description"`
+		Authors         *[]string `json:"authors"`
+		Keywords        *[]string `json:"keywords"`
+		CollectionStart *string   `json:"collection_start"`
+		CollectionEnd   *string   `json:"collection_end"`
+		IsPublic        *bool     `json:"is_public"`
+		ChangeNote      string    `json:"change_note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	fields := url.Values{
+		"title":            {ds.Title},
+		"description":      {ds.Description},
+		"authors":          ds.Authors,
+		"keywords":         ds.Keywords,
+		"collection_start": {ds.CollectionStart},
+		"collection_end":   {ds.CollectionEnd},
+		"is_public":        {strconv.FormatBool(ds.IsPublic)},
+	}
+	setField := func(name string, v *string) {
+		if v != nil {
+			fields.Set(name, *v)
+		}
+	}
+	setField("title", body.Title)
+	setField("description", body.Description)
+	setField("collection_start", body.CollectionStart)
+	setField("collection_end", body.CollectionEnd)
+	if body.Authors != nil {
+		fields["authors"] = *body.Authors
+	}
+	if body.Keywords != nil {
+		fields["keywords"] = *body.Keywords
+	}
+	if body.IsPublic != nil {
+		fields.Set("is_public", strconv.FormatBool(*body.IsPublic))
+	}
+
+	updated, err := parseDatasetMetadata(fields)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	note := strings.TrimSpace(body.ChangeNote)
+	if note == "" {
+		note = "Metadata updated"
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE datasets SET title = $2, description = $3, authors = $4, keywords = $5,
+			collection_start = NULLIF($6, '')::date, collection_end = NULLIF($7, '')::date, is_public = $8, updated_at = now()
+		WHERE id = $1`, ds.ID, updated.Title, updated.Description, pq.Array(updated.Authors), pq.Array(updated.Keywords),
+		updated.CollectionStart, updated.CollectionEnd, updated.IsPublic)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := recordDatasetVersion(tx, ds.ID, user.ID, note); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result, err := loadDataset(ds.Accession)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestContentHash(t *testing.T) {
+	meta := VersionMetadata{Title: "Reef fish counts", Authors: []string{"Nair, Priya"}, Keywords: []string{"reef"}}
+	files := []DatasetFile{
+		{ID: 1, FileName: "counts.csv", SHA256: "AA11", SizeBytes: 100},
+		{ID: 2, FileName: "sites.csv", SHA256: "bb22", SizeBytes: 50},
+		{ID: 3, FileName: "counts.csv", SHA256: "0f00", SizeBytes: 80},
+	}
+	base := contentHash(meta, files)
+	if len(base) != 64 {
+		t.Fatalf("hash %q is not hex SHA-256", base)
+	}
+
+	// Upload order, ids, timestamps and digest case do not count.
+	reordered := []DatasetFile{
+		{ID: 9, FileName: "sites.csv", SHA256: "BB22", SizeBytes: 50, UploadedAt: "2026-01-01"},
+		{ID: 8, FileName: "counts.csv", SHA256: "0F00", SizeBytes: 80},
+		{ID: 7, FileName: "counts.csv", SHA256: "aa11", SizeBytes: 100},
+	}
+	if got := contentHash(meta, reordered); got != base {
+		t.Errorf("same content in another order hashed to %s, want %s", got, base)
+	}
+
+	changed := map[string]struct {
+		meta  VersionMetadata
+		files []DatasetFile
+	}{
+		"title":     {VersionMetadata{Title: "Reef fish counts v2", Authors: meta.Authors, Keywords: meta.Keywords}, files},
+		"file size": {meta, []DatasetFile{files[0], files[1], {FileName: "counts.csv", SHA256: "0f00", SizeBytes: 81}}},
+		"file name": {meta, []DatasetFile{files[0], files[1], {FileName: "counts2.csv", SHA256: "0f00", SizeBytes: 80}}},
+		"file gone": {meta, files[:2]},
+	}
+	for name, c := range changed {
+		if contentHash(c.meta, c.files) == base {
+			t.Errorf("changing the %s left the hash unchanged", name)
+		}
+	}
+}